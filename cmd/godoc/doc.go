@@ -59,6 +59,14 @@ The flags are:
 		"pointer": display channel peers, callers and dynamic callees
 			(significantly slower)
 		See https://golang.org/lib/godoc/analysis/help.html for details.
+	-guru=addr
+		address of a guru server (see guru -serve) whose queries
+		to offer: source views link each function to its callers,
+		and each type to its 'implements' relation, and /guru
+		answers the query of a mode, a file and an offset, such as
+		/guru?mode=callers&file=/src/fmt/print.go&offset=1234,
+		with the results of the server in JSON, with the file
+		names of godoc
 	-templates=""
 		directory containing alternate template files; if set,
 		the directory may provide alternative template files
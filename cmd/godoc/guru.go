@@ -0,0 +1,206 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !golangorg
+
+package main
+
+// This file connects godoc to a guru server (see guru -serve), whose
+// queries it offers from the source views of Go files.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/cmd/guru/client"
+	"golang.org/x/tools/godoc/analysis"
+)
+
+// A guruClient serves the queries of a guru server to the browser,
+// with the positions of the results in the form of godoc, both
+// as a JSON handler and as links of the source views.
+type guruClient struct {
+	addr  string   // address of the guru server
+	roots []string // the directories bound at /src, e.g. $GOROOT/src
+
+	mu     sync.Mutex
+	client *client.Client // nil until dialed, or after a failure
+}
+
+// initGuru makes the queries of the guru server at addr available at
+// /guru, and adds links to them to the source views of pres.
+func initGuru(addr string, roots []string) {
+	g := &guruClient{addr: addr, roots: roots}
+	http.Handle("/guru", g)
+	pres.SourceLinks = g.links
+}
+
+// ServeHTTP performs the query of the request, whose parameters are
+// the mode, the source file (e.g. /src/fmt/print.go) and the byte
+// offset in it, and writes its results as a client.QueryReply.
+func (g *guruClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mode := r.FormValue("mode")
+	offset, err := strconv.Atoi(r.FormValue("offset"))
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+	filename, ok := g.osPath(r.FormValue("file"))
+	if !ok {
+		http.Error(w, "no such file", http.StatusNotFound)
+		return
+	}
+	reply, err := g.query(mode, fmt.Sprintf("%s:#%d", filename, offset))
+	if err != nil {
+		log.Printf("guru query: %v", err)
+		http.Error(w, "guru server unavailable", http.StatusBadGateway)
+		return
+	}
+	for i, res := range reply.Results {
+		reply.Results[i] = g.docPositions(res)
+	}
+	data, err := json.MarshalIndent(reply, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+// query performs a query of the guru server, dialing it if necessary.
+func (g *guruClient) query(mode, pos string) (*client.QueryReply, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == nil {
+		c, err := client.Dial(g.addr)
+		if err != nil {
+			return nil, err
+		}
+		g.client = c
+	}
+	reply, err := g.client.Query(mode, pos)
+	if err != nil {
+		// Dial again for the next query.
+		g.client.Close()
+		g.client = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+// osPath returns the name in the file system of the source file
+// /src/<path>/<filename>, as the guru server knows it.
+func (g *guruClient) osPath(file string) (string, bool) {
+	if !strings.HasPrefix(file, "/src/") || path.Clean(file) != file {
+		return "", false
+	}
+	for _, root := range g.roots {
+		filename := filepath.Join(root, filepath.FromSlash(file[len("/src/"):]))
+		if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() {
+			return filename, true
+		}
+	}
+	return "", false
+}
+
+// docPositions returns the JSON result res with its file names in the
+// form of godoc, /src/<path>/<filename>.
+func (g *guruClient) docPositions(res json.RawMessage) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(res))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return res
+	}
+	var visit func(v interface{}) interface{}
+	visit = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			for _, root := range g.roots {
+				if rel := strings.TrimPrefix(v, root+string(filepath.Separator)); rel != v {
+					return "/src/" + filepath.ToSlash(rel)
+				}
+			}
+		case []interface{}:
+			for i, elem := range v {
+				v[i] = visit(elem)
+			}
+		case map[string]interface{}:
+			for k, elem := range v {
+				v[k] = visit(elem)
+			}
+		}
+		return v
+	}
+	data, err := json.Marshal(visit(v))
+	if err != nil {
+		return res
+	}
+	return data
+}
+
+// links returns the links to the queries of the declarations of the
+// Go source file: the callers of each function and method, and the
+// 'implements' relation of each type.
+func (g *guruClient) links(file string, src []byte) []analysis.Link {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, 0)
+	if f == nil {
+		log.Printf("guru links: %v", err)
+		return nil
+	}
+	var links []analysis.Link
+	link := func(id *ast.Ident, mode, title string) {
+		if id.Name == "_" {
+			return
+		}
+		offset := fset.Position(id.Pos()).Offset
+		query := url.Values{"mode": {mode}, "file": {file}, "offset": {strconv.Itoa(offset)}}
+		links = append(links, guruLink{offset, offset + len(id.Name), title, "/guru?" + query.Encode()})
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			link(n.Name, "callers", "show the callers of "+n.Name.Name)
+		case *ast.TypeSpec:
+			link(n.Name, "implements", "show the 'implements' relation of "+n.Name.Name)
+		}
+		return true
+	})
+	return links
+}
+
+// A guruLink is an <a> element for a query of the guru server.
+type guruLink struct {
+	start, end int
+	title      string
+	href       string
+}
+
+func (l guruLink) Start() int { return l.start }
+func (l guruLink) End() int   { return l.end }
+
+func (l guruLink) Write(w io.Writer, _ int, start bool) {
+	if start {
+		fmt.Fprintf(w, `<a title="%s" href="%s">`, html.EscapeString(l.title), html.EscapeString(l.href))
+	} else {
+		io.WriteString(w, "</a>")
+	}
+}
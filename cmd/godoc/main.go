@@ -74,6 +74,7 @@ var (
 	templateDir    = flag.String("templates", "", "load templates/JS/CSS from disk in this directory")
 	showPlayground = flag.Bool("play", false, "enable playground")
 	declLinks      = flag.Bool("links", true, "link identifiers to their declarations")
+	guruAddr       = flag.String("guru", "", "address of a guru server (guru -serve) whose queries to offer in source views")
 
 	// search index
 	indexEnabled  = flag.Bool("index", false, "enable search index")
@@ -253,6 +254,17 @@ func main() {
 	readTemplates(pres)
 	registerHandlers(pres)
 
+	if *guruAddr != "" {
+		if *zipfile != "" {
+			log.Fatal("-guru requires the file system of the OS, not -zip")
+		}
+		roots := []string{filepath.Join(*goroot, "src")}
+		for _, p := range filepath.SplitList(build.Default.GOPATH) {
+			roots = append(roots, filepath.Join(p, "src"))
+		}
+		initGuru(*guruAddr, roots)
+	}
+
 	if *writeIndex {
 		// Write search index and exit.
 		if *indexFiles == "" {
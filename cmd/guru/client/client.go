@@ -0,0 +1,103 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package client provides access to a guru server, that is, a guru
+// process started with the -serve flag, which loads and analyzes a
+// program once and then answers queries about it using JSON-RPC.
+//
+// Query results are JSON objects whose schema is defined by the
+// golang.org/x/tools/cmd/guru/serial package.
+package client // import "golang.org/x/tools/cmd/guru/client"
+
+import (
+	"encoding/json"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+)
+
+// ServiceName is the name under which the guru server registers its
+// JSON-RPC service.  Its only method is "Guru.Query".
+const ServiceName = "Guru"
+
+// QueryArgs holds the arguments of a Guru.Query call.
+type QueryArgs struct {
+	Mode  string   `json:"mode"`  // query mode, e.g. "callers"
+	Posns []string `json:"posns"` // query positions, e.g. "foo.go:#123,#456"
+
+	// First and Max select a page of the items of the results of
+	// each query, for the modes whose results may be long, such as
+	// the references of referrers: those from index First, up to
+	// Max of them.  If Max is zero, the server's -max-results
+	// applies.  A page followed by more items ends with a Truncated
+	// result (see package serial), and the next page starts at
+	// First+Max.
+	First int `json:"first,omitempty"`
+	Max   int `json:"max,omitempty"`
+}
+
+// QueryReply holds the results of a Guru.Query call.
+type QueryReply struct {
+	// Version is the serial.Version of the schema of Results.
+	Version int `json:"version"`
+
+	// Results holds the stream of JSON objects
+	// produced by the queries, in order.
+	Results []json.RawMessage `json:"results"`
+
+	// Errors[i] holds the error message of the query
+	// at position Posns[i], or "" if it succeeded.
+	Errors []string `json:"errors"`
+}
+
+// Network returns the network and address denoted by addr, which is
+// either a TCP address such as "localhost:1234", or the name of a
+// Unix-domain socket prefixed by "unix:", such as "unix:/tmp/guru".
+func Network(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", addr[len("unix:"):]
+	}
+	return "tcp", addr
+}
+
+// A Client is a connection to a guru server.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the guru server at the specified address,
+// which has the form described at Network.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial(Network(addr))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{jsonrpc.NewClient(conn)}, nil
+}
+
+// Query asks the server to perform the query mode at each of the
+// specified positions.
+// A non-nil error indicates a failure to communicate with the server;
+// the failures of individual queries are reported in the reply.
+func (c *Client) Query(mode string, posns ...string) (*QueryReply, error) {
+	return c.QueryPage(mode, 0, 0, posns...)
+}
+
+// QueryPage is like Query, but requests only the page of the items of
+// the results of each query from index first, up to max of them; see
+// QueryArgs.
+func (c *Client) QueryPage(mode string, first, max int, posns ...string) (*QueryReply, error) {
+	var reply QueryReply
+	args := &QueryArgs{Mode: mode, Posns: posns, First: first, Max: max}
+	if err := c.rpc.Call(ServiceName+".Query", args, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
@@ -0,0 +1,174 @@
+;;; guru.el --- Emacs interface to guru  -*- lexical-binding: t -*-
+
+;; Copyright 2018 The Go Authors. All rights reserved.
+;; Use of this source code is governed by a BSD-style
+;; license that can be found in the LICENSE file.
+
+;;; Commentary:
+
+;; This file provides an Emacs interface to guru.  Each command runs
+;; the corresponding guru query on the identifier at point, or on the
+;; active region, and shows the results in the *oracle* buffer, whose
+;; compilation mode makes each file:line.col position a link to the
+;; source.  Within the buffer, n and p move to the next and previous
+;; result, and RET visits it; elsewhere, next-error and
+;; previous-error (M-g n and M-g p, or C-c C-o n and C-c C-o p in Go
+;; buffers) step through the results.
+;;
+;; Installation: put this file on `load-path' and add to ~/.emacs
+;;
+;;	(require 'guru)
+;;	(add-hook 'go-mode-hook #'guru-mode)
+;;
+;; and ensure that guru is on $PATH (or set `guru-command').
+;;
+;; Key bindings of `guru-mode', after the prefix C-c C-o:
+;;
+;;	a allocs       c callers      e callees      g callgraph
+;;	s callstack    k deadcode     j definition   d describe
+;;	x effects      f freevars     h hierarchy    i implements
+;;	m importers    l lockers      ! panics       P peers
+;;	t pointsto     r referrers    R rename-check S shared
+;;	E whicherrs    n next result  p previous result
+;;
+;; The contents of modified buffers are passed to guru using its
+;; -modified flag, so unsaved changes are observed.
+
+;;; Code:
+
+(require 'compile)
+
+(defgroup guru nil
+  "Go source code analysis using guru."
+  :group 'tools)
+
+(defcustom guru-command "guru"
+  "The guru executable."
+  :type 'string
+  :group 'guru)
+
+(defcustom guru-scope nil
+  "The -scope flag of queries, e.g. \"golang.org/x/tools/cmd/guru\",
+or nil for guru's default."
+  :type '(choice (const :tag "Default" nil) string)
+  :group 'guru)
+
+(defconst guru-buffer-name "*oracle*"
+  "The name of the buffer of the results of guru queries.")
+
+(define-compilation-mode guru-output-mode "Guru"
+  "Major mode for the results of guru queries.
+Each file:line.col position, as written by guru -editor=emacs, is
+a link to the source.")
+
+(defvar guru--modes nil
+  "The names of the guru queries that have commands.")
+
+(defun guru--pos ()
+  "Return the guru position of the active region, or of point."
+  (let ((file (expand-file-name buffer-file-name)))
+    (if (use-region-p)
+        (format "%s:#%d,#%d" file
+                (1- (position-bytes (region-beginning)))
+                (1- (position-bytes (region-end))))
+      (format "%s:#%d" file (1- (position-bytes (point)))))))
+
+(defun guru--modified ()
+  "Return the -modified archive of the modified Go buffers."
+  (let (entries)
+    (dolist (buf (buffer-list))
+      (with-current-buffer buf
+        (when (and buffer-file-name
+                   (buffer-modified-p)
+                   (string-suffix-p ".go" buffer-file-name))
+          (let ((text (encode-coding-string
+                       (buffer-substring-no-properties (point-min) (point-max))
+                       'utf-8)))
+            (push (concat (encode-coding-string
+                           (expand-file-name buffer-file-name) 'utf-8)
+                          "\n" (number-to-string (length text)) "\n" text)
+                  entries)))))
+    (apply #'concat (nreverse entries))))
+
+(defun guru-query (mode)
+  "Run the guru query MODE on the active region, or at point, and
+show its results in the *oracle* buffer."
+  (interactive (list (completing-read "Guru query: " guru--modes nil t)))
+  (unless buffer-file-name
+    (error "Buffer is not visiting a file"))
+  (let ((pos (guru--pos))
+        (archive (guru--modified))
+        (dir default-directory)
+        (args (append (list "-editor=emacs" "-modified")
+                      (and guru-scope (list (concat "-scope=" guru-scope)))))
+        (buf (get-buffer-create guru-buffer-name)))
+    (with-current-buffer buf
+      (let ((inhibit-read-only t))
+        (erase-buffer)
+        (setq default-directory dir)
+        (insert (format "guru %s %s\n\n" mode pos))
+        (let ((coding-system-for-write 'binary)
+              (coding-system-for-read 'utf-8))
+          (apply #'call-process-region archive nil guru-command nil t nil
+                 (append args (list mode pos)))))
+      (guru-output-mode)
+      (goto-char (point-min)))
+    (setq next-error-last-buffer buf)
+    (display-buffer buf)))
+
+(defvar guru-command-map (make-sparse-keymap)
+  "Keymap of the guru commands, after the prefix of `guru-mode'.")
+
+(defmacro guru--define-query (mode key)
+  "Define the command guru-MODE, bound to KEY in `guru-command-map'."
+  (let ((name (intern (concat "guru-" mode))))
+    `(progn
+       (defun ,name ()
+         ,(format "Run the guru %s query on the active region, or at point." mode)
+         (interactive)
+         (guru-query ,mode))
+       (add-to-list 'guru--modes ,mode t)
+       (define-key guru-command-map ,key #',name))))
+
+(guru--define-query "allocs" "a")
+(guru--define-query "callers" "c")
+(guru--define-query "callees" "e")
+(guru--define-query "callgraph" "g")
+(guru--define-query "callstack" "s")
+(guru--define-query "deadcode" "k")
+(guru--define-query "definition" "j")
+(guru--define-query "describe" "d")
+(guru--define-query "effects" "x")
+(guru--define-query "freevars" "f")
+(guru--define-query "hierarchy" "h")
+(guru--define-query "implements" "i")
+(guru--define-query "importers" "m")
+(guru--define-query "lockers" "l")
+(guru--define-query "panics" "!")
+(guru--define-query "peers" "P")
+(guru--define-query "pointsto" "t")
+(guru--define-query "referrers" "r")
+(guru--define-query "rename-check" "R")
+(guru--define-query "shared" "S")
+(guru--define-query "whicherrs" "E")
+
+(define-key guru-command-map "n" #'next-error)
+(define-key guru-command-map "p" #'previous-error)
+
+(defvar guru-mode-map
+  (let ((map (make-sparse-keymap)))
+    (define-key map (kbd "C-c C-o") guru-command-map)
+    map)
+  "Keymap of `guru-mode'.")
+
+;;;###autoload
+(define-minor-mode guru-mode
+  "Minor mode binding the guru queries in Go buffers.
+
+\\{guru-command-map}"
+  :lighter " guru"
+  :keymap guru-mode-map)
+
+(provide 'guru)
+
+;;; guru.el ends here
@@ -0,0 +1,79 @@
+" Copyright 2018 The Go Authors. All rights reserved.
+" Use of this source code is governed by a BSD-style
+" license that can be found in the LICENSE file.
+"
+" This file provides a Vim interface to guru.  Each command runs the
+" corresponding guru query on the identifier under the cursor, or on
+" the visual selection, and loads the results into the quickfix list.
+"
+" Installation: copy this file to ~/.vim/ftplugin/go/guru.vim and
+" ensure that guru is on $PATH (or set g:guru_command).
+"
+" Commands:
+"	:GuruAllocs     :GuruCallees    :GuruCallers    :GuruCallgraph
+"	:GuruCallstack  :GuruDeadcode   :GuruDefinition :GuruDescribe
+"	:GuruFreevars   :GuruImplements :GuruLockers    :GuruPeers
+"	:GuruPointsto   :GuruReferrers  :GuruWhicherrs
+"
+" Variables:
+"	g:guru_command  the guru executable (default "guru")
+"	g:guru_scope    the -scope flag, e.g. "golang.org/x/tools/cmd/guru"
+"
+" The contents of modified buffers are passed to guru using its
+" -modified flag, so unsaved changes are observed.
+
+if exists("b:did_ftplugin_guru")
+  finish
+endif
+let b:did_ftplugin_guru = 1
+
+" s:pos returns the guru position of the cursor, or of the most
+" recent visual selection if range is nonzero.
+function! s:pos(range) abort
+  let l:file = expand('%:p')
+  if a:range
+    let l:start = line2byte(line("'<")) + col("'<") - 2
+    let l:end = line2byte(line("'>")) + col("'>") - 1
+    return printf('%s:#%d,#%d', l:file, l:start, l:end)
+  endif
+  return printf('%s:#%d', l:file, line2byte(line('.')) + col('.') - 2)
+endfunction
+
+" s:modified returns the -modified archive for the current buffer.
+function! s:modified() abort
+  let l:text = join(getline(1, '$'), "\n") . "\n"
+  return expand('%:p') . "\n" . strlen(l:text) . "\n" . l:text
+endfunction
+
+function! s:guru(mode, range) abort
+  let l:cmd = [get(g:, 'guru_command', 'guru'), '-editor=vim', '-modified']
+  if exists('g:guru_scope')
+    call add(l:cmd, '-scope=' . g:guru_scope)
+  endif
+  call extend(l:cmd, [a:mode, s:pos(a:range)])
+
+  let l:out = system(join(map(l:cmd, 'shellescape(v:val)')), s:modified())
+  if v:shell_error
+    echohl ErrorMsg | echomsg substitute(l:out, '\n$', '', '') | echohl None
+    return
+  endif
+
+  " Lines without a position ("-: text") are kept as plain text.
+  let l:efm = &errorformat
+  let &errorformat = '%f:%l:%c: %m,%+G%m'
+  try
+    cgetexpr l:out
+  finally
+    let &errorformat = l:efm
+  endtry
+  copen
+endfunction
+
+for s:mode in ['allocs', 'callees', 'callers', 'callgraph', 'callstack',
+      \ 'deadcode', 'definition', 'describe', 'effects', 'freevars', 'hierarchy',
+      \ 'implements', 'importers', 'lockers', 'panics', 'peers', 'pointsto',
+      \ 'referrers', 'rename-check', 'shared', 'whicherrs']
+  execute printf('command! -buffer -range Guru%s call <SID>guru(%s, <range>)',
+        \ toupper(s:mode[0]) . s:mode[1:], string(s:mode))
+endfor
+unlet s:mode
@@ -0,0 +1,277 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+)
+
+// allocs reports where the selected composite literal, new or make
+// call, or variable is allocated---on the heap, on the stack, or
+// statically---and, for a variable of reference type, the allocation
+// sites of the objects to which it may point, according to the
+// pointer analysis.
+//
+// The heap/stack distinction is that of the SSA builder, which is
+// more conservative than the compiler's escape analysis: it places on
+// the heap every variable whose address is taken or that is captured
+// by a function literal.
+func allocs(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	// The query is either a variable or an allocating expression.
+	var obj *types.Var
+	var site ast.Expr
+	path := qpos.path
+	if id, ok := path[0].(*ast.Ident); ok {
+		if v, ok := qpos.info.ObjectOf(id).(*types.Var); ok && !v.IsField() {
+			obj = v
+		}
+	}
+	if obj == nil {
+		site = findAllocSite(qpos)
+		if site == nil {
+			return nil, fmt.Errorf("there is no variable, composite literal, new or make call here")
+		}
+		// The SSA builder initializes a variable of struct or array
+		// type directly from a literal, so the literal's storage
+		// is that of the variable.
+		if lit, ok := site.(*ast.CompositeLit); ok {
+			if v, vpath := assignedVar(qpos, lit); v != nil {
+				obj, path = v, vpath
+			}
+		}
+	}
+
+	res := &allocsResult{qpos: qpos}
+	var value ssa.Value // the variable or its address, if it may point to something
+	var isAddr bool
+	if obj != nil {
+		res.typ = obj.Type()
+		v, addr, err := ssaValueForIdent(a.prog, qpos.info, obj, path)
+		if err != nil {
+			return nil, err // e.g. trivially dead code
+		}
+		if addr {
+			res.storage, res.pos = storageOf(v)
+		} else {
+			res.storage = "stack" // lifted to a virtual register
+			res.pos = obj.Pos()
+		}
+		if _, ok := v.(*ssa.Const); ok {
+			res.pointer = true // nil here
+		} else if pointer.CanPoint(res.typ) {
+			value, isAddr = v, addr
+		}
+	} else {
+		res.typ = qpos.info.TypeOf(site)
+		v, err := ssaValueForSite(a.prog, qpos, site)
+		if err != nil {
+			return nil, err
+		}
+		res.storage, res.pos = storageOf(v)
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	if value != nil {
+		addPTAQuery(a, value, isAddr)
+	}
+
+	return func() error {
+		if value != nil {
+			ptrs, err := pointsToResults(a.prog.Fset, a.ptares, value, isAddr)
+			if err != nil {
+				return err // e.g. analytically unreachable
+			}
+			res.pointer = true
+			for _, ptr := range ptrs {
+				res.labels = append(res.labels, ptr.labels...)
+			}
+			res.snippets = labelSnippets(a.lprog, res.labels)
+		}
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// findAllocSite returns the innermost composite literal, or call of
+// new or make, enclosing the query position, or nil if there is none.
+func findAllocSite(qpos *queryPos) ast.Expr {
+	for _, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			return n
+		case *ast.CallExpr:
+			if id, ok := unparen(n.Fun).(*ast.Ident); ok {
+				if b, ok := qpos.info.Uses[id].(*types.Builtin); ok && (b.Name() == "new" || b.Name() == "make") {
+					return n
+				}
+			}
+		case ast.Stmt, ast.Decl:
+			return nil
+		}
+	}
+	return nil
+}
+
+// assignedVar returns the local variable, and the path to its
+// identifier, that is initialized or assigned by the composite literal,
+// or nil if the literal is not the operand of such a statement.
+func assignedVar(qpos *queryPos, lit *ast.CompositeLit) (*types.Var, []ast.Node) {
+	var parent ast.Node
+	for i, n := range qpos.path {
+		if n == lit && i+1 < len(qpos.path) {
+			parent = qpos.path[i+1]
+			break
+		}
+	}
+	var lhs, rhs []ast.Expr
+	switch parent := parent.(type) {
+	case *ast.AssignStmt:
+		lhs, rhs = parent.Lhs, parent.Rhs
+	case *ast.ValueSpec:
+		for _, id := range parent.Names {
+			lhs = append(lhs, id)
+		}
+		rhs = parent.Values
+	}
+	if len(lhs) != len(rhs) {
+		return nil, nil
+	}
+	for i, e := range rhs {
+		if e != lit {
+			continue
+		}
+		id, ok := lhs[i].(*ast.Ident)
+		if !ok {
+			return nil, nil
+		}
+		v, ok := qpos.info.ObjectOf(id).(*types.Var)
+		if !ok || v.Parent() == v.Pkg().Scope() {
+			return nil, nil // not a local
+		}
+		file := qpos.path[len(qpos.path)-1].(*ast.File)
+		path, _ := astutil.PathEnclosingInterval(file, id.Pos(), id.End())
+		return v, path
+	}
+	return nil, nil
+}
+
+// ssaValueForSite returns the SSA instruction that allocates the
+// storage for the composite literal or new or make call.
+func ssaValueForSite(prog *ssa.Program, qpos *queryPos, site ast.Expr) (ssa.Value, error) {
+	pkg := prog.Package(qpos.info.Pkg)
+	pkg.Build()
+
+	fn := ssa.EnclosingFunction(pkg, qpos.path)
+	if fn == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	// The SSA builder positions each allocation at the
+	// literal's left brace or the call's left paren.
+	var pos token.Pos
+	switch site := site.(type) {
+	case *ast.CompositeLit:
+		pos = site.Lbrace
+	case *ast.CallExpr:
+		pos = site.Lparen
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch v := instr.(type) {
+			case *ssa.Alloc, *ssa.MakeMap, *ssa.MakeChan, *ssa.MakeSlice:
+				if instr.Pos() == pos {
+					return v.(ssa.Value), nil
+				}
+			}
+		}
+	}
+	return nil, errorf(codeAnalysis, "no allocation for this expression in %s (it may be held in registers)", fn)
+}
+
+// storageOf returns the kind of storage allocated by v---"heap",
+// "stack", or "global"---and the position of the allocation.
+func storageOf(v ssa.Value) (string, token.Pos) {
+	switch v := v.(type) {
+	case *ssa.Alloc:
+		if v.Heap {
+			return "heap", v.Pos()
+		}
+		return "stack", v.Pos()
+	case *ssa.Global:
+		return "global", v.Pos()
+	case *ssa.FreeVar:
+		// A captured variable, allocated by the enclosing function.
+		return "heap", v.Pos()
+	}
+	return "heap", v.Pos() // make(map), make(chan), make([]T)
+}
+
+type allocsResult struct {
+	resultSource
+	qpos     *queryPos
+	typ      types.Type       // type of the selected entity
+	storage  string           // "heap", "stack", or "global"
+	pos      token.Pos        // position of the allocation or variable
+	pointer  bool             // whether the points-to set was computed
+	labels   []*pointer.Label // objects to which the variable may point
+	snippets labelSnippetMap  // source text of the labels' allocation sites
+}
+
+func (r *allocsResult) PrintPlain(printf printfFunc) {
+	switch r.storage {
+	case "global":
+		printf(r.pos, "this %s is allocated statically", r.qpos.typeString(r.typ))
+	default:
+		printf(r.pos, "this %s is allocated on the %s", r.qpos.typeString(r.typ), r.storage)
+	}
+	if r.pointer {
+		if len(r.labels) > 0 {
+			printf(r.qpos, "it may point to objects allocated here:")
+			printLabels(printf, r.labels, r.snippets, "\t")
+		} else {
+			printf(r.qpos, "it may not point to anything.")
+		}
+	}
+}
+
+func (r *allocsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *allocsResult) serialize(s *serializer) interface{} {
+	allocs := &serial.Allocs{
+		Version: serial.Version,
+		Pos:     s.pos(r.qpos.start),
+		Type:    r.qpos.typeString(r.typ),
+		Storage: r.storage,
+		Site:    s.pos(r.pos),
+	}
+	for _, l := range r.labels {
+		allocs.Labels = append(allocs.Labels, serial.PointsToLabel{
+			Pos:     s.pos(l.Pos()),
+			Desc:    l.String(),
+			Snippet: r.snippets[l],
+		})
+	}
+	allocs.Offsets = s.offsets()
+	return allocs
+}
@@ -0,0 +1,20 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.13
+
+package guru_test
+
+import (
+	"testing"
+	"time"
+)
+
+// reportPhases logs the time of each phase per query, since custom
+// benchmark metrics require Go 1.13.
+func reportPhases(b *testing.B, phases map[string]time.Duration) {
+	for _, name := range sortedPhases(phases) {
+		b.Logf("%s: %v/op", name, phases[name]/time.Duration(b.N))
+	}
+}
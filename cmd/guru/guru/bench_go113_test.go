@@ -0,0 +1,19 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.13
+
+package guru_test
+
+import (
+	"testing"
+	"time"
+)
+
+// reportPhases reports the time of each phase per query as a metric.
+func reportPhases(b *testing.B, phases map[string]time.Duration) {
+	for _, name := range sortedPhases(phases) {
+		b.ReportMetric(float64(phases[name].Nanoseconds())/float64(b.N), name+"-ns/op")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru_test
+
+// This file defines benchmarks of the latency of queries.
+//
+// Run them with:
+// 	% go test golang.org/x/tools/cmd/guru/guru -run=NONE -bench=Query
+//
+// Each query mode is measured on a synthetic program of a few dozen
+// packages, and on a small server that uses the standard library's
+// net/http package, both cold (loading and analyzing the program
+// afresh for each query) and warm (with a Server, or for describe and
+// implements, which do not use one, with a populated -cache).  Besides
+// the total time, the time of each phase of the analysis is reported
+// per query: load-ns/op for loading and type checking, ssa-ns/op for
+// SSA construction, and pta-ns/op for the pointer analysis.
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/guru"
+)
+
+// A benchProgram is a program, and a position for each query mode.
+type benchProgram struct {
+	gopath string
+	scope  []string
+	posns  map[string]string // position by mode; "" for callgraph
+}
+
+// benchModes are the query modes measured by the benchmarks.
+var benchModes = []string{"describe", "implements", "callers", "peers", "callgraph"}
+
+// ptaModes are the modes of benchModes that use a Server when warm.
+var ptaModes = map[string]bool{"callers": true, "peers": true, "callgraph": true}
+
+// benchPos returns the position of the first occurrence of substr in
+// the named file, whose content is src, in command-line syntax.
+func benchPos(b *testing.B, filename, src, substr string) string {
+	i := strings.Index(src, substr)
+	if i < 0 {
+		b.Fatalf("no %q in %s", substr, filename)
+	}
+	return fmt.Sprintf("%s:#%d,#%d", filename, i, i+len(substr))
+}
+
+// syntheticProgram returns a program of npkgs packages, each importing
+// the previous one, and a main package.  Each package declares an
+// interface, implemented by a type whose method calls that of the
+// previous package dynamically, and functions that call each other
+// statically.  It imports nothing from the standard library, so that
+// its size is independent of the Go release.
+func syntheticProgram(b *testing.B) *benchProgram {
+	const npkgs, nfuncs = 30, 30
+	files := make(map[string]string)
+	var first, last string // the sources of p0 and of p<npkgs-1>
+	for i := 0; i < npkgs; i++ {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "package p%d\n\n", i)
+		if i > 0 {
+			fmt.Fprintf(&buf, "import \"app/p%d\"\n\n", i-1)
+		}
+		buf.WriteString(`type I interface{ M(x int) int }
+
+type T struct {
+	next I
+	c    chan int
+}
+
+func (t *T) M(x int) int {
+	if t.next != nil {
+		return t.next.M(x + 1)
+	}
+	return x
+}
+
+func (t *T) Send(x int) { t.c <- x }
+
+func (t *T) Recv() int { return <-t.c }
+
+func New() *T {
+	t := &T{c: make(chan int, 1)}
+`)
+		if i > 0 {
+			fmt.Fprintf(&buf, "\tt.next = p%d.New()\n", i-1)
+		}
+		buf.WriteString("\treturn t\n}\n")
+		for j := 0; j < nfuncs; j++ {
+			fmt.Fprintf(&buf, "\nfunc F%d(x int) int {\n", j)
+			switch {
+			case j > 0:
+				fmt.Fprintf(&buf, "\treturn F%d(x) + New().M(x)\n", j-1)
+			case i > 0:
+				fmt.Fprintf(&buf, "\treturn p%d.F%d(x)\n", i-1, nfuncs-1)
+			default:
+				buf.WriteString("\treturn x\n")
+			}
+			buf.WriteString("}\n")
+		}
+		files[fmt.Sprintf("src/app/p%d/p.go", i)] = buf.String()
+		if i == 0 {
+			first = buf.String()
+		}
+		last = buf.String()
+	}
+	files["src/app/main.go"] = fmt.Sprintf(`package main
+
+import "app/p%[1]d"
+
+func main() {
+	t := p%[1]d.New()
+	var i p%[1]d.I = t
+	println(i.M(1))
+	go t.Send(1)
+	println(t.Recv())
+	println(p%[1]d.F%[2]d(1))
+}
+`, npkgs-1, nfuncs-1)
+
+	gopath := makeGOPATH(b, files)
+	p0 := filepath.Join(gopath, "src/app/p0/p.go")
+	plast := filepath.Join(gopath, fmt.Sprintf("src/app/p%d/p.go", npkgs-1))
+	return &benchProgram{
+		gopath: gopath,
+		scope:  []string{"app"},
+		posns: map[string]string{
+			"describe":   benchPos(b, plast, last, "New"),
+			"implements": benchPos(b, p0, first, "I"),
+			"callers":    benchPos(b, p0, first, "return x\n"),
+			"peers":      benchPos(b, plast, last, "<-"),
+			"callgraph":  "",
+		},
+	}
+}
+
+// stdProgram returns a small HTTP server, whose analysis is dominated
+// by the packages of the standard library that it uses.  The query
+// positions are in its own file, so that they are independent of the
+// Go release.
+func stdProgram(b *testing.B) *benchProgram {
+	const src = `package main
+
+import (
+	"io"
+	"net/http"
+)
+
+type handler struct{ c chan int }
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.c <- 1
+	io.WriteString(w, "ok")
+}
+
+func main() {
+	h := handler{make(chan int, 1)}
+	go http.ListenAndServe(":0", h)
+	<-h.c
+}
+`
+	gopath := makeGOPATH(b, map[string]string{"src/app/main.go": src})
+	filename := filepath.Join(gopath, "src/app/main.go")
+	return &benchProgram{
+		gopath: gopath,
+		scope:  []string{"app"},
+		posns: map[string]string{
+			"describe":   benchPos(b, filename, src, "ListenAndServe"),
+			"implements": benchPos(b, filename, src, "handler"),
+			"callers":    benchPos(b, filename, src, "io.WriteString"),
+			"peers":      benchPos(b, filename, src, "<-h.c"),
+			"callgraph":  "",
+		},
+	}
+}
+
+// BenchmarkQuery measures the latency of each query mode, cold and
+// warm, on each benchmark program.
+func BenchmarkQuery(b *testing.B) {
+	for _, prog := range []struct {
+		name string
+		make func(*testing.B) *benchProgram
+	}{
+		{"synthetic", syntheticProgram},
+		{"std", stdProgram},
+	} {
+		prog := prog
+		b.Run(prog.name, func(b *testing.B) {
+			p := prog.make(b)
+			defer os.RemoveAll(p.gopath)
+			for _, mode := range benchModes {
+				b.Run(mode+"/cold", func(b *testing.B) { benchCold(b, p, mode) })
+				b.Run(mode+"/warm", func(b *testing.B) { benchWarm(b, p, mode) })
+			}
+		})
+	}
+}
+
+// benchQuery returns a query of the specified mode on program p,
+// whose phases are timed by phases.
+func benchQuery(p *benchProgram, mode string, phases *phaseTimer) *guru.Query {
+	var buildContext = build.Default
+	buildContext.GOPATH = p.gopath
+	return &guru.Query{
+		Pos:      p.posns[mode],
+		Build:    &buildContext,
+		Scope:    p.scope,
+		Output:   func(*token.FileSet, guru.QueryResult) {},
+		Progress: phases.progress,
+	}
+}
+
+// benchCold measures queries that load and analyze the program afresh.
+func benchCold(b *testing.B, p *benchProgram, mode string) {
+	phases := newPhaseTimer()
+	q := benchQuery(p, mode, phases)
+	for i := 0; i < b.N; i++ {
+		q2 := *q
+		if err := guru.Run(mode, &q2); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportPhases(b, phases.total)
+}
+
+// benchWarm measures queries on a program already loaded and analyzed
+// by a Server, or for the modes that do not use one, whose dependencies
+// are in the cache.  The first query, which warms the server or the
+// cache, is not measured.
+func benchWarm(b *testing.B, p *benchProgram, mode string) {
+	phases := newPhaseTimer()
+	q := benchQuery(p, mode, phases)
+	run := func() error { q2 := *q; return guru.Run(mode, &q2) }
+	if ptaModes[mode] {
+		s, err := guru.NewServer(q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		run = func() error {
+			_, _, err := s.Query(mode, q.Pos)
+			return err
+		}
+	} else {
+		cache, err := ioutil.TempDir("", "guru-cache")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer os.RemoveAll(cache)
+		q.Cache = cache
+	}
+	if err := run(); err != nil {
+		b.Fatal(err)
+	}
+	phases.reset()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportPhases(b, phases.total)
+}
+
+// A phaseTimer accumulates the durations of the phases of queries,
+// as reported by their progress events.
+type phaseTimer struct {
+	start map[string]time.Time     // start of each phase in progress
+	total map[string]time.Duration // total duration, by benchPhase
+}
+
+func newPhaseTimer() *phaseTimer {
+	t := new(phaseTimer)
+	t.reset()
+	return t
+}
+
+func (t *phaseTimer) reset() {
+	t.start = make(map[string]time.Time)
+	t.total = make(map[string]time.Duration)
+}
+
+func (t *phaseTimer) progress(e guru.ProgressEvent) {
+	switch {
+	case e.Package != "" || e.Step != "":
+		// Progress within a phase.
+	case !e.Done:
+		t.start[e.Phase] = time.Now()
+	default:
+		t.total[benchPhase(e.Phase)] += time.Since(t.start[e.Phase])
+	}
+}
+
+// benchPhase returns the name under which the duration of the phase
+// of the analysis is reported.
+func benchPhase(phase string) string {
+	switch phase {
+	case "load", "reload":
+		return "load"
+	case "create SSA", "build SSA":
+		return "ssa"
+	case "pointer analysis":
+		return "pta"
+	}
+	return strings.Replace(phase, " ", "-", -1)
+}
+
+// sortedPhases returns the names of the phases, in order.
+func sortedPhases(phases map[string]time.Duration) []string {
+	var names []string
+	for name := range phases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,372 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the on-disk cache of type information (-cache),
+// and the reuse of the compiled export data of packages (-export),
+// which allow the queries that need only the types of the
+// dependencies of the query package to skip parsing and
+// type-checking them.
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/loader"
+)
+
+// cacheVersion distinguishes the cache entries of incompatible
+// versions of guru.
+const cacheVersion = "guru-cache-1"
+
+// A pkgCache is an on-disk cache of the export data of type-checked
+// packages.  Each entry is keyed by a hash of the package's path, the
+// contents of its files, the keys of its imports, and the relevant
+// build configuration, so an entry is never stale, only unused.
+//
+// With -export, a package that has no entry is also reused from the
+// export data of its compiled package object, as installed by
+// "go install", if that is newer than the package's files and the
+// objects of its dependencies.  Objects compiled in another build
+// configuration, such as with other build tags, are not detected.
+//
+// The export data records positions only to the line, so objects of
+// packages read from the cache have imprecise columns.
+type pkgCache struct {
+	dir     string // "" if there are no entries, only objects
+	objects bool   // whether to reuse compiled package objects
+	ctxt    *build.Context
+	fset    *token.FileSet
+	initial map[string]bool                // packages that are never reused
+	bps     map[string]*build.Package      // build information by import path
+	keys    map[string]string              // keys by import path; "" if not cacheable
+	sources map[string]string              // files of export data by import path; "" if none
+	imports map[string]*types.Package      // packages read from the cache
+	infos   map[string]*loader.PackageInfo // packages that may be reused
+}
+
+// newPkgCache returns the cache specified by q, after arranging for
+// lconf.Load to consult it, or nil if q specifies neither a cache nor
+// the reuse of compiled package objects.
+// It must be called once lconf is otherwise configured.
+func newPkgCache(q *Query, lconf *loader.Config) *pkgCache {
+	if q.Cache == "" && !q.ExportData {
+		return nil
+	}
+	if lconf.Fset == nil {
+		lconf.Fset = token.NewFileSet()
+	}
+	c := &pkgCache{
+		dir:     q.Cache,
+		objects: q.ExportData,
+		ctxt:    lconf.Build,
+		fset:    lconf.Fset,
+		initial: make(map[string]bool),
+		bps:     make(map[string]*build.Package),
+		keys:    make(map[string]string),
+		sources: make(map[string]string),
+		imports: map[string]*types.Package{"unsafe": types.Unsafe},
+		infos:   make(map[string]*loader.PackageInfo),
+	}
+	for path := range lconf.ImportPkgs {
+		c.initial[path] = true
+	}
+	c.prepare(lconf)
+	lconf.Reuse = c.reuse
+	return c
+}
+
+// prepare determines, before the load, which of the dependencies of
+// the packages specified by lconf may be reused, and reads their export
+// data, so that reuse, which the loader calls with its lock held, need
+// not find packages, hash files or decode export data.
+func (c *pkgCache) prepare(lconf *loader.Config) {
+	seen := make(map[string]bool)
+	for path, tests := range lconf.ImportPkgs {
+		bp := c.build(path)
+		if bp == nil {
+			continue
+		}
+		imports := c.imported(bp)
+		if tests {
+			imports = append(imports, c.canonical(bp.Dir, bp.TestImports)...)
+			imports = append(imports, c.canonical(bp.Dir, bp.XTestImports)...)
+		}
+		for _, imp := range imports {
+			c.visit(imp, seen)
+		}
+	}
+	for _, spec := range lconf.CreatePkgs {
+		for _, f := range spec.Files {
+			c.visitImports(lconf.Fset.File(f.Pos()).Name(), f, seen)
+		}
+		for _, name := range spec.Filenames {
+			f, err := buildutil.ParseFile(token.NewFileSet(), c.ctxt, nil, lconf.Cwd, name, parser.ImportsOnly)
+			if err == nil {
+				c.visitImports(name, f, seen)
+			}
+		}
+	}
+}
+
+// visitImports visits the packages imported by the file f, named
+// filename, of a package created from a list of files.
+func (c *pkgCache) visitImports(filename string, f *ast.File, seen map[string]bool) {
+	var imports []string
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			imports = append(imports, path)
+		}
+	}
+	for _, imp := range c.canonical(filepath.Dir(filename), imports) {
+		c.visit(imp, seen)
+	}
+}
+
+// visit reads the export data of the package, unless it is an initial
+// package, or its own or that of any of its dependencies, which it
+// visits first, is unavailable; and reports whether it was read.
+func (c *pkgCache) visit(path string, seen map[string]bool) bool {
+	if seen[path] {
+		return c.infos[path] != nil // false in case of cycles
+	}
+	seen[path] = true
+	ok := !c.initial[path] && c.source(path) != ""
+	for _, imp := range c.imported(c.build(path)) {
+		if !c.visit(imp, seen) {
+			ok = false
+		}
+	}
+	if ok {
+		if pkg, err := c.read(path); err == nil {
+			c.infos[path] = &loader.PackageInfo{Pkg: pkg, Importable: true}
+			return true
+		}
+	}
+	return false
+}
+
+// reuse returns the PackageInfo, without syntax, of the package if its
+// export data was read by prepare, or nil.
+// It implements loader.Config.Reuse.
+func (c *pkgCache) reuse(path string) *loader.PackageInfo {
+	if path == "unsafe" {
+		return &loader.PackageInfo{Pkg: types.Unsafe, Importable: true}
+	}
+	return c.infos[path]
+}
+
+// source returns the name of the file of the package's export data:
+// its cache entry, if any, or else, with -export, its compiled package
+// object, if up to date; or "" if there is none.
+func (c *pkgCache) source(path string) string {
+	if name, ok := c.sources[path]; ok {
+		return name
+	}
+	c.sources[path] = "" // (in case of cycles)
+	var name string
+	if c.dir != "" {
+		if key := c.key(path); key != "" {
+			if _, err := os.Stat(filepath.Join(c.dir, key)); err == nil {
+				name = filepath.Join(c.dir, key)
+			}
+		}
+	}
+	if name == "" && c.objects {
+		name = c.object(path)
+	}
+	c.sources[path] = name
+	return name
+}
+
+// object returns the name of the compiled package object of the
+// package, if it is newer than each of the package's files, and than
+// the object of each of its dependencies reused from one; or "" if
+// there is none.
+func (c *pkgCache) object(path string) string {
+	bp := c.build(path)
+	if bp == nil || bp.PkgObj == "" {
+		return ""
+	}
+	built := modTime(bp.PkgObj)
+	if built.IsZero() {
+		return ""
+	}
+	for _, names := range [][]string{bp.GoFiles, bp.CgoFiles} {
+		for _, name := range names {
+			if !modTime(filepath.Join(bp.Dir, name)).Before(built) {
+				return "" // stale, or missing
+			}
+		}
+	}
+	for _, imp := range c.imported(bp) {
+		if obj := c.build(imp); obj != nil && c.source(imp) == obj.PkgObj && modTime(obj.PkgObj).After(built) {
+			return "" // compiled against an older dependency
+		}
+	}
+	return bp.PkgObj
+}
+
+// modTime returns the modification time of the named file, or the zero
+// time if it cannot be found.
+func modTime(name string) time.Time {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// read reads the package's export data from its source, extracting it
+// from the package object, if it is one.
+func (c *pkgCache) read(path string) (*types.Package, error) {
+	name := c.source(path)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if bp := c.build(path); bp != nil && name == bp.PkgObj {
+		if r, err = gcexportdata.NewReader(f); err != nil {
+			return nil, err
+		}
+	}
+	return gcexportdata.Read(r, c.fset, c.imports, path)
+}
+
+// key returns the cache key of the package, or "" if it cannot be
+// cached, for example because it uses cgo or cannot be found.
+func (c *pkgCache) key(path string) string {
+	if key, ok := c.keys[path]; ok {
+		return key
+	}
+	c.keys[path] = "" // (in case of cycles)
+	bp := c.build(path)
+	if bp == nil || len(bp.CgoFiles) > 0 {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s %s %s %v %v %v\n", cacheVersion, runtime.Version(),
+		path, c.ctxt.GOOS, c.ctxt.GOARCH, c.ctxt.CgoEnabled, c.ctxt.BuildTags, c.ctxt.ReleaseTags)
+	for _, name := range bp.GoFiles {
+		rc, err := buildutil.OpenFile(c.ctxt, filepath.Join(bp.Dir, name))
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "%s\n", name)
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return ""
+		}
+	}
+	for _, imp := range c.imported(bp) {
+		key := c.key(imp)
+		if key == "" {
+			return ""
+		}
+		fmt.Fprintf(h, "%s %s\n", imp, key)
+	}
+	key := fmt.Sprintf("%x", h.Sum(nil))
+	c.keys[path] = key
+	return key
+}
+
+// build returns the build information of the package, or nil if it
+// cannot be found.
+func (c *pkgCache) build(path string) *build.Package {
+	bp, ok := c.bps[path]
+	if !ok {
+		var err error
+		if bp, err = c.ctxt.Import(path, "", 0); err != nil {
+			bp = nil
+		}
+		c.bps[path] = bp
+	}
+	return bp
+}
+
+// imported returns the canonical paths of the packages imported by bp,
+// except unsafe.
+func (c *pkgCache) imported(bp *build.Package) []string {
+	if bp == nil {
+		return nil
+	}
+	return c.canonical(bp.Dir, bp.Imports)
+}
+
+// canonical returns the canonical paths of the packages imports,
+// imported by a file in the directory dir, except unsafe.
+func (c *pkgCache) canonical(dir string, imports []string) []string {
+	var paths []string
+	for _, imp := range imports {
+		if imp == "unsafe" {
+			continue
+		}
+		if bp, err := c.ctxt.Import(imp, dir, build.FindOnly); err == nil {
+			imp = bp.ImportPath
+		}
+		paths = append(paths, imp)
+	}
+	return paths
+}
+
+// save adds to the cache the export data of the importable packages
+// of lprog that were type-checked without errors, except the initial
+// packages, which may have been augmented by their tests.
+// Failures are ignored: the cache is merely an optimization.
+func (c *pkgCache) save(lprog *loader.Program) {
+	if c == nil || c.dir == "" {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	for pkg, info := range lprog.AllPackages {
+		path := pkg.Path()
+		if !info.Importable || len(info.Files) == 0 || len(info.Errors) > 0 || c.initial[path] {
+			continue
+		}
+		key := c.key(path)
+		if key == "" {
+			continue
+		}
+		name := filepath.Join(c.dir, key)
+		if _, err := os.Stat(name); err == nil {
+			continue // already cached
+		}
+		// Write atomically, so that concurrent guru processes
+		// never observe a partial entry.
+		f, err := ioutil.TempFile(c.dir, "tmp")
+		if err != nil {
+			return
+		}
+		err = gcexportdata.Write(f, lprog.Fset, pkg)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), name)
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+}
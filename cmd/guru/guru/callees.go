@@ -0,0 +1,534 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/ssa"
+)
+
+// The callees function reports the possible callees of the function call site
+// identified by the specified source location.
+func callees(q *Query, a *analysis) (func() error, error) {
+	lprog := a.lprog
+
+	qpos, err := parseQueryPos(lprog, q.Pos, true) // needs exact pos
+	if err != nil {
+		return nil, err
+	}
+
+	e, static, err := findCallExpr(qpos)
+	if err != nil {
+		return nil, err
+	}
+	if static != nil {
+		// Deal with obviously static calls before constructing SSA form.
+		// Some static calls may yet require SSA construction,
+		// e.g.  f := func(){}; f().
+		return func() error {
+			q.Output(lprog.Fset, &calleesTypesResult{
+				site:   e,
+				caller: enclosingFunc(qpos),
+				callee: static,
+			})
+			return nil
+		}, nil
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	pkg := a.prog.Package(qpos.info.Pkg)
+	if pkg == nil {
+		return nil, errorf(codeAnalysis, "no SSA package")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	// Ascertain calling function and call site.
+	callerFn := ssa.EnclosingFunction(pkg, qpos.path)
+	if callerFn == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	// Find the call site.
+	site, err := findCallSite(callerFn, e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Avoid running the pointer analysis for static calls.
+	// Dynamic calls require the pointer analysis call graph,
+	// as do static calls of the wrapper of a method value x.M
+	// or method expression T.M, whose callees depend on the
+	// receiver: in the call graph, from which such wrappers are
+	// deleted, the site calls the methods of the receivers.
+	callee := staticCallee(site)
+	if callee != nil && isMethodWrapper(callee) {
+		callee = nil
+	}
+	if callee == nil {
+		a.needCallGraph()
+	}
+
+	return func() error {
+		funcs := []*ssa.Function{callee} // singleton
+		if callee == nil {
+			var err error
+			funcs, err = findCallees(a.cg, site)
+			if err != nil {
+				return err
+			}
+		}
+
+		q.Output(lprog.Fset, &calleesSSAResult{
+			site:  site,
+			funcs: funcs,
+		})
+		return nil
+	}, nil
+}
+
+// calleesCHA reports the possible callees of the selected call site
+// when there is no analysis scope for the pointer analysis.  It loads
+// the packages that depend on the selected one, like implements, and
+// answers from their types, by class hierarchy analysis: a dynamic
+// method call may dispatch to the method of each type that satisfies
+// the interface, and a dynamic function call to any function or
+// function literal of the same type.  The result is an
+// over-approximation.
+func calleesCHA(q *Query) error {
+	lprog, _, err := loadTypeRelations(q, true)
+	if err != nil {
+		return err
+	}
+	qpos, err := parseQueryPos(lprog, q.Pos, true) // needs exact pos
+	if err != nil {
+		return err
+	}
+	e, static, err := findCallExpr(qpos)
+	if err != nil {
+		return err
+	}
+	caller := enclosingFunc(qpos)
+	if static != nil {
+		q.Output(lprog.Fset, &calleesTypesResult{site: e, caller: caller, callee: static})
+		return nil
+	}
+
+	r := &calleesCHAResult{site: e, caller: caller}
+	var sel *types.Selection
+	if funexpr, ok := unparen(e.Fun).(*ast.SelectorExpr); ok {
+		sel = qpos.info.Selections[funexpr]
+	}
+	if sel != nil && sel.Kind() == types.MethodVal {
+		r.desc = "dynamic method call"
+		r.callees = methodCallees(lprog, sel.Obj().(*types.Func))
+	} else {
+		r.desc = "dynamic function call"
+		sig, _ := qpos.info.TypeOf(e.Fun).Underlying().(*types.Signature)
+		if sig == nil {
+			return fmt.Errorf("this is not a call of a function value")
+		}
+		r.callees = funcCallees(lprog, sig)
+	}
+	sort.Slice(r.callees, func(i, j int) bool {
+		x, y := r.callees[i], r.callees[j]
+		if x.pos == y.pos {
+			return x.name < y.name
+		}
+		return lessPos(lprog.Fset, x.pos, y.pos)
+	})
+	q.Output(lprog.Fset, r)
+	return nil
+}
+
+// A chaCallee is a possible callee of a dynamic call according to
+// class hierarchy analysis.
+type chaCallee struct {
+	name string // full name of the function, or a description of a function literal
+	pkg  string // import path of the package of the function
+	pos  token.Pos
+}
+
+// methodCallees returns the concrete methods of the named types of
+// lprog, and of pointers to them, that satisfy the interface whose
+// abstract method is m, and that a call of m may dispatch to.
+func methodCallees(lprog *loader.Program, m *types.Func) []chaCallee {
+	iface := m.Type().(*types.Signature).Recv().Type()
+	seen := make(map[*types.Func]bool)
+	var callees []chaCallee
+	for _, U := range allNamedTypes(lprog) {
+		if isInterface(U) {
+			continue
+		}
+		for _, T := range []types.Type{U, types.NewPointer(U)} {
+			if !types.AssignableTo(T, iface) {
+				continue
+			}
+			obj, _, _ := types.LookupFieldOrMethod(T, false, m.Pkg(), m.Name())
+			if fn, ok := obj.(*types.Func); ok && !seen[fn] {
+				seen[fn] = true
+				callees = append(callees, chaCallee{fn.FullName(), fn.Pkg().Path(), fn.Pos()})
+			}
+			break // the method set of *U includes that of U
+		}
+	}
+	return callees
+}
+
+// funcCallees returns the functions, concrete methods (as method
+// values) and function literals of lprog whose type is identical to
+// sig, which a call of a function value of that type may dispatch to.
+func funcCallees(lprog *loader.Program, sig *types.Signature) []chaCallee {
+	var callees []chaCallee
+	addFunc := func(obj types.Object) {
+		if fn, ok := obj.(*types.Func); ok && types.Identical(fn.Type(), sig) {
+			if recv := fn.Type().(*types.Signature).Recv(); recv == nil || !isInterface(recv.Type()) {
+				callees = append(callees, chaCallee{fn.FullName(), fn.Pkg().Path(), fn.Pos()})
+			}
+		}
+	}
+	for _, info := range lprog.AllPackages {
+		if info.Files == nil {
+			// A package read from the -cache has no syntax;
+			// only its package-level functions and methods are known.
+			scope := info.Pkg.Scope()
+			for _, name := range scope.Names() {
+				obj := scope.Lookup(name)
+				addFunc(obj)
+				if tname, ok := obj.(*types.TypeName); ok && !isAlias(tname) {
+					if named, ok := tname.Type().(*types.Named); ok {
+						for i := 0; i < named.NumMethods(); i++ {
+							addFunc(named.Method(i))
+						}
+					}
+				}
+			}
+			continue
+		}
+		for _, obj := range info.Defs {
+			addFunc(obj)
+		}
+		for _, f := range info.Files {
+			var enclosing string // name of the enclosing function declaration
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncDecl:
+					enclosing = info.Pkg.Path() + ".init"
+					if fn, ok := info.Defs[n.Name].(*types.Func); ok {
+						enclosing = fn.FullName()
+					}
+				case *ast.GenDecl:
+					enclosing = info.Pkg.Path() + ".init"
+				case *ast.FuncLit:
+					if types.Identical(info.TypeOf(n), sig) {
+						callees = append(callees, chaCallee{"function literal in " + enclosing, info.Pkg.Path(), n.Type.Func})
+					}
+				}
+				return true
+			})
+		}
+	}
+	return callees
+}
+
+// findCallExpr returns the function call enclosing the query
+// position, and its callee if the call is obviously static.
+func findCallExpr(qpos *queryPos) (*ast.CallExpr, *types.Func, error) {
+	// Determine the enclosing call for the specified position.
+	var e *ast.CallExpr
+	for _, n := range qpos.path {
+		if e, _ = n.(*ast.CallExpr); e != nil {
+			break
+		}
+	}
+	if e == nil {
+		return nil, nil, fmt.Errorf("there is no function call here")
+	}
+	// TODO(adonovan): issue an error if the call is "too far
+	// away" from the current selection, as this most likely is
+	// not what the user intended.
+
+	// Reject type conversions.
+	if qpos.info.Types[e.Fun].IsType() {
+		return nil, nil, fmt.Errorf("this is a type conversion, not a function call")
+	}
+
+	switch funexpr := unparen(e.Fun).(type) {
+	case *ast.Ident:
+		switch obj := qpos.info.Uses[funexpr].(type) {
+		case *types.Builtin:
+			// Reject calls to built-ins.
+			return nil, nil, fmt.Errorf("this is a call to the built-in '%s' operator", obj.Name())
+		case *types.Func:
+			// This is a static function call
+			return e, obj, nil
+		}
+	case *ast.SelectorExpr:
+		sel := qpos.info.Selections[funexpr]
+		if sel == nil {
+			// qualified identifier.
+			// May refer to top level function variable
+			// or to top level function.
+			callee := qpos.info.Uses[funexpr.Sel]
+			if obj, ok := callee.(*types.Func); ok {
+				return e, obj, nil
+			}
+		} else if sel.Kind() == types.MethodVal {
+			// Inspect the receiver type of the selected method.
+			// If it is concrete, the call is statically dispatched.
+			// (Due to implicit field selections, it is not enough to look
+			// at sel.Recv(), the type of the actual receiver expression.)
+			method := sel.Obj().(*types.Func)
+			recvtype := method.Type().(*types.Signature).Recv().Type()
+			if !types.IsInterface(recvtype) {
+				// static method call
+				return e, method, nil
+			}
+		}
+	}
+	return e, nil, nil
+}
+
+func findCallSite(fn *ssa.Function, call *ast.CallExpr) (ssa.CallInstruction, error) {
+	instr, _ := fn.ValueForExpr(call)
+	callInstr, _ := instr.(ssa.CallInstruction)
+	if instr == nil {
+		return nil, errorf(codeAnalysis, "this call site is unreachable in this analysis")
+	}
+	return callInstr, nil
+}
+
+// staticCallee returns the callee of a static call site,
+// or nil if the call is dynamic.
+func staticCallee(site ssa.CallInstruction) *ssa.Function {
+	if callee := site.Common().StaticCallee(); callee != nil {
+		switch callee.String() {
+		case "runtime.SetFinalizer", "(reflect.Value).Call":
+			// The PTA treats calls to these intrinsics as dynamic.
+			// TODO(adonovan): avoid reliance on PTA internals.
+
+		default:
+			return callee
+		}
+	}
+	return nil
+}
+
+// isMethodWrapper reports whether fn is the synthetic wrapper of a
+// method value (a "bound" closure) or a method expression (a "thunk").
+func isMethodWrapper(fn *ssa.Function) bool {
+	_, ok := fn.Object().(*types.Func)
+	return ok && fn.Synthetic != "" && fn.Signature.Recv() == nil
+}
+
+// callDescription describes the call site, as does
+// ssa.CallCommon.Description, except for a call of the wrapper of a
+// method value or method expression, which it describes as such.
+func callDescription(site ssa.CallInstruction) string {
+	if fn := site.Common().StaticCallee(); fn != nil && isMethodWrapper(fn) {
+		if len(fn.FreeVars) > 0 {
+			return "method value call"
+		}
+		return "method expression call"
+	}
+	return site.Common().Description()
+}
+
+// findCallees returns the callees of the dynamic call site
+// according to the pointer analysis call graph cg.
+func findCallees(cg *callgraph.Graph, site ssa.CallInstruction) ([]*ssa.Function, error) {
+	// Find all call edges from the site.
+	n := cg.Nodes[site.Parent()]
+	if n == nil {
+		return nil, errorf(codeAnalysis, "this call site is unreachable in this analysis")
+	}
+	calleesMap := make(map[*ssa.Function]bool)
+	for _, edge := range n.Out {
+		if edge.Site == site {
+			calleesMap[edge.Callee.Func] = true
+		}
+	}
+
+	// De-duplicate and sort.
+	funcs := make([]*ssa.Function, 0, len(calleesMap))
+	for f := range calleesMap {
+		funcs = append(funcs, f)
+	}
+	sort.Sort(byFuncPos(funcs))
+	return funcs, nil
+}
+
+type calleesSSAResult struct {
+	resultSource
+	site  ssa.CallInstruction
+	funcs []*ssa.Function
+}
+
+type calleesTypesResult struct {
+	resultSource
+	site   *ast.CallExpr
+	caller graphNode // the enclosing function declaration
+	callee *types.Func
+}
+
+type calleesCHAResult struct {
+	resultSource
+	site    *ast.CallExpr
+	caller  graphNode // the enclosing function declaration
+	desc    string    // description of the call site
+	callees []chaCallee
+}
+
+// enclosingFunc returns the graph node of the function declaration
+// enclosing the query, or that of the package initializer if none.
+func enclosingFunc(qpos *queryPos) graphNode {
+	for _, n := range qpos.path {
+		if decl, ok := n.(*ast.FuncDecl); ok {
+			if fn, ok := qpos.info.Defs[decl.Name].(*types.Func); ok {
+				return typesFuncNode(fn)
+			}
+		}
+	}
+	path := qpos.info.Pkg.Path()
+	return graphNode{name: path + ".init", pkg: path}
+}
+
+func (r *calleesSSAResult) PrintPlain(printf printfFunc) {
+	if len(r.funcs) == 0 {
+		// dynamic call on a provably nil func/interface
+		printf(r.site, "%s on nil value", callDescription(r.site))
+	} else {
+		printf(r.site, "this %s dispatches to:", callDescription(r.site))
+		for _, callee := range r.funcs {
+			printf(callee, "\t%s", callee)
+		}
+	}
+}
+
+func (r *calleesSSAResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *calleesSSAResult) serialize(s *serializer) interface{} {
+	j := &serial.Callees{
+		Version: serial.Version,
+		Pos:     s.pos(r.site.Pos()),
+		Desc:    callDescription(r.site),
+	}
+	for _, callee := range r.funcs {
+		j.Callees = append(j.Callees, &serial.Callee{
+			Name: callee.String(),
+			Pos:  s.pos(callee.Pos()),
+		})
+	}
+	j.Offsets = s.offsets()
+	return j
+}
+
+func (r *calleesTypesResult) PrintPlain(printf printfFunc) {
+	printf(r.site, "this static function call dispatches to:")
+	printf(r.callee, "\t%s", r.callee.FullName())
+}
+
+func (r *calleesTypesResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *calleesTypesResult) serialize(s *serializer) interface{} {
+	j := &serial.Callees{
+		Version: serial.Version,
+		Pos:     s.pos(r.site.Pos()),
+		Desc:    "static function call",
+	}
+	j.Callees = []*serial.Callee{
+		{
+			Name: r.callee.FullName(),
+			Pos:  s.pos(r.callee.Pos()),
+		},
+	}
+	j.Offsets = s.offsets()
+	return j
+}
+
+func (r *calleesCHAResult) PrintPlain(printf printfFunc) {
+	if len(r.callees) == 0 {
+		printf(r.site, "this %s has no callees in the loaded program", r.desc)
+		return
+	}
+	printf(r.site, "this %s may dispatch to (by class hierarchy analysis, an over-approximation):", r.desc)
+	for _, callee := range r.callees {
+		printf(callee.pos, "\t%s", callee.name)
+	}
+}
+
+func (r *calleesCHAResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *calleesCHAResult) serialize(s *serializer) interface{} {
+	j := &serial.Callees{
+		Version:     serial.Version,
+		Pos:         s.pos(r.site.Pos()),
+		Desc:        r.desc,
+		Approximate: true,
+	}
+	for _, callee := range r.callees {
+		j.Callees = append(j.Callees, &serial.Callee{
+			Name: callee.name,
+			Pos:  s.pos(callee.pos),
+		})
+	}
+	j.Offsets = s.offsets()
+	return j
+}
+
+func (r *calleesSSAResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+func (r *calleesSSAResult) callEdges(visit func(callEdge)) {
+	for _, callee := range r.funcs {
+		visit(callEdge{0, funcNode(r.site.Parent()), funcNode(callee), r.site.Pos(), callDescription(r.site), 0, isDynamic(r.site)})
+	}
+}
+
+func (r *calleesCHAResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+func (r *calleesCHAResult) callEdges(visit func(callEdge)) {
+	for _, callee := range r.callees {
+		visit(callEdge{0, r.caller, graphNode{callee.name, callee.pkg, callee.pos}, r.site.Lparen, r.desc, 0, true})
+	}
+}
+
+func (r *calleesTypesResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+func (r *calleesTypesResult) callEdges(visit func(callEdge)) {
+	visit(callEdge{0, r.caller, typesFuncNode(r.callee), r.site.Lparen, "static function call", 0, false})
+}
+
+type byFuncPos []*ssa.Function
+
+func (a byFuncPos) Len() int           { return len(a) }
+func (a byFuncPos) Less(i, j int) bool { return lessFunc(a[i], a[j]) }
+func (a byFuncPos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// lessFunc orders functions by position, then, for those that have
+// none, such as synthetic wrappers, by name.
+func lessFunc(x, y *ssa.Function) bool {
+	if x.Pos() == y.Pos() {
+		return x.String() < y.String()
+	}
+	return lessPos(x.Prog.Fset, x.Pos(), y.Pos())
+}
@@ -0,0 +1,314 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// The callers function reports the possible callers of the function
+// immediately enclosing the specified source location.
+// If q.Depth > 1, it also reports their callers, transitively,
+// up to that many levels.
+//
+func callers(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	pkg := a.prog.Package(qpos.info.Pkg)
+	if pkg == nil {
+		return nil, errorf(codeAnalysis, "no SSA package")
+	}
+	if !ssa.HasEnclosingFunction(pkg, qpos.path) {
+		return nil, fmt.Errorf("this position is not inside a function")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	target := ssa.EnclosingFunction(pkg, qpos.path)
+	if target == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	// If the function is never address-taken, all calls are direct
+	// and can be found quickly by inspecting the whole SSA program.
+	// Transitive callers need the complete call graph.
+	var cg *callgraph.Graph
+	if q.Depth <= 1 {
+		cg = directCallsTo(target, entryPoints(a.ptaConfig.Mains))
+	}
+	if cg == nil {
+		// Run the pointer analysis, recording each
+		// call found to originate from target.
+		// (Pointer analysis may return fewer results than
+		// directCallsTo because it ignores dead code.)
+		a.needCallGraph()
+	}
+
+	return func() error {
+		cg := cg
+		if cg == nil {
+			cg = a.cg
+		} else {
+			cg.DeleteSyntheticNodes()
+			sortCallGraph(cg)
+		}
+		edges := cg.CreateNode(target).In
+
+		// TODO(adonovan): dedup calls.
+
+		q.Output(a.lprog.Fset, &callersResult{
+			target:    target,
+			callgraph: cg,
+			edges:     edges,
+			depth:     q.Depth,
+		})
+		return nil
+	}, nil
+}
+
+// directCallsTo inspects the whole program and returns a callgraph
+// containing edges for all direct calls to the target function.
+// directCallsTo returns nil if the function is ever address-taken.
+func directCallsTo(target *ssa.Function, entrypoints []*ssa.Function) *callgraph.Graph {
+	cg := callgraph.New(nil) // use nil as root *Function
+	targetNode := cg.CreateNode(target)
+
+	// Is the function a program entry point?
+	// If so, add edge from callgraph root.
+	for _, f := range entrypoints {
+		if f == target {
+			callgraph.AddEdge(cg.Root, nil, targetNode)
+		}
+	}
+
+	// Find receiver type (for methods).
+	var recvType types.Type
+	if recv := target.Signature.Recv(); recv != nil {
+		recvType = recv.Type()
+	}
+
+	// Find all direct calls to function,
+	// or a place where its address is taken.
+	var space [32]*ssa.Value // preallocate
+	for fn := range ssautil.AllFunctions(target.Prog) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				// Is this a method (T).f of a concrete type T
+				// whose runtime type descriptor is address-taken?
+				// (To be fully sound, we would have to check that
+				// the type doesn't make it to reflection as a
+				// subelement of some other address-taken type.)
+				if recvType != nil {
+					if mi, ok := instr.(*ssa.MakeInterface); ok {
+						if types.Identical(mi.X.Type(), recvType) {
+							return nil // T is address-taken
+						}
+						if ptr, ok := mi.X.Type().(*types.Pointer); ok &&
+							types.Identical(ptr.Elem(), recvType) {
+							return nil // *T is address-taken
+						}
+					}
+				}
+
+				// Direct call to target?
+				rands := instr.Operands(space[:0])
+				if site, ok := instr.(ssa.CallInstruction); ok &&
+					site.Common().Value == target {
+					callgraph.AddEdge(cg.CreateNode(fn), site, targetNode)
+					rands = rands[1:] // skip .Value (rands[0])
+				}
+
+				// Address-taken?
+				for _, rand := range rands {
+					if rand != nil && *rand == target {
+						return nil
+					}
+				}
+			}
+		}
+	}
+
+	return cg
+}
+
+func entryPoints(mains []*ssa.Package) []*ssa.Function {
+	var entrypoints []*ssa.Function
+	for _, pkg := range mains {
+		entrypoints = append(entrypoints, pkg.Func("init"))
+		if main := pkg.Func("main"); main != nil && pkg.Pkg.Name() == "main" {
+			entrypoints = append(entrypoints, main)
+		}
+	}
+	return entrypoints
+}
+
+type callersResult struct {
+	resultSource
+	target    *ssa.Function
+	callgraph *callgraph.Graph
+	edges     []*callgraph.Edge
+	depth     int // levels of callers to report; values < 1 mean 1
+}
+
+func (r *callersResult) PrintPlain(printf printfFunc) {
+	if r.edges == nil {
+		printf(r.target, "%s is not reachable in this program.", r.target)
+	} else {
+		printf(r.target, "%s is called from these %d sites:", r.target, len(r.edges))
+		r.visitCallers(r.edges, func(edge *callgraph.Edge, depth int, note string) {
+			indent := strings.Repeat("\t", depth)
+			if edge.Caller == r.callgraph.Root {
+				printf(edge.Callee.Func, "%sthe root of the call graph", indent)
+			} else {
+				printf(edge, "%s%s from %s%s", indent, edge.Description(), edge.Caller.Func, note)
+			}
+		})
+	}
+}
+
+// visitCallers calls visit for each edge, in preorder, and for each
+// edge to its caller, transitively up to r.depth levels.  The depth of
+// edges is 1, that of their callers' incoming edges is 2, and so on.
+//
+// A caller that is recursive, or whose callers have already been
+// visited, is not expanded again; instead, note describes why.
+func (r *callersResult) visitCallers(edges []*callgraph.Edge, visit func(edge *callgraph.Edge, depth int, note string)) {
+	path := make(map[*callgraph.Node]bool) // callers on the current path
+	seen := make(map[*callgraph.Node]bool) // callers already expanded
+	var walk func(edges []*callgraph.Edge, depth int)
+	walk = func(edges []*callgraph.Edge, depth int) {
+		for _, edge := range sortedEdges(edges) {
+			caller := edge.Caller
+			var note string
+			expand := depth < r.depth && caller != r.callgraph.Root && len(caller.In) > 0
+			if expand {
+				if path[caller] {
+					note, expand = " (recursive)", false
+				} else if seen[caller] {
+					note, expand = " (callers shown above)", false
+				}
+			}
+			visit(edge, depth, note)
+			if expand {
+				path[caller] = true
+				seen[caller] = true
+				walk(caller.In, depth+1)
+				delete(path, caller)
+			}
+		}
+	}
+	if len(edges) > 0 {
+		path[edges[0].Callee] = true
+	}
+	walk(edges, 1)
+}
+
+func (r *callersResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+func (r *callersResult) callEdges(visit func(callEdge)) {
+	r.visitCallers(r.edges, func(edge *callgraph.Edge, depth int, note string) {
+		caller := graphNode{name: "<root>"}
+		if edge.Caller != r.callgraph.Root {
+			caller = funcNode(edge.Caller.Func)
+		}
+		visit(callEdge{depth, caller, funcNode(edge.Callee.Func), edge.Pos(), edge.Description(), 0, isDynamic(edge.Site)})
+	})
+}
+
+// sortedEdges returns a copy of edges sorted by call site position,
+// for determinism.
+func sortedEdges(edges []*callgraph.Edge) []*callgraph.Edge {
+	edges = append([]*callgraph.Edge(nil), edges...)
+	sort.Slice(edges, func(i, j int) bool { return lessEdge(edges[i], edges[j]) })
+	return edges
+}
+
+// lessEdge orders call edges by the position of the call site, then
+// by the names of the caller and callee, since the calls from the
+// root, and those of a dynamic call site, share a position.
+func lessEdge(x, y *callgraph.Edge) bool {
+	if x.Pos() != y.Pos() {
+		return lessPos(x.Callee.Func.Prog.Fset, x.Pos(), y.Pos())
+	}
+	if xc, yc := nodeName(x.Caller), nodeName(y.Caller); xc != yc {
+		return xc < yc
+	}
+	return nodeName(x.Callee) < nodeName(y.Callee)
+}
+
+// nodeName returns the name of the function of a call graph node, or
+// "" for the root of a graph of direct calls, which has none.
+func nodeName(n *callgraph.Node) string {
+	if n.Func == nil {
+		return ""
+	}
+	return n.Func.String()
+}
+
+// sortCallGraph sorts the edges of each node of cg by lessEdge, so
+// that the traversals of the graph, such as the search for a path, do
+// not depend on the order in which it was built.
+func sortCallGraph(cg *callgraph.Graph) {
+	for _, n := range cg.Nodes {
+		sort.Slice(n.In, func(i, j int) bool { return lessEdge(n.In[i], n.In[j]) })
+		sort.Slice(n.Out, func(i, j int) bool { return lessEdge(n.Out[i], n.Out[j]) })
+	}
+}
+
+func (r *callersResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *callersResult) serialize(s *serializer) interface{} {
+	callers := r.callerTree(s, true)
+	for i := range callers {
+		callers[i].Version = serial.Version
+	}
+	return callers
+}
+
+// callerTree returns the tree of callers visited by visitCallers, each
+// with its own callers in the Callers field.  If elems, the callers of
+// depth 1 are the elements of a result, each with the Offsets of the
+// positions of its tree.
+func (r *callersResult) callerTree(s *serializer, elems bool) []serial.Caller {
+	// stack[d-1] points to the Callers slice of depth d.
+	var callers []serial.Caller
+	stack := []*[]serial.Caller{&callers}
+	r.visitCallers(r.edges, func(edge *callgraph.Edge, depth int, note string) {
+		if elems && depth == 1 && len(callers) > 0 {
+			callers[len(callers)-1].Offsets = s.offsets()
+		}
+		stack = stack[:depth]
+		list := stack[depth-1]
+		*list = append(*list, serial.Caller{
+			Caller: edge.Caller.Func.String(),
+			Pos:    s.pos(edge.Pos()),
+			Desc:   edge.Description(),
+		})
+		stack = append(stack, &(*list)[len(*list)-1].Callers)
+	})
+	if elems && len(callers) > 0 {
+		callers[len(callers)-1].Offsets = s.offsets()
+	}
+	return callers
+}
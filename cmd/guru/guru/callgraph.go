@@ -0,0 +1,266 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// doCallgraph displays the entire call graph of the program, as
+// computed by the pointer analysis.  It has no query position.
+//
+// If q.Focus is non-empty, only the functions of the packages it
+// matches, and the calls between them, are displayed in full; the
+// calls between a function in focus and the functions of another
+// package are summarized by a single edge labelled with their number.
+//
+// The calls of each function are computed as the result is output,
+// not accumulated beforehand, since the call graph of a large
+// program has millions of edges.
+func doCallgraph(q *Query, a *analysis) (func() error, error) {
+	var focus map[string]bool
+	if len(q.Focus) > 0 {
+		focus = buildutil.ExpandPatterns(q.Build, q.Focus)
+		if len(focus) == 0 {
+			return nil, errorf(codeScope, "no packages match -focus %v", q.Focus)
+		}
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	a.needCallGraph()
+
+	return func() error {
+		cg := a.cg
+		r := &callgraphResult{root: cg.Root, focus: focus}
+		for _, n := range cg.Nodes {
+			if r.inFocus(n.Func) {
+				r.nodes = append(r.nodes, n)
+			}
+		}
+		// The root comes first, then the functions by name.
+		sort.Slice(r.nodes, func(i, j int) bool {
+			x, y := r.nodes[i], r.nodes[j]
+			if x == cg.Root || y == cg.Root {
+				return x == cg.Root && y != cg.Root
+			}
+			if x.Func.String() != y.Func.String() {
+				return x.Func.String() < y.Func.String()
+			}
+			return lessFunc(x.Func, y.Func)
+		})
+
+		q.Output(a.lprog.Fset, r)
+		return nil
+	}, nil
+}
+
+// pkgPathOf returns the import path of the package of fn, or "" for
+// a synthetic function that belongs to none.
+func pkgPathOf(fn *ssa.Function) string {
+	if fn.Pkg == nil {
+		return ""
+	}
+	return fn.Pkg.Pkg.Path()
+}
+
+// A cgFunc is a function in focus and its calls.
+type cgFunc struct {
+	node    *callgraph.Node
+	calls   []*callgraph.Edge // calls of functions in focus, by position
+	out, in []boundary        // calls to and from other packages, by path
+}
+
+// A boundary summarizes the calls between a function in focus
+// and the functions of another package, or the synthetic functions,
+// such as wrappers, that belong to no package.
+type boundary struct {
+	pkg   string // import path of the other package, or "" for synthetic functions
+	calls int    // number of call edges
+}
+
+func (b boundary) String() string {
+	if b.pkg == "" {
+		return "synthetic functions"
+	}
+	return "package " + b.pkg
+}
+
+// node returns the graph node that stands for the functions of b.
+func (b boundary) node() graphNode {
+	if b.pkg == "" {
+		return graphNode{name: "<synthetic>"}
+	}
+	return graphNode{name: b.pkg, pkg: b.pkg}
+}
+
+func sortedBoundary(m map[string]int) []boundary {
+	var res []boundary
+	for pkg, n := range m {
+		res = append(res, boundary{pkg, n})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].pkg < res[j].pkg })
+	return res
+}
+
+type callgraphResult struct {
+	resultSource
+	root  *callgraph.Node
+	focus map[string]bool   // packages in focus, or nil for all
+	nodes []*callgraph.Node // functions in focus, root first
+}
+
+func (r *callgraphResult) inFocus(fn *ssa.Function) bool {
+	if r.focus == nil || fn == r.root.Func {
+		return true
+	}
+	return fn.Pkg != nil && r.focus[fn.Pkg.Pkg.Path()]
+}
+
+// funcs calls visit for each function in focus, in order, with its
+// calls, which are computed afresh.
+func (r *callgraphResult) funcs(visit func(f *cgFunc)) {
+	for _, n := range r.nodes {
+		f := &cgFunc{node: n}
+		out := make(map[string]int)
+		for _, e := range n.Out {
+			if r.inFocus(e.Callee.Func) {
+				f.calls = append(f.calls, e)
+			} else {
+				out[pkgPathOf(e.Callee.Func)]++
+			}
+		}
+		in := make(map[string]int)
+		for _, e := range n.In {
+			if !r.inFocus(e.Caller.Func) {
+				in[pkgPathOf(e.Caller.Func)]++
+			}
+		}
+		f.calls = sortedEdges(f.calls)
+		f.out, f.in = sortedBoundary(out), sortedBoundary(in)
+		visit(f)
+	}
+}
+
+func (r *callgraphResult) len() int { return len(r.nodes) }
+
+func (r *callgraphResult) slice(i, j int) QueryResult {
+	r2 := *r
+	r2.nodes = r.nodes[i:j]
+	return &r2
+}
+
+// name returns the name of the function of a node in r.
+func (r *callgraphResult) name(n *callgraph.Node) string {
+	if n == r.root {
+		return "<root>"
+	}
+	return n.Func.String()
+}
+
+// node returns the graph node of the function of a node in r.
+func (r *callgraphResult) node(n *callgraph.Node) graphNode {
+	if n == r.root {
+		return graphNode{name: r.name(n)}
+	}
+	return funcNode(n.Func)
+}
+
+func (r *callgraphResult) PrintPlain(printf printfFunc) {
+	r.funcs(func(f *cgFunc) {
+		if f.node == r.root {
+			printf(nil, "%s", r.name(f.node))
+		} else {
+			printf(f.node.Func, "%s", r.name(f.node))
+		}
+		for _, e := range f.calls {
+			printf(e, "\t%s to %s", e.Description(), r.name(e.Callee))
+		}
+		for _, b := range f.out {
+			printf(nil, "\t%s to %s", plural(b.calls, "call"), b)
+		}
+		for _, b := range f.in {
+			printf(nil, "\t%s from %s", plural(b.calls, "call"), b)
+		}
+	})
+}
+
+// plural returns the count n of the noun, e.g. "1 call", "2 calls".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func (r *callgraphResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *callgraphResult) serialize(s *serializer) interface{} {
+	var edges []serial.CallEdge
+	r.callEdges(func(e callEdge) {
+		edges = append(edges, callgraphEdge(s, e))
+	})
+	return edges
+}
+
+func (r *callgraphResult) elements(s *serializer, emit func(elem interface{}) error) error {
+	var err error
+	r.callEdges(func(e callEdge) {
+		if err == nil {
+			edge := callgraphEdge(s, e)
+			err = emit(&edge)
+		}
+	})
+	return err
+}
+
+// callgraphEdge returns the serial form of an edge of the call graph.
+func callgraphEdge(s *serializer, e callEdge) serial.CallEdge {
+	return serial.CallEdge{
+		Version: serial.Version,
+		Caller:  e.caller.name,
+		Callee:  e.callee.name,
+		Pos:     s.pos(e.pos),
+		Desc:    e.desc,
+		Calls:   e.calls,
+		Offsets: s.offsets(),
+	}
+}
+
+func (r *callgraphResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+// callEdges visits the calls between functions in focus, then those
+// that cross the boundary of the focus, whose other endpoint is named
+// by the package path, or is "<synthetic>" for functions of no package.
+func (r *callgraphResult) callEdges(visit func(callEdge)) {
+	r.funcs(func(f *cgFunc) {
+		for _, e := range f.calls {
+			visit(callEdge{caller: r.node(f.node), callee: r.node(e.Callee), pos: e.Pos(), desc: e.Description(), dynamic: isDynamic(e.Site)})
+		}
+	})
+	r.funcs(func(f *cgFunc) {
+		node := r.node(f.node)
+		for _, b := range f.out {
+			visit(callEdge{caller: node, callee: b.node(), desc: plural(b.calls, "call"), calls: b.calls})
+		}
+		for _, b := range f.in {
+			visit(callEdge{caller: b.node(), callee: node, desc: plural(b.calls, "call"), calls: b.calls})
+		}
+	})
+}
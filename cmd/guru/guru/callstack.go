@@ -0,0 +1,351 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"container/heap"
+	"fmt"
+	"go/token"
+	"math"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/ssa"
+)
+
+// The callstack function displays an arbitrary path from a root of the callgraph
+// to the function at the current position.  If q.Paths > 0, it instead
+// displays up to that many of the shortest paths, and if q.Paths < 0,
+// all of them, as a tree.
+//
+// The information may be misleading in a context-insensitive
+// analysis. e.g. the call path X->Y->Z might be infeasible if Y never
+// calls Z when it is called from X.  TODO(adonovan): think about UI.
+//
+// TODO(adonovan): permit user to specify a starting point other than
+// the analysis root.
+//
+func callstack(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	pkg := a.prog.Package(qpos.info.Pkg)
+	if pkg == nil {
+		return nil, errorf(codeAnalysis, "no SSA package")
+	}
+
+	if !ssa.HasEnclosingFunction(pkg, qpos.path) {
+		return nil, fmt.Errorf("this position is not inside a function")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	target := ssa.EnclosingFunction(pkg, qpos.path)
+	if target == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	if q.Paths != 0 {
+		// A dynamic call may make a path shorter,
+		// so these need the complete call graph.
+		a.needCallGraph()
+		return func() error {
+			r := &callstackResult{qpos: qpos, target: target, n: q.Paths}
+			node := a.cg.Nodes[target]
+			if q.Paths < 0 {
+				r.forest = pathForest(a.cg, target)
+			} else {
+				for _, path := range shortestPaths(a.cg, node, q.Paths) {
+					r.paths = append(r.paths, path[1:]) // remove synthetic edge from <root>
+				}
+			}
+			q.Output(a.lprog.Fset, r)
+			return nil
+		}, nil
+	}
+
+	var callpath []*callgraph.Edge
+	isEnd := func(n *callgraph.Node) bool { return n.Func == target }
+
+	// First, build a callgraph containing only static call edges,
+	// and search for an arbitrary path from a root to the target function.
+	// This is quick, and the user wants a static path if one exists.
+	cg := static.CallGraph(a.prog)
+	cg.DeleteSyntheticNodes()
+	for _, ep := range entryPoints(a.ptaConfig.Mains) {
+		callpath = callgraph.PathSearch(cg.CreateNode(ep), isEnd)
+		if callpath != nil {
+			break
+		}
+	}
+
+	// No fully static path found.
+	// Run the pointer analysis and build a complete call graph.
+	if callpath == nil {
+		a.needCallGraph()
+	}
+
+	return func() error {
+		callpath := callpath
+		if callpath == nil {
+			callpath = callgraph.PathSearch(a.cg.Root, isEnd)
+			if callpath != nil {
+				callpath = callpath[1:] // remove synthetic edge from <root>
+			}
+		}
+
+		r := &callstackResult{qpos: qpos, target: target}
+		if callpath != nil {
+			r.paths = [][]*callgraph.Edge{callpath}
+		}
+		q.Output(a.lprog.Fset, r)
+		return nil
+	}, nil
+}
+
+// shortestPaths returns up to n distinct acyclic paths in cg from its
+// root to target, shortest first, each a list of edges, outermost first.
+//
+// It is a best-first search backwards from target, in which the
+// length of a partial path is estimated by adding the distance of its
+// outermost caller from the root.  The estimate is exact but for the
+// exclusion of cycles, so few partial paths are explored beyond those
+// that are part of the result.
+func shortestPaths(cg *callgraph.Graph, target *callgraph.Node, n int) [][]*callgraph.Edge {
+	// dist[n] is the length of the shortest path from the root to n.
+	dist := map[*callgraph.Node]int{cg.Root: 0}
+	queue := []*callgraph.Node{cg.Root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, edge := range node.Out {
+			if _, ok := dist[edge.Callee]; !ok {
+				dist[edge.Callee] = dist[node] + 1
+				queue = append(queue, edge.Callee)
+			}
+		}
+	}
+	if _, ok := dist[target]; !ok {
+		return nil // unreachable
+	}
+
+	var paths [][]*callgraph.Edge
+	h := &partialPaths{{node: target, cost: dist[target]}}
+	for seq := 1; h.Len() > 0 && len(paths) < n; {
+		p := heap.Pop(h).(*partialPath)
+		if p.node == cg.Root {
+			path := make([]*callgraph.Edge, len(p.edges))
+			for i, edge := range p.edges {
+				path[len(path)-1-i] = edge
+			}
+			paths = append(paths, path)
+			continue
+		}
+		for _, edge := range sortedEdges(p.node.In) {
+			d, ok := dist[edge.Caller]
+			if !ok || p.visits(edge.Caller) {
+				continue
+			}
+			edges := append(p.edges[:len(p.edges):len(p.edges)], edge)
+			heap.Push(h, &partialPath{edge.Caller, edges, len(edges) + d, seq})
+			seq++
+		}
+	}
+	return paths
+}
+
+// A partialPath is a path from node to the target of shortestPaths.
+type partialPath struct {
+	node  *callgraph.Node
+	edges []*callgraph.Edge // innermost first
+	cost  int               // length of the shortest path from the root through this one
+	seq   int               // order of discovery, for determinism
+}
+
+// visits reports whether the path passes through node.
+func (p *partialPath) visits(node *callgraph.Node) bool {
+	for _, edge := range p.edges {
+		if edge.Callee == node {
+			return true
+		}
+	}
+	return p.node == node
+}
+
+// partialPaths is a heap of partial paths, the cheapest first.
+type partialPaths []*partialPath
+
+func (h partialPaths) Len() int { return len(h) }
+func (h partialPaths) Less(i, j int) bool {
+	if h[i].cost != h[j].cost {
+		return h[i].cost < h[j].cost
+	}
+	return h[i].seq < h[j].seq
+}
+func (h partialPaths) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *partialPaths) Push(x interface{}) { *h = append(*h, x.(*partialPath)) }
+func (h *partialPaths) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// pathForest returns the tree of the callers of target that are on a
+// path in cg from its root, transitively, as a callersResult of
+// unbounded depth over the subgraph of such calls.
+func pathForest(cg *callgraph.Graph, target *ssa.Function) *callersResult {
+	// Find the nodes reachable from the root.
+	reachable := map[*callgraph.Node]bool{cg.Root: true}
+	stack := []*callgraph.Node{cg.Root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, edge := range node.Out {
+			if !reachable[edge.Callee] {
+				reachable[edge.Callee] = true
+				stack = append(stack, edge.Callee)
+			}
+		}
+	}
+
+	// Copy the calls among those from which target is reachable.
+	g := callgraph.New(cg.Root.Func)
+	if node := cg.Nodes[target]; node != nil && reachable[node] {
+		seen := map[*callgraph.Node]bool{node: true}
+		stack = append(stack, node)
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, edge := range node.In {
+				if !reachable[edge.Caller] {
+					continue
+				}
+				callgraph.AddEdge(g.CreateNode(edge.Caller.Func), edge.Site, g.CreateNode(node.Func))
+				if !seen[edge.Caller] {
+					seen[edge.Caller] = true
+					stack = append(stack, edge.Caller)
+				}
+			}
+		}
+	}
+	var edges []*callgraph.Edge
+	if node := g.Nodes[target]; node != nil {
+		edges = node.In
+	}
+	return &callersResult{
+		target:    target,
+		callgraph: g,
+		edges:     edges,
+		depth:     math.MaxInt32,
+	}
+}
+
+type callstackResult struct {
+	resultSource
+	qpos   *queryPos
+	target *ssa.Function
+	paths  [][]*callgraph.Edge // each outermost first; none if unreachable
+	n      int                 // the number of paths requested, or -1 for all; 0 for any one
+	forest *callersResult      // if n < 0, the callers on the paths
+}
+
+func (r *callstackResult) PrintPlain(printf printfFunc) {
+	switch {
+	case r.forest != nil && r.forest.edges != nil:
+		printf(r.qpos, "Found these call paths from root to %s", r.target)
+		printf(r.target, "%s", r.target)
+		r.forest.visitCallers(r.forest.edges, func(edge *callgraph.Edge, depth int, note string) {
+			indent := strings.Repeat("\t", depth-1)
+			if edge.Caller == r.forest.callgraph.Root {
+				printf(edge.Callee.Func, "%sthe root of the call graph", indent)
+			} else {
+				printf(edge, "%s%s from %s%s", indent, edge.Description(), edge.Caller.Func, note)
+			}
+		})
+	case r.forest != nil || r.paths == nil:
+		printf(r.target, "%s is unreachable in this analysis scope", r.target)
+	case r.n > 1:
+		printf(r.qpos, "Found %s from root to %s", plural(len(r.paths), "call path"), r.target)
+		for i, path := range r.paths {
+			printf(r.target, "%d. %s", i+1, r.target)
+			for j := len(path) - 1; j >= 0; j-- {
+				edge := path[j]
+				printf(edge, "\t%s from %s", edge.Description(), edge.Caller.Func)
+			}
+		}
+	default:
+		printf(r.qpos, "Found a call path from root to %s", r.target)
+		printf(r.target, "%s", r.target)
+		callpath := r.paths[0]
+		for i := len(callpath) - 1; i >= 0; i-- {
+			edge := callpath[i]
+			printf(edge, "%s from %s", edge.Description(), edge.Caller.Func)
+		}
+	}
+}
+
+func (r *callstackResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *callstackResult) serialize(s *serializer) interface{} {
+	callers := func(callpath []*callgraph.Edge) []serial.Caller {
+		var callers []serial.Caller
+		for i := len(callpath) - 1; i >= 0; i-- { // (innermost first)
+			edge := callpath[i]
+			callers = append(callers, serial.Caller{
+				Pos:    s.pos(edge.Pos()),
+				Caller: edge.Caller.Func.String(),
+				Desc:   edge.Description(),
+			})
+		}
+		return callers
+	}
+	cs := &serial.CallStack{
+		Version: serial.Version,
+		Pos:     s.pos(r.target.Pos()),
+		Target:  r.target.String(),
+	}
+	if r.forest != nil {
+		cs.Tree = r.forest.callerTree(s, false)
+	}
+	for i, path := range r.paths {
+		if i == 0 {
+			cs.Callers = callers(path)
+		} else {
+			cs.More = append(cs.More, callers(path))
+		}
+	}
+	cs.Offsets = s.offsets()
+	return cs
+}
+
+func (r *callstackResult) DOT(fset *token.FileSet) []byte {
+	return toDOT(newSerializer(fset, r.src, nil), r)
+}
+
+func (r *callstackResult) callEdges(visit func(callEdge)) {
+	if r.forest != nil {
+		r.forest.callEdges(visit)
+		return
+	}
+	seen := make(map[*callgraph.Edge]bool) // edges shared by several paths
+	for _, path := range r.paths {
+		for _, edge := range path { // (outermost first)
+			if !seen[edge] {
+				seen[edge] = true
+				visit(callEdge{0, funcNode(edge.Caller.Func), funcNode(edge.Callee.Func), edge.Pos(), edge.Description(), 0, isDynamic(edge.Site)})
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"go/token"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// deadcode reports the functions of the packages in the analysis
+// scope that are unreachable from the roots of the call graph (the
+// main and init functions), grouped by package.  It has no query
+// position.
+//
+// A function literal is reported only if the function enclosing it
+// is reachable, since otherwise it is evidently dead.
+func deadcode(q *Query, a *analysis) (func() error, error) {
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	a.needCallGraph()
+
+	return func() error {
+		// Find the functions reachable from <root>.
+		reachable := make(map[*ssa.Function]bool)
+		var visit func(n *callgraph.Node)
+		visit = func(n *callgraph.Node) {
+			if !reachable[n.Func] {
+				reachable[n.Func] = true
+				for _, e := range n.Out {
+					visit(e.Callee)
+				}
+			}
+		}
+		visit(a.cg.Root)
+
+		scope := make(map[*ssa.Package]bool)
+		for _, info := range a.lprog.InitialPackages() {
+			if pkg := a.prog.Package(info.Pkg); pkg != nil {
+				scope[pkg] = true
+			}
+		}
+
+		// Function literals that are never called may be absent
+		// from AllFunctions, so we find them through their parents.
+		dead := make(map[*ssa.Package][]*ssa.Function)
+		var check func(fn *ssa.Function)
+		check = func(fn *ssa.Function) {
+			if !reachable[fn] {
+				dead[fn.Pkg] = append(dead[fn.Pkg], fn)
+				return
+			}
+			for _, anon := range fn.AnonFuncs {
+				check(anon)
+			}
+		}
+		for fn := range ssautil.AllFunctions(a.prog) {
+			if fn.Parent() == nil && scope[fn.Pkg] && fn.Synthetic == "" && fn.Pos().IsValid() {
+				check(fn)
+			}
+		}
+
+		var pkgs []deadPackage
+		for pkg, fns := range dead {
+			sort.Sort(byFuncPos(fns))
+			pkgs = append(pkgs, deadPackage{pkg, fns})
+		}
+		sort.Slice(pkgs, func(i, j int) bool {
+			return pkgs[i].pkg.Pkg.Path() < pkgs[j].pkg.Pkg.Path()
+		})
+
+		q.Output(a.lprog.Fset, &deadcodeResult{pkgs: pkgs})
+		return nil
+	}, nil
+}
+
+type deadPackage struct {
+	pkg   *ssa.Package
+	funcs []*ssa.Function // in order of position
+}
+
+type deadcodeResult struct {
+	resultSource
+	pkgs []deadPackage // in order of package path
+}
+
+func (r *deadcodeResult) PrintPlain(printf printfFunc) {
+	if len(r.pkgs) == 0 {
+		printf(nil, "All functions in scope are reachable.")
+		return
+	}
+	for _, p := range r.pkgs {
+		printf(nil, "Unreachable functions of package %s:", p.pkg.Pkg.Path())
+		for _, fn := range p.funcs {
+			printf(fn, "\t%s", fn.RelString(p.pkg.Pkg))
+		}
+	}
+}
+
+func (r *deadcodeResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *deadcodeResult) serialize(s *serializer) interface{} {
+	j := serial.DeadCode{Version: serial.Version}
+	for _, p := range r.pkgs {
+		jp := serial.DeadPackage{Path: p.pkg.Pkg.Path()}
+		for _, fn := range p.funcs {
+			jp.Funcs = append(jp.Funcs, serial.DeadFunc{
+				Name: fn.String(),
+				Pos:  s.pos(fn.Pos()),
+			})
+		}
+		j.Packages = append(j.Packages, jp)
+	}
+	j.Offsets = s.offsets()
+	return &j
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"fmt"
@@ -63,18 +63,27 @@ func definition(q *Query) error {
 	}
 
 	// Run the type checker.
-	lconf := loader.Config{Build: q.Build}
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
 	allowErrors(&lconf)
+	cancelHook(q, &lconf)
 
 	if _, err := importQueryPackage(q.Pos, &lconf); err != nil {
 		return err
 	}
 
 	// Load/parse/type-check the program.
-	lprog, err := lconf.Load()
-	if err != nil {
+	cache := newPkgCache(q, &lconf)
+	progressHook(q, &lconf, "load")
+	end := beginPhase(q, "load")
+	lprog, err := load(q, &lconf)
+	end()
+	if err := canceled(q); err != nil {
 		return err
 	}
+	if err != nil {
+		return withCode(codeLoad, err)
+	}
+	cache.save(lprog)
 
 	qpos, err := parseQueryPos(lprog, q.Pos, false)
 	if err != nil {
@@ -189,6 +198,7 @@ func findPackageMember(ctxt *build.Context, fset *token.FileSet, srcdir, pkg, me
 }
 
 type definitionResult struct {
+	resultSource
 	pos   token.Pos // (nonzero) location of definition
 	descr string    // description of object it denotes
 }
@@ -197,9 +207,13 @@ func (r *definitionResult) PrintPlain(printf printfFunc) {
 	printf(r.pos, "defined here as %s", r.descr)
 }
 
-func (r *definitionResult) JSON(fset *token.FileSet) []byte {
-	return toJSON(&serial.Definition{
-		Desc:   r.descr,
-		ObjPos: fset.Position(r.pos).String(),
-	})
+func (r *definitionResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *definitionResult) serialize(s *serializer) interface{} {
+	return &serial.Definition{
+		Version: serial.Version,
+		Desc:    r.descr,
+		ObjPos:  s.pos(r.pos),
+		Offsets: s.offsets(),
+	}
 }
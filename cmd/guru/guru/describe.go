@@ -2,16 +2,20 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"bytes"
 	"fmt"
 	"go/ast"
 	"go/constant"
+	"go/doc"
+	"go/parser"
 	"go/token"
 	"go/types"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -26,20 +30,34 @@ import (
 // - its syntactic category
 // - the definition of its referent (for identifiers) [now redundant]
 // - its type, fields, and methods (for an expression or type expression)
+// - the doc comment of its referent (for identifiers and packages)
+// - the cases of a select statement, and with a scope, the possible
+//   peers of the channel operation of each
 //
 func describe(q *Query) error {
-	lconf := loader.Config{Build: q.Build}
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
 	allowErrors(&lconf)
+	cancelHook(q, &lconf)
+	lconf.ParserMode |= parser.ParseComments // for doc comments
 
 	if _, err := importQueryPackage(q.Pos, &lconf); err != nil {
 		return err
 	}
 
 	// Load/parse/type-check the program.
-	lprog, err := lconf.Load()
-	if err != nil {
+	cache := newPkgCache(q, &lconf)
+	progressHook(q, &lconf, "load")
+	end := beginPhase(q, "load")
+	lprog, err := load(q, &lconf)
+	end()
+	if err := canceled(q); err != nil {
 		return err
 	}
+	if err != nil {
+		return withCode(codeLoad, err)
+	}
+	cache.save(lprog)
+	reportTypeErrors(q, errorPackages(lprog)) // the results are best-effort
 
 	qpos, err := parseQueryPos(lprog, q.Pos, true) // (need exact pos)
 	if err != nil {
@@ -47,7 +65,7 @@ func describe(q *Query) error {
 	}
 
 	if false { // debugging
-		fprintf(os.Stderr, lprog.Fset, qpos.path[0], "you selected: %s %s",
+		fprintf(os.Stderr, "emacs", false, false, "", lprog.Fset, nil, qpos.path[0], "you selected: %s %s",
 			astutil.NodeDescription(qpos.path[0]), pathToString(qpos.path))
 	}
 
@@ -55,19 +73,27 @@ func describe(q *Query) error {
 	path, action := findInterestingNode(qpos.info, qpos.path)
 	switch action {
 	case actionExpr:
-		qr, err = describeValue(qpos, path)
+		qr, err = describeValue(lprog, qpos, path, sizesFor(q.Build))
 
 	case actionType:
-		qr, err = describeType(qpos, path)
+		qr, err = describeType(lprog, qpos, path, sizesFor(q.Build))
 
 	case actionPackage:
-		qr, err = describePackage(qpos, path)
+		qr, err = describePackage(lprog, qpos, path)
 
 	case actionStmt:
+		if sel, ok := path[0].(*ast.SelectStmt); ok {
+			if len(q.Scope) > 0 {
+				// The peers need a whole-program analysis.
+				return describeSelect(q)
+			}
+			qr = newDescribeSelectResult(qpos, sel)
+			break
+		}
 		qr, err = describeStmt(qpos, path)
 
 	case actionUnknown:
-		qr = &describeUnknownResult{path[0]}
+		qr = &describeUnknownResult{node: path[0]}
 
 	default:
 		panic(action) // unreachable
@@ -80,6 +106,7 @@ func describe(q *Query) error {
 }
 
 type describeUnknownResult struct {
+	resultSource
 	node ast.Node
 }
 
@@ -88,11 +115,15 @@ func (r *describeUnknownResult) PrintPlain(printf printfFunc) {
 	printf(r.node, "%s", astutil.NodeDescription(r.node))
 }
 
-func (r *describeUnknownResult) JSON(fset *token.FileSet) []byte {
-	return toJSON(&serial.Describe{
-		Desc: astutil.NodeDescription(r.node),
-		Pos:  fset.Position(r.node.Pos()).String(),
-	})
+func (r *describeUnknownResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describeUnknownResult) serialize(s *serializer) interface{} {
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    astutil.NodeDescription(r.node),
+		Pos:     s.pos(r.node.Pos()),
+		Offsets: s.offsets(),
+	}
 }
 
 type action int
@@ -310,7 +341,7 @@ func findInterestingNode(pkginfo *loader.PackageInfo, path []ast.Node) ([]ast.No
 	return nil, actionUnknown // unreachable
 }
 
-func describeValue(qpos *queryPos, path []ast.Node) (*describeValueResult, error) {
+func describeValue(lprog *loader.Program, qpos *queryPos, path []ast.Node, sizes types.Sizes) (*describeValueResult, error) {
 	var expr ast.Expr
 	var obj types.Object
 	switch n := path[0].(type) {
@@ -335,16 +366,28 @@ func describeValue(qpos *queryPos, path []ast.Node) (*describeValueResult, error
 	if c, ok := obj.(*types.Const); ok {
 		constVal = c.Val()
 	}
+	var constInfo *constantInfo
+	if constVal != nil && constVal.Kind() != constant.Unknown {
+		// The type of a reference to an untyped constant is
+		// that to which it is converted; describe the constant.
+		ctyp := typ
+		if c, ok := obj.(*types.Const); ok {
+			ctyp = c.Type()
+		}
+		constInfo = describeConstant(constVal, ctyp, sizes)
+	}
 
 	return &describeValueResult{
-		qpos:     qpos,
-		expr:     expr,
-		typ:      typ,
-		names:    appendNames(nil, typ),
-		constVal: constVal,
-		obj:      obj,
-		methods:  accessibleMethods(typ, qpos.info.Pkg),
-		fields:   accessibleFields(typ, qpos.info.Pkg),
+		qpos:      qpos,
+		expr:      expr,
+		typ:       typ,
+		names:     appendNames(nil, typ),
+		constVal:  constVal,
+		constInfo: constInfo,
+		obj:       obj,
+		doc:       objectDoc(lprog, obj),
+		methods:   accessibleMethods(typ, qpos.info.Pkg),
+		fields:    accessibleFields(typ, qpos.info.Pkg),
 	}, nil
 }
 
@@ -372,14 +415,17 @@ func appendNames(names []*types.Named, typ types.Type) []*types.Named {
 }
 
 type describeValueResult struct {
-	qpos     *queryPos
-	expr     ast.Expr       // query node
-	typ      types.Type     // type of expression
-	names    []*types.Named // named types within typ
-	constVal constant.Value // value of expression, if constant
-	obj      types.Object   // var/func/const object, if expr was Ident
-	methods  []*types.Selection
-	fields   []describeField
+	resultSource
+	qpos      *queryPos
+	expr      ast.Expr       // query node
+	typ       types.Type     // type of expression
+	names     []*types.Named // named types within typ
+	constVal  constant.Value // value of expression, if constant
+	constInfo *constantInfo  // details of constVal, if known
+	obj       types.Object   // var/func/const object, if expr was Ident
+	doc       string         // doc comment of obj, if any
+	methods   []*types.Selection
+	fields    []describeField
 }
 
 func (r *describeValueResult) PrintPlain(printf printfFunc) {
@@ -410,6 +456,9 @@ func (r *describeValueResult) PrintPlain(printf printfFunc) {
 				printf(def, "defined here")
 			}
 		}
+		if r.doc != "" {
+			printf(r.obj, "%s", doc.Synopsis(r.doc))
+		}
 	} else {
 		desc := astutil.NodeDescription(r.expr)
 		if suffix != "" {
@@ -420,51 +469,190 @@ func (r *describeValueResult) PrintPlain(printf printfFunc) {
 			printf(r.expr, "%s of type %s", desc, r.qpos.typeString(r.typ))
 		}
 	}
+	if r.constInfo != nil {
+		printf(r.expr, "%s", r.constInfo)
+	}
 
 	printMethods(printf, r.expr, r.methods)
 	printFields(printf, r.expr, r.fields)
 	printNamedTypes(printf, r.expr, r.names)
 }
 
-func (r *describeValueResult) JSON(fset *token.FileSet) []byte {
+func (r *describeValueResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describeValueResult) serialize(s *serializer) interface{} {
 	var value, objpos string
 	if r.constVal != nil {
 		value = r.constVal.String()
 	}
+	var kind, exact, note string
+	var untyped bool
+	if c := r.constInfo; c != nil {
+		kind, exact, note, untyped = c.kind, c.exact, c.note, c.untyped
+	}
 	if r.obj != nil {
-		objpos = fset.Position(r.obj.Pos()).String()
+		objpos = s.pos(r.obj.Pos())
 	}
 
 	typesPos := make([]serial.Definition, len(r.names))
 	for i, t := range r.names {
 		typesPos[i] = serial.Definition{
-			ObjPos: fset.Position(t.Obj().Pos()).String(),
+			ObjPos: s.pos(t.Obj().Pos()),
 			Desc:   r.qpos.typeString(t),
 		}
 	}
 
-	return toJSON(&serial.Describe{
-		Desc:   astutil.NodeDescription(r.expr),
-		Pos:    fset.Position(r.expr.Pos()).String(),
-		Detail: "value",
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    astutil.NodeDescription(r.expr),
+		Pos:     s.pos(r.expr.Pos()),
+		Doc:     r.doc,
+		Detail:  "value",
 		Value: &serial.DescribeValue{
 			Type:     r.qpos.typeString(r.typ),
 			TypesPos: typesPos,
 			Value:    value,
+			Kind:     kind,
+			Untyped:  untyped,
+			Exact:    exact,
+			Note:     note,
 			ObjPos:   objpos,
 		},
-	})
+		Offsets: s.offsets(),
+	}
+}
+
+// A constantInfo describes the value of a constant beyond its type:
+// its kind, its exact value, if the usual notation of it abbreviates or
+// rounds it, and whether an untyped constant is representable by its
+// default type, that of a variable it is assigned to.
+type constantInfo struct {
+	kind    string // "boolean", "string", "integer", "floating-point" or "complex"
+	untyped bool
+	exact   string // the exact value, if it differs from constant.Value.String
+	note    string // e.g. "overflows int", for an untyped constant
+}
+
+func describeConstant(val constant.Value, typ types.Type, sizes types.Sizes) *constantInfo {
+	c := &constantInfo{exact: val.ExactString()}
+	switch val.Kind() {
+	case constant.Bool:
+		c.kind = "boolean"
+	case constant.String:
+		c.kind = "string"
+	case constant.Int:
+		c.kind = "integer"
+	case constant.Float:
+		c.kind = "floating-point"
+		if exactString(val) {
+			c.exact = ""
+		} else if f, ok := constant.Float64Val(val); ok {
+			// Show a float that is exact in float64 in the
+			// shortest notation that is, not as a fraction.
+			c.exact = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	case constant.Complex:
+		c.kind = "complex"
+	}
+	if c.exact == val.String() {
+		c.exact = ""
+	}
+
+	basic, ok := typ.(*types.Basic)
+	if !ok || basic.Info()&types.IsUntyped == 0 {
+		return c
+	}
+	c.untyped = true
+	if def, ok := types.Default(typ).(*types.Basic); ok {
+		c.note = representability(val, def, sizes)
+	}
+	return c
+}
+
+// exactString reports whether the usual notation of the float val,
+// constant.Value.String, which has 6 significant digits, denotes it
+// exactly.
+func exactString(val constant.Value) bool {
+	s := val.String()
+	lit := constant.MakeFromLiteral(strings.TrimPrefix(s, "-"), token.FLOAT, 0)
+	if lit.Kind() == constant.Unknown {
+		return false
+	}
+	if strings.HasPrefix(s, "-") {
+		lit = constant.UnaryOp(token.SUB, lit, 0)
+	}
+	return constant.Compare(lit, token.EQL, val)
+}
+
+// representability returns a note of why val is not exactly
+// representable by the basic type t, or "" if it is.
+func representability(val constant.Value, t *types.Basic, sizes types.Sizes) string {
+	switch {
+	case t.Info()&types.IsInteger != 0:
+		bits := uint(8 * sizes.Sizeof(t))
+		if t.Info()&types.IsUnsigned != 0 {
+			max := constant.Shift(constant.MakeInt64(1), token.SHL, bits)
+			if constant.Sign(val) < 0 || constant.Compare(val, token.GEQ, max) {
+				return "overflows " + t.Name()
+			}
+		} else {
+			max := constant.Shift(constant.MakeInt64(1), token.SHL, bits-1)
+			min := constant.UnaryOp(token.SUB, max, 0)
+			if constant.Compare(val, token.LSS, min) || constant.Compare(val, token.GEQ, max) {
+				return "overflows " + t.Name()
+			}
+		}
+
+	case t.Info()&types.IsFloat != 0:
+		return floatRepresentability(val, t.Name())
+
+	case t.Info()&types.IsComplex != 0:
+		if note := floatRepresentability(constant.Real(val), t.Name()); note != "" {
+			return note
+		}
+		return floatRepresentability(constant.Imag(val), t.Name())
+	}
+	return ""
+}
+
+// floatRepresentability is representability for float64, and the
+// parts of complex128, which type name names.
+func floatRepresentability(val constant.Value, name string) string {
+	f, exact := constant.Float64Val(val)
+	switch {
+	case math.IsInf(f, 0):
+		return "overflows " + name
+	case !exact:
+		return "is not exactly representable as " + name
+	}
+	return ""
+}
+
+func (c *constantInfo) String() string {
+	var buf bytes.Buffer
+	if c.untyped {
+		buf.WriteString("untyped ")
+	}
+	fmt.Fprintf(&buf, "%s constant", c.kind)
+	if c.exact != "" {
+		fmt.Fprintf(&buf, ", exactly %s", c.exact)
+	}
+	if c.note != "" {
+		fmt.Fprintf(&buf, ", which %s", c.note)
+	}
+	return buf.String()
 }
 
 // ---- TYPE ------------------------------------------------------------
 
-func describeType(qpos *queryPos, path []ast.Node) (*describeTypeResult, error) {
-	var description string
+func describeType(lprog *loader.Program, qpos *queryPos, path []ast.Node, sizes types.Sizes) (*describeTypeResult, error) {
+	var description, docText string
 	var typ types.Type
 	switch n := path[0].(type) {
 	case *ast.Ident:
 		obj := qpos.info.ObjectOf(n).(*types.TypeName)
 		typ = obj.Type()
+		docText = objectDoc(lprog, obj)
 		if isAlias(obj) {
 			description = "alias of "
 		} else if obj.Pos() == n.Pos() {
@@ -488,15 +676,15 @@ func describeType(qpos *queryPos, path []ast.Node) (*describeTypeResult, error)
 	// Show sizes for structs and named types (it's fairly obvious for others).
 	switch typ.(type) {
 	case *types.Named, *types.Struct:
-		szs := types.StdSizes{WordSize: 8, MaxAlign: 8} // assume amd64
 		description = fmt.Sprintf("%s (size %d, align %d)", description,
-			szs.Sizeof(typ), szs.Alignof(typ))
+			sizes.Sizeof(typ), sizes.Alignof(typ))
 	}
 
 	return &describeTypeResult{
 		qpos:        qpos,
 		node:        path[0],
 		description: description,
+		doc:         docText,
 		typ:         typ,
 		methods:     accessibleMethods(typ, qpos.info.Pkg),
 		fields:      accessibleFields(typ, qpos.info.Pkg),
@@ -504,9 +692,11 @@ func describeType(qpos *queryPos, path []ast.Node) (*describeTypeResult, error)
 }
 
 type describeTypeResult struct {
+	resultSource
 	qpos        *queryPos
 	node        ast.Node
 	description string
+	doc         string // doc comment of the named type, if any
 	typ         types.Type
 	methods     []*types.Selection
 	fields      []describeField
@@ -524,9 +714,30 @@ func printMethods(printf printfFunc, node ast.Node, methods []*types.Selection)
 	for _, meth := range methods {
 		// Print the method type relative to the package
 		// in which it was defined, not the query package,
-		printf(meth.Obj(), "\t%s",
-			types.SelectionString(meth, types.RelativeTo(meth.Obj().Pkg())))
+		var via string
+		if path := promotionPath(meth); path != "" {
+			via = " (via " + path + ")"
+		}
+		printf(meth.Obj(), "\t%s%s",
+			types.SelectionString(meth, types.RelativeTo(meth.Obj().Pkg())), via)
+	}
+}
+
+// promotionPath returns the dotted names of the embedded fields
+// through which the promoted method sel is selected, or "" if the
+// method is not promoted.
+func promotionPath(sel *types.Selection) string {
+	var names []string
+	T := sel.Recv()
+	index := sel.Index()
+	for _, i := range index[:len(index)-1] {
+		// The implicit fields of a method selection are always
+		// struct fields; interface method sets are flattened.
+		f := deref(T).Underlying().(*types.Struct).Field(i)
+		names = append(names, f.Name())
+		T = f.Type()
 	}
+	return strings.Join(names, ".")
 }
 
 func printFields(printf printfFunc, node ast.Node, fields []describeField) {
@@ -574,6 +785,9 @@ func printNamedTypes(printf printfFunc, node ast.Node, names []*types.Named) {
 
 func (r *describeTypeResult) PrintPlain(printf printfFunc) {
 	printf(r.node, "%s", r.description)
+	if r.doc != "" {
+		printf(r.node, "%s", doc.Synopsis(r.doc))
+	}
 
 	// Show the underlying type for a reference to a named type.
 	if nt, ok := r.typ.(*types.Named); ok && r.node.Pos() != nt.Obj().Pos() {
@@ -594,30 +808,67 @@ func (r *describeTypeResult) PrintPlain(printf printfFunc) {
 	printFields(printf, r.node, r.fields)
 }
 
-func (r *describeTypeResult) JSON(fset *token.FileSet) []byte {
+func (r *describeTypeResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describeTypeResult) serialize(s *serializer) interface{} {
 	var namePos, nameDef string
 	if nt, ok := r.typ.(*types.Named); ok {
-		namePos = fset.Position(nt.Obj().Pos()).String()
+		namePos = s.pos(nt.Obj().Pos())
 		nameDef = nt.Underlying().String()
 	}
-	return toJSON(&serial.Describe{
-		Desc:   r.description,
-		Pos:    fset.Position(r.node.Pos()).String(),
-		Detail: "type",
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    r.description,
+		Pos:     s.pos(r.node.Pos()),
+		Doc:     r.doc,
+		Detail:  "type",
 		Type: &serial.DescribeType{
 			Type:    r.qpos.typeString(r.typ),
 			NamePos: namePos,
 			NameDef: nameDef,
-			Methods: methodsToSerial(r.qpos.info.Pkg, r.methods, fset),
+			Methods: methodsToSerial(r.qpos.info.Pkg, r.methods, s),
+			Fields:  fieldsToSerial(r.fields, s),
 		},
-	})
+		Offsets: s.offsets(),
+	}
 }
 
 // ---- PACKAGE ------------------------------------------------------------
 
-func describePackage(qpos *queryPos, path []ast.Node) (*describePackageResult, error) {
-	var description string
-	var pkg *types.Package
+func describePackage(lprog *loader.Program, qpos *queryPos, path []ast.Node) (*describePackageResult, error) {
+	pkg, description, err := selectedPackage(qpos, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*describeMember
+	// NB: "unsafe" has no types.Package
+	if pkg != nil {
+		// Enumerate the accessible package members
+		// in lexicographic order.
+		for _, name := range pkg.Scope().Names() {
+			if pkg == qpos.info.Pkg || ast.IsExported(name) {
+				mem := pkg.Scope().Lookup(name)
+				var methods []*types.Selection
+				if mem, ok := mem.(*types.TypeName); ok {
+					methods = accessibleMethods(mem.Type(), qpos.info.Pkg)
+				}
+				members = append(members, &describeMember{
+					mem,
+					objectDoc(lprog, mem),
+					methods,
+				})
+
+			}
+		}
+	}
+
+	return &describePackageResult{fset: qpos.fset, node: path[0], description: description, doc: packageDoc(lprog, pkg), pkg: pkg, members: members}, nil
+}
+
+// selectedPackage returns the package denoted by the path of a query
+// of action actionPackage, and a description of the selection.
+func selectedPackage(qpos *queryPos, path []ast.Node) (pkg *types.Package, description string, err error) {
 	switch n := path[0].(type) {
 	case *ast.ImportSpec:
 		var obj types.Object
@@ -628,7 +879,7 @@ func describePackage(qpos *queryPos, path []ast.Node) (*describePackageResult, e
 		}
 		pkgname, _ := obj.(*types.PkgName)
 		if pkgname == nil {
-			return nil, fmt.Errorf("can't import package %s", n.Path.Value)
+			return nil, "", fmt.Errorf("can't import package %s", n.Path.Value)
 		}
 		pkg = pkgname.Imported()
 		description = fmt.Sprintf("import of package %q", pkg.Path())
@@ -647,48 +898,32 @@ func describePackage(qpos *queryPos, path []ast.Node) (*describePackageResult, e
 
 	default:
 		// Unreachable?
-		return nil, fmt.Errorf("unexpected AST for package: %T", n)
-	}
-
-	var members []*describeMember
-	// NB: "unsafe" has no types.Package
-	if pkg != nil {
-		// Enumerate the accessible package members
-		// in lexicographic order.
-		for _, name := range pkg.Scope().Names() {
-			if pkg == qpos.info.Pkg || ast.IsExported(name) {
-				mem := pkg.Scope().Lookup(name)
-				var methods []*types.Selection
-				if mem, ok := mem.(*types.TypeName); ok {
-					methods = accessibleMethods(mem.Type(), qpos.info.Pkg)
-				}
-				members = append(members, &describeMember{
-					mem,
-					methods,
-				})
-
-			}
-		}
+		return nil, "", fmt.Errorf("unexpected AST for package: %T", n)
 	}
-
-	return &describePackageResult{qpos.fset, path[0], description, pkg, members}, nil
+	return pkg, description, nil
 }
 
 type describePackageResult struct {
+	resultSource
 	fset        *token.FileSet
 	node        ast.Node
 	description string
+	doc         string // doc comment of the package, if any
 	pkg         *types.Package
 	members     []*describeMember // in lexicographic name order
 }
 
 type describeMember struct {
 	obj     types.Object
+	doc     string             // doc comment of obj, if any
 	methods []*types.Selection // in types.MethodSet order
 }
 
 func (r *describePackageResult) PrintPlain(printf printfFunc) {
 	printf(r.node, "%s", r.description)
+	if r.doc != "" {
+		printf(r.node, "%s", doc.Synopsis(r.doc))
+	}
 
 	// Compute max width of name "column".
 	maxname := 0
@@ -748,7 +983,9 @@ func formatMember(obj types.Object, maxname int) string {
 	return buf.String()
 }
 
-func (r *describePackageResult) JSON(fset *token.FileSet) []byte {
+func (r *describePackageResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describePackageResult) serialize(s *serializer) interface{} {
 	var members []*serial.DescribeMember
 	for _, mem := range r.members {
 		obj := mem.obj
@@ -769,20 +1006,24 @@ func (r *describePackageResult) JSON(fset *token.FileSet) []byte {
 			Name:    obj.Name(),
 			Type:    alias + typ.String(),
 			Value:   val,
-			Pos:     fset.Position(obj.Pos()).String(),
+			Pos:     s.pos(obj.Pos()),
 			Kind:    tokenOf(obj),
-			Methods: methodsToSerial(r.pkg, mem.methods, fset),
+			Doc:     mem.doc,
+			Methods: methodsToSerial(r.pkg, mem.methods, s),
 		})
 	}
-	return toJSON(&serial.Describe{
-		Desc:   r.description,
-		Pos:    fset.Position(r.node.Pos()).String(),
-		Detail: "package",
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    r.description,
+		Pos:     s.pos(r.node.Pos()),
+		Doc:     r.doc,
+		Detail:  "package",
 		Package: &serial.DescribePackage{
 			Path:    r.pkg.Path(),
 			Members: members,
 		},
-	})
+		Offsets: s.offsets(),
+	}
 }
 
 func tokenOf(o types.Object) string {
@@ -823,10 +1064,11 @@ func describeStmt(qpos *queryPos, path []ast.Node) (*describeStmtResult, error)
 		// Nothing much to say about statements.
 		description = astutil.NodeDescription(n)
 	}
-	return &describeStmtResult{qpos.fset, path[0], description}, nil
+	return &describeStmtResult{fset: qpos.fset, node: path[0], description: description}, nil
 }
 
 type describeStmtResult struct {
+	resultSource
 	fset        *token.FileSet
 	node        ast.Node
 	description string
@@ -836,16 +1078,241 @@ func (r *describeStmtResult) PrintPlain(printf printfFunc) {
 	printf(r.node, "%s", r.description)
 }
 
-func (r *describeStmtResult) JSON(fset *token.FileSet) []byte {
-	return toJSON(&serial.Describe{
-		Desc:   r.description,
-		Pos:    fset.Position(r.node.Pos()).String(),
-		Detail: "unknown",
-	})
+func (r *describeStmtResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describeStmtResult) serialize(s *serializer) interface{} {
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    r.description,
+		Pos:     s.pos(r.node.Pos()),
+		Detail:  "unknown",
+		Offsets: s.offsets(),
+	}
+}
+
+// ---- SELECT ---------------------------------------------------------------
+
+// describeSelect describes the selected select statement, loading the
+// program of the query's scope to find the peers of each of its cases
+// by a single pointer analysis.
+func describeSelect(q *Query) error {
+	a, err := loadAnalysis(q, wholeProgramMode, []string{q.Pos})
+	if err != nil {
+		return err
+	}
+	qpos, err := parseQueryPos(a.lprog, q.Pos, true)
+	if err != nil {
+		return err
+	}
+	path, _ := findInterestingNode(qpos.info, qpos.path)
+	sel, ok := path[0].(*ast.SelectStmt)
+	if !ok {
+		return fmt.Errorf("there is no select statement here")
+	}
+	r := newDescribeSelectResult(qpos, sel)
+	r.analyzed = true
+
+	if err := a.createSSA(); err != nil {
+		return err
+	}
+	a.buildSSA()
+
+	// Find the operation of each case, and its possible peers.
+	ops := allChanOps(a.prog, q.PTA.Reflection)
+	queryOps := make([]chanOp, len(r.cases))
+	peers := make([][]chanOp, len(r.cases))
+	for i, c := range r.cases {
+		for _, op := range ops {
+			if c.dir != 0 && op.pos == c.opPos {
+				queryOps[i] = op
+				peers[i] = addPeersQueries(a.ptaConfig, op, ops)
+				a.needPTA = true
+				break
+			}
+		}
+	}
+	if err := a.analyze(q); err != nil {
+		return err
+	}
+	for i, c := range r.cases {
+		if queryOps[i].ch != nil {
+			c.peers = peersOf(a.prog.Fset, a.ptares, queryOps[i], peers[i])
+		}
+	}
+
+	q.Output(a.lprog.Fset, r)
+	return nil
+}
+
+// newDescribeSelectResult returns the description of the cases of the
+// select statement, without their peers.
+func newDescribeSelectResult(qpos *queryPos, sel *ast.SelectStmt) *describeSelectResult {
+	r := &describeSelectResult{qpos: qpos, node: sel}
+	for _, stmt := range sel.Body.List {
+		clause := stmt.(*ast.CommClause)
+		c := &selectCase{pos: clause.Case}
+		var ch ast.Expr
+		switch comm := clause.Comm.(type) {
+		case *ast.SendStmt:
+			c.dir, c.opPos, ch = types.SendOnly, comm.Arrow, comm.Chan
+		case *ast.ExprStmt:
+			c.dir, c.opPos, ch = recvOp(comm.X)
+		case *ast.AssignStmt:
+			c.dir, c.opPos, ch = recvOp(comm.Rhs[0])
+		}
+		if ch != nil {
+			c.chanType = qpos.info.TypeOf(ch)
+		}
+		r.cases = append(r.cases, c)
+	}
+	return r
+}
+
+// recvOp returns the direction, the position of the <- token, and the
+// channel operand of the receive expression e of a select case.
+func recvOp(e ast.Expr) (types.ChanDir, token.Pos, ast.Expr) {
+	if u, ok := unparen(e).(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+		return types.RecvOnly, u.OpPos, u.X
+	}
+	return 0, token.NoPos, nil // unreachable in well-typed code
+}
+
+type describeSelectResult struct {
+	resultSource
+	qpos     *queryPos
+	node     *ast.SelectStmt
+	cases    []*selectCase
+	analyzed bool // whether the peers of the cases were sought
+}
+
+// A selectCase is a communication clause of a select statement.
+type selectCase struct {
+	pos      token.Pos     // position of the case or default keyword
+	dir      types.ChanDir // SendOnly or RecvOnly; zero for the default case
+	opPos    token.Pos     // position of the <- token
+	chanType types.Type    // type of the channel operand
+	peers    *peersResult  // the possible peers, if found
+}
+
+func (r *describeSelectResult) PrintPlain(printf printfFunc) {
+	printf(r.node, "select statement with %s", plural(len(r.cases), "case"))
+	for _, c := range r.cases {
+		switch c.dir {
+		case types.SendOnly:
+			printf(c.pos, "\tsend to channel of type %s", r.qpos.typeString(c.chanType))
+		case types.RecvOnly:
+			printf(c.pos, "\treceive from channel of type %s", r.qpos.typeString(c.chanType))
+		default:
+			printf(c.pos, "\tdefault case")
+			continue
+		}
+		switch {
+		case c.peers != nil && len(c.peers.makes) == 0:
+			printf(edgePos(c.opPos), "\t\tthis channel can't point to anything")
+		case c.peers != nil:
+			c.peers.printAliases(printf, "\t\t")
+		case r.analyzed:
+			printf(edgePos(c.opPos), "\t\tno peers found (dead code?)")
+		}
+	}
+}
+
+func (r *describeSelectResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *describeSelectResult) serialize(s *serializer) interface{} {
+	var cases []serial.DescribeSelectCase
+	for _, c := range r.cases {
+		sc := serial.DescribeSelectCase{Pos: s.pos(c.pos)}
+		switch c.dir {
+		case types.SendOnly:
+			sc.Dir = "send"
+		case types.RecvOnly:
+			sc.Dir = "receive"
+		default:
+			sc.Dir = "default"
+		}
+		if c.chanType != nil {
+			sc.Type = c.chanType.String()
+		}
+		if c.peers != nil {
+			sc.Peers = c.peers.toSerial(s)
+		}
+		cases = append(cases, sc)
+	}
+	return &serial.Describe{
+		Version: serial.Version,
+		Desc:    astutil.NodeDescription(r.node),
+		Pos:     s.pos(r.node.Pos()),
+		Detail:  "select",
+		Select:  &serial.DescribeSelect{Cases: cases},
+		Offsets: s.offsets(),
+	}
 }
 
 // ------------------- Utilities -------------------
 
+// objectDoc returns the text of the doc comment of the declaration of
+// obj, or "" if it has none or its syntax was not loaded.
+func objectDoc(lprog *loader.Program, obj types.Object) string {
+	if obj == nil || !obj.Pos().IsValid() {
+		return ""
+	}
+	if obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope() {
+		return "" // a local object is not documented
+	}
+	info := lprog.AllPackages[obj.Pkg()]
+	if info == nil {
+		return ""
+	}
+	var path []ast.Node
+	for _, f := range info.Files {
+		if f.Pos() <= obj.Pos() && obj.Pos() < f.End() {
+			path, _ = astutil.PathEnclosingInterval(f, obj.Pos(), obj.Pos())
+			break
+		}
+	}
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			return n.Doc.Text()
+		case *ast.Field:
+			return n.Doc.Text()
+		case *ast.TypeSpec:
+			if n.Doc != nil {
+				return n.Doc.Text()
+			}
+		case *ast.ValueSpec:
+			if n.Doc != nil {
+				return n.Doc.Text()
+			}
+		case *ast.GenDecl:
+			// An undocumented spec is documented by its
+			// declaration, unless that is parenthesized.
+			if !n.Lparen.IsValid() {
+				return n.Doc.Text()
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// packageDoc returns the text of the package comment of pkg, or ""
+// if it has none or its syntax was not loaded.
+func packageDoc(lprog *loader.Program, pkg *types.Package) string {
+	if pkg == nil {
+		return ""
+	}
+	if info := lprog.Package(pkg.Path()); info != nil {
+		for _, f := range info.Files {
+			if f.Doc != nil {
+				return f.Doc.Text()
+			}
+		}
+	}
+	return ""
+}
+
 // pathToString returns a string containing the concrete types of the
 // nodes in path.
 func pathToString(path []ast.Node) string {
@@ -930,7 +1397,7 @@ func isAccessibleFrom(obj types.Object, pkg *types.Package) bool {
 	return ast.IsExported(obj.Name()) || obj.Pkg() == pkg
 }
 
-func methodsToSerial(this *types.Package, methods []*types.Selection, fset *token.FileSet) []serial.DescribeMethod {
+func methodsToSerial(this *types.Package, methods []*types.Selection, s *serializer) []serial.DescribeMethod {
 	qualifier := types.RelativeTo(this)
 	var jmethods []serial.DescribeMethod
 	for _, meth := range methods {
@@ -938,10 +1405,28 @@ func methodsToSerial(this *types.Package, methods []*types.Selection, fset *toke
 		if meth != nil { // may contain nils when called by implements (on a method)
 			ser = serial.DescribeMethod{
 				Name: types.SelectionString(meth, qualifier),
-				Pos:  fset.Position(meth.Obj().Pos()).String(),
+				Pos:  s.pos(meth.Obj().Pos()),
+				Via:  promotionPath(meth),
 			}
 		}
 		jmethods = append(jmethods, ser)
 	}
 	return jmethods
 }
+
+func fieldsToSerial(fields []describeField, s *serializer) []serial.DescribeField {
+	var jfields []serial.DescribeField
+	for _, f := range fields {
+		var via []string
+		for _, fld := range f.implicits {
+			via = append(via, fld.Obj().Name())
+		}
+		jfields = append(jfields, serial.DescribeField{
+			Name: f.field.Name(),
+			Type: types.TypeString(f.field.Type(), types.RelativeTo(f.field.Pkg())),
+			Pos:  s.pos(f.field.Pos()),
+			Via:  strings.Join(via, "."),
+		})
+	}
+	return jfields
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru_test
+
+// This file defines a line-based diff in the unified format, so that
+// the golden tests need no external diff command.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A diffOp is a line of an edit script: kind is ' ' for a line common
+// to both texts, '-' for a line deleted from the first, or '+' for a
+// line inserted from the second.
+type diffOp struct {
+	kind byte
+	line string // including its newline, if any
+}
+
+// diffOps returns a minimal edit script transforming the lines a
+// into the lines b, computed from their longest common subsequence.
+// It takes time and space proportional to len(a)*len(b), which is
+// fine for golden files.
+func diffOps(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
+
+// unifiedDiff returns the differences between the texts a and b,
+// named aname and bname, in the unified format of 'diff -u', with
+// three lines of context; or "" if they are equal.
+func unifiedDiff(aname, bname, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffOps(splitLines(a), splitLines(b))
+
+	// aline[k] and bline[k] are the numbers of the lines of a
+	// and b that precede ops[k].
+	aline := make([]int, len(ops)+1)
+	bline := make([]int, len(ops)+1)
+	for k, op := range ops {
+		aline[k+1], bline[k+1] = aline[k], bline[k]
+		if op.kind != '+' {
+			aline[k+1]++
+		}
+		if op.kind != '-' {
+			bline[k+1]++
+		}
+	}
+
+	const context = 3
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aname, bname)
+	for start := 0; start < len(ops); {
+		// Find the next change.
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk over the changes separated by
+		// at most twice the context.
+		end := start + 1
+		for k := end; k < len(ops) && k-end <= 2*context; k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			}
+		}
+		lo, hi := start-context, end+context
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(aline[lo], aline[hi]), hunkRange(bline[lo], bline[hi]))
+		for _, op := range ops[lo:hi] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = hi
+	}
+	return buf.String()
+}
+
+// hunkRange returns the range of the lines numbered from+1 to to, as
+// in the header of a hunk.  As with GNU diff, the count is omitted if
+// it is 1, and an empty range is numbered by the line before it.
+func hunkRange(from, to int) string {
+	switch to - from {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprint(to)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+// splitLines splits s into lines, each with its newline, except
+// perhaps the last.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, test := range []struct {
+		a, b, want string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nx\nc\n", `--- a
++++ b
+@@ -1,3 +1,3 @@
+ a
+-b
++x
+ c
+`},
+		{"", "a\n", `--- a
++++ b
+@@ -0,0 +1 @@
++a
+`},
+		{"a\nb", "a\nb\n", `--- a
++++ b
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`},
+		// Distant changes make separate hunks.
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n", `--- a
++++ b
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -7,4 +8,3 @@
+ 7
+ 8
+ 9
+-10
+`},
+	} {
+		got := unifiedDiff("a", "b", test.a, test.b)
+		if got != test.want {
+			t.Errorf("unifiedDiff(%q, %q) =\n%s\nwant:\n%s", test.a, test.b, got, test.want)
+		}
+	}
+}
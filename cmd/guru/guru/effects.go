@@ -0,0 +1,232 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// effects reports the package-level variables that the function at
+// the selected position may read or write, itself or through the
+// functions that it may call, directly or indirectly, according to
+// the call graph, those started by its go statements included.  For
+// each variable, it reports the first load and the first store of it
+// that it finds, searching the callees breadth first.
+//
+// A load or store of a field or array element of a variable is an
+// access of the variable.  An access through a pointer is one of each
+// variable to which the pointer analysis finds that it may point.
+func effects(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	pkg := a.prog.Package(qpos.info.Pkg)
+	if pkg == nil {
+		return nil, errorf(codeAnalysis, "no SSA package")
+	}
+
+	if !ssa.HasEnclosingFunction(pkg, qpos.path) {
+		return nil, fmt.Errorf("this position is not inside a function")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	target := ssa.EnclosingFunction(pkg, qpos.path)
+	if target == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	// Find the loads and stores of every function, and query the
+	// pointer analysis for the addresses that are not those of a
+	// package-level variable, which the call graph determines.
+	accesses := make(map[*ssa.Function][]memAccess)
+	for fn := range ssautil.AllFunctions(a.prog) {
+		for _, acc := range memAccesses(fn) {
+			if globalOf(acc.addr) == nil {
+				a.ptaConfig.AddQuery(acc.addr)
+			}
+			accesses[fn] = append(accesses[fn], acc)
+		}
+	}
+	a.needPTA = true
+	a.needCallGraph()
+
+	return func() error {
+		fset := a.prog.Fset
+		res := &effectsResult{qpos: qpos, fn: target}
+		effects := make(map[*ssa.Global]*globalEffect)
+		for _, fn := range calleesOf(a.cg, target) {
+			accs := accesses[fn]
+			sort.Slice(accs, func(i, j int) bool { return lessPos(fset, accs[i].pos, accs[j].pos) })
+			for i := range accs {
+				acc := &accs[i]
+				for _, g := range accessedGlobals(a, acc.addr) {
+					e := effects[g]
+					if e == nil {
+						e = &globalEffect{global: g}
+						effects[g] = e
+						res.effects = append(res.effects, e)
+					}
+					if acc.write && e.write == nil {
+						e.write = acc
+					} else if !acc.write && e.read == nil {
+						e.read = acc
+					}
+				}
+			}
+		}
+		sort.Slice(res.effects, func(i, j int) bool {
+			return res.effects[i].global.String() < res.effects[j].global.String()
+		})
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// calleesOf returns fn and the functions that it may call, directly or
+// indirectly, according to cg, breadth first.
+func calleesOf(cg *callgraph.Graph, fn *ssa.Function) []*ssa.Function {
+	node := cg.Nodes[fn]
+	if node == nil {
+		return []*ssa.Function{fn}
+	}
+	seen := map[*callgraph.Node]bool{node: true}
+	queue := []*callgraph.Node{node}
+	var fns []*ssa.Function
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		fns = append(fns, node.Func)
+		for _, edge := range node.Out {
+			if !seen[edge.Callee] {
+				seen[edge.Callee] = true
+				queue = append(queue, edge.Callee)
+			}
+		}
+	}
+	return fns
+}
+
+// globalOf returns the package-level variable of which addr is the
+// address, or that of one of its fields or array elements, or nil.
+func globalOf(addr ssa.Value) *ssa.Global {
+	for {
+		switch v := addr.(type) {
+		case *ssa.Global:
+			return v
+		case *ssa.FieldAddr:
+			addr = v.X
+		case *ssa.IndexAddr:
+			if _, ok := v.X.Type().Underlying().(*types.Pointer); !ok {
+				return nil // an element of a slice
+			}
+			addr = v.X
+		default:
+			return nil
+		}
+	}
+}
+
+// accessedGlobals returns the package-level variables of the source
+// that addr, the address of a load or store, may denote.
+func accessedGlobals(a *analysis, addr ssa.Value) []*ssa.Global {
+	var globals []*ssa.Global
+	if g := globalOf(addr); g != nil {
+		globals = append(globals, g)
+	} else if ptr, ok := a.ptares.Queries[addr]; ok {
+		seen := make(map[*ssa.Global]bool)
+		for _, label := range ptr.PointsTo().Labels() {
+			if g, ok := label.Value().(*ssa.Global); ok && !seen[g] {
+				seen[g] = true
+				globals = append(globals, g)
+			}
+		}
+	}
+	// Omit synthetic variables, such as init$guard.
+	i := 0
+	for _, g := range globals {
+		if g.Object() != nil {
+			globals[i] = g
+			i++
+		}
+	}
+	return globals[:i]
+}
+
+// A globalEffect is a package-level variable that a function may
+// access, with a representative load and store of it.
+type globalEffect struct {
+	global      *ssa.Global
+	read, write *memAccess // nil if there is none
+}
+
+type effectsResult struct {
+	resultSource
+	qpos    *queryPos
+	fn      *ssa.Function   // the selected function
+	effects []*globalEffect // in order of name
+}
+
+func (r *effectsResult) PrintPlain(printf printfFunc) {
+	pkg := r.qpos.info.Pkg
+	if len(r.effects) == 0 {
+		printf(r.qpos, "%s accesses no package-level variables.", r.fn.RelString(pkg))
+		return
+	}
+	printf(r.qpos, "%s may access these package-level variables:", r.fn.RelString(pkg))
+	for _, e := range r.effects {
+		name := e.global.RelString(pkg)
+		if e.read != nil {
+			printf(e.read.pos, "\t%s, read here, in %s", name, e.read.fn.RelString(pkg))
+		}
+		if e.write != nil {
+			printf(e.write.pos, "\t%s, written here, in %s", name, e.write.fn.RelString(pkg))
+		}
+	}
+}
+
+func (r *effectsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *effectsResult) serialize(s *serializer) interface{} {
+	effects := &serial.Effects{
+		Version: serial.Version,
+		Pos:     s.pos(r.qpos.start),
+		Func:    r.fn.String(),
+	}
+	access := func(acc *memAccess) *serial.EffectAccess {
+		if acc == nil {
+			return nil
+		}
+		return &serial.EffectAccess{
+			Pos:  s.pos(acc.pos),
+			Func: acc.fn.String(),
+		}
+	}
+	for _, e := range r.effects {
+		effects.Globals = append(effects.Globals, serial.EffectGlobal{
+			Name:  e.global.String(),
+			Pos:   s.pos(e.global.Pos()),
+			Read:  access(e.read),
+			Write: access(e.write),
+		})
+	}
+	effects.Offsets = s.offsets()
+	return effects
+}
@@ -0,0 +1,9 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// PosRange exports posRange for the golden tests of guru_test, which
+// check the positions of the results of queries.
+var PosRange = posRange
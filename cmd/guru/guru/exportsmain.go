@@ -0,0 +1,215 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the synthetic main package of a library, which
+// calls its exported functions and methods, so that the pointer
+// analysis, which starts from main packages, may analyze a library
+// that has no tests.
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// createExportsMainPackage creates and returns a synthetic "main"
+// package for the specified package whose main function calls each
+// of its exported functions, and the exported methods of its exported
+// named types, with zero arguments; or nil if it has none that a main
+// package can call.  Functions whose parameters are of types that
+// cannot be named outside the package are not called.
+//
+// Like ssa.CreateTestMainPackage, it adds the new package to prog.
+func createExportsMainPackage(prog *ssa.Program, pkg *ssa.Package) *ssa.Package {
+	if pkg.Pkg.Name() == "main" {
+		return nil // not importable
+	}
+	q := &exportsQualifier{pkg: pkg.Pkg, names: make(map[*types.Package]string)}
+	var calls []string // the statements of main
+	scope := pkg.Pkg.Scope()
+	for _, name := range scope.Names() {
+		if !ast.IsExported(name) {
+			continue
+		}
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Func:
+			if args, ok := q.zeroArgs(obj.Type().(*types.Signature)); ok {
+				calls = append(calls, fmt.Sprintf("p.%s(%s)", name, args))
+			}
+		case *types.TypeName:
+			T := obj.Type()
+			if obj.IsAlias() || isInterface(T) || !q.nameable(T) {
+				continue
+			}
+			mset := types.NewMethodSet(types.NewPointer(T))
+			for i := 0; i < mset.Len(); i++ {
+				m := mset.At(i).Obj()
+				if !m.Exported() {
+					continue
+				}
+				if args, ok := q.zeroArgs(m.Type().(*types.Signature)); ok {
+					calls = append(calls, fmt.Sprintf("new(p.%s).%s(%s)", name, m.Name(), args))
+				}
+			}
+		}
+	}
+	if calls == nil {
+		return nil
+	}
+
+	// Synthesize the source of the package.
+	path := pkg.Pkg.Path() + "$main"
+	var buf bytes.Buffer
+	buf.WriteString("package main\n\n")
+	fmt.Fprintf(&buf, "import p %q\n", pkg.Pkg.Path())
+	for _, dep := range q.deps {
+		fmt.Fprintf(&buf, "import %s %q\n", q.names[dep], dep.Path())
+	}
+	buf.WriteString("\nfunc main() {\n")
+	for _, call := range calls {
+		fmt.Fprintf(&buf, "\t%s\n", call)
+	}
+	buf.WriteString("}\n")
+
+	// Parse and type-check it.  Failure is an internal error,
+	// which leaves the package out of the analysis.
+	f, err := parser.ParseFile(prog.Fset, path+".go", &buf, 0)
+	if err != nil {
+		return nil
+	}
+	conf := types.Config{Importer: q}
+	files := []*ast.File{f}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Scopes:     make(map[ast.Node]*types.Scope),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	mainPkg, err := conf.Check(path, prog.Fset, files, info)
+	if err != nil {
+		return nil
+	}
+
+	// Create and build SSA code.
+	main := prog.CreatePackage(mainPkg, files, info, false)
+	main.SetDebugMode(false)
+	main.Build()
+	main.Func("main").Synthetic = "main function of exported functions"
+	main.Func("init").Synthetic = "package initializer"
+	return main
+}
+
+// An exportsQualifier names the types of the arguments of the calls of
+// a synthetic main package: those of pkg are qualified by "p", and
+// those of each other package by the name of its import.  It is also
+// the importer of those packages.
+type exportsQualifier struct {
+	pkg   *types.Package
+	names map[*types.Package]string // names of the imports, by package
+	deps  []*types.Package          // packages other than pkg, in order of import
+}
+
+func (q *exportsQualifier) qualify(pkg *types.Package) string {
+	if pkg == q.pkg {
+		return "p"
+	}
+	name, ok := q.names[pkg]
+	if !ok {
+		name = fmt.Sprintf("p%d", len(q.deps))
+		q.names[pkg] = name
+		q.deps = append(q.deps, pkg)
+	}
+	return name
+}
+
+func (q *exportsQualifier) Import(path string) (*types.Package, error) {
+	if path == q.pkg.Path() {
+		return q.pkg, nil
+	}
+	for _, dep := range q.deps {
+		if dep.Path() == path {
+			return dep, nil
+		}
+	}
+	return nil, fmt.Errorf("not found") // can't happen
+}
+
+// zeroArgs returns the arguments, of zero values, of a call to a
+// function of signature sig, and whether they can be written outside
+// the package.  A variadic function is called with no variadic
+// arguments.
+func (q *exportsQualifier) zeroArgs(sig *types.Signature) (string, bool) {
+	n := sig.Params().Len()
+	if sig.Variadic() {
+		n--
+	}
+	args := make([]string, n)
+	for i := range args {
+		T := sig.Params().At(i).Type()
+		if !q.nameable(T) {
+			return "", false
+		}
+		args[i] = fmt.Sprintf("*new(%s)", types.TypeString(T, q.qualify))
+	}
+	return strings.Join(args, ", "), true
+}
+
+// nameable reports whether the type T can be written outside the
+// package that declares it: whether its named types are exported, and
+// its structs and interfaces have only exported fields and methods.
+func (q *exportsQualifier) nameable(T types.Type) bool {
+	switch T := T.(type) {
+	case *types.Basic:
+		return T.Kind() != types.Invalid && T.Kind() != types.UnsafePointer &&
+			T.Info()&types.IsUntyped == 0
+	case *types.Named:
+		obj := T.Obj()
+		return obj.Pkg() == nil || obj.Exported() && !strings.Contains(obj.Pkg().Path(), "$")
+	case *types.Pointer:
+		return q.nameable(T.Elem())
+	case *types.Slice:
+		return q.nameable(T.Elem())
+	case *types.Array:
+		return q.nameable(T.Elem())
+	case *types.Chan:
+		return q.nameable(T.Elem())
+	case *types.Map:
+		return q.nameable(T.Key()) && q.nameable(T.Elem())
+	case *types.Signature:
+		return q.nameableTuple(T.Params()) && q.nameableTuple(T.Results())
+	case *types.Struct:
+		for i := 0; i < T.NumFields(); i++ {
+			if f := T.Field(i); !f.Exported() || !q.nameable(f.Type()) {
+				return false
+			}
+		}
+		return true
+	case *types.Interface:
+		for i := 0; i < T.NumMethods(); i++ {
+			if m := T.Method(i); !m.Exported() || !q.nameable(m.Type()) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (q *exportsQualifier) nameableTuple(tuple *types.Tuple) bool {
+	for i := 0; i < tuple.Len(); i++ {
+		if !q.nameable(tuple.At(i).Type()) {
+			return false
+		}
+	}
+	return true
+}
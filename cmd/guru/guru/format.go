@@ -0,0 +1,289 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the output formats of the guru command: plain
+// text, JSON, newline-delimited JSON, XML, DOT, GraphML and JSON lines.  Further formats, such as HTML
+// or those of particular editors, may be added with RegisterFormatter.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+)
+
+// A Formatter writes the results of queries in an output format.
+// It receives each result as the QueryResult of the query, not as
+// text, so that it may render the result in its own way, from its
+// JSON form, its plain text, or, for a query of the call graph, its
+// edges (see graphResult).  Calls are not concurrent.
+type Formatter interface {
+	// Format writes the result qr, whose positions are in fset, to w.
+	Format(w io.Writer, fset *token.FileSet, qr QueryResult) error
+}
+
+// An ErrorFormatter is a Formatter that also writes the failures of
+// queries in its format.  The failures of queries whose Formatter is
+// not an ErrorFormatter are reported as messages on standard error.
+type ErrorFormatter interface {
+	Formatter
+
+	// FormatError writes the failure e of a query to w.
+	FormatError(w io.Writer, e *serial.Error) error
+}
+
+// FormatOptions are the options of the guru command that concern
+// the output formats.
+type FormatOptions struct {
+	Editor string // the syntax of positions in plain text: "emacs", "vim" or "acme"
+	Runes  bool   // columns of positions are in runes, not bytes
+	Color  bool   // plain text is colored
+	Root   string // if nonempty, file names within this directory are relative to it
+}
+
+// formatters holds the constructor of the Formatter of each output
+// format, by name.
+var formatters = map[string]func(opts *FormatOptions) Formatter{
+	"plain":   func(opts *FormatOptions) Formatter { return plainFormatter{opts} },
+	"json":    func(opts *FormatOptions) Formatter { return jsonFormatter{opts} },
+	"xml":     func(opts *FormatOptions) Formatter { return xmlFormatter{opts} },
+	"dot":     func(opts *FormatOptions) Formatter { return dotFormatter{opts} },
+	"graphml": func(opts *FormatOptions) Formatter { return graphmlFormatter{opts} },
+	"jsonl":   func(opts *FormatOptions) Formatter { return jsonlFormatter{opts} },
+	"ndjson":  func(opts *FormatOptions) Formatter { return ndjsonFormatter{opts} },
+}
+
+// RegisterFormatter makes an output format available, under the
+// specified name, to NewFormatter, alongside those of the -format flag
+// of the guru command.  The constructor new returns its Formatter for
+// the specified options.
+// It panics if a format of that name exists already.
+func RegisterFormatter(name string, new func(opts *FormatOptions) Formatter) {
+	if _, ok := formatters[name]; ok {
+		panic("RegisterFormatter: duplicate format " + name)
+	}
+	formatters[name] = new
+}
+
+// NewFormatter returns the Formatter of the output format named by
+// format, for the specified options.  As with the -format flag of the
+// guru command, a format that contains "{{" is instead a template
+// (see templateFormatter).
+func NewFormatter(format string, opts *FormatOptions) (Formatter, error) {
+	if isTemplateFormat(format) {
+		new, err := parseTemplateFormat(format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %v", err)
+		}
+		return new(opts), nil
+	}
+	new, ok := formatters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q: want %s, or a template", format, formatNames())
+	}
+	return new(opts), nil
+}
+
+// formatNames returns the names of the output formats, in the form
+// "a, b, or c", for messages: the standard ones, then the others in
+// order.
+func formatNames() string {
+	names := []string{"plain", "json", "jsonl", "ndjson", "xml"}
+	var others []string
+	for name := range formatters {
+		switch name {
+		case "plain", "json", "jsonl", "ndjson", "xml", "dot", "graphml":
+		default:
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	names = append(append(names, others...), "dot", "or graphml")
+	return strings.Join(names, ", ")
+}
+
+// serializer returns a serializer of the positions, in fset, of the
+// result qr, to the form specified by opts.
+func (opts *FormatOptions) serializer(fset *token.FileSet, qr QueryResult) *serializer {
+	return newSerializer(fset, sourceOf(qr), opts)
+}
+
+// resultJSON returns the JSON of the result qr, whose positions are in
+// fset, with its positions in the form specified by opts.  Those of a
+// result of another package are as its JSON method writes them.
+func (opts *FormatOptions) resultJSON(fset *token.FileSet, qr QueryResult) []byte {
+	if r, ok := qr.(serialResult); ok {
+		return streamJSON(r.serialize(opts.serializer(fset, qr)))
+	}
+	return qr.JSON(fset)
+}
+
+// plainFormatter writes results as plain text, one "pos: text" line
+// at a time.
+type plainFormatter struct{ opts *FormatOptions }
+
+func (f plainFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	src := sourceOf(qr)
+	qr.PrintPlain(func(pos interface{}, format string, args ...interface{}) {
+		fprintf(w, f.opts.Editor, f.opts.Runes, f.opts.Color, f.opts.Root, fset, src, pos, format, args...)
+	})
+	return nil
+}
+
+// jsonFormatter writes results as JSON (see package serial).
+type jsonFormatter struct{ opts *FormatOptions }
+
+func (f jsonFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	_, err := fmt.Fprintf(w, "%s\n", f.opts.resultJSON(fset, qr))
+	return err
+}
+
+func (f jsonFormatter) FormatError(w io.Writer, e *serial.Error) error {
+	_, err := fmt.Fprintf(w, "%s\n", toJSON(e))
+	return err
+}
+
+// ndjsonFormatter writes results as newline-delimited JSON: each
+// object of the result stream, and each element of a result that is
+// an array, as compact JSON on a line of its own.
+type ndjsonFormatter struct{ opts *FormatOptions }
+
+// An elementsResult is a QueryResult whose elements -format=ndjson
+// writes one at a time, as each is computed, rather than from the
+// JSON of the whole: the calls of callgraph, the references of
+// referrers.
+type elementsResult interface {
+	QueryResult
+
+	// elements calls emit with the serial form of each element, in
+	// order, converting its positions using s.
+	elements(s *serializer, emit func(elem interface{}) error) error
+}
+
+func (f ndjsonFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	emit := func(data []byte) error {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	emitObject := func(obj interface{}) error { return emit(toJSON(obj)) }
+	switch r := qr.(type) {
+	case elementsResult:
+		return r.elements(f.opts.serializer(fset, qr), emitObject)
+	case serialResult:
+		for _, obj := range serialObjects(r.serialize(f.opts.serializer(fset, qr))) {
+			if err := emitObject(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data := bytes.TrimSpace(qr.JSON(fset))
+	if !bytes.HasPrefix(data, []byte("[")) {
+		return emit(data)
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		if err := emit(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f ndjsonFormatter) FormatError(w io.Writer, e *serial.Error) error {
+	return jsonlFormatter{f.opts}.FormatError(w, e)
+}
+
+// xmlFormatter writes results as XML of the structure of their JSON.
+type xmlFormatter struct{ opts *FormatOptions }
+
+func (f xmlFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	return writeXML(w, f.opts.resultJSON(fset, qr))
+}
+
+func (f xmlFormatter) FormatError(w io.Writer, e *serial.Error) error {
+	return writeXML(w, toJSON(e))
+}
+
+func writeXML(w io.Writer, data []byte) error {
+	data, err := toXML(data)
+	if err != nil {
+		return fmt.Errorf("XML error: %v", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// dotFormatter writes the results of queries of the call graph as
+// Graphviz digraphs.
+type dotFormatter struct{ opts *FormatOptions }
+
+func (f dotFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	if tr, ok := qr.(*truncatedResult); ok {
+		_, err := fmt.Fprintf(w, "// %s\n", tr.text())
+		return err
+	}
+	gr, ok := qr.(graphResult)
+	if !ok {
+		return fmt.Errorf("-format=dot is not supported by this query")
+	}
+	_, err := fmt.Fprintf(w, "%s\n", toDOT(f.opts.serializer(fset, gr), gr))
+	return err
+}
+
+// graphmlFormatter writes the results of queries of the call graph as
+// GraphML documents, for tools such as Gephi and yEd.
+type graphmlFormatter struct{ opts *FormatOptions }
+
+func (f graphmlFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	if tr, ok := qr.(*truncatedResult); ok {
+		_, err := fmt.Fprintf(w, "<!-- %s -->\n", strings.Replace(tr.text(), "--", "- -", -1))
+		return err
+	}
+	gr, ok := qr.(graphResult)
+	if !ok {
+		return fmt.Errorf("-format=graphml is not supported by this query")
+	}
+	return writeGraphML(w, f.opts.serializer(fset, gr), gr)
+}
+
+// jsonlFormatter writes the results of queries of the call graph as
+// one serial.CallEdge per line.
+type jsonlFormatter struct{ opts *FormatOptions }
+
+func (f jsonlFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	if tr, ok := qr.(*truncatedResult); ok {
+		var buf bytes.Buffer
+		json.Compact(&buf, tr.JSON(fset))
+		_, err := fmt.Fprintf(w, "%s\n", buf.Bytes())
+		return err
+	}
+	gr, ok := qr.(graphResult)
+	if !ok {
+		return fmt.Errorf("-format=jsonl is not supported by this query")
+	}
+	return writeEdges(w, f.opts.serializer(fset, gr), gr)
+}
+
+func (f jsonlFormatter) FormatError(w io.Writer, e *serial.Error) error {
+	var buf bytes.Buffer
+	json.Compact(&buf, toJSON(e))
+	_, err := fmt.Fprintf(w, "%s\n", buf.Bytes())
+	return err
+}
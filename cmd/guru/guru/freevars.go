@@ -0,0 +1,454 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/loader"
+)
+
+// freevars displays the lexical (not package-level) free variables of
+// the selection.
+//
+// It treats A.B.C as a separate variable from A to reveal the parts
+// of an aggregate type that are actually needed.
+// This aids refactoring.
+//
+// It also reports the signature of the function that would result
+// from extracting the selection; see extractSignature.
+//
+// TODO(adonovan): optionally display the free references to
+// file/package scope objects, and to objects from other packages.
+// Depending on where the resulting function abstraction will go,
+// these might be interesting.  Perhaps group the results into three
+// bands.
+//
+func freevars(q *Query) error {
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
+	allowErrors(&lconf)
+	cancelHook(q, &lconf)
+
+	if _, err := importQueryPackage(q.Pos, &lconf); err != nil {
+		return err
+	}
+
+	// Load/parse/type-check the program.
+	cache := newPkgCache(q, &lconf)
+	progressHook(q, &lconf, "load")
+	end := beginPhase(q, "load")
+	lprog, err := load(q, &lconf)
+	end()
+	if err := canceled(q); err != nil {
+		return err
+	}
+	if err != nil {
+		return withCode(codeLoad, err)
+	}
+	cache.save(lprog)
+	reportTypeErrors(q, errorPackages(lprog)) // the results are best-effort
+
+	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	if err != nil {
+		return err
+	}
+
+	file := qpos.path[len(qpos.path)-1] // the enclosing file
+	fileScope := qpos.info.Scopes[file]
+	pkgScope := fileScope.Parent()
+
+	// The id and sel functions return non-nil if they denote an
+	// object o or selection o.x.y that is referenced by the
+	// selection but defined neither within the selection nor at
+	// file scope, i.e. it is in the lexical environment.
+	var id func(n *ast.Ident) types.Object
+	var sel func(n *ast.SelectorExpr) types.Object
+
+	sel = func(n *ast.SelectorExpr) types.Object {
+		switch x := unparen(n.X).(type) {
+		case *ast.SelectorExpr:
+			return sel(x)
+		case *ast.Ident:
+			return id(x)
+		}
+		return nil
+	}
+
+	id = func(n *ast.Ident) types.Object {
+		obj := qpos.info.Uses[n]
+		if obj == nil {
+			return nil // not a reference
+		}
+		if _, ok := obj.(*types.PkgName); ok {
+			return nil // imported package
+		}
+		if !(file.Pos() <= obj.Pos() && obj.Pos() <= file.End()) {
+			return nil // not defined in this file
+		}
+		scope := obj.Parent()
+		if scope == nil {
+			return nil // e.g. interface method, struct field
+		}
+		if scope == fileScope || scope == pkgScope {
+			return nil // defined at file or package scope
+		}
+		if qpos.start <= obj.Pos() && obj.Pos() <= qpos.end {
+			return nil // defined within selection => not free
+		}
+		return obj
+	}
+
+	// Maps each reference that is free in the selection
+	// to the object it refers to.
+	// The map de-duplicates repeated references.
+	refsMap := make(map[string]freevarsRef)
+
+	// Visit all the identifiers in the selected ASTs.
+	ast.Inspect(qpos.path[0], func(n ast.Node) bool {
+		if n == nil {
+			return true // popping DFS stack
+		}
+
+		// Is this node contained within the selection?
+		// (freevars permits inexact selections,
+		// like two stmts in a block.)
+		if qpos.start <= n.Pos() && n.End() <= qpos.end {
+			var obj types.Object
+			var prune bool
+			switch n := n.(type) {
+			case *ast.Ident:
+				obj = id(n)
+
+			case *ast.SelectorExpr:
+				obj = sel(n)
+				prune = true
+			}
+
+			if obj != nil {
+				var kind string
+				switch obj.(type) {
+				case *types.Var:
+					kind = "var"
+				case *types.Func:
+					kind = "func"
+				case *types.TypeName:
+					kind = "type"
+				case *types.Const:
+					kind = "const"
+				case *types.Label:
+					kind = "label"
+				default:
+					panic(obj)
+				}
+
+				typ := qpos.info.TypeOf(n.(ast.Expr))
+				ref := freevarsRef{kind, printNode(lprog.Fset, n), typ, obj}
+				refsMap[ref.ref] = ref
+
+				if prune {
+					return false // don't descend
+				}
+			}
+		}
+
+		return true // descend
+	})
+
+	refs := make([]freevarsRef, 0, len(refsMap))
+	for _, ref := range refsMap {
+		refs = append(refs, ref)
+	}
+	sort.Sort(byRef(refs))
+
+	q.Output(lprog.Fset, &freevarsResult{
+		qpos: qpos,
+		refs: refs,
+		sig:  extractSignature(qpos, refs),
+	})
+	return nil
+}
+
+type freevarsResult struct {
+	resultSource
+	qpos *queryPos
+	refs []freevarsRef
+	sig  *extractSig
+}
+
+// An extractSig is the signature of the function that would result
+// from extracting the selection: its parameters are the free
+// variables and constants, and its results are the variables defined
+// in the selection and used after it.
+type extractSig struct {
+	params, results []extractVar
+	expr            types.Type // type of the selected expression, if any
+}
+
+type extractVar struct {
+	name    string
+	typ     types.Type
+	pointer bool // variable is assigned in the selection, so must be passed by address
+}
+
+// extractSignature returns the signature of the function that would
+// result from extracting the selection, whose free references are refs.
+// It does not account for control flow, such as a return statement,
+// that leaves the selection.
+func extractSignature(qpos *queryPos, refs []freevarsRef) *extractSig {
+	info := qpos.info
+	inSelection := func(pos token.Pos) bool {
+		return qpos.start <= pos && pos <= qpos.end
+	}
+	sig := new(extractSig)
+	if e, ok := qpos.path[0].(ast.Expr); ok && qpos.exact {
+		if tv, ok := info.Types[e]; ok && tv.IsValue() {
+			sig.expr = types.Default(tv.Type)
+		}
+	}
+
+	// Find the free variables that the selection assigns or
+	// whose address it takes.
+	assigned := make(map[types.Object]bool)
+	markAssigned := func(e ast.Expr) {
+		for {
+			switch x := e.(type) {
+			case *ast.ParenExpr:
+				e = x.X
+				continue
+			case *ast.Ident:
+				if obj := info.Uses[x]; obj != nil {
+					assigned[obj] = true
+				}
+			case *ast.SelectorExpr:
+				// Assigning a field of a struct variable assigns the variable.
+				if sel, ok := info.Selections[x]; ok && sel.Kind() == types.FieldVal && !isPointer(info.TypeOf(x.X)) {
+					e = x.X
+					continue
+				}
+			case *ast.IndexExpr:
+				// Likewise for an element of an array variable.
+				if _, ok := info.TypeOf(x.X).Underlying().(*types.Array); ok {
+					e = x.X
+					continue
+				}
+			}
+			return
+		}
+	}
+	ast.Inspect(qpos.path[0], func(n ast.Node) bool {
+		if n == nil || !(qpos.start <= n.Pos() && n.End() <= qpos.end) {
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				markAssigned(lhs)
+			}
+		case *ast.IncDecStmt:
+			markAssigned(n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				if n.Key != nil {
+					markAssigned(n.Key)
+				}
+				if n.Value != nil {
+					markAssigned(n.Value)
+				}
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				markAssigned(n.X)
+			}
+		case *ast.SelectorExpr:
+			// A call of a pointer method on a variable takes its address.
+			if sel, ok := info.Selections[n]; ok && sel.Kind() == types.MethodVal {
+				if isPointer(sel.Obj().Type().(*types.Signature).Recv().Type()) && !isPointer(info.TypeOf(n.X)) {
+					markAssigned(n.X)
+				}
+			}
+		}
+		return true
+	})
+
+	// The parameters are the free variables and constants,
+	// in order of declaration.
+	names := make(map[string]bool)
+	addVar := func(vars []extractVar, name string, typ types.Type, pointer bool) []extractVar {
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+		}
+		names[name] = true
+		return append(vars, extractVar{name, typ, pointer})
+	}
+	var objs []types.Object
+	seen := make(map[types.Object]bool)
+	for _, ref := range refs {
+		switch ref.obj.(type) {
+		case *types.Var, *types.Const:
+			if !seen[ref.obj] {
+				seen[ref.obj] = true
+				objs = append(objs, ref.obj)
+			}
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Pos() < objs[j].Pos() })
+	for _, obj := range objs {
+		_, isVar := obj.(*types.Var)
+		sig.params = addVar(sig.params, obj.Name(), types.Default(obj.Type()), isVar && assigned[obj])
+	}
+
+	// The results are the variables defined within the selection
+	// and used after it in the enclosing function.
+	var body ast.Node
+	for _, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if body != nil {
+			break
+		}
+	}
+	if body != nil {
+		seen := make(map[types.Object]bool)
+		objs = nil
+		ast.Inspect(body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Pos() > qpos.end {
+				if obj, ok := info.Uses[id].(*types.Var); ok && inSelection(obj.Pos()) && !seen[obj] {
+					seen[obj] = true
+					objs = append(objs, obj)
+				}
+			}
+			return true
+		})
+		sort.Slice(objs, func(i, j int) bool { return objs[i].Pos() < objs[j].Pos() })
+		for _, obj := range objs {
+			sig.results = addVar(sig.results, obj.Name(), obj.Type(), false)
+		}
+	}
+	return sig
+}
+
+// String returns the signature in Go syntax, relative to the query package.
+func (sig *extractSig) String(qpos *queryPos) string {
+	var buf bytes.Buffer
+	buf.WriteString("func(")
+	for i, v := range sig.params {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(v.name)
+		buf.WriteByte(' ')
+		buf.WriteString(v.typeString(qpos))
+	}
+	buf.WriteByte(')')
+	switch {
+	case sig.expr != nil:
+		fmt.Fprintf(&buf, " %s", qpos.typeString(sig.expr))
+	case len(sig.results) > 0:
+		buf.WriteString(" (")
+		for i, v := range sig.results {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(&buf, "%s %s", v.name, v.typeString(qpos))
+		}
+		buf.WriteByte(')')
+	}
+	return buf.String()
+}
+
+func (v extractVar) typeString(qpos *queryPos) string {
+	if v.pointer {
+		return "*" + qpos.typeString(v.typ)
+	}
+	return qpos.typeString(v.typ)
+}
+
+func (v extractVar) toSerial(qpos *queryPos) serial.ExtractVar {
+	return serial.ExtractVar{Name: v.name, Type: v.typeString(qpos), Pointer: v.pointer}
+}
+
+type freevarsRef struct {
+	kind string
+	ref  string
+	typ  types.Type
+	obj  types.Object
+}
+
+func (r *freevarsResult) PrintPlain(printf printfFunc) {
+	if len(r.refs) == 0 {
+		printf(r.qpos, "No free identifiers.")
+	} else {
+		printf(r.qpos, "Free identifiers:")
+		qualifier := types.RelativeTo(r.qpos.info.Pkg)
+		for _, ref := range r.refs {
+			// Avoid printing "type T T".
+			var typstr string
+			if ref.kind != "type" && ref.kind != "label" {
+				typstr = " " + types.TypeString(ref.typ, qualifier)
+			}
+			printf(ref.obj, "%s %s%s", ref.kind, ref.ref, typstr)
+		}
+	}
+	printf(r.qpos, "Extracted function signature: %s", r.sig.String(r.qpos))
+}
+
+func (r *freevarsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *freevarsResult) serialize(s *serializer) interface{} {
+	var stream serialStream
+	for _, ref := range r.refs {
+		stream = append(stream, &serial.FreeVar{
+			Version: serial.Version,
+			Pos:     s.pos(ref.obj.Pos()),
+			Kind:    ref.kind,
+			Ref:     ref.ref,
+			Type:    ref.typ.String(),
+			Offsets: s.offsets(),
+		})
+	}
+	extract := &serial.ExtractSignature{Version: serial.Version, Signature: r.sig.String(r.qpos)}
+	for _, v := range r.sig.params {
+		extract.Params = append(extract.Params, v.toSerial(r.qpos))
+	}
+	for _, v := range r.sig.results {
+		extract.Results = append(extract.Results, v.toSerial(r.qpos))
+	}
+	if r.sig.expr != nil {
+		extract.Results = append(extract.Results, serial.ExtractVar{Type: r.qpos.typeString(r.sig.expr)})
+	}
+	return append(stream, extract)
+}
+
+// -------- utils --------
+
+func isPointer(T types.Type) bool {
+	_, ok := T.Underlying().(*types.Pointer)
+	return ok
+}
+
+type byRef []freevarsRef
+
+func (p byRef) Len() int           { return len(p) }
+func (p byRef) Less(i, j int) bool { return p[i].ref < p[j].ref }
+func (p byRef) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// printNode returns the pretty-printed syntax of n.
+func printNode(fset *token.FileSet, n ast.Node) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, n)
+	return buf.String()
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the call-graph output formats, -format=dot,
+// -format=graphml and -format=jsonl, of the callees, callers,
+// callstack and callgraph queries.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/token"
+	"go/types"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/ssa"
+)
+
+// A graphResult is a QueryResult that describes part of the call graph.
+type graphResult interface {
+	QueryResult
+
+	// DOT returns the graph as a Graphviz digraph, with the
+	// positions of QueryResult.JSON.
+	DOT(fset *token.FileSet) []byte
+
+	// callEdges calls visit for each edge of the graph, in order.
+	callEdges(visit func(callEdge))
+}
+
+// A callEdge is an edge of the call graph of a graphResult.
+type callEdge struct {
+	depth          int // depth within the tree of transitive callers, or zero
+	caller, callee graphNode
+	pos            token.Pos
+	desc           string // description of call site
+	calls          int    // callgraph: number of calls summarized by the edge, or zero
+	dynamic        bool   // the call site is dynamic: a call of a function value or interface method
+}
+
+// A graphNode is an endpoint of a callEdge: a function, the root of
+// the call graph, or, for callgraph, a package outside the focus or
+// the synthetic functions, which belong to no package.
+type graphNode struct {
+	name string    // full name of the function, "<root>", "<synthetic>", or import path of the package
+	pkg  string    // import path of the package of the function, if any
+	pos  token.Pos // position of the function, if any
+}
+
+// funcNode returns the graph node of the SSA function fn.
+func funcNode(fn *ssa.Function) graphNode {
+	return graphNode{fn.String(), pkgPathOf(fn), fn.Pos()}
+}
+
+// typesFuncNode returns the graph node of the function object fn.
+func typesFuncNode(fn *types.Func) graphNode {
+	n := graphNode{name: fn.FullName(), pos: fn.Pos()}
+	if fn.Pkg() != nil {
+		n.pkg = fn.Pkg().Path()
+	}
+	return n
+}
+
+// funcName returns the name of the function of n relative to its
+// package, e.g. "(*T).f", or "" if n is not a function.
+func (n graphNode) funcName() string {
+	switch {
+	case n.name == "<root>" || n.name == "<synthetic>" || n.name == n.pkg:
+		return "" // the root, or a package
+	case n.pkg == "":
+		return n.name // a synthetic function
+	}
+	return strings.Replace(n.name, n.pkg+".", "", 1)
+}
+
+// isDynamic reports whether site is a dynamic call: of an interface
+// method, or of a function value.
+func isDynamic(site ssa.CallInstruction) bool {
+	return site != nil && site.Common().StaticCallee() == nil
+}
+
+// toDOT returns a DOT digraph in which the nodes are functions and
+// the edges are calls, labelled by the positions of the call sites,
+// as converted by s.
+func toDOT(s *serializer, r graphResult) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph callgraph {\n")
+	r.callEdges(func(e callEdge) {
+		fmt.Fprintf(&buf, "\t%q -> %q", e.caller.name, e.callee.name)
+		if e.pos.IsValid() {
+			fmt.Fprintf(&buf, " [label=%q]", s.posString(e.pos))
+		} else if e.calls > 0 {
+			fmt.Fprintf(&buf, " [label=%q]", e.desc)
+		}
+		buf.WriteString(";\n")
+	})
+	buf.WriteString("}")
+	return buf.Bytes()
+}
+
+// writeEdges writes the edges of r to w as a stream of serial.CallEdge
+// records, one per line, as they are visited, with their positions
+// converted by s.
+func writeEdges(w io.Writer, s *serializer, r graphResult) error {
+	g := serial.CallGraph{
+		Edges: func(emit func(*serial.CallEdge) error) error {
+			var err error
+			r.callEdges(func(e callEdge) {
+				if err == nil {
+					err = emit(&serial.CallEdge{
+						Depth:  e.depth,
+						Caller: e.caller.name,
+						Callee: e.callee.name,
+						Pos:    s.posString(e.pos),
+						Desc:   e.desc,
+						Calls:  e.calls,
+					})
+				}
+			})
+			return err
+		},
+	}
+	_, err := g.WriteTo(w)
+	return err
+}
+
+// writeGraphML writes the graph of r to w as a GraphML document, in
+// which the nodes are functions, with their package, name and
+// position, and the edges are calls, with the position and
+// description of the call site, and whether it is dynamic.  The
+// edges that summarize the calls between a function and another
+// package, in callgraph, have instead their number of calls.  The
+// positions are converted by s.
+func writeGraphML(w io.Writer, s *serializer, r graphResult) error {
+	text := func(s string) string {
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	}
+
+	// Nodes are numbered in order of their first edge.
+	var nodes, edges bytes.Buffer
+	ids := make(map[string]int)
+	id := func(n graphNode) int {
+		i, ok := ids[n.name]
+		if !ok {
+			i = len(ids)
+			ids[n.name] = i
+			fmt.Fprintf(&nodes, "    <node id=\"n%d\">\n", i)
+			fmt.Fprintf(&nodes, "      <data key=\"name\">%s</data>\n", text(n.name))
+			if n.pkg != "" {
+				fmt.Fprintf(&nodes, "      <data key=\"package\">%s</data>\n", text(n.pkg))
+			}
+			if fn := n.funcName(); fn != "" {
+				fmt.Fprintf(&nodes, "      <data key=\"function\">%s</data>\n", text(fn))
+			}
+			if n.pos.IsValid() {
+				fmt.Fprintf(&nodes, "      <data key=\"npos\">%s</data>\n", text(s.posString(n.pos)))
+			}
+			nodes.WriteString("    </node>\n")
+		}
+		return i
+	}
+	r.callEdges(func(e callEdge) {
+		caller, callee := id(e.caller), id(e.callee)
+		fmt.Fprintf(&edges, "    <edge source=\"n%d\" target=\"n%d\">\n", caller, callee)
+		if e.pos.IsValid() {
+			fmt.Fprintf(&edges, "      <data key=\"epos\">%s</data>\n", text(s.posString(e.pos)))
+		}
+		fmt.Fprintf(&edges, "      <data key=\"desc\">%s</data>\n", text(e.desc))
+		if e.calls > 0 {
+			fmt.Fprintf(&edges, "      <data key=\"calls\">%d</data>\n", e.calls)
+		} else {
+			fmt.Fprintf(&edges, "      <data key=\"dynamic\">%t</data>\n", e.dynamic)
+		}
+		edges.WriteString("    </edge>\n")
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(graphMLHeader)
+	buf.Write(nodes.Bytes())
+	buf.Write(edges.Bytes())
+	buf.WriteString("  </graph>\n</graphml>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// graphMLHeader declares the attributes of the nodes and edges of
+// the GraphML graph, and begins it.
+const graphMLHeader = `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="name" for="node" attr.name="name" attr.type="string"/>
+  <key id="package" for="node" attr.name="package" attr.type="string"/>
+  <key id="function" for="node" attr.name="function" attr.type="string"/>
+  <key id="npos" for="node" attr.name="position" attr.type="string"/>
+  <key id="epos" for="edge" attr.name="position" attr.type="string"/>
+  <key id="desc" for="edge" attr.name="description" attr.type="string"/>
+  <key id="dynamic" for="edge" attr.name="dynamic" attr.type="boolean"/>
+  <key id="calls" for="edge" attr.name="calls" attr.type="int"/>
+  <graph id="callgraph" edgedefault="directed">
+`
@@ -0,0 +1,1539 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package guru answers questions about Go source code; it is the
+// query engine of the guru command.  A client runs a Query of one of
+// the query modes (see Modes) using Run, against the program of the
+// query's scope, loaded afresh; using a Program, against one that it
+// has loaded itself; or using a Server, against one loaded and
+// analyzed once for many queries.  Each query result is passed to
+// Query.Output, and may be written in any of the output formats of
+// the command by a Formatter (see NewFormatter).
+package guru // import "golang.org/x/tools/cmd/guru/guru"
+
+// TODO(adonovan): new queries
+// - show all statements that may update the selected lvalue
+//   (local, global, field, etc).
+// - show all places where an object of type T is created
+//   (&T{}, var t T, new(T), new(struct{array [3]T}), etc.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+type printfFunc func(pos interface{}, format string, args ...interface{})
+
+// A QueryResult is an item of output.  Each query produces a stream of
+// query results, calling Query.Output for each one.  The results, and
+// the items within each, are in a deterministic order, generally that
+// of their positions (see lessPos), so that repeated queries produce
+// the same output.
+type QueryResult interface {
+	// JSON returns the QueryResult in JSON form.
+	JSON(fset *token.FileSet) []byte
+
+	// PrintPlain prints the QueryResult in plain text form.
+	// The implementation calls printfFunc to print each line of output.
+	PrintPlain(printf printfFunc)
+}
+
+// A QueryPos represents the position provided as input to a query:
+// a textual extent in the program's source code, the AST node it
+// corresponds to, and the package to which it belongs.
+// Instances are created by parseQueryPos.
+type queryPos struct {
+	fset       *token.FileSet
+	start, end token.Pos           // source extent of query
+	path       []ast.Node          // AST path from query node to root of ast.File
+	exact      bool                // 2nd result of PathEnclosingInterval
+	info       *loader.PackageInfo // type info for the queried package (nil for fastQueryPos)
+}
+
+// TypeString prints type T relative to the query position.
+func (qpos *queryPos) typeString(T types.Type) string {
+	return types.TypeString(T, types.RelativeTo(qpos.info.Pkg))
+}
+
+// ObjectString prints object obj relative to the query position.
+func (qpos *queryPos) objectString(obj types.Object) string {
+	return types.ObjectString(obj, types.RelativeTo(qpos.info.Pkg))
+}
+
+// A Query specifies a single guru query.
+type Query struct {
+	Pos   string         // query position
+	Build *build.Context // package loading configuration
+	Roots []string       // (optional) additional workspaces, searched before Build.GOPATH
+	Cache string         // (optional) directory of the on-disk cache of type information
+
+	// Overlay, if non-nil, maps the names of files to contents that
+	// replace those of the files on disk, or add files that are not
+	// there, such as the unsaved changes of an editor, or generated
+	// files; it is read through Build, as if by
+	// buildutil.OverlayContext.  A client may instead supply a file
+	// system of its own by the OpenFile, ReadDir and IsDir functions
+	// of Build, through which guru reads every source file.
+	Overlay map[string][]byte
+
+	// ExportData allows the queries that need only the types of the
+	// dependencies of the query package, such as describe, to read
+	// them from the export data of their compiled package objects,
+	// where up to date, instead of parsing and type-checking them.
+	ExportData bool
+
+	// NoTests excludes the _test.go files and external test packages
+	// of the packages in the analysis scope, and of those searched by
+	// implements and referrers, for a faster analysis of production
+	// code.  By default they are included.
+	NoTests bool
+
+	// Parallelism, if positive, limits the number of packages
+	// type-checked at once while loading the program.
+	Parallelism int
+
+	// Scope specifies the packages of the pointer analysis, by
+	// package patterns as for buildutil.ExpandPatterns: import paths,
+	// or patterns such as golang.org/x/tools/..., ..., std and cmd,
+	// those preceded by '-' excluding the packages they match.
+	// Patterns relative to the current directory, such as ./..., must
+	// first be replaced by those of import paths; see ExpandPatterns.
+	Scope []string
+	PTA   PTAOptions // configuration of the pointer analysis
+
+	// query-specific options
+	Depth int      // callers: levels of transitive callers to report (default 1)
+	Paths int      // callstack: number of shortest paths to report, or -1 for all; 0 for any one path
+	Focus []string // callgraph: package patterns, as for Scope, whose functions are shown in full
+
+	// result-printing function, safe for concurrent use
+	Output func(*token.FileSet, QueryResult)
+
+	// PhysicalPositions causes the positions of the results to be
+	// reported as they are in the files as parsed, not as the //line
+	// comments of generated code, such as that of yacc or stringer,
+	// map them, as with the -positions=physical flag of the guru
+	// command.
+	PhysicalPositions bool
+
+	// MaxResults, if positive, limits the items of the results of a
+	// query to that many, for the modes whose results may be long:
+	// the references of referrers, and the functions of callgraph.
+	// The output of a query that has more ends with a result that
+	// reports how many were omitted (see serial.Truncated).
+	MaxResults int
+
+	// Progress, if non-nil, receives the events of the loading and
+	// analysis of the program, so that an interactive client may
+	// show the progress of a slow query.  Calls are not concurrent.
+	Progress func(ProgressEvent)
+
+	// TypeErrors, if non-nil, receives the errors of the packages
+	// with type errors, so that a client may report them alongside
+	// the results.  A query fails for errors in the package of its
+	// position, or in those it imports, but otherwise proceeds, less
+	// the packages with errors and those that depend on them; describe
+	// and freevars make what they can even of a package with errors.
+	// Calls are not concurrent.
+	TypeErrors func(error)
+
+	// Context, if non-nil, cancels the query once it is done:
+	// loading, SSA construction and the pointer analysis stop
+	// early, and the query fails with the context's error.
+	Context context.Context
+
+	// MaxMemory, if nonzero, is a soft limit in bytes on the heap
+	// (see runtime.MemStats.HeapAlloc).  A query that exceeds it
+	// stops early, as if canceled: the results output so far stand,
+	// but they may be incomplete, and the query fails with an error
+	// of code "memory".
+	MaxMemory uint64
+
+	program     *Program    // the program of the query, if run by a Program
+	firstResult int         // the index of the first item of the results to output; see limitResults
+	src         *fileSource // the source of the files of its results; see withSource
+}
+
+// A ProgressEvent reports the start or end of a phase of the loading
+// and analysis of a query's program, or a step within a phase.
+//
+// The phases of a query are within its "query" phase, which spans the
+// whole of a query run by Run, and the preparation and completion of
+// each query of a batch; the rest of its time is that of evaluating
+// the query.
+type ProgressEvent struct {
+	Phase string // "query", "load", "create SSA", "build SSA", "call graph", "pointer analysis" or "reload"
+	Done  bool   // whether the phase is complete
+
+	// During "load" and "reload", each package type-checked is
+	// reported: Package is its import path, and Packages the number
+	// of packages type-checked so far.
+	Package  string
+	Packages int
+
+	// During "pointer analysis", Step is "generate" as the
+	// constraints are generated, then "solve" as they are solved.
+	Step string
+}
+
+// PTAOptions holds the options of the whole-program analysis used by
+// the callees, callers, callstack, peers, pointsto and whicherrs queries,
+// and by describe queries of select statements.
+// The zero value is the default configuration.
+type PTAOptions struct {
+	Log        io.Writer // (optional) pointer-analysis constraint-solver log
+	Timing     io.Writer // (optional) receives the duration of each phase of the analysis
+	Reflection bool      // model reflection soundly (currently slow)
+
+	// Context selects the context sensitivity of the analysis.
+	// If "1cfa", each static call to a function of the package
+	// containing the query position (or, if there is none, of the
+	// initial packages) is analyzed in its own context, which is
+	// more precise but slower.  The default, "", uses the pointer
+	// analysis's own policy.
+	Context string
+
+	// CallGraph selects the algorithm of the call graph of the
+	// callees, callers, callstack, callgraph and deadcode queries:
+	// "cha" for Class Hierarchy Analysis, "rta" for Rapid Type
+	// Analysis, or "pta", or equivalently "", for the pointer
+	// analysis, which is the slowest but most precise.
+	CallGraph string
+}
+
+// Run runs the query mode at the position q.Pos, calling q.Output
+// for each of its results, in order, and returns its failure, if any;
+// results output before a failure, as by a query that exceeds its
+// MaxMemory, stand.
+func Run(mode string, q *Query) (err error) {
+	q = withSource(addRoots(q))
+	if m, ok := modes[mode]; ok {
+		var flush func()
+		q, flush = limitResults(q, m)
+		defer func() {
+			if err == nil {
+				flush()
+			}
+		}()
+	}
+	defer beginPhase(q, "query")()
+	if mode == "callees" && len(q.Scope) == 0 && q.program == nil {
+		// Without a scope there can be no pointer analysis.
+		return calleesCHA(q)
+	}
+	m, ok := modes[mode]
+	if !ok {
+		return errorf(codeMode, "invalid mode: %q", mode)
+	}
+	if m.needs&needScope == 0 {
+		return m.run(q)
+	}
+	a, err := loadAnalysis(q, m, []string{q.Pos})
+	if err != nil {
+		return err
+	}
+	finish, err := m.prepare(q, a)
+	if err := canceled(q); err != nil {
+		return err // the error of prepare, if any, is spurious
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.analyze(q); err != nil {
+		return err
+	}
+	return finish()
+}
+
+// RunBatch runs the guru query mode at each of the positions posns,
+// which supersede q.Pos, emitting results in order.
+//
+// Whole-program queries in the batch share a single loaded program,
+// SSA construction and pointer analysis, so the cost of analysis is
+// paid once, not once per position.  Other queries are run in turn.
+//
+// RunBatch returns a slice of errors, one per position, in which
+// element i reports the failure (if any) of the query at posns[i].
+func RunBatch(mode string, q *Query, posns []string) []error {
+	q = addRoots(q)
+	m, ok := modes[mode]
+	if !ok || m.needs&needScope == 0 || mode == "callees" && len(q.Scope) == 0 {
+		return runEach(mode, q, posns)
+	}
+
+	// Use the first position to select the query package.
+	q2 := *q
+	if len(posns) > 0 {
+		q2.Pos = posns[0]
+	}
+	a, err := loadAnalysis(&q2, m, posns)
+	if err != nil {
+		errs := make([]error, len(posns))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	return runPTABatch(a, m, q, posns)
+}
+
+// addRoots returns q, or if q specifies additional workspaces or an
+// overlay, a copy of q whose build context searches the workspaces
+// before its own GOPATH, and reads the files of the overlay.
+// Like the directories of GOPATH, each workspace holds its packages'
+// source in a src subdirectory.
+func addRoots(q *Query) *Query {
+	if len(q.Roots) == 0 && q.Overlay == nil {
+		return q
+	}
+	ctxt := *q.Build // copy
+	if len(q.Roots) > 0 {
+		gopath := append(append([]string(nil), q.Roots...), filepath.SplitList(ctxt.GOPATH)...)
+		ctxt.GOPATH = strings.Join(gopath, string(filepath.ListSeparator))
+	}
+	q2 := *q
+	q2.Build = &ctxt
+	if q.Overlay != nil {
+		q2.Build = buildutil.OverlayContext(&ctxt, q.Overlay)
+	}
+	q2.Roots = nil
+	q2.Overlay = nil
+	return &q2
+}
+
+// runEach runs the query mode at each of the positions posns in turn.
+func runEach(mode string, q *Query, posns []string) []error {
+	errs := make([]error, len(posns))
+	for i, pos := range posns {
+		q2 := *q
+		q2.Pos = pos
+		errs[i] = Run(mode, &q2)
+	}
+	return errs
+}
+
+// runPTABatch runs the whole-program query mode at each of the
+// positions posns using the analysis a.  It resolves each query
+// against the program, then runs the pointer analysis (if needed)
+// once for all of them.
+func runPTABatch(a *analysis, mode *queryMode, q *Query, posns []string) []error {
+	q = withSource(q)
+	errs := make([]error, len(posns))
+	finishers := make([]func() error, len(posns))
+	flushes := make([]func(), len(posns))
+	for i, pos := range posns {
+		q2 := *q
+		q2.Pos = pos
+		q3, flush := limitResults(&q2, mode)
+		end := beginPhase(q, "query")
+		finishers[i], errs[i] = mode.prepare(q3, a)
+		end()
+		flushes[i] = flush
+	}
+	if err := a.analyze(q); err != nil {
+		// q was canceled, so every query fails.
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, finish := range finishers {
+		if errs[i] == nil {
+			end := beginPhase(q, "query")
+			if errs[i] = finish(); errs[i] == nil {
+				flushes[i]()
+			}
+			end()
+		}
+	}
+	return errs
+}
+
+// A queryMode is a query mode, with the inputs and phases of the
+// analysis that it needs, so that the program of the analysis scope is
+// loaded, and its SSA form constructed and analyzed, only by the modes
+// that require them.
+type queryMode struct {
+	desc  string // the description of the mode, in the help message
+	needs needs
+
+	// run performs a query that does not need the analysis scope,
+	// loading only the packages it requires.
+	run func(q *Query) error
+
+	// For a query that needs the analysis scope, prepare resolves
+	// the query q against the analysis a, registering any
+	// pointer-analysis queries or call-graph construction it needs in
+	// a.ptaConfig (see needPTA), and returns a function that completes
+	// the query once the analysis has run.  Its SSA form, if needed,
+	// is built in ssaMode.
+	ssaMode ssa.BuilderMode
+	prepare func(q *Query, a *analysis) (finish func() error, err error)
+
+	graph bool   // the results are of the call graph, in the dot, graphml and jsonl formats too
+	item  string // the unit of the items of the results that Query.MaxResults limits, if any
+}
+
+// needs is the set of inputs and phases of the analysis needed by a
+// query mode.
+type needs int
+
+const (
+	needPos      needs = 1 << iota // a query position
+	needExactPos                   // a query position that selects a single syntax node
+	needScope                      // the loaded program of the analysis scope
+	needSSA                        // the SSA form of that program
+	needPTA                        // the pointer analysis or call graph (if the query requires it)
+
+	needAll = needScope | needSSA | needPTA // the needs of whole-program queries
+)
+
+var modes = map[string]*queryMode{
+	"allocs":       {desc: "show where selected value or variable is allocated", needs: needPos | needAll, ssaMode: ssa.GlobalDebug, prepare: allocs},
+	"callees":      {desc: "show possible targets of selected function call", needs: needPos | needExactPos | needAll, ssaMode: ssa.GlobalDebug, prepare: callees, graph: true},
+	"callers":      {desc: "show possible callers of selected function", needs: needPos | needAll, prepare: callers, graph: true},
+	"callgraph":    {desc: "show call graph of the program, or of the -focus packages", needs: needAll, prepare: doCallgraph, graph: true, item: "function"},
+	"callstack":    {desc: "show path from callgraph root to selected function", needs: needPos | needAll, prepare: callstack, graph: true},
+	"deadcode":     {desc: "show functions in scope unreachable from main and init", needs: needAll, prepare: deadcode},
+	"definition":   {desc: "show declaration of selected identifier", needs: needPos, run: definition},
+	"describe":     {desc: "describe selected syntax: definition, methods, etc", needs: needPos | needExactPos, run: describe},
+	"effects":      {desc: "show package-level variables the selected function may read or write", needs: needPos | needAll, ssaMode: ssa.GlobalDebug, prepare: effects},
+	"freevars":     {desc: "show free variables of selection", needs: needPos, run: freevars},
+	"hierarchy":    {desc: "show supertypes and subtypes of selected type, as trees", needs: needPos, run: hierarchy},
+	"implements":   {desc: "show 'implements' relation for selected type or method", needs: needPos, run: implements},
+	"importers":    {desc: "show packages in scope that import selected package", needs: needPos | needScope, prepare: importers},
+	"lockers":      {desc: "show lock/unlock calls of selected mutex or mutex op", needs: needPos | needAll, ssaMode: ssa.GlobalDebug, prepare: lockers},
+	"panics":       {desc: "show panics the selected recover may recover, or recovers of the selected panic", needs: needPos | needAll, prepare: panics},
+	"peers":        {desc: "show send/receive corresponding to selected channel op", needs: needPos | needAll, ssaMode: ssa.GlobalDebug, prepare: peers},
+	"pointsto":     {desc: "show variables the selected pointer may point to", needs: needPos | needExactPos | needAll, ssaMode: ssa.GlobalDebug, prepare: pointsto},
+	"referrers":    {desc: "show all refs to entity denoted by selected identifier", needs: needPos, run: referrers, item: "reference"},
+	"rename-check": {desc: "show what renaming the selected identifier must consider", needs: needPos, run: renameCheck},
+	"shared":       {desc: "show goroutines and conflicting accesses of selected variable", needs: needPos | needAll, ssaMode: ssa.GlobalDebug, prepare: shared},
+	"what":         {desc: "show basic information about the selected syntax node", needs: needPos, run: what},
+	"whicherrs":    {desc: "show possible values of the selected error variable", needs: needPos | needExactPos | needAll, ssaMode: ssa.GlobalDebug, prepare: whicherrs},
+}
+
+// A ModeInfo describes a query mode, so that a front end may present
+// the modes, and validate queries, without a list of its own.
+type ModeInfo struct {
+	Name        string
+	Description string // e.g. "show possible callers of selected function"
+
+	NeedsPos   bool // the query needs a position; otherwise it concerns the whole program
+	NeedsExact bool // the position must select exactly a single syntax node
+	NeedsScope bool // the query analyzes the program of the Scope, from its main packages or tests
+
+	Formats []string // the standard output formats that the mode supports, e.g. "json"
+}
+
+// Modes returns descriptions of the query modes, in order of name.
+func Modes() []ModeInfo {
+	var infos []ModeInfo
+	for name, m := range modes {
+		formats := []string{"plain", "json", "xml", "ndjson"}
+		if m.graph {
+			formats = append(formats, "dot", "graphml", "jsonl")
+		}
+		infos = append(infos, ModeInfo{
+			Name:        name,
+			Description: m.desc,
+			NeedsPos:    m.needs&needPos != 0,
+			NeedsExact:  m.needs&needExactPos != 0,
+			NeedsScope:  m.needs&needScope != 0,
+			Formats:     formats,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// wholeProgramMode describes an analysis shared by whole-program
+// queries of any mode, such as that of a Server.
+var wholeProgramMode = &queryMode{needs: needAll, ssaMode: ssa.GlobalDebug}
+
+// An analysis holds the state of a whole-program analysis that may be
+// shared by several queries: the loaded program, its SSA form, and the
+// configuration and result of the pointer analysis.
+//
+// SSA construction and pointer analysis are performed on demand.
+type analysis struct {
+	q     *Query
+	lprog *loader.Program
+	mode  *queryMode // the needs of the queries, and the SSA builder mode
+
+	prog      *ssa.Program    // SSA program; nil until createSSA, unless supplied by a Program
+	ptaConfig *pointer.Config // pointer analysis configuration; nil until createSSA
+	built     bool            // prog.Build has been called
+
+	needPTA bool             // some pending query requires the pointer analysis
+	needCG  bool             // some pending query requires the CHA or RTA call graph
+	ptares  *pointer.Result  // result of the most recent pointer analysis
+	cg      *callgraph.Graph // call graph sans synthetic nodes, once computed
+}
+
+// loadAnalysis loads, parses and type-checks the program specified by
+// the query's analysis scope, for queries of the specified mode at
+// the positions posns.
+func loadAnalysis(q *Query, mode *queryMode, posns []string) (*analysis, error) {
+	if q.program != nil {
+		return q.program.analysis(q), nil
+	}
+	defer beginPhase(q, "load")()
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
+	if mode == wholeProgramMode {
+		// for the doc comments of the describe queries of a Server
+		lconf.ParserMode |= parser.ParseComments
+	}
+	progressHook(q, &lconf, "load")
+	cancelHook(q, &lconf)
+
+	if err := setPTAScope(&lconf, q.Scope, !q.NoTests); err != nil {
+		return nil, err
+	}
+	importQueryTests(posns, &lconf)
+
+	// Load/parse/type-check the program.  A query of a position
+	// tolerates errors in the packages on which it does not depend.
+	lprog, err := loadWithSoftErrors(q, &lconf, true)
+	if err := canceled(q); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &analysis{q: q, lprog: lprog, mode: mode}, nil
+}
+
+// createSSA creates (but does not build) the SSA program, unless
+// supplied by a Program, and the configuration of the pointer
+// analysis, if not already done.
+func (a *analysis) createSSA() error {
+	if a.ptaConfig != nil {
+		return nil
+	}
+	if a.mode.needs&needSSA == 0 {
+		panic("createSSA: query mode does not need SSA")
+	}
+	defer beginPhase(a.q, "create SSA")()
+	prog := a.prog
+	if prog == nil {
+		prog = ssautil.CreateProgram(a.lprog, a.mode.ssaMode)
+	}
+
+	ptaConfig, err := setupPTA(prog, a.lprog, a.q.PTA.Log, a.q.PTA.Reflection)
+	if err != nil {
+		return err
+	}
+	switch a.q.PTA.Context {
+	case "":
+	case "1cfa":
+		pkgs := a.contextPackages()
+		ptaConfig.ContextSensitive = func(fn *ssa.Function) bool {
+			return fn.Pkg != nil && pkgs[fn.Pkg.Pkg]
+		}
+	default:
+		return fmt.Errorf("invalid pointer analysis context %q", a.q.PTA.Context)
+	}
+	a.prog = prog
+	a.ptaConfig = ptaConfig
+	return nil
+}
+
+// contextPackages returns the packages whose functions are analyzed
+// context-sensitively when PTAOptions.Context is "1cfa": the package
+// of the query position, if any, otherwise the initial packages.
+func (a *analysis) contextPackages() map[*types.Package]bool {
+	pkgs := make(map[*types.Package]bool)
+	if qpos, err := parseQueryPos(a.lprog, a.q.Pos, false); err == nil {
+		pkgs[qpos.info.Pkg] = true
+	} else {
+		for _, info := range a.lprog.InitialPackages() {
+			pkgs[info.Pkg] = true
+		}
+	}
+	return pkgs
+}
+
+// buildSSA builds the SSA code for all functions of the program,
+// if not already done.
+// Callers should defer SSA construction till after errors are reported.
+//
+// If the query is canceled, the remaining packages are not built, and
+// the build resumes at the next call.
+func (a *analysis) buildSSA() {
+	if !a.built {
+		defer beginPhase(a.q, "build SSA")()
+		// Like a.prog.Build, but checking for cancellation.
+		var wg sync.WaitGroup
+		for _, pkg := range a.prog.AllPackages() {
+			wg.Add(1)
+			go func(pkg *ssa.Package) {
+				defer wg.Done()
+				if canceled(a.q) == nil {
+					pkg.Build()
+				}
+			}(pkg)
+		}
+		wg.Wait()
+		a.built = canceled(a.q) == nil
+	}
+}
+
+// analyze builds the CHA or RTA call graph, and runs the pointer
+// analysis, on behalf of the query q, if any pending query requires
+// them.  It fails only if q is canceled.
+//
+// The queries registered in a.ptaConfig are consumed, even on
+// failure, so that a long-lived analysis (see Server) may run the
+// pointer analysis again for subsequent queries.  The call graph is
+// retained.
+func (a *analysis) analyze(q *Query) error {
+	if err := canceled(q); err != nil {
+		return err // e.g. during SSA construction
+	}
+	if (a.needCG || a.needPTA) && a.mode.needs&needPTA == 0 {
+		panic("analyze: query mode does not need the pointer analysis")
+	}
+	if a.needCG {
+		end := beginPhase(q, "call graph")
+		a.cg = callGraph(a.prog, q.PTA.CallGraph, entryPoints(a.ptaConfig.Mains))
+		sortCallGraph(a.cg)
+		a.needCG = false
+		end()
+	}
+	if !a.needPTA {
+		return nil
+	}
+	defer beginPhase(q, "pointer analysis")()
+	a.ptaConfig.Progress = nil
+	if progress := q.Progress; progress != nil {
+		a.ptaConfig.Progress = func(step string) {
+			progress(ProgressEvent{Phase: "pointer analysis", Step: step})
+		}
+	}
+	done, release := cancelChan(q)
+	defer release()
+	a.ptaConfig.Cancel = done
+	conf := a.ptaConfig
+	ptares, err := ptrAnalysis(q, conf)
+	a.needPTA = false
+
+	// A pointer.Config must not be reused, so the next analysis has
+	// a fresh one, without the queries, extended ones included, of
+	// this one.
+	a.ptaConfig = &pointer.Config{
+		Mains:            conf.Mains,
+		Reflection:       conf.Reflection,
+		ContextSensitive: conf.ContextSensitive,
+		BuildCallGraph:   conf.BuildCallGraph,
+		Log:              conf.Log,
+	}
+	if err != nil {
+		return err
+	}
+	if ptares.CallGraph != nil {
+		ptares.CallGraph.DeleteSyntheticNodes()
+		sortCallGraph(ptares.CallGraph)
+		a.cg = ptares.CallGraph
+		a.ptaConfig.BuildCallGraph = false
+	}
+	a.ptares = ptares
+	return nil
+}
+
+// needCallGraph records that the query requires the call graph
+// computed by the algorithm of a.q.PTA.CallGraph, by default the
+// pointer analysis.
+func (a *analysis) needCallGraph() {
+	if a.cg == nil {
+		switch a.q.PTA.CallGraph {
+		case "cha", "rta":
+			a.needCG = true
+		default:
+			a.ptaConfig.BuildCallGraph = true
+			a.needPTA = true
+		}
+	}
+}
+
+// callGraph returns the call graph of prog computed by the algorithm,
+// "cha" or "rta", without synthetic nodes.  Like that of the pointer
+// analysis, its root is a synthetic function that calls the roots.
+func callGraph(prog *ssa.Program, algorithm string, roots []*ssa.Function) *callgraph.Graph {
+	var cg *callgraph.Graph
+	switch algorithm {
+	case "cha":
+		cg = cha.CallGraph(prog)
+		delete(cg.Nodes, nil) // its root, which has no edges
+	case "rta":
+		if res := rta.Analyze(roots, true); res != nil {
+			cg = res.CallGraph
+		}
+	}
+	root := prog.NewFunction("<root>", new(types.Signature), "root of callgraph")
+	if cg == nil {
+		cg = callgraph.New(root) // no roots
+	}
+	cg.DeleteSyntheticNodes()
+	cg.Root = cg.CreateNode(root)
+	for _, fn := range roots {
+		callgraph.AddEdge(cg.Root, nil, cg.CreateNode(fn))
+	}
+	return cg
+}
+
+// beginPhase reports the start of the named phase of the analysis to
+// q.Progress, and returns a function that reports its end to
+// q.Progress and its duration to q.PTA.Timing.
+func beginPhase(q *Query, phase string) (end func()) {
+	start := time.Now()
+	if q.Progress != nil {
+		q.Progress(ProgressEvent{Phase: phase})
+	}
+	return func() {
+		logTime(q.PTA.Timing, phase, start)
+		if q.Progress != nil {
+			q.Progress(ProgressEvent{Phase: phase, Done: true})
+		}
+	}
+}
+
+// progressHook arranges for lconf to report each package it
+// type-checks, during the named phase, to q.Progress.
+func progressHook(q *Query, lconf *loader.Config, phase string) {
+	if q.Progress == nil {
+		return
+	}
+	var mu sync.Mutex // AfterTypeCheck is called concurrently
+	seen := make(map[*loader.PackageInfo]bool)
+	after := lconf.AfterTypeCheck
+	lconf.AfterTypeCheck = func(info *loader.PackageInfo, files []*ast.File) {
+		if after != nil {
+			after(info, files)
+		}
+		mu.Lock()
+		// A package augmented by its tests is type-checked twice.
+		if !seen[info] {
+			seen[info] = true
+			q.Progress(ProgressEvent{Phase: phase, Package: info.Pkg.Path(), Packages: len(seen)})
+		}
+		mu.Unlock()
+	}
+}
+
+// canceled returns the error of q's context, if q has been canceled,
+// or a memory error, if the heap exceeds q's memory limit.
+func canceled(q *Query) error {
+	if q.Context != nil {
+		if err := q.Context.Err(); err != nil {
+			return err
+		}
+	}
+	if q.MaxMemory != 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > q.MaxMemory {
+			return memoryError(q)
+		}
+	}
+	return nil
+}
+
+// memoryError returns the error of a query that exceeded its memory limit.
+func memoryError(q *Query) error {
+	return errorf(codeMemory, "results truncated: memory limit of %d MB exceeded", q.MaxMemory>>20)
+}
+
+// cancelChan returns a channel that is closed once q is canceled (see
+// canceled), and a function to release its resources.
+func cancelChan(q *Query) (done <-chan struct{}, release func()) {
+	if q.MaxMemory == 0 {
+		if q.Context == nil {
+			return nil, func() {}
+		}
+		return q.Context.Done(), func() {}
+	}
+	// Poll the heap size.
+	var ctxDone <-chan struct{}
+	if q.Context != nil {
+		ctxDone = q.Context.Done()
+	}
+	c := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for canceled(q) == nil {
+			select {
+			case <-stop:
+				return
+			case <-ctxDone:
+			case <-ticker.C:
+			}
+		}
+		close(c)
+	}()
+	return c, func() { close(stop) }
+}
+
+// cancelHook arranges for lconf to stop loading packages once q is
+// canceled, by failing to find any more.  The caller must check
+// canceled after the load, whose errors are then spurious, and are
+// not reported to lconf.TypeChecker.Error.
+func cancelHook(q *Query, lconf *loader.Config) {
+	if q.Context == nil {
+		return
+	}
+	report := lconf.TypeChecker.Error
+	if report == nil {
+		report = func(err error) { fmt.Fprintln(os.Stderr, err) } // the loader's default
+	}
+	lconf.TypeChecker.Error = func(err error) {
+		if canceled(q) == nil {
+			report(err)
+		}
+	}
+	find := lconf.FindPackage
+	if find == nil {
+		find = (*build.Context).Import
+	}
+	lconf.FindPackage = func(ctxt *build.Context, path, dir string, mode build.ImportMode) (*build.Package, error) {
+		if err := canceled(q); err != nil {
+			return nil, err
+		}
+		return find(ctxt, path, dir, mode)
+	}
+}
+
+// logTime writes to w, if non-nil, the time elapsed since start
+// during the named phase of the analysis.
+func logTime(w io.Writer, phase string, start time.Time) {
+	if w != nil {
+		fmt.Fprintf(w, "%s: %s\n", phase, time.Since(start))
+	}
+}
+
+func setPTAScope(lconf *loader.Config, scope []string, tests bool) error {
+	pkgs := buildutil.ExpandPatterns(lconf.Build, scope)
+	if len(pkgs) == 0 {
+		return errorf(codeScope, "no packages specified for pointer analysis scope")
+	}
+	for path := range pkgs {
+		importPackage(lconf, path, tests)
+	}
+	return nil
+}
+
+// importPackage tells conf to import the package, augmented by its
+// tests if tests is set.
+func importPackage(conf *loader.Config, path string, tests bool) {
+	if tests {
+		conf.ImportWithTests(path)
+	} else {
+		conf.Import(path)
+	}
+}
+
+// ExpandPatterns returns the package patterns, such as those of the
+// Scope or Focus of q, in which those relative to the current
+// directory, such as ./... or ../p, are replaced by the corresponding
+// patterns of import paths in the build context and workspaces of q.
+func ExpandPatterns(q *Query, patterns []string) ([]string, error) {
+	ctxt := addRoots(q).Build
+	var res []string
+	for _, pattern := range patterns {
+		neg := strings.HasPrefix(pattern, "-")
+		if neg {
+			pattern = pattern[1:]
+		}
+		if build.IsLocalImport(pattern) {
+			// Split the directory from the wildcard, if any.
+			dir, wildcard := pattern, ""
+			if i := strings.Index(pattern, "..."); i >= 0 {
+				dir, wildcard = pattern[:i], pattern[i:]
+				if strings.HasSuffix(dir, "/") {
+					dir, wildcard = dir[:len(dir)-1], "/"+wildcard
+				} else {
+					// e.g. ./c..., a prefix of names within the directory
+					dir, wildcard = filepath.Dir(dir), "/"+filepath.Base(dir)+wildcard
+				}
+			}
+			// guessImportPath considers only the directory of the file.
+			_, importPath, err := guessImportPath(filepath.Join(dir, "x.go"), ctxt)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", pattern, err)
+			}
+			pattern = filepath.ToSlash(importPath) + wildcard
+		}
+		if neg {
+			pattern = "-" + pattern
+		}
+		res = append(res, pattern)
+	}
+	return res, nil
+}
+
+// Create a pointer.Config whose scope is the initial packages of lprog
+// and their dependencies.
+func setupPTA(prog *ssa.Program, lprog *loader.Program, ptaLog io.Writer, reflection bool) (*pointer.Config, error) {
+	// For each initial package (specified on the command line),
+	// if it has a main function, analyze that,
+	// otherwise analyze its tests, if any,
+	// otherwise analyze calls to its exported functions.
+	var mains []*ssa.Package
+	for _, info := range lprog.InitialPackages() {
+		p := prog.Package(info.Pkg)
+		if p == nil {
+			continue // omitted due to errors (see loadWithSoftErrors)
+		}
+
+		// Add package to the pointer analysis scope.
+		if p.Pkg.Name() == "main" && p.Func("main") != nil {
+			mains = append(mains, p)
+		} else if main := prog.CreateTestMainPackage(p); main != nil {
+			mains = append(mains, main)
+		} else if main := createExportsMainPackage(prog, p); main != nil {
+			mains = append(mains, main)
+		}
+	}
+	if mains == nil {
+		return nil, errorf(codeScope, "analysis scope has no main, no tests and no exported functions")
+	}
+	return &pointer.Config{
+		Log:        ptaLog,
+		Reflection: reflection,
+		Mains:      mains,
+	}, nil
+}
+
+// importQueryPackage finds the package P containing the
+// query position and tells conf to import it.
+// It returns the package's path.
+func importQueryPackage(pos string, conf *loader.Config) (string, error) {
+	fqpos, err := fastQueryPos(conf.Build, pos)
+	if err != nil {
+		return "", err // bad query
+	}
+	filename := fqpos.fset.File(fqpos.start).Name()
+
+	// allowErrors disables cgo preprocessing for speed,
+	// but a query within a file that imports "C" requires it.
+	cgo := importsC(fqpos.path[len(fqpos.path)-1].(*ast.File))
+	if cgo && !conf.Build.CgoEnabled {
+		ctxt := *conf.Build // copy
+		ctxt.CgoEnabled = true
+		conf.Build = &ctxt
+	}
+
+	_, importPath, err := guessImportPath(filename, conf.Build)
+	if err != nil {
+		// Can't find GOPATH dir.
+		// Treat the query file, and the others of its package
+		// in its directory, as an ad hoc package, like go run.
+		importPath = "command-line-arguments"
+		conf.CreateFromFilenames(importPath, adhocFiles(conf.Build, filename)...)
+	} else {
+		// Check that it's possible to load the queried package.
+		// (e.g. guru tests contain different 'package' decls in same dir.)
+		// Keep consistent with logic in loader/util.go!
+		cfg2 := *conf.Build
+		cfg2.CgoEnabled = cgo
+		bp, err := cfg2.Import(importPath, "", 0)
+		if err != nil {
+			return "", withCode(codeLoad, err) // no files for package
+		}
+
+		switch pkgContainsFile(bp, filename) {
+		case 'T':
+			conf.ImportWithTests(importPath)
+		case 'X':
+			conf.ImportWithTests(importPath)
+			importPath += "_test" // for TypeCheckFuncBodies
+		case 'G':
+			conf.Import(importPath)
+		default:
+			// This happens for ad-hoc packages like
+			// $GOROOT/src/net/http/triv.go, which is
+			// excluded by a build constraint; like go run,
+			// treat the file as its own package.
+			importPath = "command-line-arguments"
+			conf.CreateFromFilenames(importPath, filename)
+		}
+	}
+
+	conf.TypeCheckFuncBodies = func(p string) bool { return p == importPath }
+
+	return importPath, nil
+}
+
+// adhocFiles returns the files of the ad hoc package, outside any
+// GOPATH directory, whose file contains the query: it and the other
+// files of its directory that belong to the same package, or, if they
+// cannot be determined, it alone.  The files of an internal test
+// belong to the package with those that it tests; an external test
+// stands alone.
+func adhocFiles(ctxt *build.Context, filename string) []string {
+	dir := filepath.Dir(filename)
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		return []string{filename}
+	}
+	var names []string
+	switch pkgContainsFile(bp, filename) {
+	case 'G':
+		names = append(append(names, bp.GoFiles...), bp.CgoFiles...)
+	case 'T':
+		names = append(append(append(names, bp.GoFiles...), bp.CgoFiles...), bp.TestGoFiles...)
+	default:
+		return []string{filename}
+	}
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(dir, name)
+		if sameFile(files[i], filename) {
+			files[i] = filename // (as named by the query)
+		}
+	}
+	return files
+}
+
+// importQueryTests tells conf to import, with its tests, each package
+// whose _test.go file contains one of the query positions posns, so
+// that queries within its internal or external tests find them, and
+// their test main, in the analysis even if the scope does not include
+// them.
+func importQueryTests(posns []string, conf *loader.Config) {
+	for _, path := range queryTestPackages(posns, conf.Build) {
+		conf.ImportWithTests(path)
+	}
+}
+
+// queryTestPackages returns the import paths of the packages whose
+// _test.go files contain the query positions posns, in order, without
+// duplicates.  Errors are ignored; they are reported once the program
+// is loaded.
+func queryTestPackages(posns []string, ctxt *build.Context) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pos := range posns {
+		filename, _, _, err := parsePos(pos)
+		if err != nil || !strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		if _, importPath, err := guessImportPath(filename, ctxt); err == nil && !seen[importPath] {
+			seen[importPath] = true
+			paths = append(paths, importPath)
+		}
+	}
+	return paths
+}
+
+// importsC reports whether f imports "C", and so requires cgo preprocessing.
+func importsC(f *ast.File) bool {
+	for _, imp := range f.Imports {
+		if imp.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// pkgContainsFile reports whether file was among the packages Go
+// (or cgo) files, Test files, eXternal test files, or not found.
+func pkgContainsFile(bp *build.Package, filename string) byte {
+	goFiles := append(append([]string(nil), bp.GoFiles...), bp.CgoFiles...)
+	for i, files := range [][]string{goFiles, bp.TestGoFiles, bp.XTestGoFiles} {
+		for _, file := range files {
+			if sameFile(filepath.Join(bp.Dir, file), filename) {
+				return "GTX"[i]
+			}
+		}
+	}
+	return 0 // not found
+}
+
+// ParseQueryPos parses the source query position pos and returns the
+// AST node of the loaded program lprog that it identifies.
+// If needExact, it must identify a single AST subtree;
+// this is appropriate for queries that allow fairly arbitrary syntax,
+// e.g. "describe".
+//
+func parseQueryPos(lprog *loader.Program, pos string, needExact bool) (*queryPos, error) {
+	filename, startPos, endPos, err := parsePos(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the named file among those in the loaded program.
+	// Its FileSet may contain other copies of the file, such as the
+	// stale ones of a Server's re-loads, or those that referrers
+	// parses to search a Program's workspace, so search the syntax.
+	var file *token.File
+	for _, info := range lprog.AllPackages {
+		for _, f := range info.Files {
+			if tf := lprog.Fset.File(f.Pos()); tf != nil && sameFile(filename, tf.Name()) {
+				file = tf
+			}
+		}
+	}
+	if file == nil {
+		return nil, errorf(codePosition, "file %s not found in loaded program", filename)
+	}
+
+	// Byte offsets within a file preprocessed by cgo must be
+	// expressed as lines and columns of the original file.
+	if isPreprocessed(file) {
+		if startPos, err = lineCol(filename, startPos); err != nil {
+			return nil, err
+		}
+		if endPos, err = lineCol(filename, endPos); err != nil {
+			return nil, err
+		}
+	}
+
+	start, end, err := fileOffsetToPos(file, startPos, endPos)
+	if err != nil {
+		return nil, err
+	}
+	info, path, exact := lprog.PathEnclosingInterval(start, end)
+	if path == nil {
+		return nil, errorf(codePosition, "no syntax here")
+	}
+	if needExact && !exact {
+		return nil, errorf(codePosition, "ambiguous selection within %s", astutil.NodeDescription(path[0]))
+	}
+	return &queryPos{lprog.Fset, start, end, path, exact, info}, nil
+}
+
+// ---------- Utilities ----------
+
+// Error codes classify the failures of queries; see serial.Error.
+const (
+	codeMode     = "mode"     // unknown query mode
+	codePosition = "position" // malformed query position, or outside the program
+	codeLoad     = "load"     // the program could not be loaded
+	codeScope    = "scope"    // the pointer analysis scope is unsuitable
+	codeAnalysis = "analysis" // a limitation of the analysis, e.g. dead code
+	codeQuery    = "query"    // the query does not apply at this position
+	codeMemory   = "memory"   // the query exceeded its memory limit
+)
+
+// A queryError is an error classified by one of the error codes.
+type queryError struct {
+	code string
+	err  error
+}
+
+func (e *queryError) Error() string { return e.err.Error() }
+
+// errorf returns an error with the specified code and a message
+// formatted as if by fmt.Errorf.
+func errorf(code, format string, args ...interface{}) error {
+	return &queryError{code, fmt.Errorf(format, args...)}
+}
+
+// withCode returns err, classified by code unless err is nil or has
+// a code already.
+func withCode(code string, err error) error {
+	if _, ok := err.(*queryError); ok || err == nil {
+		return err
+	}
+	return &queryError{code, err}
+}
+
+// ErrorCode returns the code of err, the failure of a query, as in its
+// serial.Error; unclassified errors are reported by the query itself.
+func ErrorCode(err error) string {
+	if err, ok := err.(*queryError); ok {
+		return err.code
+	}
+	return codeQuery
+}
+
+// SerialError returns the serial form of the failure err of the query
+// of the specified mode at position pos, which an ErrorFormatter
+// writes.
+func SerialError(mode string, q *Query, pos string, err error) *serial.Error {
+	return &serial.Error{
+		Version:  serial.Version,
+		Code:     ErrorCode(err),
+		Message:  err.Error(),
+		Mode:     mode,
+		Position: pos,
+		Scope:    q.Scope,
+	}
+}
+
+// load loads the program specified by lconf, or, if the query is run
+// by a Program, returns its loaded program instead.
+func load(q *Query, lconf *loader.Config) (*loader.Program, error) {
+	if q.program != nil {
+		return q.program.lprog, nil
+	}
+	return lconf.Load()
+}
+
+// loadWithSoftErrors calls lconf.Load, suppressing "soft" errors.  (See Go issue 16530.)
+// The errors of the packages with hard errors are reported to q (see
+// Query.TypeErrors), and the load fails if any of them concern the
+// query: if tolerant, those of the package of the query position and
+// its dependencies, or, if the query has no position, of any package;
+// otherwise all of them.
+//
+// The tolerated packages with hard errors, and those that depend on
+// them, are not TransitivelyErrorFree, and so are omitted from the SSA
+// form of the program, and from the analysis.
+// TODO(adonovan): Once the loader has an option to allow soft errors,
+// replace calls to loadWithSoftErrors with loader calls with that parameter.
+func loadWithSoftErrors(q *Query, lconf *loader.Config, tolerant bool) (*loader.Program, error) {
+	lconf.AllowErrors = true
+	lconf.TypeChecker.Error = func(err error) {} // reported once loaded
+
+	// Ideally we would just return conf.Load() here, but go/types
+	// reports certain "soft" errors that gc does not (Go issue 14596).
+	// As a workaround, we set AllowErrors=true and then duplicate
+	// the loader's error checking but allow soft errors.
+	// It would be nice if the loader API permitted "AllowErrors: soft".
+	prog, err := lconf.Load()
+	if err != nil {
+		return nil, withCode(codeLoad, err)
+	}
+	if canceled(q) != nil {
+		return prog, nil // the errors are spurious (see cancelHook)
+	}
+	errpkgs := errorPackages(prog)
+	reportTypeErrors(q, errpkgs)
+
+	// Report hard errors in the packages that concern the query.
+	var deps map[*types.Package]bool // nil => all
+	if tolerant && errpkgs != nil {
+		deps = queryDeps(q, prog)
+	}
+	var paths []string
+	for _, info := range errpkgs {
+		if deps == nil || deps[info.Pkg] {
+			paths = append(paths, info.Pkg.Path())
+		}
+	}
+	if paths != nil {
+		var more string
+		if len(paths) > 3 {
+			more = fmt.Sprintf(" and %d more", len(paths)-3)
+			paths = paths[:3]
+		}
+		return nil, errorf(codeLoad, "couldn't load packages due to errors: %s%s",
+			strings.Join(paths, ", "), more)
+	}
+
+	// Enable SSA construction for packages containing only soft
+	// errors, and whose dependencies do too.
+	free := make(map[*types.Package]bool)
+	var isFree func(pkg *types.Package) bool
+	isFree = func(pkg *types.Package) bool {
+		ok, seen := free[pkg]
+		if !seen {
+			free[pkg] = false // an import cycle is an error anyway
+			info := prog.AllPackages[pkg]
+			ok = info != nil && !containsHardErrors(info.Errors)
+			for _, imp := range pkg.Imports() {
+				ok = isFree(imp) && ok
+			}
+			free[pkg] = ok
+		}
+		return ok
+	}
+	for pkg, info := range prog.AllPackages {
+		info.TransitivelyErrorFree = isFree(pkg)
+	}
+	return prog, nil
+}
+
+// errorPackages returns the packages of lprog with hard errors, in
+// order of path.
+func errorPackages(lprog *loader.Program) []*loader.PackageInfo {
+	var pkgs []*loader.PackageInfo
+	for _, info := range lprog.AllPackages {
+		if containsHardErrors(info.Errors) {
+			pkgs = append(pkgs, info)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Pkg.Path() < pkgs[j].Pkg.Path() })
+	return pkgs
+}
+
+// reportTypeErrors reports the errors of the packages pkgs to q, if it
+// wants them (see Query.TypeErrors).
+func reportTypeErrors(q *Query, pkgs []*loader.PackageInfo) {
+	if q.TypeErrors == nil {
+		return
+	}
+	for _, info := range pkgs {
+		for _, err := range info.Errors {
+			q.TypeErrors(err)
+		}
+	}
+}
+
+// queryDeps returns the package of the query position in lprog, and
+// all those it imports, directly or indirectly, or nil if the query
+// has no position or it is not in lprog.
+func queryDeps(q *Query, lprog *loader.Program) map[*types.Package]bool {
+	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	if err != nil {
+		return nil
+	}
+	deps := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if !deps[pkg] {
+			deps[pkg] = true
+			for _, imp := range pkg.Imports() {
+				visit(imp)
+			}
+		}
+	}
+	visit(qpos.info.Pkg)
+	return deps
+}
+
+func containsHardErrors(errors []error) bool {
+	for _, err := range errors {
+		if err, ok := err.(types.Error); ok && err.Soft {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// allowErrors causes type errors to be silently ignored.
+// (Not suitable if SSA construction follows.)
+func allowErrors(lconf *loader.Config) {
+	ctxt := *lconf.Build // copy
+	ctxt.CgoEnabled = false
+	lconf.Build = &ctxt
+	lconf.AllowErrors = true
+	// AllErrors makes the parser always return an AST instead of
+	// bailing out after 10 errors and returning an empty ast.File.
+	lconf.ParserMode = parser.AllErrors
+	lconf.TypeChecker.Error = func(err error) {}
+}
+
+// ptrAnalysis runs the pointer analysis and returns its result.
+// It fails only if q is canceled.
+func ptrAnalysis(q *Query, conf *pointer.Config) (*pointer.Result, error) {
+	result, err := pointer.Analyze(conf)
+	if err != nil {
+		select {
+		case <-conf.Cancel:
+			if err := canceled(q); err != nil {
+				return nil, err
+			}
+			return nil, memoryError(q) // the heap has since shrunk
+		default:
+		}
+		panic(err) // pointer analysis internal error
+	}
+	return result, nil
+}
+
+func unparen(e ast.Expr) ast.Expr { return astutil.Unparen(e) }
+
+// deref returns a pointer's element type; otherwise it returns typ.
+func deref(typ types.Type) types.Type {
+	if p, ok := typ.Underlying().(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return typ
+}
+
+// fprintf prints to w a message of the form "location: message\n"
+// where location is derived from pos.
+//
+// pos must be one of:
+//    - a token.Pos, denoting a position
+//    - an ast.Node, denoting an interval
+//    - anything with a Pos() method:
+//         ssa.Member, ssa.Value, ssa.Instruction, types.Object, pointer.Label, etc.
+//    - a QueryPos, denoting the extent of the user's query.
+//    - nil, meaning no position at all.
+//
+// editor selects the syntax of the position, runes whether its
+// columns count characters instead of bytes, and root the directory,
+// if any, to which its file name is relative; see formatPos, which
+// reads the files of character columns and offsets from src.
+// If color is set, the position and, for a QueryPos, a call graph
+// edge or an edgePos, the message are colored by ANSI escapes.
+//
+func fprintf(w io.Writer, editor string, runes, color bool, root string, fset *token.FileSet, src *fileSource, pos interface{}, format string, args ...interface{}) {
+	start, end := posRange(fset, pos)
+	posn := formatPos(editor, runes, root, fset, src, start, end)
+	msg := fmt.Sprintf(format, args...)
+	if color {
+		if start.IsValid() {
+			posn = ansiPos + posn + ansiReset
+		}
+		switch pos.(type) {
+		case *queryPos:
+			msg = ansiQuery + msg + ansiReset
+		case *callgraph.Edge, edgePos:
+			msg = ansiEdge + msg + ansiReset
+		}
+	}
+	io.WriteString(w, posn)
+	io.WriteString(w, ": ")
+	io.WriteString(w, msg)
+	io.WriteString(w, "\n")
+}
+
+// posRange returns the range of source denoted by pos, a value of one
+// of the types accepted by fprintf.
+func posRange(fset *token.FileSet, pos interface{}) (start, end token.Pos) {
+	switch pos := pos.(type) {
+	case ast.Node:
+		start = pos.Pos()
+		end = pos.End()
+	case token.Pos:
+		start = pos
+		end = start
+	case *types.PkgName:
+		// The Pos of most PkgName objects does not coincide with an identifier,
+		// so we suppress the usual start+len(name) heuristic for types.Objects.
+		start = pos.Pos()
+		end = start
+	case types.Object:
+		start = pos.Pos()
+		end = start
+		// An object of export data is located only to its line.
+		if file := fset.File(start); file != nil && !isLineOnly(file) {
+			end += token.Pos(len(pos.Name())) // heuristic
+		}
+	case interface {
+		Pos() token.Pos
+	}:
+		start = pos.Pos()
+		end = start
+	case *queryPos:
+		start = pos.start
+		end = pos.end
+	case nil:
+		// no-op
+	default:
+		panic(fmt.Sprintf("invalid pos: %T", pos))
+	}
+	return start, end
+}
+
+// The ANSI escape sequences of colored plain output.
+const (
+	ansiPos   = "\x1b[36m" // cyan: positions
+	ansiQuery = "\x1b[1m"  // bold: the query itself
+	ansiEdge  = "\x1b[33m" // yellow: calls and channel operations
+	ansiReset = "\x1b[0m"
+)
+
+// An edgePos is the position of an operation, such as a channel send
+// or receive, that colored plain output highlights like a call.
+type edgePos token.Pos
+
+func (pos edgePos) Pos() token.Pos { return token.Pos(pos) }
+
+// formatPos formats the position [start, end) in the syntax
+// preferred by the specified editor, which is one of:
+//
+//	emacs	file:line.col-line.col, compatible with the 'gnu'
+//		compilation-error-regexp in Emacs' compilation mode.
+//
+//	vim	file:line:col of the start of the interval, compatible
+//		with Vim's default 'errorformat' for the quickfix list.
+//
+//	acme	file:#q0,#q1, an address understood by Acme and the
+//		plumber, where q0 and q1 are character (not byte) offsets.
+//
+// A zero-length interval is formatted as file:line:col, and
+// token.NoPos as "-".  If runes is set, columns are 1-based character
+// (not byte) indices, counted in the files as read from src.  If root
+// is not empty, the name of a file beneath that directory is relative
+// to it; see relativePath.
+func formatPos(editor string, runes bool, root string, fset *token.FileSet, src *fileSource, start, end token.Pos) string {
+	sp := src.position(fset, start)
+	filename := relativePath(root, sp.Filename)
+	if editor == "acme" && sp.IsValid() {
+		ep := src.position(fset, end)
+		return fmt.Sprintf("%s:#%d,#%d", filename,
+			src.runeOffset(fset, start, sp), src.runeOffset(fset, end, ep))
+	}
+	if runes {
+		sp.Column = src.runeColumn(sp.Filename, sp.Line, sp.Column)
+	}
+	if start == end || editor == "vim" {
+		sp.Filename = filename
+		return sp.String()
+	}
+	ep := src.position(fset, end)
+	if runes {
+		ep.Column = src.runeColumn(ep.Filename, ep.Line, ep.Column)
+	}
+	// emacs: the -1 below is a concession to Emacs's broken use
+	// of inclusive (not half-open) intervals.
+	return fmt.Sprintf("%s:%d.%d-%d.%d",
+		filename, sp.Line, sp.Column, ep.Line, ep.Column-1)
+}
+
+// relativePath returns the file name relative to the directory root,
+// if it is beneath it, so that output is independent of the location
+// of the workspace.  Other names, and all names if root is empty, are
+// returned unchanged.
+func relativePath(root, filename string) string {
+	if root == "" || !filepath.IsAbs(filename) {
+		return filename
+	}
+	rel, err := filepath.Rel(root, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filename
+	}
+	return rel
+}
+
+func toJSON(x interface{}) []byte {
+	b, err := json.MarshalIndent(x, "", "\t")
+	if err != nil {
+		log.Fatalf("JSON error: %v", err)
+	}
+	return b
+}
+
+// sizesFor returns the sizes of types in the build configuration ctxt.
+func sizesFor(ctxt *build.Context) types.Sizes {
+	if sizes := types.SizesFor(ctxt.Compiler, ctxt.GOARCH); sizes != nil {
+		return sizes
+	}
+	return &types.StdSizes{WordSize: 8, MaxAlign: 8} // assume amd64
+}
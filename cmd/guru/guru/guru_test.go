@@ -0,0 +1,1917 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru_test
+
+// This file defines a test framework for guru queries.
+//
+// The files beneath testdata/src contain Go programs containing
+// query annotations of the form:
+//
+//   @verb id "select"
+//
+// where verb is the query mode (e.g. "callers"), id is a unique name
+// for this query, and "select" is a regular expression matching the
+// substring of the current line that is the query's input selection.
+//
+// The expected output for each query is provided in the accompanying
+// .golden file.
+//
+// A query may be followed by flags of the command line, such as
+//
+//   @callers id "select" -depth=2
+//
+// which set the corresponding fields of its Query: -depth, -paths (a
+// number, or -1 for all), -pta, -callgraph, -focus and -scope (both
+// comma-separated, an empty -scope being none).  Such a query loads
+// its program afresh, rather than sharing that of its package.  With
+// -format=dot, its output is the DOT graph of its result.
+//
+// Location information is not included in plain output because it's
+// too fragile to display as text.  Instead, a file may name locations
+// with annotations of the form:
+//
+//   @loc name "select"
+//
+// where "select" matches the substring of the current line that starts
+// at the location.  In such a file, the selection of each query names
+// a location too, by its id, and each line of plain output whose
+// position starts at a named location is marked with its name,
+// e.g. "defined here  @name", so that the golden file shows where
+// the results are without depending on the line numbers.
+//
+// Run this test with:
+// 	% go test golang.org/x/tools/cmd/guru/guru -update
+// to update the golden files.
+
+import (
+	"bytes"
+	"context"
+	encjson "encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/client"
+	"golang.org/x/tools/cmd/guru/guru"
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/gcexportdata"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+func init() {
+	// This test currently requires GOPATH mode.
+	// Explicitly disabling module mode should suffix, but
+	// we'll also turn off GOPROXY just for good measure.
+	if err := os.Setenv("GO111MODULE", "off"); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Setenv("GOPROXY", "off"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var updateFlag = flag.Bool("update", false, "Update the golden files.")
+
+type query struct {
+	id       string         // unique id
+	verb     string         // query mode, e.g. "callees"
+	posn     token.Position // query position
+	filename string
+	queryPos string         // query position in command-line syntax
+	start    token.Position // start of the selection, if any
+	flags    []string       // command-line flags of the query, if any
+}
+
+func parseRegexp(text string) (*regexp.Regexp, error) {
+	pattern, err := strconv.Unquote(text)
+	if err != nil {
+		return nil, fmt.Errorf("can't unquote %s", text)
+	}
+	return regexp.Compile(pattern)
+}
+
+// parseQueries parses and returns the queries in the named file.
+func parseQueries(t *testing.T, filename string) []*query {
+	filedata, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parse the file once to discover the test queries.
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, filedata, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(filedata, []byte("\n"))
+
+	var queries []*query
+	queriesById := make(map[string]*query)
+
+	// Find all annotations of these forms:
+	expectRe := regexp.MustCompile(`@([a-z-]+)\s+(\S+)\s+("(?:[^"\\]|\\.)*")((?:\s+-\S+)*)$`) // @verb id "regexp" -flag...
+	for _, c := range f.Comments {
+		text := strings.TrimSpace(c.Text())
+		if text == "" || text[0] != '@' {
+			continue
+		}
+		posn := fset.Position(c.Pos())
+
+		// @verb id "regexp"
+		match := expectRe.FindStringSubmatch(text)
+		if match == nil {
+			t.Errorf("%s: ill-formed query: %s", posn, text)
+			continue
+		}
+
+		id := match[2]
+		if prev, ok := queriesById[id]; ok {
+			t.Errorf("%s: duplicate id %s", posn, id)
+			t.Errorf("%s: previously used here", prev.posn)
+			continue
+		}
+
+		q := &query{
+			id:       id,
+			verb:     match[1],
+			filename: filename,
+			posn:     posn,
+			flags:    strings.Fields(match[4]),
+		}
+
+		if match[3] != `"nopos"` {
+			selectRe, err := parseRegexp(match[3])
+			if err != nil {
+				t.Errorf("%s: %s", posn, err)
+				continue
+			}
+
+			// Find text of the current line, sans query.
+			// (Queries must be // not /**/ comments.)
+			line := lines[posn.Line-1][:posn.Column-1]
+
+			// Apply regexp to current line to find input selection.
+			loc := selectRe.FindIndex(line)
+			if loc == nil {
+				t.Errorf("%s: selection pattern %s doesn't match line %q",
+					posn, match[3], string(line))
+				continue
+			}
+
+			// Column, like the offsets of FindIndex, counts bytes.
+			linestart := posn.Offset - (posn.Column - 1)
+
+			// Compute the file offsets.
+			start, end := linestart+loc[0], linestart+loc[1]
+			if sel := filedata[start:end]; !bytes.Equal(sel, line[loc[0]:loc[1]]) {
+				t.Errorf("%s: selection %q is at the wrong offset, not %q", posn, sel, line[loc[0]:loc[1]])
+				continue
+			}
+			q.queryPos = fmt.Sprintf("%s:#%d,#%d", filename, start, end)
+			q.start = posn
+			q.start.Offset, q.start.Column = start, loc[0]+1
+		}
+
+		queries = append(queries, q)
+		queriesById[id] = q
+	}
+
+	// Return the slice, not map, for deterministic iteration.
+	return queries
+}
+
+// locations returns the named locations of a file, given its queries:
+// for each "line:column", the name of the @loc annotation or query
+// whose selection starts there; or nil if the file has no @loc
+// annotations.
+func locations(queries []*query) map[string]string {
+	var locs map[string]string
+	for _, q := range queries {
+		if q.verb == "loc" {
+			locs = make(map[string]string)
+			break
+		}
+	}
+	if locs != nil {
+		for _, q := range queries {
+			if q.queryPos != "" {
+				locs[fmt.Sprintf("%d:%d", q.start.Line, q.start.Column)] = q.id
+			}
+		}
+	}
+	return locs
+}
+
+// A testProgram is the program of a testdata package, loaded and
+// analyzed once, by a guru server, for the queries of all its files.
+type testProgram struct {
+	once   sync.Once
+	server *guru.Server // nil if the program failed to load
+}
+
+// testQuery returns the template of the queries of the testdata
+// package pkg.
+func testQuery(pkg string) *guru.Query {
+	var buildContext = build.Default
+	buildContext.GOPATH = "testdata"
+	return &guru.Query{
+		Build: &buildContext,
+		Scope: []string{pkg},
+		PTA:   guru.PTAOptions{Reflection: true},
+	}
+}
+
+// get returns the server of the program of the testdata package pkg,
+// loading it on the first call, or nil if it fails to load, in which
+// case each query loads the program afresh, and reports the failure.
+func (p *testProgram) get(pkg string) *guru.Server {
+	p.once.Do(func() {
+		p.server, _ = guru.NewServer(testQuery(pkg))
+	})
+	return p.server
+}
+
+// needsScope reports whether a query mode analyzes the whole program
+// of its scope.
+func needsScope(mode string) bool {
+	for _, m := range guru.Modes() {
+		if m.Name == mode {
+			return m.NeedsScope
+		}
+	}
+	return false
+}
+
+// setFlags sets the fields of query specified by the command-line
+// flags of a test query, and reports whether its output is in the
+// DOT format.
+func setFlags(query *guru.Query, flags []string) (dot bool, err error) {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.IntVar(&query.Depth, "depth", query.Depth, "")
+	fs.IntVar(&query.Paths, "paths", query.Paths, "")
+	fs.StringVar(&query.PTA.Context, "pta", query.PTA.Context, "")
+	fs.StringVar(&query.PTA.CallGraph, "callgraph", query.PTA.CallGraph, "")
+	focus := fs.String("focus", "", "")
+	scope := fs.String("scope", strings.Join(query.Scope, ","), "")
+	format := fs.String("format", "plain", "")
+	if err := fs.Parse(flags); err != nil {
+		return false, err
+	}
+	query.Focus = splitList(*focus)
+	query.Scope = splitList(*scope)
+	switch *format {
+	case "plain":
+		return false, nil
+	case "dot":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid -format %q", *format)
+}
+
+// splitList returns the elements of a comma-separated list, or nil
+// if it is empty.
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// doQuery poses query q to the guru and writes its response and
+// error (if any) to out.  A query that analyzes the whole program
+// uses the analysis of program, unless it has flags.  Each line of
+// plain output whose position is at one of the named locations of q's
+// file is marked with its name.
+func doQuery(out io.Writer, q *query, json bool, locs map[string]string, program *testProgram) {
+	fmt.Fprintf(out, "-------- @%s %s --------\n", q.verb, q.id)
+
+	pkg := filepath.Dir(strings.TrimPrefix(q.filename, "testdata/src/"))
+	gopathAbs, _ := filepath.Abs("testdata")
+
+	query := testQuery(pkg)
+	dot, err := setFlags(query, q.flags)
+	if err != nil {
+		fmt.Fprintf(out, "\nError: %s\n", err)
+		return
+	}
+
+	var outputMu sync.Mutex // guards outputs
+	var outputs []string    // JSON objects or lines of text
+	outputFn := func(fset *token.FileSet, qr guru.QueryResult) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		if dot {
+			graph := string(qr.(interface {
+				DOT(*token.FileSet) []byte
+			}).DOT(fset))
+			outputs = append(outputs, strings.Replace(graph, gopathAbs, "$GOPATH", -1))
+		} else if json {
+			jsonstr := string(qr.JSON(fset))
+			if filepath.Separator == '\\' {
+				// Use slashes in the filenames.
+				jsonstr = strings.Replace(jsonstr, `\\`, "/", -1)
+			}
+			// Sanitize any absolute filenames that creep in.
+			jsonstr = strings.Replace(jsonstr, filepath.ToSlash(gopathAbs), "$GOPATH", -1)
+			outputs = append(outputs, jsonstr)
+		} else {
+			// suppress position information, except for named locations
+			qr.PrintPlain(func(pos interface{}, format string, args ...interface{}) {
+				line := fmt.Sprintf(format, args...)
+				if start, _ := guru.PosRange(fset, pos); start.IsValid() {
+					posn := fset.Position(start)
+					if filepath.ToSlash(posn.Filename) == q.filename {
+						if name, ok := locs[fmt.Sprintf("%d:%d", posn.Line, posn.Column)]; ok {
+							line += "  @" + name
+						}
+					}
+				}
+				outputs = append(outputs, line)
+			})
+		}
+	}
+
+	var server *guru.Server
+	if needsScope(q.verb) && len(q.flags) == 0 {
+		server = program.get(pkg)
+	}
+	if server != nil {
+		err = server.Run(q.verb, []string{q.queryPos}, outputFn)[0]
+	} else {
+		query.Pos = q.queryPos
+		query.Output = outputFn
+		err = guru.Run(q.verb, query)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "\nError: %s\n", err)
+		return
+	}
+
+	for _, output := range outputs {
+		fmt.Fprintf(out, "%s\n", output)
+	}
+
+	if !json {
+		io.WriteString(out, "\n")
+	}
+}
+
+func TestGuru(t *testing.T) {
+	if testing.Short() {
+		// These tests are super slow.
+		// TODO: make a lighter version of the tests for short mode?
+		t.Skipf("skipping in short mode")
+	}
+	if runtime.GOOS == "android" {
+		t.Skipf("skipping test on %q (no testdata dir)", runtime.GOOS)
+	}
+
+	// The files of a package share the analysis of its program,
+	// which is loaded by the first of them to need it.
+	programs := make(map[string]*testProgram)
+	limit := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for _, filename := range []string{
+		"testdata/src/alias/alias.go", // iff guru.HasAlias (go1.9)
+		"testdata/src/allocs/main.go",
+		"testdata/src/callees-cha/lib/lib.go",
+		"testdata/src/callees-methods/main.go",
+		"testdata/src/callers-depth/main.go",
+		"testdata/src/callgraph-algorithm/main.go",
+		"testdata/src/callgraph-focus/main.go",
+		"testdata/src/calls/main.go",
+		"testdata/src/callstack-paths/main.go",
+		"testdata/src/deadcode/main.go",
+		"testdata/src/describe/main.go",
+		"testdata/src/describe/main19.go", // iff go1.9
+		"testdata/src/describe-constants/main.go",
+		"testdata/src/describe-doc/main.go",
+		"testdata/src/effects/main.go",
+		"testdata/src/exports/lib/lib.go",
+		"testdata/src/freevars/main.go",
+		"testdata/src/hierarchy/main.go",
+		"testdata/src/implements/main.go",
+		"testdata/src/implements-methods/main.go",
+		"testdata/src/importers/lib/lib.go",
+		"testdata/src/importers/mid/mid.go",
+		"testdata/src/imports/main.go",
+		"testdata/src/lockers/main.go",
+		"testdata/src/panics/main.go",
+		"testdata/src/peers/main.go",
+		"testdata/src/pointsto/main.go",
+		"testdata/src/pta-context/main.go",
+		"testdata/src/referrers/main.go",
+		"testdata/src/reflection/main.go",
+		"testdata/src/rename-check/p/p.go",
+		"testdata/src/shared/main.go",
+		"testdata/src/what/main.go",
+		"testdata/src/whicherrs/main.go",
+		"testdata/src/softerrs/main.go",
+		"testdata/src/nonascii/main.go",
+		// JSON:
+		// TODO(adonovan): most of these are very similar; combine them.
+		"testdata/src/calls-json/main.go",
+		"testdata/src/peers-json/main.go",
+		"testdata/src/definition-json/main.go",
+		"testdata/src/definition-json/main19.go",
+		"testdata/src/describe-json/main.go",
+		"testdata/src/freevars-json/main.go",
+		"testdata/src/hierarchy-json/main.go",
+		"testdata/src/implements-json/main.go",
+		"testdata/src/implements-methods-json/main.go",
+		"testdata/src/pointsto-json/main.go",
+		"testdata/src/referrers-json/main.go",
+		"testdata/src/what-json/main.go",
+		"testdata/src/nonascii-json/main.go",
+	} {
+		filename := filename
+		name := strings.Split(filename, "/")[2]
+		if programs[name] == nil {
+			programs[name] = new(testProgram)
+		}
+		program := programs[name]
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			if filename == "testdata/src/referrers/main.go" && (runtime.GOOS == "plan9" || runtime.GOOS == "windows") {
+				// Disable this test on plan9 and windows since it expects
+				// a particular wording for a "no such file or directory" error.
+				t.Skip()
+			}
+			if filename == "testdata/src/alias/alias.go" && !guru.HasAlias {
+				t.Skip()
+			}
+			if strings.HasSuffix(filename, "19.go") && !contains(build.Default.ReleaseTags, "go1.9") {
+				// TODO(adonovan): recombine the 'describe' and 'definition'
+				// tests once we drop support for go1.8.
+				t.Skip()
+			}
+
+			json := strings.Contains(filename, "-json/")
+			queries := parseQueries(t, filename)
+			golden := filename + "lden"
+
+			// Run the guru on each query, recording its output
+			// and error (if any).  The queries run concurrently,
+			// up to GOMAXPROCS of them across all the files.
+			outs := make([]bytes.Buffer, len(queries))
+			locs := locations(queries)
+			var wg sync.WaitGroup
+			for i, q := range queries {
+				if q.verb == "loc" {
+					continue
+				}
+				wg.Add(1)
+				go func(out *bytes.Buffer, q *query) {
+					defer wg.Done()
+					limit <- struct{}{}
+					defer func() { <-limit }()
+					doQuery(out, q, json, locs, program)
+				}(&outs[i], q)
+			}
+			wg.Wait()
+			got := new(bytes.Buffer)
+			for i := range outs {
+				got.Write(outs[i].Bytes())
+			}
+
+			// Compare the output with foo.golden, which
+			// may have acquired CRLF line endings on checkout.
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = bytes.Replace(want, []byte("\r\n"), []byte("\n"), -1)
+			if diff := unifiedDiff(golden, "got", string(want), got.String()); diff != "" {
+				t.Errorf("Guru tests for %s failed:\n%s", filename, diff)
+
+				if *updateFlag {
+					t.Logf("Updating %s...", golden)
+					if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+						t.Errorf("Update failed: %s", err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, x := range haystack {
+		if needle == x {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIssue14684(t *testing.T) {
+	var buildContext = build.Default
+	buildContext.GOPATH = "testdata"
+	query := guru.Query{
+		Pos:   "testdata/src/README.txt:#1",
+		Build: &buildContext,
+	}
+	err := guru.Run("freevars", &query)
+	if err == nil {
+		t.Fatal("guru query succeeded unexpectedly")
+	}
+	if got, want := err.Error(), "testdata/src/README.txt is not a Go source file"; got != want {
+		t.Errorf("query error was %q, want %q", got, want)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	var buildContext = build.Default
+	buildContext.GOPATH = "testdata"
+
+	// Gather the positions of the "peers" queries.
+	var posns []string
+	for _, q := range parseQueries(t, "testdata/src/peers/main.go") {
+		if q.verb == "peers" {
+			posns = append(posns, q.queryPos)
+		}
+	}
+
+	run := func(posns ...string) (string, []error) {
+		var outputMu sync.Mutex
+		var buf bytes.Buffer
+		query := guru.Query{
+			Build: &buildContext,
+			Scope: []string{"peers"},
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				outputMu.Lock()
+				defer outputMu.Unlock()
+				fmt.Fprintf(&buf, "%s\n", qr.JSON(fset))
+			},
+		}
+		errs := guru.RunBatch("peers", &query, posns)
+		return buf.String(), errs
+	}
+
+	// The output of the batch should be the concatenation
+	// of the outputs of the queries run one at a time.
+	var want string
+	for _, posn := range posns {
+		got, errs := run(posn)
+		if errs[0] != nil {
+			t.Fatalf("query at %s failed: %s", posn, errs[0])
+		}
+		want += got
+	}
+	got, errs := run(posns...)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("batch query at %s failed: %s", posns[i], err)
+		}
+	}
+	if got == "" || got != want {
+		t.Errorf("batch output differs from individual queries:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	var buildContext = build.Default
+	buildContext.GOPATH = "testdata"
+
+	var posns []string
+	for _, q := range parseQueries(t, "testdata/src/peers/main.go") {
+		if q.verb == "peers" {
+			posns = append(posns, q.queryPos)
+		}
+	}
+
+	// Compute the expected results using one-shot queries.
+	var want []string
+	for _, posn := range posns {
+		query := guru.Query{
+			Pos:   posn,
+			Build: &buildContext,
+			Scope: []string{"peers"},
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				// The server's results are compacted in transit.
+				var buf bytes.Buffer
+				if err := encjson.Compact(&buf, qr.JSON(fset)); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, buf.String())
+			},
+		}
+		if err := guru.Run("peers", &query); err != nil {
+			t.Fatalf("query at %s failed: %s", posn, err)
+		}
+	}
+
+	s, err := guru.NewServer(&guru.Query{
+		Build: &buildContext,
+		Scope: []string{"peers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Ask twice, to exercise reuse of the analysis.
+	for i := 0; i < 2; i++ {
+		reply, err := c.Query("peers", posns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, msg := range reply.Errors {
+			if msg != "" {
+				t.Errorf("query at %s failed: %s", posns[j], msg)
+			}
+		}
+		var got []string
+		for _, res := range reply.Results {
+			got = append(got, string(res))
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("server results differ from one-shot queries:\ngot:\n%s\nwant:\n%s",
+				strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+// TestServeReload checks that a Server notices changes to the
+// program's files between queries.
+func TestServeReload(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	files := map[string]string{
+		"src/lib/lib.go":  "package lib\n\nvar P = new(int)\n",
+		"src/app/main.go": "package main\n\nimport \"lib\"\n\nfunc main() {\n\tp := lib.P\n\t_ = p\n}\n",
+	}
+	gopath := makeGOPATH(t, files)
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	s, err := guru.NewServer(&guru.Query{
+		Build: &buildContext,
+		Scope: []string{"app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pointsto reports the position of the allocation new(int).
+	mainFile := filepath.Join(gopath, "src/app/main.go")
+	posn := fmt.Sprintf("%s:#%d", mainFile, strings.Index(files["src/app/main.go"], "p :="))
+	pointsTo := func() string {
+		fset, results, err := s.Query("pointsto", posn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("pointsto returned %d results, want 1", len(results))
+		}
+		return string(results[0].JSON(fset))
+	}
+	before := pointsTo()
+	if !strings.Contains(before, "lib.go:3:") {
+		t.Fatalf("pointsto result does not mention lib.go:3:\n%s", before)
+	}
+
+	// Move the allocation down a line; the change must be seen.
+	libFile := filepath.Join(gopath, "src/lib/lib.go")
+	if err := ioutil.WriteFile(libFile, []byte("package lib\n\n\nvar P = new(int)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after := pointsTo(); !strings.Contains(after, "lib.go:4:") {
+		t.Errorf("after change, pointsto result does not mention lib.go:4:\n%s", after)
+	}
+}
+
+// TestServeProgram checks that a Server runs queries that do not need
+// whole-program analysis against its loaded program, if their
+// positions are within it, and otherwise loads their packages afresh.
+func TestServeProgram(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	files := map[string]string{
+		"src/app/main.go":    "package main\n\n// V is a variable.\nvar V int\n\nfunc main() { _ = V }\n",
+		"src/other/other.go": "package other\n\n// W is another.\nvar W int\n",
+	}
+	gopath := makeGOPATH(t, files)
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	var loaded []string // the packages type-checked by each query
+	s, err := guru.NewServer(&guru.Query{
+		Build: &buildContext,
+		Scope: []string{"app"},
+		Progress: func(e guru.ProgressEvent) {
+			if e.Package != "" {
+				loaded = append(loaded, e.Package)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		file, ident, doc, loaded string
+	}{
+		{"src/app/main.go", "V int", "V is a variable.", ""},
+		{"src/other/other.go", "W int", "W is another.", "other"},
+	} {
+		loaded = nil
+		posn := fmt.Sprintf("%s:#%d", filepath.Join(gopath, test.file), strings.Index(files[test.file], test.ident))
+		fset, results, err := s.Query("describe", posn)
+		if err != nil {
+			t.Fatalf("describe %s: %v", test.file, err)
+		}
+		if len(results) != 1 || !strings.Contains(string(results[0].JSON(fset)), test.doc) {
+			t.Errorf("describe %s: results lack doc comment %q", test.file, test.doc)
+		}
+		if got := strings.Join(loaded, " "); got != test.loaded {
+			t.Errorf("describe %s: type-checked %q, want %q", test.file, got, test.loaded)
+		}
+	}
+}
+
+// makeGOPATH creates a temporary GOPATH tree containing the specified
+// files, keyed by slash-separated relative name.
+// The caller must remove it.
+func makeGOPATH(t testing.TB, files map[string]string) string {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		name = filepath.Join(gopath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gopath
+}
+
+// TestProgram checks that queries run against a program loaded and
+// analyzed by the client.
+func TestProgram(t *testing.T) {
+	const src = `package main
+
+type I interface{ f() }
+
+type T int32
+
+func (T) f() {}
+
+func main() {
+	var i I = T(0)
+	i.f()
+	x := new(int)
+	print(x)
+}
+`
+	gopath := makeGOPATH(t, map[string]string{"src/app/main.go": src})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	lconf := loader.Config{Build: &buildContext}
+	lconf.Import("app")
+	lprog, err := lconf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := ssautil.CreateProgram(lprog, ssa.GlobalDebug)
+	prog.Build()
+	ptares, err := pointer.Analyze(&pointer.Config{
+		Mains:          []*ssa.Package{prog.Package(lprog.Package("app").Pkg)},
+		BuildCallGraph: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := guru.NewProgram(lprog, prog, ptares)
+
+	filename := filepath.Join(gopath, "src/app/main.go")
+	for _, test := range []struct {
+		mode, substr, want string
+	}{
+		{"describe", "T(0)", "reference to type T (size 4, align 4)\ndefined as int32\nMethods:\n\tmethod (T) f()\n"},
+		{"callees", "i.f()", "this dynamic method call dispatches to:\n\t(app.T).f\n"},
+		{"pointsto", "x)", "this *int may point to these objects:\n\tnew: new(int)\n"},
+	} {
+		var buf bytes.Buffer
+		q := guru.Query{
+			Pos:   fmt.Sprintf("%s:#%d", filename, strings.Index(src, test.substr)),
+			Build: &buildContext,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+					fmt.Fprintf(&buf, format+"\n", args...)
+				})
+			},
+		}
+		if err := p.Run(test.mode, &q); err != nil {
+			t.Errorf("%s: %v", test.mode, err)
+			continue
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", test.mode, got, test.want)
+		}
+	}
+}
+
+// TestAdhocPackage checks that a query of a file outside any GOPATH
+// directory loads it with the other files of its package, like go run.
+func TestAdhocPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guru-adhoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const src = "package main\n\nfunc main() { println(helper()) }\n"
+	for name, content := range map[string]string{
+		"a.go":     src,
+		"b.go":     "package main\n\nfunc helper() int { return 1 }\n",
+		"other.go": "// +build ignore\n\npackage other\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buildContext = build.Default
+	buildContext.GOPATH = filepath.Join(dir, "gopath") // (nonexistent)
+
+	var buf bytes.Buffer
+	q := guru.Query{
+		Pos:   fmt.Sprintf("%s:#%d", filepath.Join(dir, "a.go"), strings.Index(src, "helper")),
+		Build: &buildContext,
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+				fmt.Fprintf(&buf, format+"\n", args...)
+			})
+		},
+	}
+	if err := guru.Run("describe", &q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "reference to func helper() int\ndefined here\n"; got != want {
+		t.Errorf("describe:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestCgo checks that queries work within a file that imports "C".
+func TestCgo(t *testing.T) {
+	if !build.Default.CgoEnabled {
+		t.Skip("cgo not enabled")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("no gcc")
+	}
+	const src = `package app
+
+// static int twice(int x) { return 2*x; }
+import "C"
+
+func Twice(x int) int {
+	return int(C.twice(C.int(x)))
+}
+
+func Use() int { return Twice(3) }
+`
+	gopath := makeGOPATH(t, map[string]string{"src/app/app.go": src})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	filename := filepath.Join(gopath, "src/app/app.go")
+	var buf bytes.Buffer
+	q := guru.Query{
+		Pos:   fmt.Sprintf("%s:#%d", filename, strings.Index(src, "Twice(3)")),
+		Build: &buildContext,
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+				fmt.Fprintf(&buf, format+"\n", args...)
+			})
+		},
+	}
+	if err := guru.Run("describe", &q); err != nil {
+		t.Fatal(err)
+	}
+	want := "reference to func Twice(x int) int\ndefined here\n"
+	if got := buf.String(); got != want {
+		t.Errorf("describe:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestRoots checks that packages are found in Query.Roots before GOPATH.
+func TestRoots(t *testing.T) {
+	const src = `package app
+
+import "lib"
+
+var _ = lib.X
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"gopath/src/app/app.go": src,
+		"gopath/src/lib/lib.go": "package lib\n\nvar Y int\n",
+		"vendor/src/lib/lib.go": "package lib\n\nvar X int\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = filepath.Join(gopath, "gopath")
+
+	filename := filepath.Join(gopath, "gopath/src/app/app.go")
+	var def struct {
+		ObjPos string `json:"objpos"`
+	}
+	q := guru.Query{
+		Pos:   fmt.Sprintf("%s:#%d", filename, strings.Index(src, "X")),
+		Build: &buildContext,
+		Roots: []string{filepath.Join(gopath, "vendor")},
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			if err := encjson.Unmarshal(qr.JSON(fset), &def); err != nil {
+				t.Error(err)
+			}
+		},
+	}
+	if err := guru.Run("definition", &q); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(gopath, "vendor/src/lib/lib.go") + ":3:5"; def.ObjPos != want {
+		t.Errorf("definition of lib.X is at %s, want %s", def.ObjPos, want)
+	}
+}
+
+// TestBuildTags checks that queries observe the build tags of Query.Build.
+func TestBuildTags(t *testing.T) {
+	const src = `package app
+
+var _ = X
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"src/app/app.go":         src,
+		"src/app/integration.go": "// +build integration\n\npackage app\n\nvar X int\n",
+		"src/app/default.go":     "// +build !integration\n\npackage app\n\nvar X string\n",
+	})
+	defer os.RemoveAll(gopath)
+
+	filename := filepath.Join(gopath, "src/app/app.go")
+	for _, test := range []struct {
+		tags []string
+		want string
+	}{
+		{nil, "default.go:5:5"},
+		{[]string{"integration"}, "integration.go:5:5"},
+	} {
+		var buildContext = build.Default
+		buildContext.GOPATH = gopath
+		buildContext.BuildTags = test.tags
+
+		var def struct {
+			ObjPos string `json:"objpos"`
+		}
+		q := guru.Query{
+			Pos:   fmt.Sprintf("%s:#%d", filename, strings.Index(src, "X")),
+			Build: &buildContext,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				if err := encjson.Unmarshal(qr.JSON(fset), &def); err != nil {
+					t.Error(err)
+				}
+			},
+		}
+		if err := guru.Run("definition", &q); err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(gopath, "src/app", test.want); def.ObjPos != want {
+			t.Errorf("-tags=%q: definition of X is at %s, want %s", test.tags, def.ObjPos, want)
+		}
+	}
+}
+
+// TestCache checks that queries using an on-disk cache of type
+// information observe changes to the cached packages.
+func TestCache(t *testing.T) {
+	const src = `package app
+
+import "lib"
+
+var _ lib.T
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"src/app/app.go": src,
+		"src/lib/lib.go": "package lib\n\ntype T struct{ X int }\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+	cache := filepath.Join(gopath, "cache")
+
+	describe := func() string {
+		var got string
+		q := guru.Query{
+			Pos:   fmt.Sprintf("%s:#%d", filepath.Join(gopath, "src/app/app.go"), strings.Index(src, "T")),
+			Build: &buildContext,
+			Cache: cache,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				got = string(qr.JSON(fset))
+			},
+		}
+		if err := guru.Run("describe", &q); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// The first query populates the cache; the second uses it.
+	for i := 0; i < 2; i++ {
+		if got := describe(); !strings.Contains(got, "struct{X int}") {
+			t.Errorf("query %d: result does not describe struct{X int}:\n%s", i, got)
+		}
+	}
+	if entries, _ := ioutil.ReadDir(cache); len(entries) == 0 {
+		t.Errorf("cache is empty")
+	}
+
+	// A change to lib must be seen.
+	libFile := filepath.Join(gopath, "src/lib/lib.go")
+	if err := ioutil.WriteFile(libFile, []byte("package lib\n\ntype T struct{ Y int }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(); !strings.Contains(got, "struct{Y int}") {
+		t.Errorf("after change, result does not describe struct{Y int}:\n%s", got)
+	}
+}
+
+// TestExportData checks that queries may read a dependency from its
+// compiled package object, but only while it is newer than the
+// dependency's files.
+func TestExportData(t *testing.T) {
+	const src = `package app
+
+import "lib"
+
+var _ lib.T
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"src/app/app.go": src,
+		"src/lib/lib.go": "package lib\n\ntype T struct{ Y int }\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+	libFile := filepath.Join(gopath, "src/lib/lib.go")
+
+	// Install an object of lib whose T differs from that of its
+	// source, so that the results show which was read.
+	bp, err := buildContext.Import("lib", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, libFile, "package lib\n\ntype T struct{ X int }\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("lib", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj bytes.Buffer
+	fmt.Fprintf(&obj, "go object %s %s\n\n$$B\n", buildContext.GOOS, buildContext.GOARCH)
+	if err := gcexportdata.Write(&obj, fset, pkg); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(bp.PkgObj), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bp.PkgObj, obj.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hourAgo := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(libFile, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func() string {
+		var got string
+		q := guru.Query{
+			Pos:        fmt.Sprintf("%s:#%d", filepath.Join(gopath, "src/app/app.go"), strings.Index(src, "T")),
+			Build:      &buildContext,
+			ExportData: true,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				got = string(qr.JSON(fset))
+			},
+		}
+		if err := guru.Run("describe", &q); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := describe(); !strings.Contains(got, "struct{X int}") {
+		t.Errorf("result does not describe struct{X int} of the object:\n%s", got)
+	}
+
+	// Referrers of an object of the object file must locate it
+	// precisely, in its source.
+	var refs []string
+	q := guru.Query{
+		Pos:        fmt.Sprintf("%s:#%d", filepath.Join(gopath, "src/app/app.go"), strings.Index(src, "T")),
+		Build:      &buildContext,
+		ExportData: true,
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			refs = append(refs, string(qr.JSON(fset)))
+		},
+	}
+	if err := guru.Run("referrers", &q); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`"objpos": "%s:3:6"`, libFile); len(refs) == 0 || !strings.Contains(refs[0], want) {
+		t.Errorf("referrers: got %q, want first result with %s", refs, want)
+	}
+
+	// Once lib is newer than its object, its source must be read.
+	if err := os.Chtimes(libFile, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(); !strings.Contains(got, "struct{Y int}") {
+		t.Errorf("after change, result does not describe struct{Y int}:\n%s", got)
+	}
+}
+
+// TestOverlay checks that queries read the files of Query.Overlay, both
+// those that replace files on disk and those that are not there, in
+// packages that are not there either.
+func TestOverlay(t *testing.T) {
+	const src = `package app
+
+import "gen"
+
+var V = gen.X
+`
+	gopath := makeGOPATH(t, map[string]string{"src/app/app.go": "package app\n"})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+	appFile := filepath.Join(gopath, "src/app/app.go")
+	genFile := filepath.Join(gopath, "src/gen/gen.go")
+	overlay := map[string][]byte{
+		appFile: []byte(src),
+		genFile: []byte("package gen\n\nconst X = 42\n"),
+	}
+
+	for _, test := range []struct {
+		mode, pos string
+		want      string
+	}{
+		{"describe", fmt.Sprintf("%s:#%d", appFile, strings.Index(src, "X")),
+			"reference to const gen.X untyped int of value 42\n"},
+		{"definition", fmt.Sprintf("%s:#%d", appFile, strings.Index(src, "X")),
+			"defined here as const gen.X\n"},
+		{"referrers", fmt.Sprintf("%s:#%d", genFile, strings.Index("package gen\n\nconst X = 42\n", "X")),
+			"references to const X untyped int\nvar V = gen.X\n"},
+	} {
+		var buf bytes.Buffer
+		q := guru.Query{
+			Pos:     test.pos,
+			Build:   &buildContext,
+			Overlay: overlay,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+					fmt.Fprintf(&buf, format+"\n", args...)
+				})
+			},
+		}
+		if err := guru.Run(test.mode, &q); err != nil {
+			t.Errorf("%s: %v", test.mode, err)
+			continue
+		}
+		if got := buf.String(); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%s: got %q, want prefix %q", test.mode, got, test.want)
+		}
+	}
+}
+
+// TestModified checks that queries observe the unsaved contents of
+// files supplied in a -modified archive, not those on disk.
+func TestModified(t *testing.T) {
+	gopath := makeGOPATH(t, map[string]string{
+		"src/p/p.go": "package p\n\nvar x = 1\n",
+	})
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src/p/p.go")
+
+	const src = "package p\n\nvar unsaved = 1\n\nvar y = unsaved\n"
+	archive := fmt.Sprintf("%s\n%d\n%s", filename, len(src), src)
+	modified, err := buildutil.ParseOverlayArchive(strings.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	var got string
+	q := guru.Query{
+		Pos:   fmt.Sprintf("%s:#%d", filename, strings.LastIndex(src, "unsaved")),
+		Build: buildutil.OverlayContext(&buildContext, modified),
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			qr.PrintPlain(func(pos interface{}, format string, args ...interface{}) {
+				got = fmt.Sprintf(format, args...)
+			})
+		},
+	}
+	if err := guru.Run("definition", &q); err != nil {
+		t.Fatal(err)
+	}
+	if want := "defined here as var unsaved"; got != want {
+		t.Errorf("definition: got %q, want %q", got, want)
+	}
+}
+
+// TestTarget checks that queries reflect the GOOS and GOARCH of the
+// build context, not those of the host.
+func TestTarget(t *testing.T) {
+	const src = `package app
+
+type T struct {
+	p *int
+	n int
+	x X
+}
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"src/app/app.go":       src,
+		"src/app/x_windows.go": "package app\n\ntype X [3]byte\n",
+		"src/app/x_other.go":   "// +build !windows\n\npackage app\n\ntype X struct{}\n",
+	})
+	defer os.RemoveAll(gopath)
+
+	filename := filepath.Join(gopath, "src/app/app.go")
+	for _, test := range []struct {
+		goos, goarch, want string
+	}{
+		{"linux", "amd64", "size 24, align 8"}, // (a final empty field is padded)
+		{"linux", "arm", "size 12, align 4"},
+		{"windows", "386", "size 12, align 4"},
+	} {
+		var buildContext = build.Default
+		buildContext.GOPATH = gopath
+		buildContext.GOOS = test.goos
+		buildContext.GOARCH = test.goarch
+		buildContext.CgoEnabled = false
+
+		var got string
+		q := guru.Query{
+			Pos:   fmt.Sprintf("%s:#%d", filename, strings.Index(src, "T")),
+			Build: &buildContext,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(pos interface{}, format string, args ...interface{}) {
+					if got == "" {
+						got = fmt.Sprintf(format, args...)
+					}
+				})
+			},
+		}
+		if err := guru.Run("describe", &q); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s/%s: got %q, want %s", test.goos, test.goarch, got, test.want)
+		}
+	}
+}
+
+// TestNoTests checks that NoTests excludes the tests of the packages
+// searched by a query from its results.
+func TestNoTests(t *testing.T) {
+	const src = "package p\n\nfunc F() {}\n"
+	gopath := makeGOPATH(t, map[string]string{
+		"src/p/p.go":      src,
+		"src/p/p_test.go": "package p\n\nfunc init() { F() }\n",
+		"src/q/q.go":      "package q\n\nimport \"p\"\n\nfunc G() { p.F() }\n",
+		"src/q/x_test.go": "package q_test\n\nimport \"p\"\n\nfunc H() { p.F() }\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	filename := filepath.Join(gopath, "src/p/p.go")
+	for _, test := range []struct {
+		noTests bool
+		want    []string // files containing references
+	}{
+		{false, []string{"p.go", "p_test.go", "q.go", "x_test.go"}},
+		{true, []string{"p.go", "p_test.go", "q.go"}}, // (the tests of the query package are kept)
+	} {
+		var buf bytes.Buffer
+		q := guru.Query{
+			Pos:     fmt.Sprintf("%s:#%d", filename, strings.Index(src, "F")),
+			Build:   &buildContext,
+			NoTests: test.noTests,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				buf.Write(qr.JSON(fset))
+			},
+		}
+		if err := guru.Run("referrers", &q); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, name := range []string{"p.go", "p_test.go", "q.go", "x_test.go"} {
+			if strings.Contains(buf.String(), string(filepath.Separator)+name+":") {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("referrers with NoTests=%t: found references in %v, want %v",
+				test.noTests, got, test.want)
+		}
+	}
+}
+
+// TestQueryInTests checks that the whole-program queries apply within
+// the internal and external tests of a package, even one outside the
+// analysis scope.  A stub "testing" package in a fake GOROOT keeps the
+// analysis small.
+func TestQueryInTests(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	const xtest = `package p_test
+
+import (
+	"p"
+	"testing"
+)
+
+func helper() int { return p.F() }
+
+func TestX(t *testing.T) { helper() }
+`
+	root := makeGOPATH(t, map[string]string{
+		"src/app/main.go": "package main\n\nimport \"p\"\n\nfunc main() { p.F() }\n",
+		"src/p/p.go":      "package p\n\nfunc F() int { return 1 }\n",
+		"src/p/p_test.go": "package p\n\nimport \"testing\"\n\nfunc g() int { return 1 }\n\nfunc TestG(t *testing.T) { g() }\n",
+		"src/p/x_test.go": xtest,
+		"src/io/io.go":    "package io\n\ntype Writer interface{ Write([]byte) (int, error) }\n",
+		"src/os/os.go":    "package os\n\nvar Args []string\n\nfunc Exit(code int) {}\n",
+		"src/testing/stub.go": `package testing
+
+import (
+	"io"
+	"os"
+)
+
+// The test main imports io and os through testing.
+var (
+	_ io.Writer
+	_ = os.Exit
+)
+
+type T struct{}
+
+type InternalTest struct {
+	Name string
+	F    func(*T)
+}
+
+type InternalBenchmark struct {
+	Name string
+	F    func()
+}
+
+type InternalExample struct {
+	Name string
+	F    func()
+}
+
+type M struct{ tests []InternalTest }
+
+func MainStart(deps interface{}, tests []InternalTest, benchmarks []InternalBenchmark, examples []InternalExample) *M {
+	return &M{tests}
+}
+
+func (m *M) Run() int {
+	for _, test := range m.tests {
+		test.F(new(T))
+	}
+	return 0
+}
+`,
+	})
+	defer os.RemoveAll(root)
+	var buildContext = build.Default
+	buildContext.GOROOT = root
+	buildContext.GOPATH = ""
+	buildContext.CgoEnabled = false
+
+	ptest := filepath.Join(root, "src/p/p_test.go")
+	xfile := filepath.Join(root, "src/p/x_test.go")
+	for _, test := range []struct {
+		pos     string
+		scope   string
+		noTests bool
+		want    string
+	}{
+		{fmt.Sprintf("%s:#%d", xfile, strings.Index(xtest, "helper")), "app", false, "p_test.TestX"},
+		{fmt.Sprintf("%s:#%d", xfile, strings.Index(xtest, "helper")), "p", true, "p_test.TestX"},
+		{ptest + ":5:6", "app", false, "p.TestG"}, // g
+	} {
+		var got []string
+		q := guru.Query{
+			Pos:     test.pos,
+			Build:   &buildContext,
+			Scope:   []string{test.scope},
+			NoTests: test.noTests,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+					got = append(got, fmt.Sprintf(format, args...))
+				})
+			},
+		}
+		if err := guru.Run("callers", &q); err != nil {
+			t.Errorf("callers %s with scope %s: %v", test.pos, test.scope, err)
+			continue
+		}
+		if !strings.Contains(strings.Join(got, "\n"), "from "+test.want) {
+			t.Errorf("callers %s with scope %s: got %q, want a call from %s", test.pos, test.scope, got, test.want)
+		}
+	}
+
+	// Every position of a batch, not only the first, and of a
+	// server query, adds the tests of its package.
+	var mu sync.Mutex
+	var got []string
+	output := func(fset *token.FileSet, qr guru.QueryResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+			got = append(got, fmt.Sprintf(format, args...))
+		})
+	}
+	q := guru.Query{Build: &buildContext, Scope: []string{"app"}, Output: output}
+	posns := []string{filepath.Join(root, "src/app/main.go") + ":5:6", ptest + ":5:6"} // main, g
+	for i, err := range guru.RunBatch("callers", &q, posns) {
+		if err != nil {
+			t.Errorf("batch callers %s: %v", posns[i], err)
+		}
+	}
+	if !strings.Contains(strings.Join(got, "\n"), "from p.TestG") {
+		t.Errorf("batch callers %s: got %q, want a call from p.TestG", posns, got)
+	}
+
+	s, err := guru.NewServer(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := s.Run("callers", posns[1:], output)[0]; err != nil {
+		t.Errorf("server callers %s: %v", posns[1], err)
+	}
+	if !strings.Contains(strings.Join(got, "\n"), "from p.TestG") {
+		t.Errorf("server callers %s: got %q, want a call from p.TestG", posns[1], got)
+	}
+}
+
+// TestProgress checks the sequence of progress events of a query that
+// requires the pointer analysis.
+func TestProgress(t *testing.T) {
+	gopath := makeGOPATH(t, map[string]string{
+		"src/lib/lib.go": "package lib\n\nfunc F() {}\n",
+		"src/app/main.go": `package main
+
+import "lib"
+
+func main() { lib.F() }
+`,
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	var events []string
+	q := guru.Query{
+		Build:  &buildContext,
+		Scope:  []string{"app"},
+		Output: func(*token.FileSet, guru.QueryResult) {},
+		Progress: func(e guru.ProgressEvent) {
+			s := e.Phase
+			switch {
+			case e.Done:
+				s += " done"
+			case e.Package != "":
+				s += fmt.Sprintf(" %d %s", e.Packages, e.Package)
+			case e.Step != "":
+				s += " " + e.Step
+			}
+			events = append(events, s)
+		},
+	}
+	if err := guru.Run("callgraph", &q); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"query",
+		"load",
+		"load 1 lib",
+		"load 2 app",
+		"load done",
+		"create SSA",
+		"create SSA done",
+		"build SSA",
+		"build SSA done",
+		"pointer analysis",
+		"pointer analysis generate",
+		"pointer analysis solve",
+		"pointer analysis done",
+		"query done",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	gopath := makeGOPATH(t, map[string]string{
+		"src/lib/lib.go": "package lib\n\nfunc F() {}\n",
+		"src/app/main.go": `package main
+
+import "lib"
+
+func main() { lib.F() }
+`,
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	// Cancel the query at the start of each phase in turn.
+	for _, event := range []string{"load", "build SSA", "pointer analysis generate", "pointer analysis solve"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var output bool
+		q := guru.Query{
+			Build:   &buildContext,
+			Scope:   []string{"app"},
+			Output:  func(*token.FileSet, guru.QueryResult) { output = true },
+			Context: ctx,
+			Progress: func(e guru.ProgressEvent) {
+				s := e.Phase
+				if e.Step != "" {
+					s += " " + e.Step
+				}
+				if s == event && !e.Done {
+					cancel()
+				}
+			},
+		}
+		if err := guru.Run("callgraph", &q); err != context.Canceled {
+			t.Errorf("canceled at %q: got error %v, want %v", event, err, context.Canceled)
+		}
+		if output {
+			t.Errorf("canceled at %q: got output", event)
+		}
+	}
+
+	// A canceled query leaves a server ready for the next one.
+	s, err := guru.NewServer(&guru.Query{Build: &buildContext, Scope: []string{"app"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output := func(*token.FileSet, guru.QueryResult) {}
+	if err := s.RunContext(ctx, "callgraph", []string{""}, output)[0]; err != context.Canceled {
+		t.Errorf("server: got error %v, want %v", err, context.Canceled)
+	}
+	if err := s.Run("callgraph", []string{""}, output)[0]; err != nil {
+		t.Errorf("server: query after cancellation failed: %v", err)
+	}
+}
+
+// TestTypeErrors checks that queries tolerate type errors that do not
+// concern them, and report them.
+func TestTypeErrors(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	const appSrc = `package main
+
+import "lib"
+
+func main() { lib.F() }
+`
+	const brokenSrc = `package broken
+
+var X int = "one"
+
+func G() int {
+	y := X
+	return y + 1
+}
+`
+	gopath := makeGOPATH(t, map[string]string{
+		"src/app/main.go":       appSrc,
+		"src/lib/lib.go":        "package lib\n\nfunc F() {}\n",
+		"src/broken/broken.go":  brokenSrc,
+		"src/other/main.go":     "package main\n\nimport \"broken\"\n\nfunc main() { broken.G() }\n",
+		"src/other/main_dep.go": "package main\n\nimport _ \"lib\"\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+
+	run := func(mode, filename, src, substr string) (string, []string, error) {
+		var buf bytes.Buffer
+		var errors []string
+		q := guru.Query{
+			Pos: fmt.Sprintf("%s:#%d,#%d", filepath.Join(gopath, "src", filename),
+				strings.Index(src, substr), strings.Index(src, substr)+len(substr)),
+			Build: &buildContext,
+			Scope: []string{"app", "other"},
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+					fmt.Fprintf(&buf, format+"\n", args...)
+				})
+			},
+			TypeErrors: func(err error) {
+				errors = append(errors, err.Error())
+			},
+		}
+		err := guru.Run(mode, &q)
+		return buf.String(), errors, err
+	}
+	checkErrors := func(mode string, errors []string) {
+		if len(errors) != 1 || !strings.Contains(errors[0], `cannot use "one"`) {
+			t.Errorf("%s: type errors = %q, want the error of package broken", mode, errors)
+		}
+	}
+
+	// The query of package lib omits package other, which imports
+	// broken.
+	out, errors, err := run("callers", "lib/lib.go", "package lib\n\nfunc F() {}\n", "func F")
+	if err != nil {
+		t.Errorf("callers: %v", err)
+	} else if want := "lib.F is called from these 1 sites:\n\tstatic function call from app.main\n"; out != want {
+		t.Errorf("callers:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+	checkErrors("callers", errors)
+
+	// The query of package broken fails.
+	_, errors, err = run("callers", "broken/broken.go", brokenSrc, "func G")
+	if err == nil || !strings.Contains(err.Error(), "couldn't load packages due to errors: broken") {
+		t.Errorf("callers in broken: got error %v, want failure due to errors in broken", err)
+	}
+	checkErrors("callers in broken", errors)
+
+	// describe and freevars make what they can of package broken.
+	out, errors, err = run("describe", "broken/broken.go", brokenSrc, "X\n")
+	if err != nil {
+		t.Errorf("describe: %v", err)
+	} else if !strings.HasPrefix(out, "reference to var X int\n") {
+		t.Errorf("describe: got:\n%s\nwant reference to var X int", out)
+	}
+	checkErrors("describe", errors)
+
+	out, errors, err = run("freevars", "broken/broken.go", brokenSrc, "y + 1")
+	if err != nil {
+		t.Errorf("freevars: %v", err)
+	} else if !strings.HasPrefix(out, "Free identifiers:\nvar y int\n") {
+		t.Errorf("freevars: got:\n%s\nwant var y int", out)
+	}
+	checkErrors("freevars", errors)
+}
+
+// TestMaxResults checks the limiting of the references of referrers
+// and the functions of callgraph, and the pages of a server's results.
+func TestMaxResults(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	const libSrc = "package lib\n\nfunc F() {}\n\nfunc G() { F() }\n"
+	gopath := makeGOPATH(t, map[string]string{
+		"src/lib/lib.go":  libSrc,
+		"src/app/main.go": "package main\n\nimport \"lib\"\n\nfunc main() {\n\tlib.F()\n\tlib.F()\n\tlib.G()\n}\n",
+	})
+	defer os.RemoveAll(gopath)
+	var buildContext = build.Default
+	buildContext.GOPATH = gopath
+	pos := fmt.Sprintf("%s:#%d", filepath.Join(gopath, "src/lib/lib.go"), strings.Index(libSrc, "F"))
+
+	run := func(mode string, max int) string {
+		var buf bytes.Buffer
+		q := guru.Query{
+			Pos:        pos,
+			Build:      &buildContext,
+			Scope:      []string{"app"},
+			MaxResults: max,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+					fmt.Fprintf(&buf, format+"\n", args...)
+				})
+			},
+		}
+		if err := guru.Run(mode, &q); err != nil {
+			t.Fatalf("%s -max-results=%d: %v", mode, max, err)
+		}
+		return buf.String()
+	}
+	for _, test := range []struct {
+		mode string
+		max  int
+		want string
+	}{
+		{"referrers", 0, "references to func F()\n\tlib.F()\n\tlib.F()\nfunc G() { F() }\n"},
+		{"referrers", 3, "references to func F()\n\tlib.F()\n\tlib.F()\nfunc G() { F() }\n"},
+		{"referrers", 2, "references to func F()\n\tlib.F()\n\tlib.F()\nand 1 more reference\n"},
+		{"referrers", 1, "references to func F()\n\tlib.F()\nand 2 more references\n"},
+		{"callgraph", 2, "<root>\n\tsynthetic call to app.init\n\tsynthetic call to app.main\napp.init\n\tstatic function call to lib.init\nand 4 more functions\n"},
+	} {
+		if got := run(test.mode, test.max); got != test.want {
+			t.Errorf("%s -max-results=%d:\ngot:\n%s\nwant:\n%s", test.mode, test.max, got, test.want)
+		}
+	}
+
+	// A server's pages of the references together make the whole.
+	s, err := guru.NewServer(&guru.Query{
+		Build:      &buildContext,
+		Scope:      []string{"app"},
+		MaxResults: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+	c, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var texts []string
+	for first, pages := 0, 0; ; first, pages = first+2, pages+1 {
+		if pages > 2 {
+			t.Fatalf("too many pages: %s", texts)
+		}
+		reply, err := c.QueryPage("referrers", first, 2, pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Errors[0] != "" {
+			t.Fatalf("referrers page at %d: %s", first, reply.Errors[0])
+		}
+		var truncated bool
+		for _, res := range reply.Results {
+			var v struct {
+				Refs      []serial.Ref // of a ReferrersPackage
+				Truncated bool         // of a Truncated
+			}
+			if err := encjson.Unmarshal(res, &v); err != nil {
+				t.Fatal(err)
+			}
+			for _, ref := range v.Refs {
+				texts = append(texts, strings.TrimSpace(ref.Text))
+			}
+			truncated = truncated || v.Truncated
+		}
+		if !truncated {
+			break
+		}
+	}
+	if got, want := strings.Join(texts, "; "), "lib.F(); lib.F(); func G() { F() }"; got != want {
+		t.Errorf("pages of referrers: got %s, want %s", got, want)
+	}
+
+	// Without a page, the server's -max-results applies.
+	reply, err := c.Query("referrers", pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reply.Results); n != 3 || !strings.Contains(string(reply.Results[2]), `"more":2`) {
+		t.Errorf("referrers with server -max-results=1: got %d results: %s", n, reply.Results)
+	}
+}
+
+// TestStdScope checks a query of the standard library, whose packages
+// have no main function, over the scope std.
+func TestStdScope(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping in short mode")
+	}
+	const ioSrc = "package io\n\nfunc Copy() {}\n"
+	goroot := makeGOPATH(t, map[string]string{
+		"src/io/io.go":         ioSrc,
+		"src/bufio/bufio.go":   "package bufio\n\nimport \"io\"\n\nfunc Flush() { io.Copy() }\n",
+		"src/cmd/tool/main.go": "package main\n\nimport \"io\"\n\nfunc main() { io.Copy() }\n",
+	})
+	defer os.RemoveAll(goroot)
+	var buildContext = build.Default
+	buildContext.GOROOT = goroot
+	buildContext.GOPATH = ""
+
+	var buf bytes.Buffer
+	q := guru.Query{
+		Pos:   fmt.Sprintf("%s:#%d", filepath.Join(goroot, "src/io/io.go"), strings.Index(ioSrc, "Copy")),
+		Build: &buildContext,
+		Scope: []string{"std"},
+		Output: func(fset *token.FileSet, qr guru.QueryResult) {
+			qr.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+				fmt.Fprintf(&buf, format+"\n", args...)
+			})
+		},
+	}
+	if err := guru.Run("callers", &q); err != nil {
+		t.Fatal(err)
+	}
+	// The call from cmd/tool is not in the scope.
+	want := "io.Copy is called from these 1 sites:\n\tstatic function call from bufio.Flush\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestPhysicalPositions checks that the positions of a query's results
+// are those mapped by //line comments unless the query has
+// PhysicalPositions, independently of other queries.
+func TestPhysicalPositions(t *testing.T) {
+	const src = "package p\n\n//line gen.y:10:1\nvar x = 1\n\nvar _ = x\n"
+	gopath := makeGOPATH(t, map[string]string{"src/p/p.go": src})
+	defer os.RemoveAll(gopath)
+	buildContext := build.Default
+	buildContext.GOPATH = gopath
+	filename := filepath.Join(gopath, "src/p/p.go")
+
+	for _, test := range []struct {
+		physical bool
+		want     string
+	}{
+		{false, `"objpos": "` + filepath.Join(gopath, "src/p/gen.y") + `:10:5"`},
+		{true, `"objpos": "` + filename + `:4:5"`},
+		{false, `"objpos": "` + filepath.Join(gopath, "src/p/gen.y") + `:10:5"`},
+	} {
+		var out []string
+		q := &guru.Query{
+			Pos:               fmt.Sprintf("%s:#%d", filename, strings.LastIndex(src, "x")),
+			Build:             &buildContext,
+			PhysicalPositions: test.physical,
+			Output: func(fset *token.FileSet, qr guru.QueryResult) {
+				out = append(out, string(qr.JSON(fset)))
+			},
+		}
+		if err := guru.Run("definition", q); err != nil {
+			t.Errorf("physical=%t: %v", test.physical, err)
+			continue
+		}
+		if got := strings.Join(out, "\n"); !strings.Contains(got, test.want) {
+			t.Errorf("physical=%t: got %s, want %s", test.physical, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// The hierarchy function displays the type hierarchy of the selected
+// named type or interface: above it, the interfaces it satisfies and
+// the types it embeds, and below it, the types that satisfy or embed
+// it, each as a tree of immediate relations.
+//
+// Like implements, it considers only named types, and ignores empty
+// interfaces, which all types satisfy.
+func hierarchy(q *Query) error {
+	lprog, qpos, err := loadTypeRelations(q, false)
+	if err != nil {
+		return err
+	}
+
+	// Find the selected type.
+	path, action := findInterestingNode(qpos.info, qpos.path)
+	var T types.Type
+	switch action {
+	case actionExpr, actionType:
+		T = qpos.info.TypeOf(path[0].(ast.Expr))
+	}
+	if T == nil {
+		return fmt.Errorf("not a type or value")
+	}
+	T = deref(T)
+	if _, ok := T.(*types.Named); !ok && !isInterface(T) {
+		return fmt.Errorf("%s is not a named type or an interface", qpos.typeString(T))
+	}
+
+	var pos interface{} = qpos
+	if nt, ok := T.(*types.Named); ok {
+		pos = nt.Obj()
+	}
+	r := &hierarchyResult{qpos: qpos, t: T, pos: pos}
+
+	h := &hierarchyBuilder{below: make(map[[2]types.Type]bool)}
+	if !h.isEmpty(T) {
+		// Find the other types whose relation to T
+		// is that of a supertype, or of a subtype.
+		var candidates []types.Type
+		for _, U := range allNamedTypes(lprog) {
+			if !types.Identical(U, T) && !h.isEmpty(U) {
+				candidates = append(candidates, U)
+			}
+		}
+		sort.Sort(byType{lprog.Fset, candidates})
+		up := h.supertypes(T, candidates)
+		down := h.subtypes(T, candidates)
+
+		// Interfaces with the same methods as T are
+		// both above and below it; report them apart.
+		var strict []types.Type
+		for _, V := range up {
+			if h.isBelow(V, T) {
+				r.same = append(r.same, V)
+			} else {
+				strict = append(strict, V)
+			}
+		}
+		up = strict
+		strict = nil
+		for _, U := range down {
+			if !h.isBelow(T, U) {
+				strict = append(strict, U)
+			}
+		}
+		down = strict
+
+		r.supers = h.tree(T, up, true)
+		r.subs = h.tree(T, down, false)
+	}
+
+	q.Output(lprog.Fset, r)
+	return nil
+}
+
+// A hierarchyBuilder computes the relation of subtype to supertype
+// among named types: a type is below an interface that it, or a
+// pointer to it, satisfies, and below a type it embeds.
+type hierarchyBuilder struct {
+	msets typeutil.MethodSetCache
+	below map[[2]types.Type]bool // memo of isBelow
+}
+
+// isEmpty reports whether T is an empty interface.
+func (h *hierarchyBuilder) isEmpty(T types.Type) bool {
+	return isInterface(T) && h.msets.MethodSet(T).Len() == 0
+}
+
+// isBelow reports whether U is below V: whether V is an interface
+// satisfied by U or *U, or U is a struct that embeds V.  Satisfaction
+// is transitive, but embedding is not; the search of the tree finds
+// the types that embed V indirectly.
+func (h *hierarchyBuilder) isBelow(U, V types.Type) bool {
+	key := [2]types.Type{U, V}
+	below, ok := h.below[key]
+	if !ok {
+		if isInterface(V) {
+			below = types.AssignableTo(U, V) || h.viaPointer(U, V)
+		} else {
+			below = embeds(U, V)
+		}
+		h.below[key] = below
+	}
+	return below
+}
+
+// viaPointer reports whether interface V is satisfied by *U but not U.
+func (h *hierarchyBuilder) viaPointer(U, V types.Type) bool {
+	return !isInterface(U) && !types.AssignableTo(U, V) && types.AssignableTo(types.NewPointer(U), V)
+}
+
+// embeds reports whether U is a struct with an embedded field of type
+// V or *V.
+func embeds(U, V types.Type) bool {
+	if st, ok := U.Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			if f := st.Field(i); f.Anonymous() && types.Identical(deref(f.Type()), V) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// supertypes returns the candidates above T, transitively.
+func (h *hierarchyBuilder) supertypes(T types.Type, candidates []types.Type) []types.Type {
+	var up []types.Type
+	seen := map[types.Type]bool{T: true}
+	for queue := []types.Type{T}; len(queue) > 0; queue = queue[1:] {
+		for _, V := range candidates {
+			if !seen[V] && h.isBelow(queue[0], V) {
+				seen[V] = true
+				up = append(up, V)
+				queue = append(queue, V)
+			}
+		}
+	}
+	return up
+}
+
+// subtypes returns the candidates below T, transitively.
+func (h *hierarchyBuilder) subtypes(T types.Type, candidates []types.Type) []types.Type {
+	var down []types.Type
+	if isInterface(T) {
+		// Satisfaction is transitive, so this is a single pass:
+		// a type below one of T's subtypes is below T too.
+		for _, U := range candidates {
+			if h.isBelow(U, T) {
+				down = append(down, U)
+			}
+		}
+		return down
+	}
+	// Only structs are below a concrete type, by embedding it.
+	seen := map[types.Type]bool{T: true}
+	for queue := []types.Type{T}; len(queue) > 0; queue = queue[1:] {
+		for _, U := range candidates {
+			if !seen[U] && embeds(U, queue[0]) {
+				seen[U] = true
+				down = append(down, U)
+				queue = append(queue, U)
+			}
+		}
+	}
+	return down
+}
+
+// A hierarchyNode is a node of a tree of supertypes or subtypes.
+type hierarchyNode struct {
+	t        types.Type // a named type
+	ptr      bool       // the relation to the parent holds only of a pointer to the subtype
+	shown    bool       // the node is expanded elsewhere in the tree
+	children []*hierarchyNode
+}
+
+// tree returns the tree of the types rel, which are all strictly above
+// T if up, or strictly below it otherwise, rooted at T, in which the
+// children of each type are its immediate relatives.  A type related
+// to T through several others is expanded only once.
+func (h *hierarchyBuilder) tree(T types.Type, rel []types.Type, up bool) []*hierarchyNode {
+	// sub and super order the pair of types (x, y) by the direction of the tree.
+	sub, super := func(x, y types.Type) types.Type { return x }, func(x, y types.Type) types.Type { return y }
+	if !up {
+		sub, super = super, sub
+	}
+	// related reports whether y is strictly beyond x in that direction.
+	related := func(x, y types.Type) bool {
+		return h.isBelow(sub(x, y), super(x, y)) && !h.isBelow(super(x, y), sub(x, y))
+	}
+
+	seen := map[types.Type]bool{T: true}
+	var expand func(x types.Type) []*hierarchyNode
+	expand = func(x types.Type) []*hierarchyNode {
+		var near []types.Type // the relatives of x
+		for _, y := range rel {
+			if y != x && related(x, y) {
+				near = append(near, y)
+			}
+		}
+		var children []*hierarchyNode
+	nextRelative:
+		for _, y := range near {
+			// Skip y if it is related to x through another,
+			// nearer relative w.
+			for _, w := range near {
+				if w != y && related(w, y) {
+					continue nextRelative
+				}
+			}
+			n := &hierarchyNode{t: y}
+			if isInterface(super(x, y)) {
+				n.ptr = h.viaPointer(sub(x, y), super(x, y))
+			}
+			children = append(children, n)
+		}
+		for _, n := range children {
+			if seen[n.t] {
+				n.shown = true
+			} else {
+				seen[n.t] = true
+				n.children = expand(n.t)
+			}
+		}
+		return children
+	}
+	return expand(T)
+}
+
+type hierarchyResult struct {
+	resultSource
+	qpos   *queryPos
+	t      types.Type   // queried type: named, or an interface
+	pos    interface{}  // pos of t (*types.TypeName or *queryPos)
+	same   []types.Type // interfaces with the same methods as t
+	supers []*hierarchyNode
+	subs   []*hierarchyNode
+}
+
+func (r *hierarchyResult) PrintPlain(printf printfFunc) {
+	if isInterface(r.t) && types.NewMethodSet(r.t).Len() == 0 {
+		printf(r.pos, "empty interface type %s", r.qpos.typeString(r.t))
+		return
+	}
+	if r.same == nil && r.supers == nil && r.subs == nil {
+		printf(r.pos, "%s type %s has no supertypes or subtypes",
+			typeKind(r.t), r.qpos.typeString(r.t))
+		return
+	}
+	printf(r.pos, "%s type %s", typeKind(r.t), r.qpos.typeString(r.t))
+	for _, V := range r.same {
+		printf(V.(*types.Named).Obj(), "\tis equivalent to %s", r.qpos.typeString(V))
+	}
+
+	var visit func(parent types.Type, nodes []*hierarchyNode, depth int, up bool)
+	visit = func(parent types.Type, nodes []*hierarchyNode, depth int, up bool) {
+		indent := strings.Repeat("\t", depth)
+		for _, n := range nodes {
+			pos := n.t.(*types.Named).Obj()
+			var note string
+			if n.shown {
+				note = " (shown above)"
+			}
+			switch {
+			case up && n.ptr:
+				printf(pos, "%simplements %s (as *%s)%s", indent,
+					r.qpos.typeString(n.t), r.qpos.typeString(parent), note)
+			case up && isInterface(n.t):
+				printf(pos, "%simplements %s%s", indent, r.qpos.typeString(n.t), note)
+			case up:
+				printf(pos, "%sembeds %s type %s%s", indent,
+					typeKind(n.t), r.qpos.typeString(n.t), note)
+			case isInterface(parent):
+				t := r.subtype(n)
+				printf(pos, "%sis implemented by %s type %s%s", indent,
+					typeKind(t), r.qpos.typeString(t), note)
+			default:
+				printf(pos, "%sis embedded in %s type %s%s", indent,
+					typeKind(n.t), r.qpos.typeString(n.t), note)
+			}
+			visit(n.t, n.children, depth+1, up)
+		}
+	}
+	visit(r.t, r.supers, 1, true)
+	visit(r.t, r.subs, 1, false)
+}
+
+// subtype returns the type of the subtree n that is below its parent:
+// a pointer to it if only that satisfies the parent.
+func (r *hierarchyResult) subtype(n *hierarchyNode) types.Type {
+	if n.ptr {
+		return types.NewPointer(n.t)
+	}
+	return n.t
+}
+
+func (r *hierarchyResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *hierarchyResult) serialize(s *serializer) interface{} {
+	var convert func(parent types.Type, nodes []*hierarchyNode, up bool) []serial.HierarchyType
+	convert = func(parent types.Type, nodes []*hierarchyNode, up bool) []serial.HierarchyType {
+		var res []serial.HierarchyType
+		for _, n := range nodes {
+			var relation string
+			switch {
+			case up && isInterface(n.t):
+				relation = "implements"
+			case up:
+				relation = "embeds"
+			case isInterface(parent):
+				relation = "implemented-by"
+			default:
+				relation = "embedded-in"
+			}
+			t := makeImplementsType(n.t, s)
+			res = append(res, serial.HierarchyType{
+				Name:     t.Name,
+				Pos:      t.Pos,
+				Kind:     t.Kind,
+				Relation: relation,
+				Pointer:  n.ptr,
+				Shown:    n.shown,
+				Types:    convert(n.t, n.children, up),
+			})
+		}
+		return res
+	}
+	return &serial.TypeHierarchy{
+		Version:    serial.Version,
+		T:          makeImplementsType(r.t, s),
+		Equivalent: makeImplementsTypes(r.same, s),
+		Supertypes: convert(r.t, r.supers, true),
+		Subtypes:   convert(r.t, r.subs, false),
+		Offsets:    s.offsets(),
+	}
+}
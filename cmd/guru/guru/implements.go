@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"fmt"
@@ -26,45 +26,7 @@ import (
 // by an implements query on the receiver type.
 //
 func implements(q *Query) error {
-	lconf := loader.Config{Build: q.Build}
-	allowErrors(&lconf)
-
-	qpkg, err := importQueryPackage(q.Pos, &lconf)
-	if err != nil {
-		return err
-	}
-
-	// Set the packages to search.
-	if len(q.Scope) > 0 {
-		// Inspect all packages in the analysis scope, if specified.
-		if err := setPTAScope(&lconf, q.Scope); err != nil {
-			return err
-		}
-	} else {
-		// Otherwise inspect the forward and reverse
-		// transitive closure of the selected package.
-		// (In theory even this is incomplete.)
-		_, rev, _ := importgraph.Build(q.Build)
-		for path := range rev.Search(qpkg) {
-			lconf.ImportWithTests(path)
-		}
-
-		// TODO(adonovan): for completeness, we should also
-		// type-check and inspect function bodies in all
-		// imported packages.  This would be expensive, but we
-		// could optimize by skipping functions that do not
-		// contain type declarations.  This would require
-		// changing the loader's TypeCheckFuncBodies hook to
-		// provide the []*ast.File.
-	}
-
-	// Load/parse/type-check the program.
-	lprog, err := lconf.Load()
-	if err != nil {
-		return err
-	}
-
-	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	lprog, qpos, err := loadTypeRelations(q, false)
 	if err != nil {
 		return err
 	}
@@ -101,21 +63,7 @@ func implements(q *Query) error {
 		return fmt.Errorf("not a type, method, or value")
 	}
 
-	// Find all named types, even local types (which can have
-	// methods due to promotion) and the built-in "error".
-	// We ignore aliases 'type M = N' to avoid duplicate
-	// reporting of the Named type N.
-	var allNamed []*types.Named
-	for _, info := range lprog.AllPackages {
-		for _, obj := range info.Defs {
-			if obj, ok := obj.(*types.TypeName); ok && !isAlias(obj) {
-				if named, ok := obj.Type().(*types.Named); ok {
-					allNamed = append(allNamed, named)
-				}
-			}
-		}
-	}
-	allNamed = append(allNamed, types.Universe.Lookup("error").Type().(*types.Named))
+	allNamed := allNamedTypes(lprog)
 
 	var msets typeutil.MethodSetCache
 
@@ -167,10 +115,10 @@ func implements(q *Query) error {
 		pos = nt.Obj()
 	}
 
-	// Sort types (arbitrarily) to ensure test determinism.
-	sort.Sort(typesByString(to))
-	sort.Sort(typesByString(from))
-	sort.Sort(typesByString(fromPtr))
+	// Sort types (arbitrarily) to ensure determinism.
+	sort.Sort(byType{lprog.Fset, to})
+	sort.Sort(byType{lprog.Fset, from})
+	sort.Sort(byType{lprog.Fset, fromPtr})
 
 	var toMethod, fromMethod, fromPtrMethod []*types.Selection // contain nils
 	if method != nil {
@@ -189,12 +137,107 @@ func implements(q *Query) error {
 	}
 
 	q.Output(lprog.Fset, &implementsResult{
-		qpos, T, pos, to, from, fromPtr, method, toMethod, fromMethod, fromPtrMethod,
+		qpos: qpos, t: T, pos: pos, to: to, from: from, fromPtr: fromPtr,
+		method: method, toMethod: toMethod, fromMethod: fromMethod, fromPtrMethod: fromPtrMethod,
 	})
 	return nil
 }
 
+// loadTypeRelations loads the program for a query, such as implements,
+// of the relations between the selected type and the other named types
+// of the analysis scope, or if there is none, of the packages that
+// depend on the selected one.  If bodies, the function bodies of all
+// packages are type-checked, not only those of the selected one.
+func loadTypeRelations(q *Query, bodies bool) (*loader.Program, *queryPos, error) {
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
+	allowErrors(&lconf)
+	cancelHook(q, &lconf)
+
+	qpkg, err := importQueryPackage(q.Pos, &lconf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bodies {
+		lconf.TypeCheckFuncBodies = nil
+	}
+
+	// Set the packages to search.
+	if len(q.Scope) > 0 {
+		// Inspect all packages in the analysis scope, if specified.
+		if err := setPTAScope(&lconf, q.Scope, !q.NoTests); err != nil {
+			return nil, nil, err
+		}
+	} else if q.program == nil {
+		// Otherwise inspect the forward and reverse
+		// transitive closure of the selected package.
+		// (In theory even this is incomplete.)
+		_, rev, _ := importgraph.Build(q.Build)
+		for path := range rev.Search(qpkg) {
+			importPackage(&lconf, path, !q.NoTests)
+		}
+
+		// TODO(adonovan): for completeness, we should also
+		// type-check and inspect function bodies in all
+		// imported packages.  This would be expensive, but we
+		// could optimize by skipping functions that do not
+		// contain type declarations.  This would require
+		// changing the loader's TypeCheckFuncBodies hook to
+		// provide the []*ast.File.
+	}
+
+	// Load/parse/type-check the program.
+	cache := newPkgCache(q, &lconf)
+	progressHook(q, &lconf, "load")
+	end := beginPhase(q, "load")
+	lprog, err := load(q, &lconf)
+	end()
+	if err := canceled(q); err != nil {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, withCode(codeLoad, err)
+	}
+	cache.save(lprog)
+
+	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lprog, qpos, nil
+}
+
+// allNamedTypes returns all the named types of the program, even local
+// types (which can have methods due to promotion) and the built-in
+// "error".  It ignores aliases 'type M = N' to avoid duplicate
+// reporting of the Named type N.
+func allNamedTypes(lprog *loader.Program) []*types.Named {
+	var allNamed []*types.Named
+	addNamed := func(obj types.Object) {
+		if obj, ok := obj.(*types.TypeName); ok && !isAlias(obj) {
+			if named, ok := obj.Type().(*types.Named); ok {
+				allNamed = append(allNamed, named)
+			}
+		}
+	}
+	for _, info := range lprog.AllPackages {
+		if info.Files == nil {
+			// A package read from the -cache has no syntax;
+			// only its package-level types are known.
+			scope := info.Pkg.Scope()
+			for _, name := range scope.Names() {
+				addNamed(scope.Lookup(name))
+			}
+			continue
+		}
+		for _, obj := range info.Defs {
+			addNamed(obj)
+		}
+	}
+	return append(allNamed, types.Universe.Lookup("error").Type().(*types.Named))
+}
+
 type implementsResult struct {
+	resultSource
 	qpos *queryPos
 
 	t       types.Type   // queried type (not necessarily named)
@@ -266,84 +309,84 @@ func (r *implementsResult) PrintPlain(printf printfFunc) {
 	} else {
 		relation = "implements"
 
-		if r.from != nil {
-			if r.method == nil {
-				printf(r.pos, "%s type %s",
-					typeKind(r.t), r.qpos.typeString(r.t))
-			} else {
+		if r.method != nil {
+			// A method is reported once, whether the interfaces
+			// it implements are satisfied by T or only by *T.
+			if r.from != nil || r.fromPtr != nil {
 				printf(r.method, "concrete method %s",
 					r.qpos.objectString(r.method))
 			}
-			for i, super := range r.from {
-				if r.method == nil {
-					printf(super.(*types.Named).Obj(), "\t%s %s",
-						relation, r.qpos.typeString(super))
-				} else {
-					meth(r.fromMethod[i])
-				}
+			for i := range r.from {
+				meth(r.fromMethod[i])
 			}
-		}
-		if r.fromPtr != nil {
-			if r.method == nil {
-				printf(r.pos, "pointer type *%s", r.qpos.typeString(r.t))
-			} else {
-				// TODO(adonovan): de-dup (C).f and (*C).f implementing (I).f.
-				printf(r.method, "concrete method %s",
-					r.qpos.objectString(r.method))
+			for i := range r.fromPtr {
+				meth(r.fromPtrMethod[i])
 			}
+		}
 
-			for i, psuper := range r.fromPtr {
-				if r.method == nil {
-					printf(psuper.(*types.Named).Obj(), "\t%s %s",
-						relation, r.qpos.typeString(psuper))
-				} else {
-					meth(r.fromPtrMethod[i])
-				}
+		if r.method == nil && r.from != nil {
+			printf(r.pos, "%s type %s",
+				typeKind(r.t), r.qpos.typeString(r.t))
+			for _, super := range r.from {
+				printf(super.(*types.Named).Obj(), "\t%s %s",
+					relation, r.qpos.typeString(super))
 			}
-		} else if r.from == nil {
+		}
+		if r.method == nil && r.fromPtr != nil {
+			printf(r.pos, "pointer type *%s", r.qpos.typeString(r.t))
+			for _, psuper := range r.fromPtr {
+				printf(psuper.(*types.Named).Obj(), "\t%s %s",
+					relation, r.qpos.typeString(psuper))
+			}
+		}
+		if r.from == nil && r.fromPtr == nil {
 			printf(r.pos, "%s type %s implements only interface{}",
 				typeKind(r.t), r.qpos.typeString(r.t))
 		}
 	}
 }
 
-func (r *implementsResult) JSON(fset *token.FileSet) []byte {
+func (r *implementsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *implementsResult) serialize(s *serializer) interface{} {
 	var method *serial.DescribeMethod
 	if r.method != nil {
 		method = &serial.DescribeMethod{
 			Name: r.qpos.objectString(r.method),
-			Pos:  fset.Position(r.method.Pos()).String(),
+			Pos:  s.pos(r.method.Pos()),
 		}
 	}
-	return toJSON(&serial.Implements{
-		T:                       makeImplementsType(r.t, fset),
-		AssignableTo:            makeImplementsTypes(r.to, fset),
-		AssignableFrom:          makeImplementsTypes(r.from, fset),
-		AssignableFromPtr:       makeImplementsTypes(r.fromPtr, fset),
-		AssignableToMethod:      methodsToSerial(r.qpos.info.Pkg, r.toMethod, fset),
-		AssignableFromMethod:    methodsToSerial(r.qpos.info.Pkg, r.fromMethod, fset),
-		AssignableFromPtrMethod: methodsToSerial(r.qpos.info.Pkg, r.fromPtrMethod, fset),
+	return &serial.Implements{
+		Version:                 serial.Version,
+		T:                       makeImplementsType(r.t, s),
+		AssignableTo:            makeImplementsTypes(r.to, s),
+		AssignableFrom:          makeImplementsTypes(r.from, s),
+		AssignableFromPtr:       makeImplementsTypes(r.fromPtr, s),
+		AssignableToMethod:      methodsToSerial(r.qpos.info.Pkg, r.toMethod, s),
+		AssignableFromMethod:    methodsToSerial(r.qpos.info.Pkg, r.fromMethod, s),
+		AssignableFromPtrMethod: methodsToSerial(r.qpos.info.Pkg, r.fromPtrMethod, s),
 		Method:                  method,
-	})
+		Offsets:                 s.offsets(),
+	}
 
 }
 
-func makeImplementsTypes(tt []types.Type, fset *token.FileSet) []serial.ImplementsType {
+func makeImplementsTypes(tt []types.Type, s *serializer) []serial.ImplementsType {
 	var r []serial.ImplementsType
 	for _, t := range tt {
-		r = append(r, makeImplementsType(t, fset))
+		r = append(r, makeImplementsType(t, s))
 	}
 	return r
 }
 
-func makeImplementsType(T types.Type, fset *token.FileSet) serial.ImplementsType {
+func makeImplementsType(T types.Type, s *serializer) serial.ImplementsType {
 	var pos token.Pos
 	if nt, ok := deref(T).(*types.Named); ok { // implementsResult.t may be non-named
 		pos = nt.Obj().Pos()
 	}
 	return serial.ImplementsType{
 		Name: T.String(),
-		Pos:  fset.Position(pos).String(),
+		Pos:  s.pos(pos),
 		Kind: typeKind(T),
 	}
 }
@@ -357,8 +400,26 @@ func typeKind(T types.Type) string {
 
 func isInterface(T types.Type) bool { return types.IsInterface(T) }
 
-type typesByString []types.Type
+type byType struct {
+	fset  *token.FileSet
+	types []types.Type
+}
+
+func (p byType) Len() int           { return len(p.types) }
+func (p byType) Less(i, j int) bool { return lessType(p.fset, p.types[i], p.types[j]) }
+func (p byType) Swap(i, j int)      { p.types[i], p.types[j] = p.types[j], p.types[i] }
 
-func (p typesByString) Len() int           { return len(p) }
-func (p typesByString) Less(i, j int) bool { return p[i].String() < p[j].String() }
-func (p typesByString) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+// lessType orders types by their string, then, for distinct named
+// types of the same string, such as local types of the same name in
+// different functions, by the position of their declarations.
+func lessType(fset *token.FileSet, x, y types.Type) bool {
+	if xs, ys := x.String(), y.String(); xs != ys {
+		return xs < ys
+	}
+	xn, _ := deref(x).(*types.Named)
+	yn, _ := deref(y).(*types.Named)
+	if xn != nil && yn != nil {
+		return lessPos(fset, xn.Obj().Pos(), yn.Obj().Pos())
+	}
+	return false
+}
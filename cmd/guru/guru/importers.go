@@ -0,0 +1,156 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/loader"
+)
+
+// importers reports the packages of the analysis scope that import the
+// selected package, directly or indirectly, and for each of them, the
+// import declarations by which it does so: those of the package itself,
+// or of the packages through which it depends on it.
+//
+// The package may be selected by its package clause, by an import
+// declaration of it, or by a reference to it, such as the fmt of
+// fmt.Println.
+func importers(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+	path, action := findInterestingNode(qpos.info, qpos.path)
+	if action != actionPackage {
+		return nil, fmt.Errorf("no package is selected; try an import path or a package name")
+	}
+	pkg, _, err := selectedPackage(qpos, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		q.Output(a.lprog.Fset, &importersResult{
+			qpos:      qpos,
+			pkg:       pkg,
+			importers: findImporters(a.lprog, pkg),
+		})
+		return nil
+	}, nil
+}
+
+// An importer is an import declaration, in a package of the scope, of
+// the queried package or of one that depends on it.
+type importer struct {
+	pkg      *types.Package // the importing package
+	spec     *ast.ImportSpec
+	imported *types.Package // the package imported by spec
+}
+
+// findImporters returns the import declarations, in the initial
+// packages of lprog, by which they depend on pkg, in order of the path
+// of the importing package, then of position (see lessPos).
+func findImporters(lprog *loader.Program, pkg *types.Package) []importer {
+	// reaches reports whether p depends on pkg.
+	memo := make(map[*types.Package]bool)
+	var reaches func(p *types.Package) bool
+	reaches = func(p *types.Package) bool {
+		r, ok := memo[p]
+		if !ok {
+			memo[p] = false // an import cycle is an error anyway
+			for _, imp := range p.Imports() {
+				if imp == pkg || reaches(imp) {
+					r = true
+					break
+				}
+			}
+			memo[p] = r
+		}
+		return r
+	}
+
+	var importers []importer
+	for _, info := range lprog.InitialPackages() {
+		if info.Pkg == pkg || !reaches(info.Pkg) {
+			continue
+		}
+		for _, f := range info.Files {
+			for _, spec := range f.Imports {
+				var obj types.Object
+				if spec.Name != nil {
+					obj = info.Defs[spec.Name]
+				} else {
+					obj = info.Implicits[spec]
+				}
+				pkgname, _ := obj.(*types.PkgName)
+				if pkgname == nil {
+					continue // e.g. "C", or an error
+				}
+				if imp := pkgname.Imported(); imp == pkg || reaches(imp) {
+					importers = append(importers, importer{info.Pkg, spec, imp})
+				}
+			}
+		}
+	}
+	sort.SliceStable(importers, func(i, j int) bool {
+		x, y := importers[i], importers[j]
+		if x.pkg.Path() != y.pkg.Path() {
+			return x.pkg.Path() < y.pkg.Path()
+		}
+		return lessPos(lprog.Fset, x.spec.Pos(), y.spec.Pos())
+	})
+	return importers
+}
+
+type importersResult struct {
+	resultSource
+	qpos      *queryPos
+	pkg       *types.Package // the queried package
+	importers []importer
+}
+
+func (r *importersResult) PrintPlain(printf printfFunc) {
+	n := 0
+	for i, imp := range r.importers {
+		if i == 0 || imp.pkg != r.importers[i-1].pkg {
+			n++
+		}
+	}
+	if n == 0 {
+		printf(r.qpos, "package %s is imported by no package in scope", r.pkg.Path())
+		return
+	}
+	printf(r.qpos, "package %s is imported by these %d packages:", r.pkg.Path(), n)
+	for _, imp := range r.importers {
+		if imp.imported == r.pkg {
+			printf(imp.spec, "\t%s imports it directly", imp.pkg.Path())
+		} else {
+			printf(imp.spec, "\t%s imports it through %s", imp.pkg.Path(), imp.imported.Path())
+		}
+	}
+}
+
+func (r *importersResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *importersResult) serialize(s *serializer) interface{} {
+	importers := make([]serial.Importer, len(r.importers))
+	for i, imp := range r.importers {
+		importers[i] = serial.Importer{
+			Version: serial.Version,
+			Pos:     s.pos(imp.spec.Pos()),
+			Package: imp.pkg.Path(),
+			Import:  imp.imported.Path(),
+			Direct:  imp.imported == r.pkg,
+			Offsets: s.offsets(),
+		}
+	}
+	return importers
+}
@@ -4,7 +4,7 @@
 
 // +build !go1.9
 
-package main
+package guru
 
 import "go/types"
 
@@ -0,0 +1,114 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the limiting of the items of a query's results,
+// such as the references of referrers, to Query.MaxResults of them,
+// and the pages of them that a guru server returns on request.
+
+import (
+	"fmt"
+	"go/token"
+	"sync"
+
+	"golang.org/x/tools/cmd/guru/serial"
+)
+
+// A limitedResult is a QueryResult whose items may be limited in
+// number.  The items of a mode's results are counted together, across
+// the results of a query, in the unit of the mode (see queryMode.item).
+type limitedResult interface {
+	QueryResult
+
+	// len returns the number of items of the result.
+	len() int
+
+	// slice returns a copy of the result that retains only its
+	// items [i:j].
+	slice(i, j int) QueryResult
+}
+
+// limitResults returns q, or, if its results are to be limited, a copy
+// of q whose Output retains only the items from index q.firstResult,
+// up to q.MaxResults of them (if positive), and a function that, once
+// the query is complete, outputs a truncatedResult if any were
+// omitted after them.
+func limitResults(q *Query, mode *queryMode) (*Query, func()) {
+	if mode.item == "" || q.MaxResults <= 0 && q.firstResult <= 0 {
+		return q, func() {}
+	}
+	l := &limiter{output: q.Output, first: q.firstResult, max: q.MaxResults}
+	q2 := *q
+	q2.Output = l.Output
+	return &q2, func() {
+		if l.max > 0 && l.seen > l.first+l.max {
+			q.Output(l.fset, &truncatedResult{more: l.seen - (l.first + l.max), item: mode.item})
+		}
+	}
+}
+
+// A limiter is the Output of a query that outputs only the items of
+// its limitedResults in [first, first+max), or [first:] if max is not
+// positive; it passes other results unchanged.
+type limiter struct {
+	output     func(*token.FileSet, QueryResult)
+	first, max int
+
+	mu   sync.Mutex
+	seen int            // the number of items of the results so far
+	fset *token.FileSet // that of the last limitedResult
+}
+
+func (l *limiter) Output(fset *token.FileSet, qr QueryResult) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := qr.(limitedResult)
+	if !ok || r.len() == 0 {
+		l.output(fset, qr)
+		return
+	}
+	n := r.len()
+	i, j := l.first-l.seen, n
+	if l.max > 0 && l.first+l.max-l.seen < j {
+		j = l.first + l.max - l.seen
+	}
+	if i < 0 {
+		i = 0
+	}
+	l.seen += n
+	l.fset = fset
+	if i >= j {
+		return // the result lies wholly outside the limits
+	}
+	if i > 0 || j < n {
+		qr = r.slice(i, j)
+	}
+	l.output(fset, qr)
+}
+
+// A truncatedResult is the final result of a query whose results were
+// limited by Query.MaxResults, and so lack the more items that follow.
+type truncatedResult struct {
+	more int    // the number of items omitted
+	item string // the unit of the items, e.g. "reference"
+}
+
+func (r *truncatedResult) PrintPlain(printf printfFunc) {
+	printf(nil, "%s", r.text())
+}
+
+func (r *truncatedResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *truncatedResult) serialize(s *serializer) interface{} {
+	return &serial.Truncated{Version: serial.Version, Truncated: true, More: r.more}
+}
+
+// text describes the omitted items, e.g. "and 3 more references".
+func (r *truncatedResult) text() string {
+	if r.more == 1 {
+		return "and 1 more " + r.item
+	}
+	return fmt.Sprintf("and %d more %ss", r.more, r.item)
+}
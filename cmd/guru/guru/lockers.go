@@ -0,0 +1,293 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// lockers enumerates, for a given sync.Mutex or sync.RWMutex, or a
+// call of one of its Lock, Unlock, RLock or RUnlock methods, the calls
+// of those methods that may operate on the same mutex, and the
+// functions that acquire it.
+//
+// Only static calls are reported; calls through the sync.Locker
+// interface are not.
+func lockers(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	// The query is either a call of a mutex method,
+	// or an expression denoting a mutex or a pointer to one.
+	callPos := findLockOp(qpos)
+	var expr ast.Expr
+	if callPos == token.NoPos {
+		if e, ok := qpos.path[0].(ast.Expr); ok && isMutex(deref(qpos.info.TypeOf(e))) {
+			expr = e
+		} else {
+			return nil, fmt.Errorf("there is no mutex or mutex operation here")
+		}
+	}
+
+	var value ssa.Value // the queried mutex, or its address
+	var isAddr bool
+	if expr != nil {
+		path, _ := findInterestingNode(qpos.info, qpos.path)
+		if id, ok := expr.(*ast.Ident); ok {
+			value, isAddr, err = ssaValueForIdent(a.prog, qpos.info, qpos.info.ObjectOf(id), path)
+		} else {
+			value, isAddr, err = ssaValueForExpr(a.prog, qpos.info, path)
+		}
+		if err != nil {
+			return nil, err // e.g. trivially dead code
+		}
+		if _, ok := value.Type().Underlying().(*types.Pointer); !ok {
+			return nil, errorf(codeAnalysis, "can't locate the address of this mutex")
+		}
+		// value must denote a pointer to the mutex.
+		// If it is the address of a variable of type *sync.Mutex,
+		// we need the variable's contents, not the address itself.
+		isAddr = isAddr && isPointer(deref(value.Type()))
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	// Look at all mutex operations in the whole ssa.Program.
+	var ops []lockOp
+	for fn := range ssautil.AllFunctions(a.prog) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if op, ok := lockOpOf(instr); ok {
+					ops = append(ops, op)
+					if op.pos == callPos {
+						value = op.mu // we found the query op
+					}
+				}
+			}
+		}
+	}
+	if value == nil {
+		return nil, errorf(codeAnalysis, "ssa.Instruction for mutex operation not found")
+	}
+	queryType := deref(value.Type())
+	if isAddr {
+		queryType = deref(queryType)
+		a.ptaConfig.AddIndirectQuery(value)
+	} else {
+		a.ptaConfig.AddQuery(value)
+	}
+
+	// Discard operations on mutexes of the other type.
+	i := 0
+	for _, op := range ops {
+		if types.Identical(deref(op.mu.Type()), queryType) {
+			a.ptaConfig.AddQuery(op.mu)
+			ops[i] = op
+			i++
+		}
+	}
+	ops = ops[:i]
+
+	// The pointer analysis runs once all queries are prepared.
+	a.needPTA = true
+
+	return func() error {
+		ptares := a.ptares
+
+		queryPtr := ptares.Queries[value]
+		if isAddr {
+			queryPtr = ptares.IndirectQueries[value]
+		}
+		if queryPtr == (pointer.Pointer{}) {
+			return errorf(codeAnalysis, "pointer analysis did not find expression (dead code?)")
+		}
+
+		// Ascertain which variables or allocations the mutex may be.
+		var allocs []token.Pos
+		for _, label := range queryPtr.PointsTo().Labels() {
+			allocs = append(allocs, label.Pos())
+		}
+		sort.Sort(byPos{a.prog.Fset, allocs})
+
+		// Ascertain which operations may operate on the same mutex.
+		calls := make(map[string][]token.Pos)
+		holders := make(map[*ssa.Function]bool)
+		for _, op := range ops {
+			ptr, ok := ptares.Queries[op.mu]
+			if !ok || !ptr.MayAlias(queryPtr) {
+				continue
+			}
+			calls[op.method] = append(calls[op.method], op.pos)
+			if op.method == "Lock" || op.method == "RLock" {
+				holders[op.fn] = true
+			}
+		}
+		for _, posns := range calls {
+			sort.Sort(byPos{a.prog.Fset, posns})
+		}
+		var fns []*ssa.Function
+		for fn := range holders {
+			fns = append(fns, fn)
+		}
+		sort.Sort(byFuncPos(fns))
+
+		q.Output(a.lprog.Fset, &lockersResult{
+			qpos:      qpos,
+			queryType: queryType,
+			allocs:    allocs,
+			locks:     calls["Lock"],
+			unlocks:   calls["Unlock"],
+			rlocks:    calls["RLock"],
+			runlocks:  calls["RUnlock"],
+			holders:   fns,
+		})
+		return nil
+	}, nil
+}
+
+// findLockOp returns the position of the Lparen of the enclosing call
+// of a mutex method, or NoPos if there is none.
+func findLockOp(qpos *queryPos) token.Pos {
+	for _, n := range qpos.path {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if sel, ok := unparen(call.Fun).(*ast.SelectorExpr); ok {
+				if fn, ok := qpos.info.Uses[sel.Sel].(*types.Func); ok && isMutexMethod(fn) {
+					return call.Lparen
+				}
+			}
+			return token.NoPos
+		}
+	}
+	return token.NoPos
+}
+
+// A lockOp is a static call of a method of sync.Mutex or sync.RWMutex.
+type lockOp struct {
+	mu     ssa.Value     // the address of the mutex
+	method string        // Lock, Unlock, RLock or RUnlock
+	pos    token.Pos     // Lparen of the call
+	fn     *ssa.Function // the function containing the call
+}
+
+// lockOpOf returns the mutex operation of the instruction, if it is one.
+func lockOpOf(instr ssa.Instruction) (lockOp, bool) {
+	if call, ok := instr.(ssa.CallInstruction); ok {
+		cc := call.Common()
+		if fn := cc.StaticCallee(); fn != nil && fn.Object() != nil && cc.Pos().IsValid() {
+			if obj, ok := fn.Object().(*types.Func); ok && isMutexMethod(obj) {
+				return lockOp{cc.Args[0], fn.Name(), cc.Pos(), instr.Parent()}, true
+			}
+		}
+	}
+	return lockOp{}, false
+}
+
+// isMutexMethod reports whether fn is the Lock, Unlock, RLock or
+// RUnlock method of sync.Mutex or sync.RWMutex.
+func isMutexMethod(fn *types.Func) bool {
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil || !isMutex(deref(recv.Type())) {
+		return false
+	}
+	switch fn.Name() {
+	case "Lock", "Unlock", "RLock", "RUnlock":
+		return true
+	}
+	return false
+}
+
+// isMutex reports whether T is sync.Mutex or sync.RWMutex.
+func isMutex(T types.Type) bool {
+	named, ok := T.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "sync" &&
+		(obj.Name() == "Mutex" || obj.Name() == "RWMutex")
+}
+
+type lockersResult struct {
+	resultSource
+	qpos                                     *queryPos
+	queryType                                types.Type      // sync.Mutex or sync.RWMutex
+	allocs, locks, unlocks, rlocks, runlocks []token.Pos     // positions of aliased mutexes and operations
+	holders                                  []*ssa.Function // functions that may acquire the mutex
+}
+
+func (r *lockersResult) PrintPlain(printf printfFunc) {
+	if len(r.allocs) == 0 {
+		printf(r.qpos, "This mutex can't point to anything.")
+		return
+	}
+	printf(r.qpos, "This mutex of type %s may be:", r.qpos.typeString(r.queryType))
+	for _, alloc := range r.allocs {
+		printf(alloc, "\tallocated here")
+	}
+	for _, pos := range r.locks {
+		printf(edgePos(pos), "\tlocked, here")
+	}
+	for _, pos := range r.unlocks {
+		printf(edgePos(pos), "\tunlocked, here")
+	}
+	for _, pos := range r.rlocks {
+		printf(edgePos(pos), "\tread-locked, here")
+	}
+	for _, pos := range r.runlocks {
+		printf(edgePos(pos), "\tread-unlocked, here")
+	}
+	if len(r.holders) > 0 {
+		printf(r.qpos, "It may be acquired by these functions:")
+		for _, fn := range r.holders {
+			printf(fn, "\t%s", fn.RelString(r.qpos.info.Pkg))
+		}
+	}
+}
+
+func (r *lockersResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *lockersResult) serialize(s *serializer) interface{} {
+	posns := func(posns []token.Pos) []string {
+		var res []string
+		for _, pos := range posns {
+			res = append(res, s.pos(pos))
+		}
+		return res
+	}
+	lockers := &serial.Lockers{
+		Version:  serial.Version,
+		Pos:      s.pos(r.qpos.start),
+		Type:     r.queryType.String(),
+		Allocs:   posns(r.allocs),
+		Locks:    posns(r.locks),
+		Unlocks:  posns(r.unlocks),
+		RLocks:   posns(r.rlocks),
+		RUnlocks: posns(r.runlocks),
+	}
+	for _, fn := range r.holders {
+		lockers.Holders = append(lockers.Holders, serial.LockHolder{
+			Name: fn.String(),
+			Pos:  s.pos(fn.Pos()),
+		})
+	}
+	lockers.Offsets = s.offsets()
+	return lockers
+}
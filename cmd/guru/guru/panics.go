@@ -0,0 +1,297 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+)
+
+// panics reports, for a selected call of recover, or defer statement,
+// the calls of panic whose values it may recover: those in the
+// functions that may defer the call of recover (or in the function
+// of the defer statement), or that they may call, directly or
+// indirectly, according to the call graph.  For a selected call of
+// panic, it reports conversely the calls of recover that may recover
+// its value: those made directly by the functions deferred by any
+// function that may call the function of the panic, itself included.
+//
+// Calls by go statements are not followed, as a panic does not
+// propagate from one goroutine to another.  The values of runtime
+// panics, such as those of nil dereferences, are not considered, nor
+// is the order of the calls: every deferred recover that may run
+// while a panic unwinds the stack is reported, though one that runs
+// earlier may recover it first.
+func panics(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	// The selection is the innermost call of panic
+	// or recover, or defer statement, that encloses it.
+	var (
+		builtin string // "panic" or "recover", for a call
+		node    ast.Node
+		path    []ast.Node // path from node to the root
+	)
+outer:
+	for i, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			id, ok := astutil.Unparen(n.Fun).(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if b, ok := qpos.info.Uses[id].(*types.Builtin); ok && (b.Name() == "panic" || b.Name() == "recover") {
+				builtin, node, path = b.Name(), n, qpos.path[i:]
+				break outer
+			}
+		case *ast.DeferStmt:
+			node, path = n, qpos.path[i:]
+			break outer
+		}
+	}
+	if node == nil {
+		return nil, fmt.Errorf("there is no call of panic or recover, or defer statement, here")
+	}
+
+	pkg := a.prog.Package(qpos.info.Pkg)
+	if pkg == nil {
+		return nil, errorf(codeAnalysis, "no SSA package")
+	}
+	if !ssa.HasEnclosingFunction(pkg, path) {
+		return nil, fmt.Errorf("this position is not inside a function")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	fn := ssa.EnclosingFunction(pkg, path)
+	if fn == nil {
+		return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	a.needCallGraph()
+
+	return func() error {
+		res := &panicsResult{qpos: qpos, pkg: qpos.info.Pkg}
+		switch builtin {
+		case "panic":
+			res.desc = "call of panic"
+			res.recovers = recoversOf(a.prog.Fset, a.cg, fn)
+		case "recover":
+			res.desc = "call of recover"
+			var deferrers []*ssa.Function
+			if node := a.cg.Nodes[fn]; node != nil {
+				for _, edge := range node.In {
+					if _, ok := edge.Site.(*ssa.Defer); ok {
+						deferrers = append(deferrers, edge.Caller.Func)
+					}
+				}
+			}
+			res.panics = panicsOf(a.prog.Fset, a.cg, deferrers)
+		default:
+			res.desc = "defer statement"
+			deferPos := node.(*ast.DeferStmt).Defer
+			if node := a.cg.Nodes[fn]; node != nil {
+				for _, edge := range node.Out {
+					if d, ok := edge.Site.(*ssa.Defer); ok && d.Pos() == deferPos {
+						res.recovers = append(res.recovers, recoverCalls(fn, edge.Callee.Func)...)
+					}
+				}
+			}
+			res.recovers = sortRecovers(a.prog.Fset, res.recovers)
+			if len(res.recovers) > 0 {
+				res.panics = panicsOf(a.prog.Fset, a.cg, []*ssa.Function{fn})
+			}
+		}
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// panicsOf returns the explicit calls of panic in the functions fns,
+// and in those that they may call, directly or indirectly, except by
+// go statements, in order of position.
+func panicsOf(fset *token.FileSet, cg *callgraph.Graph, fns []*ssa.Function) []panicSite {
+	seen := make(map[*callgraph.Node]bool)
+	var queue []*callgraph.Node
+	for _, fn := range fns {
+		if node := cg.Nodes[fn]; node != nil && !seen[node] {
+			seen[node] = true
+			queue = append(queue, node)
+		}
+	}
+	var sites []panicSite
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, b := range node.Func.Blocks {
+			for _, instr := range b.Instrs {
+				if p, ok := instr.(*ssa.Panic); ok && p.Pos().IsValid() {
+					sites = append(sites, panicSite{p.Pos(), node.Func})
+				}
+			}
+		}
+		for _, edge := range node.Out {
+			if _, ok := edge.Site.(*ssa.Go); !ok && !seen[edge.Callee] {
+				seen[edge.Callee] = true
+				queue = append(queue, edge.Callee)
+			}
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool { return lessPos(fset, sites[i].pos, sites[j].pos) })
+	return sites
+}
+
+// recoversOf returns the calls of recover by the functions deferred
+// by fn, or by those that may call it, directly or indirectly, except
+// by go statements, in order of position.
+func recoversOf(fset *token.FileSet, cg *callgraph.Graph, fn *ssa.Function) []recoverSite {
+	node := cg.Nodes[fn]
+	if node == nil {
+		return nil
+	}
+	seen := map[*callgraph.Node]bool{node: true}
+	queue := []*callgraph.Node{node}
+	var sites []recoverSite
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, edge := range node.Out {
+			if _, ok := edge.Site.(*ssa.Defer); ok {
+				sites = append(sites, recoverCalls(node.Func, edge.Callee.Func)...)
+			}
+		}
+		for _, edge := range node.In {
+			if _, ok := edge.Site.(*ssa.Go); !ok && !seen[edge.Caller] {
+				seen[edge.Caller] = true
+				queue = append(queue, edge.Caller)
+			}
+		}
+	}
+	return sortRecovers(fset, sites)
+}
+
+// recoverCalls returns the calls of recover by the function deferred,
+// which deferrer defers.
+func recoverCalls(deferrer, deferred *ssa.Function) []recoverSite {
+	var sites []recoverSite
+	for _, b := range deferred.Blocks {
+		for _, instr := range b.Instrs {
+			if call, ok := instr.(*ssa.Call); ok {
+				if b, ok := call.Call.Value.(*ssa.Builtin); ok && b.Name() == "recover" {
+					sites = append(sites, recoverSite{call.Pos(), deferred, deferrer})
+				}
+			}
+		}
+	}
+	return sites
+}
+
+// sortRecovers sorts sites by position, then by deferrer, removing
+// duplicates.
+func sortRecovers(fset *token.FileSet, sites []recoverSite) []recoverSite {
+	sort.Slice(sites, func(i, j int) bool {
+		x, y := sites[i], sites[j]
+		if x.pos != y.pos {
+			return lessPos(fset, x.pos, y.pos)
+		}
+		return x.deferrer.String() < y.deferrer.String()
+	})
+	var uniq []recoverSite
+	for i, site := range sites {
+		if i == 0 || site != sites[i-1] {
+			uniq = append(uniq, site)
+		}
+	}
+	return uniq
+}
+
+type panicSite struct {
+	pos token.Pos
+	fn  *ssa.Function // the function of the call of panic
+}
+
+type recoverSite struct {
+	pos      token.Pos
+	fn       *ssa.Function // the deferred function that calls recover
+	deferrer *ssa.Function // the function that defers fn
+}
+
+type panicsResult struct {
+	resultSource
+	qpos     *queryPos
+	pkg      *types.Package
+	desc     string        // "call of panic", "call of recover" or "defer statement"
+	panics   []panicSite   // for a recover or defer statement, the panics it may recover
+	recovers []recoverSite // for a panic, the recovers that may recover it; for a defer statement, its own
+}
+
+func (r *panicsResult) PrintPlain(printf printfFunc) {
+	if r.desc == "call of panic" {
+		if len(r.recovers) == 0 {
+			printf(r.qpos, "No call of recover may recover the value of this panic.")
+			return
+		}
+		printf(r.qpos, "These calls of recover may recover the value of this panic:")
+		for _, site := range r.recovers {
+			printf(site.pos, "\tin %s, deferred by %s",
+				site.fn.RelString(r.pkg), site.deferrer.RelString(r.pkg))
+		}
+		return
+	}
+
+	if r.desc == "defer statement" && len(r.recovers) == 0 {
+		printf(r.qpos, "No function deferred here calls recover.")
+		return
+	}
+	if len(r.panics) == 0 {
+		printf(r.qpos, "This %s may recover no call of panic.", r.desc)
+		return
+	}
+	printf(r.qpos, "This %s may recover the values of these calls of panic:", r.desc)
+	for _, site := range r.panics {
+		printf(site.pos, "\tin %s", site.fn.RelString(r.pkg))
+	}
+}
+
+func (r *panicsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *panicsResult) serialize(s *serializer) interface{} {
+	res := &serial.Panics{
+		Version: serial.Version,
+		Pos:     s.pos(r.qpos.start),
+		Desc:    r.desc,
+	}
+	for _, site := range r.panics {
+		res.Panics = append(res.Panics, serial.PanicSite{
+			Pos:  s.pos(site.pos),
+			Func: site.fn.String(),
+		})
+	}
+	for _, site := range r.recovers {
+		res.Recovers = append(res.Recovers, serial.RecoverSite{
+			Pos:      s.pos(site.pos),
+			Func:     site.fn.String(),
+			Deferrer: site.deferrer.String(),
+		})
+	}
+	res.Offsets = s.offsets()
+	return res
+}
@@ -0,0 +1,378 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// peers enumerates, for a given channel send (or receive) operation,
+// the set of possible receives (or sends) that correspond to it.
+// The pointer analysis follows a channel wherever its value flows,
+// so the peers of one that is sent over another channel, as in
+// registries of reply channels, include the operations on it by
+// those that receive it.
+//
+// If the query enables reflection (PTAOptions.Reflection), calls to the
+// Send, TrySend, Recv, TryRecv and Close methods of reflect.Value are
+// reported too.
+//
+// TODO(adonovan): support reflect.Select.
+// TODO(adonovan): permit the user to query based on a MakeChan (not send/recv),
+// or the implicit receive in "for v := range ch".
+func peers(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	opPos := findOp(qpos)
+	if opPos == token.NoPos {
+		return nil, fmt.Errorf("there is no channel operation here")
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	var queryOp chanOp // the originating send or receive operation
+	ops := allChanOps(a.prog, q.PTA.Reflection)
+	for _, op := range ops {
+		if op.pos == opPos {
+			queryOp = op // we found the query op
+		}
+	}
+	if queryOp.ch == nil {
+		return nil, errorf(codeAnalysis, "ssa.Instruction for send/receive not found")
+	}
+	ops = addPeersQueries(a.ptaConfig, queryOp, ops)
+
+	// For a channel that is a field x.f, also query the objects x
+	// of which it is a field.
+	var base ssa.Value
+	var field *types.Var
+	if !queryOp.reflect {
+		if base, field = fieldBase(queryOp.ch, false); base != nil {
+			a.ptaConfig.AddQuery(base)
+		}
+	}
+
+	// The pointer analysis runs once all queries are prepared.
+	a.needPTA = true
+
+	return func() error {
+		res := peersOf(a.prog.Fset, a.ptares, queryOp, ops)
+		if base != nil {
+			res.field = fieldOf(a.lprog, a.ptares, base, field)
+		}
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// allChanOps returns the channel operations in the whole program,
+// including calls to the channel methods of reflect.Value if
+// reflection is true.
+func allChanOps(prog *ssa.Program, reflection bool) []chanOp {
+	var ops []chanOp
+	for fn := range ssautil.AllFunctions(prog) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				ops = append(ops, chanOps(instr, reflection)...)
+			}
+		}
+	}
+	return ops
+}
+
+// addPeersQueries adds the channels of queryOp and of its possible
+// peers among ops to the queries of the pointer analysis, and returns
+// those peers.
+func addPeersQueries(conf *pointer.Config, queryOp chanOp, ops []chanOp) []chanOp {
+	// Discard operations of wrong channel element type.
+	// Build set of channel ssa.Values as query to pointer analysis.
+	// We compare channels by element types, not channel types, to
+	// ignore both directionality and type names.
+	queryElemType := queryOp.ch.Type().Underlying().(*types.Chan).Elem()
+	conf.AddQuery(queryOp.ch)
+	var peers []chanOp
+	for _, op := range ops {
+		// The dynamic type of a reflect.Value is checked
+		// once the analysis has run.
+		if op.reflect || types.Identical(op.ch.Type().Underlying().(*types.Chan).Elem(), queryElemType) {
+			conf.AddQuery(op.ch)
+			peers = append(peers, op)
+		}
+	}
+	return peers
+}
+
+// peersOf returns the result of a peers query of queryOp, whose
+// possible peers, added by addPeersQueries, are ops, in the program
+// whose positions are in fset.
+func peersOf(fset *token.FileSet, ptares *pointer.Result, queryOp chanOp, ops []chanOp) *peersResult {
+	queryType := queryOp.ch.Type()
+	queryElemType := queryType.Underlying().(*types.Chan).Elem()
+
+	// Find the points-to set.
+	queryChanPtr := ptares.Queries[queryOp.ch]
+
+	// Ascertain which make(chan) labels the query's channel can alias,
+	// and the buffer capacity of each, where it is a constant.
+	var makes []token.Pos
+	capacity := make(map[token.Pos]int64)
+	for _, label := range queryChanPtr.PointsTo().Labels() {
+		makes = append(makes, label.Pos())
+		capacity[label.Pos()] = -1
+		if mc, ok := label.Value().(*ssa.MakeChan); ok {
+			if c, ok := mc.Size.(*ssa.Const); ok {
+				capacity[label.Pos()] = c.Int64()
+			}
+		}
+	}
+	sort.Sort(byPos{fset, makes})
+	caps := make([]int64, len(makes))
+	for i, pos := range makes {
+		caps[i] = capacity[pos]
+	}
+
+	// Ascertain which channel operations can alias the same make(chan) labels.
+	var sends, receives, closes []token.Pos
+	for _, op := range ops {
+		ptr, ok := ptares.Queries[op.ch]
+		if !ok {
+			continue
+		}
+		if op.reflect && reflectMayAlias(ptr.PointsTo(), queryElemType, queryChanPtr.PointsTo()) ||
+			!op.reflect && ptr.MayAlias(queryChanPtr) {
+			switch op.dir {
+			case types.SendOnly:
+				sends = append(sends, op.pos)
+			case types.RecvOnly:
+				receives = append(receives, op.pos)
+			case types.SendRecv:
+				closes = append(closes, op.pos)
+			}
+		}
+	}
+	sort.Sort(byPos{fset, sends})
+	sort.Sort(byPos{fset, receives})
+	sort.Sort(byPos{fset, closes})
+
+	return &peersResult{
+		queryPos:  queryOp.pos,
+		queryType: queryType,
+		makes:     makes,
+		caps:      caps,
+		sends:     sends,
+		receives:  receives,
+		closes:    closes,
+	}
+}
+
+// findOp returns the position of the enclosing send/receive/close op.
+// For send and receive operations, this is the position of the <- token;
+// for close operations, it's the Lparen of the function call.
+//
+// TODO(adonovan): handle implicit receive operations from 'for...range chan' statements.
+func findOp(qpos *queryPos) token.Pos {
+	for _, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				return n.OpPos
+			}
+		case *ast.SendStmt:
+			return n.Arrow
+		case *ast.CallExpr:
+			// close function call can only exist as a direct identifier
+			if close, ok := unparen(n.Fun).(*ast.Ident); ok {
+				if b, ok := qpos.info.Info.Uses[close].(*types.Builtin); ok && b.Name() == "close" {
+					return n.Lparen
+				}
+			}
+		}
+	}
+	return token.NoPos
+}
+
+// chanOp abstracts an ssa.Send, ssa.Unop(ARROW), a SelectState, or a
+// call to close or to a channel method of reflect.Value.
+type chanOp struct {
+	ch      ssa.Value
+	dir     types.ChanDir // SendOnly=send, RecvOnly=recv, SendRecv=close
+	pos     token.Pos
+	reflect bool // ch is a reflect.Value, not a channel
+}
+
+// reflectChanMethods maps each channel method of reflect.Value to the
+// direction of its operation.
+var reflectChanMethods = map[string]types.ChanDir{
+	"Close":   types.SendRecv,
+	"Recv":    types.RecvOnly,
+	"Send":    types.SendOnly,
+	"TryRecv": types.RecvOnly,
+	"TrySend": types.SendOnly,
+}
+
+// chanOps returns a slice of all the channel operations in the
+// instruction, including calls to the channel methods of
+// reflect.Value if reflection is true.
+func chanOps(instr ssa.Instruction, reflection bool) []chanOp {
+	// TODO(adonovan): handle calls to reflect.Select too.
+	var ops []chanOp
+	switch instr := instr.(type) {
+	case *ssa.UnOp:
+		if instr.Op == token.ARROW {
+			ops = append(ops, chanOp{instr.X, types.RecvOnly, instr.Pos(), false})
+		}
+	case *ssa.Send:
+		ops = append(ops, chanOp{instr.Chan, types.SendOnly, instr.Pos(), false})
+	case *ssa.Select:
+		for _, st := range instr.States {
+			ops = append(ops, chanOp{st.Chan, st.Dir, st.Pos, false})
+		}
+	case ssa.CallInstruction:
+		cc := instr.Common()
+		if b, ok := cc.Value.(*ssa.Builtin); ok && b.Name() == "close" {
+			ops = append(ops, chanOp{cc.Args[0], types.SendRecv, cc.Pos(), false})
+		} else if fn := cc.StaticCallee(); reflection && fn != nil && isReflectValueMethod(fn) {
+			if dir, ok := reflectChanMethods[fn.Name()]; ok {
+				ops = append(ops, chanOp{cc.Args[0], dir, cc.Pos(), true})
+			}
+		}
+	}
+	return ops
+}
+
+// isReflectValueMethod reports whether fn is a method of reflect.Value.
+func isReflectValueMethod(fn *ssa.Function) bool {
+	recv := fn.Signature.Recv()
+	if recv == nil {
+		return false
+	}
+	named, ok := recv.Type().(*types.Named)
+	return ok && named.Obj().Name() == "Value" &&
+		named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "reflect"
+}
+
+// reflectMayAlias reports whether reflect.Value pts, whose dynamic
+// types are channels of element type elem, may refer to a channel in
+// the points-to set ch.
+func reflectMayAlias(pts pointer.PointsToSet, elem types.Type, ch pointer.PointsToSet) bool {
+	found := false
+	pts.DynamicTypes().Iterate(func(T types.Type, v interface{}) {
+		if tChan, ok := T.Underlying().(*types.Chan); ok && types.Identical(tChan.Elem(), elem) {
+			if v.(pointer.PointsToSet).Intersects(ch) {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// TODO(adonovan): show the line of text for each pos, like "referrers" does.
+type peersResult struct {
+	resultSource
+	queryPos                       token.Pos    // of queried channel op
+	queryType                      types.Type   // type of queried channel
+	makes, sends, receives, closes []token.Pos  // positions of aliased makechan/send/receive/close instrs
+	caps                           []int64      // buffer capacity of makes[i], or -1 if not constant
+	field                          *fieldResult // objects of which the channel x.f is a field, or nil
+}
+
+func (r *peersResult) PrintPlain(printf printfFunc) {
+	if len(r.makes) == 0 {
+		printf(r.queryPos, "This channel can't point to anything.")
+		return
+	}
+	printf(r.queryPos, "This channel of type %s may be:", r.queryType)
+	r.printAliases(printf, "\t")
+	if r.field != nil && len(r.field.labels) > 0 {
+		printf(r.queryPos, "This channel is held in field %s of these objects:", r.field.field.Name())
+		r.field.print(printf, "\t")
+	}
+}
+
+// printAliases prints the allocations and operations of the channels
+// that the queried one may alias, each line preceded by indent.
+func (r *peersResult) printAliases(printf printfFunc, indent string) {
+	for i, alloc := range r.makes {
+		switch c := r.caps[i]; {
+		case c < 0:
+			printf(alloc, "%sallocated here", indent)
+		case c == 0:
+			printf(alloc, "%sallocated here, unbuffered", indent)
+		default:
+			printf(alloc, "%sallocated here, with buffer capacity %d", indent, c)
+		}
+	}
+	for _, send := range r.sends {
+		printf(edgePos(send), "%ssent to, here", indent)
+	}
+	for _, receive := range r.receives {
+		printf(edgePos(receive), "%sreceived from, here", indent)
+	}
+	for _, clos := range r.closes {
+		printf(edgePos(clos), "%sclosed, here", indent)
+	}
+}
+
+func (r *peersResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *peersResult) serialize(s *serializer) interface{} {
+	peers := r.toSerial(s)
+	peers.Version = serial.Version
+	peers.Offsets = s.offsets()
+	return peers
+}
+
+func (r *peersResult) toSerial(s *serializer) *serial.Peers {
+	peers := &serial.Peers{
+		Pos:  s.pos(r.queryPos),
+		Type: r.queryType.String(),
+		Caps: r.caps,
+	}
+	for _, alloc := range r.makes {
+		peers.Allocs = append(peers.Allocs, s.pos(alloc))
+	}
+	for _, send := range r.sends {
+		peers.Sends = append(peers.Sends, s.pos(send))
+	}
+	for _, receive := range r.receives {
+		peers.Receives = append(peers.Receives, s.pos(receive))
+	}
+	for _, clos := range r.closes {
+		peers.Closes = append(peers.Closes, s.pos(clos))
+	}
+	if r.field != nil {
+		peers.Field = r.field.field.Name()
+		peers.Holders = r.field.toSerial(s)
+	}
+	return peers
+}
+
+// -------- utils --------
+
+type byPos struct {
+	fset  *token.FileSet
+	posns []token.Pos
+}
+
+func (p byPos) Len() int           { return len(p.posns) }
+func (p byPos) Less(i, j int) bool { return lessPos(p.fset, p.posns[i], p.posns[j]) }
+func (p byPos) Swap(i, j int)      { p.posns[i], p.posns[j] = p.posns[j], p.posns[i] }
@@ -0,0 +1,605 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+)
+
+// pointsto runs the pointer analysis on the selected expression,
+// and reports its points-to set (for a pointer-like expression)
+// or its dynamic types (for an interface, reflect.Value, or
+// reflect.Type expression) and their points-to sets.
+//
+// For a map, it also reports those of its keys and of its values,
+// separately, and for a slice or channel, those of its elements.
+//
+// All printed sets are sorted to ensure determinism.
+//
+func pointsto(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, true) // needs exact pos
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+	prog := a.prog
+
+	path, action := findInterestingNode(qpos.info, qpos.path)
+	if action != actionExpr {
+		return nil, fmt.Errorf("pointer analysis wants an expression; got %s",
+			astutil.NodeDescription(qpos.path[0]))
+	}
+
+	var expr ast.Expr
+	var obj types.Object
+	switch n := path[0].(type) {
+	case *ast.ValueSpec:
+		// ambiguous ValueSpec containing multiple names
+		return nil, fmt.Errorf("multiple value specification")
+	case *ast.Ident:
+		obj = qpos.info.ObjectOf(n)
+		expr = n
+	case ast.Expr:
+		expr = n
+	default:
+		// TODO(adonovan): is this reachable?
+		return nil, fmt.Errorf("unexpected AST for expr: %T", n)
+	}
+
+	// Reject non-pointerlike types (includes all constants---except nil).
+	// TODO(adonovan): reject nil too.
+	typ := qpos.info.TypeOf(expr)
+	if !pointer.CanPoint(typ) {
+		return nil, fmt.Errorf("pointer analysis wants an expression of reference type; got %s", typ)
+	}
+
+	// Determine the ssa.Value for the expression.
+	var value ssa.Value
+	var isAddr bool
+	if obj != nil {
+		// def/ref of func/var object
+		value, isAddr, err = ssaValueForIdent(prog, qpos.info, obj, path)
+	} else {
+		value, isAddr, err = ssaValueForExpr(prog, qpos.info, path)
+	}
+	if err != nil {
+		return nil, err // e.g. trivially dead code
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	// Prepare the pointer analysis.
+	addPTAQuery(a, value, isAddr)
+	elems, err := addElemQueries(a, value, isAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// For a field x.f of pointer-like type, also query the objects x
+	// of which it is a field.
+	var base ssa.Value
+	var field *types.Var
+	if !pointer.CanHaveDynamicTypes(typ) {
+		if base, field = fieldBase(value, isAddr); base != nil {
+			a.ptaConfig.AddQuery(base)
+		}
+	}
+
+	return func() error {
+		ptrs, err := pointsToResults(a.prog.Fset, a.ptares, value, isAddr)
+		if err != nil {
+			return err // e.g. analytically unreachable
+		}
+
+		var labels []*pointer.Label
+		for _, ptr := range ptrs {
+			labels = append(labels, ptr.labels...)
+		}
+		var elemResults []elemResult
+		for _, e := range elems {
+			r := elemResult{kind: e.kind, typ: e.typ, ptrs: pointerResults(a.prog.Fset, *e.ptr, e.typ)}
+			for _, ptr := range r.ptrs {
+				labels = append(labels, ptr.labels...)
+			}
+			elemResults = append(elemResults, r)
+		}
+		res := &pointstoResult{
+			qpos:     qpos,
+			typ:      typ,
+			ptrs:     ptrs,
+			elems:    elemResults,
+			snippets: labelSnippets(a.lprog, labels),
+		}
+		if base != nil {
+			res.field = fieldOf(a.lprog, a.ptares, base, field)
+		}
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// ssaValueForIdent returns the ssa.Value for the ast.Ident whose path
+// to the root of the AST is path.  isAddr reports whether the
+// ssa.Value is the address denoted by the ast.Ident, not its value.
+//
+func ssaValueForIdent(prog *ssa.Program, qinfo *loader.PackageInfo, obj types.Object, path []ast.Node) (value ssa.Value, isAddr bool, err error) {
+	switch obj := obj.(type) {
+	case *types.Var:
+		pkg := prog.Package(qinfo.Pkg)
+		pkg.Build()
+		if v, addr := prog.VarValue(obj, pkg, path); v != nil {
+			return v, addr, nil
+		}
+		return nil, false, errorf(codeAnalysis, "can't locate SSA Value for var %s", obj.Name())
+
+	case *types.Func:
+		fn := prog.FuncValue(obj)
+		if fn == nil {
+			return nil, false, fmt.Errorf("%s is an interface method", obj)
+		}
+		// TODO(adonovan): there's no point running PTA on a *Func ident.
+		// Eliminate this feature.
+		return fn, false, nil
+	}
+	panic(obj)
+}
+
+// ssaValueForExpr returns the ssa.Value of the non-ast.Ident
+// expression whose path to the root of the AST is path.
+//
+func ssaValueForExpr(prog *ssa.Program, qinfo *loader.PackageInfo, path []ast.Node) (value ssa.Value, isAddr bool, err error) {
+	pkg := prog.Package(qinfo.Pkg)
+	pkg.SetDebugMode(true)
+	pkg.Build()
+
+	fn := ssa.EnclosingFunction(pkg, path)
+	if fn == nil {
+		return nil, false, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+	}
+
+	if v, addr := fn.ValueForExpr(path[0].(ast.Expr)); v != nil {
+		return v, addr, nil
+	}
+
+	return nil, false, errorf(codeAnalysis, "can't locate SSA Value for expression in %s", fn)
+}
+
+// addPTAQuery requests the pointer analysis of the selected SSA value or address.
+func addPTAQuery(a *analysis, v ssa.Value, isAddr bool) {
+	if isAddr {
+		a.ptaConfig.AddIndirectQuery(v)
+	} else {
+		a.ptaConfig.AddQuery(v)
+	}
+	a.needPTA = true
+}
+
+// pointsToResults returns the points-to information for the selected
+// SSA value or address from the result of the pointer analysis.
+func pointsToResults(fset *token.FileSet, ptares *pointer.Result, v ssa.Value, isAddr bool) (ptrs []pointerResult, err error) {
+	T := v.Type()
+	var ptr pointer.Pointer
+	if isAddr {
+		ptr = ptares.IndirectQueries[v]
+		T = deref(T)
+	} else {
+		ptr = ptares.Queries[v]
+	}
+	if ptr == (pointer.Pointer{}) {
+		return nil, errorf(codeAnalysis, "pointer analysis did not find expression (dead code?)")
+	}
+	return pointerResults(fset, ptr, T), nil
+}
+
+// pointerResults returns the points-to information of ptr, a pointer
+// of type T: its dynamic types, if T is an interface, or its labels.
+func pointerResults(fset *token.FileSet, ptr pointer.Pointer, T types.Type) (ptrs []pointerResult) {
+	pts := ptr.PointsTo()
+
+	if pointer.CanHaveDynamicTypes(T) {
+		// Show concrete types for interface/reflect.Value expression.
+		if concs := pts.DynamicTypes(); concs.Len() > 0 {
+			concs.Iterate(func(conc types.Type, pta interface{}) {
+				labels := pta.(pointer.PointsToSet).Labels()
+				sort.Sort(byPosAndString{fset, labels}) // to ensure determinism
+				ptrs = append(ptrs, pointerResult{conc, labels})
+			})
+		}
+	} else {
+		// Show labels for other expressions.
+		labels := pts.Labels()
+		sort.Sort(byPosAndString{fset, labels}) // to ensure determinism
+		ptrs = append(ptrs, pointerResult{T, labels})
+	}
+	sort.Slice(ptrs, func(i, j int) bool { // to ensure determinism
+		return lessType(fset, ptrs[i].typ, ptrs[j].typ)
+	})
+	return ptrs
+}
+
+// An elemQuery is an extended query of the pointer analysis for the
+// keys, values or elements of the selected map, slice or channel.
+type elemQuery struct {
+	kind string // "keys", "values" or "elements"
+	typ  types.Type
+	ptr  *pointer.Pointer
+}
+
+// addElemQueries requests the pointer analysis of the keys and values
+// of the selected map value (or, if isAddr, the map at the address),
+// or of the elements of the selected slice or channel, of those that
+// are pointer-like.
+func addElemQueries(a *analysis, v ssa.Value, isAddr bool) ([]elemQuery, error) {
+	x, T := "x", v.Type()
+	if isAddr {
+		x, T = "(*x)", deref(T)
+	}
+	var queries []elemQuery
+	add := func(kind, query string, typ types.Type) error {
+		if !pointer.CanPoint(typ) {
+			return nil
+		}
+		ptr, err := a.ptaConfig.AddExtendedQuery(v, query)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, elemQuery{kind, typ, ptr})
+		return nil
+	}
+	var err error
+	switch T := T.Underlying().(type) {
+	case *types.Map:
+		if err = add("keys", "key("+x+")", T.Key()); err == nil {
+			err = add("values", x+"[0]", T.Elem())
+		}
+	case *types.Slice:
+		err = add("elements", x+"[0]", T.Elem())
+	case *types.Chan:
+		err = add("elements", "<-"+x, T.Elem())
+	}
+	return queries, err
+}
+
+// fieldBase returns, if v is the value (or, if isAddr, the address)
+// of a field selection x.f, the pointer x to the struct that contains
+// the field, and the field.  Otherwise it returns nil.
+func fieldBase(v ssa.Value, isAddr bool) (ssa.Value, *types.Var) {
+	if !isAddr {
+		load, ok := v.(*ssa.UnOp)
+		if !ok || load.Op != token.MUL {
+			return nil, nil
+		}
+		v = load.X
+	}
+	fa, ok := v.(*ssa.FieldAddr)
+	if !ok {
+		return nil, nil
+	}
+	return fa.X, deref(fa.X.Type()).Underlying().(*types.Struct).Field(fa.Field)
+}
+
+// fieldOf returns the objects to which base, the pointer x of a field
+// selection x.f, may point, from the result of the pointer analysis.
+func fieldOf(lprog *loader.Program, ptares *pointer.Result, base ssa.Value, field *types.Var) *fieldResult {
+	labels := ptares.Queries[base].PointsTo().Labels()
+	sort.Sort(byPosAndString{lprog.Fset, labels}) // to ensure determinism
+	return &fieldResult{
+		field:    field,
+		labels:   labels,
+		snippets: labelSnippets(lprog, labels),
+	}
+}
+
+// A fieldResult describes the objects in which a selected field x.f
+// resides: each label of the points-to set of x, with the field,
+// denotes the field of one allocation.
+type fieldResult struct {
+	field    *types.Var
+	labels   []*pointer.Label // objects of which the field is part
+	snippets labelSnippetMap
+}
+
+// print prints the field of each object, with indent.
+func (r *fieldResult) print(printf printfFunc, indent string) {
+	for _, label := range r.labels {
+		if snippet := r.snippets[label]; snippet != "" {
+			printf(label, "%s%s.%s: %s", indent, label, r.field.Name(), snippet)
+		} else {
+			printf(label, "%s%s.%s", indent, label, r.field.Name())
+		}
+	}
+}
+
+func (r *fieldResult) toSerial(s *serializer) []serial.PointsToLabel {
+	var holders []serial.PointsToLabel
+	for _, l := range r.labels {
+		holders = append(holders, serial.PointsToLabel{
+			Pos:     s.pos(l.Pos()),
+			Desc:    l.String() + "." + r.field.Name(),
+			Snippet: r.snippets[l],
+		})
+	}
+	return holders
+}
+
+type pointerResult struct {
+	typ    types.Type       // type of the pointer (always concrete)
+	labels []*pointer.Label // set of labels
+}
+
+// An elemResult is the points-to information of the keys, values or
+// elements of a map, slice or channel.
+type elemResult struct {
+	kind string          // "keys", "values" or "elements"
+	typ  types.Type      // their type
+	ptrs []pointerResult // pointer info, as for pointstoResult.ptrs
+}
+
+type pointstoResult struct {
+	resultSource
+	qpos     *queryPos
+	typ      types.Type      // type of expression
+	ptrs     []pointerResult // pointer info (typ is concrete => len==1)
+	elems    []elemResult    // of a map, slice or channel, those of its keys, values or elements
+	snippets labelSnippetMap // source text of the labels' allocation sites
+	field    *fieldResult    // objects of which a selected field x.f is part, or nil
+}
+
+func (r *pointstoResult) PrintPlain(printf printfFunc) {
+	if pointer.CanHaveDynamicTypes(r.typ) {
+		// Show concrete types for interface, reflect.Type or
+		// reflect.Value expression.
+
+		if len(r.ptrs) > 0 {
+			printf(r.qpos, "this %s may contain these dynamic types:", r.qpos.typeString(r.typ))
+			r.printDynamicTypes(printf, r.ptrs)
+		} else {
+			printf(r.qpos, "this %s cannot contain any dynamic types.", r.typ)
+		}
+	} else {
+		// Show labels for other expressions.
+		if ptr := r.ptrs[0]; len(ptr.labels) > 0 {
+			printf(r.qpos, "this %s may point to these objects:",
+				r.qpos.typeString(r.typ))
+			printLabels(printf, ptr.labels, r.snippets, "\t")
+		} else {
+			printf(r.qpos, "this %s may not point to anything.",
+				r.qpos.typeString(r.typ))
+		}
+		if r.field != nil && len(r.field.labels) > 0 {
+			printf(r.qpos, "this %s is held in field %s of these objects:",
+				r.qpos.typeString(r.typ), r.field.field.Name())
+			r.field.print(printf, "\t")
+		}
+	}
+
+	for _, e := range r.elems {
+		desc := fmt.Sprintf("its %s, of type %s,", e.kind, r.qpos.typeString(e.typ))
+		switch {
+		case pointer.CanHaveDynamicTypes(e.typ) && len(e.ptrs) > 0:
+			printf(r.qpos, "%s may contain these dynamic types:", desc)
+			r.printDynamicTypes(printf, e.ptrs)
+		case pointer.CanHaveDynamicTypes(e.typ):
+			printf(r.qpos, "%s cannot contain any dynamic types.", desc)
+		case len(e.ptrs[0].labels) > 0:
+			printf(r.qpos, "%s may point to these objects:", desc)
+			printLabels(printf, e.ptrs[0].labels, r.snippets, "\t")
+		default:
+			printf(r.qpos, "%s may not point to anything.", desc)
+		}
+	}
+}
+
+// printDynamicTypes prints the dynamic types of ptrs, each with the
+// objects to which it may point.
+func (r *pointstoResult) printDynamicTypes(printf printfFunc, ptrs []pointerResult) {
+	for _, ptr := range ptrs {
+		var obj types.Object
+		if nt, ok := deref(ptr.typ).(*types.Named); ok {
+			obj = nt.Obj()
+		}
+		if len(ptr.labels) > 0 {
+			printf(obj, "\t%s, may point to:", r.qpos.typeString(ptr.typ))
+			printLabels(printf, ptr.labels, r.snippets, "\t\t")
+		} else {
+			printf(obj, "\t%s", r.qpos.typeString(ptr.typ))
+		}
+	}
+}
+
+func (r *pointstoResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *pointstoResult) serialize(s *serializer) interface{} {
+	var pts []serial.PointsTo
+	for _, ptr := range r.ptrs {
+		pt := r.toSerial(s, ptr)
+		pt.Version = serial.Version
+		if r.field != nil {
+			pt.Field = r.field.field.Name()
+			pt.Holders = r.field.toSerial(s)
+		}
+		for _, e := range r.elems {
+			var elems []serial.PointsTo
+			for _, ptr := range e.ptrs {
+				elems = append(elems, r.toSerial(s, ptr))
+			}
+			switch e.kind {
+			case "keys":
+				pt.Keys = elems
+			case "values":
+				pt.Values = elems
+			case "elements":
+				pt.Elements = elems
+			}
+		}
+		pt.Offsets = s.offsets()
+		pts = append(pts, pt)
+	}
+	return pts
+}
+
+func (r *pointstoResult) toSerial(s *serializer, ptr pointerResult) serial.PointsTo {
+	var namePos string
+	if nt, ok := deref(ptr.typ).(*types.Named); ok {
+		namePos = s.pos(nt.Obj().Pos())
+	}
+	var labels []serial.PointsToLabel
+	for _, l := range ptr.labels {
+		labels = append(labels, serial.PointsToLabel{
+			Pos:     s.pos(l.Pos()),
+			Desc:    l.String(),
+			Snippet: r.snippets[l],
+		})
+	}
+	return serial.PointsTo{
+		Type:    r.qpos.typeString(ptr.typ),
+		NamePos: namePos,
+		Labels:  labels,
+	}
+}
+
+type byPosAndString struct {
+	fset   *token.FileSet
+	labels []*pointer.Label
+}
+
+func (a byPosAndString) Len() int { return len(a.labels) }
+func (a byPosAndString) Less(i, j int) bool {
+	x, y := a.labels[i], a.labels[j]
+	if x.Pos() == y.Pos() {
+		return x.String() < y.String()
+	}
+	return lessPos(a.fset, x.Pos(), y.Pos())
+}
+func (a byPosAndString) Swap(i, j int) { a.labels[i], a.labels[j] = a.labels[j], a.labels[i] }
+
+func printLabels(printf printfFunc, labels []*pointer.Label, snippets labelSnippetMap, prefix string) {
+	// TODO(adonovan): due to context-sensitivity, many of these
+	// labels may differ only by context, which isn't apparent.
+	for _, label := range labels {
+		if snippet := snippets[label]; snippet != "" {
+			printf(label, "%s%s: %s", prefix, label, snippet)
+		} else {
+			printf(label, "%s%s", prefix, label)
+		}
+	}
+}
+
+// A labelSnippetMap maps each label that has an allocation site in
+// the syntax to the source text of the site (see labelSnippets).
+type labelSnippetMap map[*pointer.Label]string
+
+// maxSnippet is the length in bytes beyond which the source text of
+// an allocation site is truncated.
+const maxSnippet = 40
+
+// labelSnippets returns the source text of the allocation site of
+// each of the labels that has one---a composite literal, a call such
+// as make or new, or a function literal---as a single line, truncated
+// to maxSnippet bytes, so that a reader may tell the objects of a
+// points-to set apart without first visiting their positions.
+func labelSnippets(lprog *loader.Program, labels []*pointer.Label) labelSnippetMap {
+	files := make(map[*token.File]*ast.File)
+	for _, info := range lprog.AllPackages {
+		for _, f := range info.Files {
+			files[lprog.Fset.File(f.Pos())] = f
+		}
+	}
+	snippets := make(labelSnippetMap)
+	for _, l := range labels {
+		if _, ok := snippets[l]; ok || !l.Pos().IsValid() {
+			continue
+		}
+		f := files[lprog.Fset.File(l.Pos())]
+		if f == nil {
+			continue
+		}
+		path, _ := astutil.PathEnclosingInterval(f, l.Pos(), l.Pos())
+		if site := allocSiteOf(path); site != nil {
+			snippets[l] = snippet(lprog.Fset, site)
+		}
+	}
+	return snippets
+}
+
+// allocSiteOf returns the allocating expression that encloses the
+// innermost node of path, which is the position of a label: a
+// composite literal (with its & operator, if any), a call, or a
+// function literal.  It returns nil for the declaration of a
+// variable or function.
+func allocSiteOf(path []ast.Node) ast.Expr {
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.CompositeLit:
+			if i+1 < len(path) {
+				if u, ok := path[i+1].(*ast.UnaryExpr); ok && u.Op == token.AND {
+					return u
+				}
+			}
+			return n
+		case *ast.CallExpr, *ast.FuncLit:
+			return n.(ast.Expr)
+		case ast.Expr:
+			// e.g. the type of a function literal
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// snippet returns the source text of the expression e, in one line
+// of at most maxSnippet bytes (plus an ellipsis).  The body of a
+// function literal is elided.
+func snippet(fset *token.FileSet, e ast.Expr) string {
+	var buf bytes.Buffer
+	if lit, ok := e.(*ast.FuncLit); ok {
+		printer.Fprint(&buf, fset, lit.Type)
+		buf.WriteString(" {...}")
+	} else {
+		printer.Fprint(&buf, fset, e)
+	}
+	// Join the lines, within the braces and parens that
+	// enclose them without the usual spaces or final comma.
+	var text string
+	for i, line := range strings.Split(buf.String(), "\n") {
+		line = strings.Replace(strings.TrimSpace(line), "\t", " ", -1) // alignment
+		switch {
+		case i == 0:
+		case strings.HasSuffix(text, "{"), strings.HasSuffix(text, "("):
+		case strings.HasPrefix(line, "}"), strings.HasPrefix(line, ")"):
+			text = strings.TrimSuffix(text, ",")
+		default:
+			text += " "
+		}
+		text += line
+	}
+	if len(text) > maxSnippet {
+		i := maxSnippet
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+		text = text[:i] + "..."
+	}
+	return text
+}
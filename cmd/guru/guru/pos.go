@@ -0,0 +1,394 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines utilities for working with file positions.
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// A filePos is a position within a file, specified either as a byte
+// offset or, if line > 0, as a 1-based line and column (in bytes).
+type filePos struct {
+	offset    int
+	line, col int
+}
+
+// posRE matches the position syntax accepted by parsePos.
+var posRE = regexp.MustCompile(`^(.*):(#\d+|\d+:\d+)(?:,(#\d+|\d+:\d+))?$`)
+
+// parseFilePos parses a string of the form "#%d" or "%d:%d".
+// Its syntax has already been checked by posRE.
+func parseFilePos(s string) filePos {
+	if s[0] == '#' {
+		offset, _ := strconv.Atoi(s[1:])
+		return filePos{offset: offset}
+	}
+	colon := strings.Index(s, ":")
+	line, _ := strconv.Atoi(s[:colon])
+	col, _ := strconv.Atoi(s[colon+1:])
+	return filePos{line: line, col: col}
+}
+
+// parsePos parses a string of the form "file:pos" or "file:start,end"
+// and returns its components.  Each of pos, start, and end is either
+// a byte offset of the form #%d or a line and column of the form
+// %d:%d, as in "foo.go:#123,#456" or "foo.go:10:5,12:1".
+//
+func parsePos(pos string) (filename string, start, end filePos, err error) {
+	if pos == "" {
+		err = errorf(codePosition, "no source position specified")
+		return
+	}
+
+	m := posRE.FindStringSubmatch(pos)
+	if m == nil {
+		err = errorf(codePosition, "bad position syntax %q", pos)
+		return
+	}
+	filename = m[1]
+	start = parseFilePos(m[2])
+	end = start
+	if m[3] != "" {
+		end = parseFilePos(m[3])
+	}
+	if start.line > 0 && start.col < 1 || end.line > 0 && end.col < 1 {
+		err = errorf(codePosition, "invalid column in query position %q", pos)
+	}
+	return
+}
+
+// fileOffsetToPos translates the specified file-relative positions
+// into token.Pos form.  It returns an error if the file was not found
+// or the positions were out of bounds.
+//
+func fileOffsetToPos(file *token.File, startPos, endPos filePos) (start, end token.Pos, err error) {
+	// Range check [start..end], inclusive of both end-points.
+
+	if offset := fileOffset(file, startPos); 0 <= offset && offset <= file.Size() {
+		start = file.Pos(offset)
+	} else {
+		err = errorf(codePosition, "start position is beyond end of file")
+		return
+	}
+
+	if offset := fileOffset(file, endPos); 0 <= offset && offset <= file.Size() {
+		end = file.Pos(offset)
+	} else {
+		err = errorf(codePosition, "end position is beyond end of file")
+		return
+	}
+
+	return
+}
+
+// fileOffset returns the byte offset within file of the position p,
+// or -1 if its line is out of range.
+func fileOffset(file *token.File, p filePos) int {
+	if p.line == 0 {
+		return p.offset
+	}
+	// Use the lines of the file as parsed, unless it was
+	// preprocessed, in which case use those of the original file.
+	adjusted := isPreprocessed(file)
+	line := func(pos token.Pos) int { return file.PositionFor(pos, adjusted).Line }
+	if p.line > line(file.Pos(file.Size())) {
+		return -1
+	}
+	// Find the start of the line by binary search of the line table.
+	lineStart := sort.Search(file.Size(), func(offset int) bool {
+		return line(file.Pos(offset)) >= p.line
+	})
+	return lineStart + p.col - 1
+}
+
+// position returns the position of pos in fset.  By default it is the
+// logical position, to which the //line comments of generated code,
+// such as that of yacc or stringer, map it: usually one in the
+// original source.  If src is of a query with PhysicalPositions, it is
+// the position in the file as parsed, unless the file was preprocessed
+// by cgo, whose positions are always those of the original file.
+func (src *fileSource) position(fset *token.FileSet, pos token.Pos) token.Position {
+	if src != nil && src.physical {
+		if file := fset.File(pos); file != nil && !isPreprocessed(file) {
+			return file.PositionFor(pos, false)
+		}
+	}
+	return fset.Position(pos)
+}
+
+// isPreprocessed reports whether file is the output of a preprocessor
+// such as cgo, whose //line comments map its lines back to those of
+// the file of the same name.  Such a file's offsets, unlike its
+// lines, do not correspond to those of the file on disk.
+func isPreprocessed(file *token.File) bool {
+	end := file.Pos(file.Size())
+	posn := file.Position(end)
+	return posn.Filename == file.Name() && posn.Line != file.PositionFor(end, false).Line
+}
+
+// isLineOnly reports whether file holds the positions of export data,
+// such as that of the packages reused by -cache and -export, which
+// records only the line of each object: its lines are one byte long,
+// as if of newlines only, so that its columns are all 1 and its
+// offsets do not correspond to those of the file on disk.
+func isLineOnly(file *token.File) bool {
+	return file.LineCount() == file.Size()
+}
+
+// sourceOffset returns the byte offset within data, the contents of
+// its file, of posn, the position of pos, or false if there is no such
+// position.
+func sourceOffset(fset *token.FileSet, pos token.Pos, posn token.Position, data []byte) (int, bool) {
+	start := posn.Offset
+	if file := fset.File(pos); posn.Filename != file.Name() || isPreprocessed(file) || isLineOnly(file) {
+		// A //line comment maps pos to a position in another
+		// file, or cgo to one in the original of the file as
+		// parsed, or pos is of export data, whose offset is that
+		// of its line and column.
+		var ok bool
+		if start, ok = lineOffset(data, posn.Line, posn.Column); !ok {
+			return 0, false
+		}
+	}
+	if start > len(data) {
+		return 0, false
+	}
+	return start, true
+}
+
+// lineCol converts the byte offset p, if it is one, within the file
+// on disk named filename to a line and column.  Preprocessing by cgo
+// preserves line numbers and, on lines that do not refer to package
+// "C", columns.
+func lineCol(filename string, p filePos) (filePos, error) {
+	if p.line > 0 {
+		return p, nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return p, err
+	}
+	if p.offset > len(content) {
+		return p, errorf(codePosition, "position is beyond end of file")
+	}
+	lineStart := bytes.LastIndexByte(content[:p.offset], '\n') + 1
+	line := bytes.Count(content[:lineStart], []byte("\n")) + 1
+	return filePos{line: line, col: p.offset - lineStart + 1}, nil
+}
+
+// sameFile returns true if x and y have the same basename and denote
+// the same file.  Files that are not on disk, such as those of an
+// overlay, are the same only if their names are.
+//
+func sameFile(x, y string) bool {
+	if filepath.Clean(x) == filepath.Clean(y) {
+		return true
+	}
+	if filepath.Base(x) == filepath.Base(y) { // (optimisation)
+		if xi, err := os.Stat(x); err == nil {
+			if yi, err := os.Stat(y); err == nil {
+				return os.SameFile(xi, yi)
+			}
+		}
+	}
+	return false
+}
+
+// fastQueryPos parses the position string and returns a queryPos.
+// It parses only a single file and does not run the type checker.
+func fastQueryPos(ctxt *build.Context, pos string) (*queryPos, error) {
+	filename, startPos, endPos, err := parsePos(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the file, opening it the file via the build.Context
+	// so that we observe the effects of the -modified flag.
+	fset := token.NewFileSet()
+	cwd, _ := os.Getwd()
+	f, err := buildutil.ParseFile(fset, ctxt, nil, cwd, filename, parser.Mode(0))
+	// ParseFile usually returns a partial file along with an error.
+	// Only fail if there is no file.
+	if f == nil {
+		return nil, withCode(codePosition, err)
+	}
+	if !f.Pos().IsValid() {
+		return nil, errorf(codePosition, "%s is not a Go source file", filename)
+	}
+
+	start, end, err := fileOffsetToPos(fset.File(f.Pos()), startPos, endPos)
+	if err != nil {
+		return nil, err
+	}
+
+	path, exact := astutil.PathEnclosingInterval(f, start, end)
+	if path == nil {
+		return nil, errorf(codePosition, "no syntax here")
+	}
+
+	return &queryPos{fset, start, end, path, exact, nil}, nil
+}
+
+// IdentPos returns the query position of the declaring identifier of
+// the package-level entity denoted by ident, a qualified name of the
+// form "path.Name" or "path.Type.Member", where Member is a method, a
+// struct field, or an interface method; for example,
+// "net/http.Client.Do", or, if ident is the import path of a package,
+// of the name of its package clause, as for the -ident flag of the
+// guru command.  The package is found, as by a query q, in the build
+// context and workspaces of q; it is parsed but not type-checked.
+func IdentPos(q *Query, ident string) (string, error) {
+	ctxt := addRoots(q).Build
+	cwd, _ := os.Getwd()
+	if bp, err := ctxt.Import(ident, cwd, 0); err == nil {
+		if files := append(bp.GoFiles, bp.CgoFiles...); len(files) > 0 {
+			fset := token.NewFileSet()
+			f, err := buildutil.ParseFile(fset, ctxt, nil, bp.Dir, files[0], parser.PackageClauseOnly)
+			if f == nil {
+				return "", err
+			}
+			// The offset is within the file as parsed, even if a
+			// //line comment maps it to another.
+			posn := fset.PositionFor(f.Name.Pos(), false)
+			return fmt.Sprintf("%s:#%d,#%d", posn.Filename, posn.Offset, posn.Offset+len(f.Name.Name)), nil
+		}
+	}
+	// Try the longest prefix that names a package first,
+	// since the last path segment may contain dots (e.g. gopkg.in/yaml.v2).
+	slash := strings.LastIndex(ident, "/")
+	for dot := strings.LastIndex(ident, "."); dot > slash; dot = strings.LastIndex(ident[:dot], ".") {
+		path, names := ident[:dot], strings.Split(ident[dot+1:], ".")
+		if len(names) > 2 {
+			break
+		}
+		bp, err := ctxt.Import(path, cwd, 0)
+		if err != nil {
+			continue
+		}
+		fset := token.NewFileSet()
+		for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+			f, err := buildutil.ParseFile(fset, ctxt, nil, bp.Dir, name, 0)
+			if f == nil {
+				return "", err
+			}
+			if id := findDecl(f, names); id != nil {
+				posn := fset.PositionFor(id.Pos(), false)
+				return fmt.Sprintf("%s:#%d,#%d",
+					posn.Filename, posn.Offset, posn.Offset+len(id.Name)), nil
+			}
+		}
+		return "", fmt.Errorf("package %s has no declaration of %s",
+			bp.ImportPath, strings.Join(names, "."))
+	}
+	return "", fmt.Errorf("%s is neither a package nor a qualified identifier of one", ident)
+}
+
+// findDecl returns the declaring identifier in f of the package member
+// names[0] or, if len(names) == 2, of the method, field, or interface
+// method names[1] of type names[0], or nil if there is none.
+func findDecl(f *ast.File, names []string) *ast.Ident {
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.Name != names[len(names)-1] {
+				continue
+			}
+			if decl.Recv == nil {
+				if len(names) == 1 {
+					return decl.Name
+				}
+			} else if len(names) == 2 && recvTypeName(decl.Recv) == names[0] {
+				return decl.Name
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						if len(names) == 1 && id.Name == names[0] {
+							return id
+						}
+					}
+
+				case *ast.TypeSpec:
+					if spec.Name.Name != names[0] {
+						continue
+					}
+					if len(names) == 1 {
+						return spec.Name
+					}
+					var fields *ast.FieldList
+					switch T := spec.Type.(type) {
+					case *ast.StructType:
+						fields = T.Fields
+					case *ast.InterfaceType:
+						fields = T.Methods
+					}
+					if fields != nil {
+						for _, field := range fields.List {
+							for _, id := range field.Names {
+								if id.Name == names[1] {
+									return id
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// recvTypeName returns the name of the named type of a method receiver.
+func recvTypeName(recv *ast.FieldList) string {
+	if len(recv.List) == 0 {
+		return ""
+	}
+	T := recv.List[0].Type
+	if star, ok := T.(*ast.StarExpr); ok {
+		T = star.X
+	}
+	if id, ok := astutil.Unparen(T).(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// lessPos is the order of the results of all queries: by file name,
+// then by position within the file, with token.NoPos first.  Unlike
+// the order of token.Pos, it does not depend on the order in which
+// packages were loaded, which is concurrent, so that the output of
+// repeated runs is the same.
+func lessPos(fset *token.FileSet, x, y token.Pos) bool {
+	fx, fy := fset.File(x), fset.File(y)
+	switch {
+	case fx == fy:
+		return x < y
+	case fx == nil || fy == nil:
+		return fx == nil
+	case fx.Name() != fy.Name():
+		return fx.Name() < fy.Name()
+	}
+	return x-token.Pos(fx.Base()) < y-token.Pos(fy.Base()) // distinct Files of one name
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"sync"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+)
+
+// A Program is a program loaded, and perhaps analyzed, by a client of
+// guru, such as a coverage tool or a custom analyzer, against which it
+// may run queries of any mode without loading the program again.
+//
+// Queries of a Program consider only its packages: its initial
+// packages are the analysis scope, and the Scope of each query is
+// ignored.  The exception is referrers of exported objects, which, as
+// usual, loads the packages of the workspace that use them.
+type Program struct {
+	lprog  *loader.Program
+	prog   *ssa.Program
+	ptares *pointer.Result
+
+	mu sync.Mutex // serializes queries
+	a  *analysis  // shared whole-program analysis; nil until needed
+}
+
+// NewProgram returns a Program for the loaded program lprog, which
+// must have been loaded with the syntax and type information of the
+// function bodies of the packages that queries concern.
+//
+// prog, if non-nil, is the SSA form of lprog, as created by
+// ssautil.CreateProgram; it need not be built.  The allocs, callees,
+// effects, lockers, peers, pointsto, shared and whicherrs queries need
+// it to be in ssa.GlobalDebug mode.  If prog is nil, the SSA form is created as
+// needed.
+//
+// ptares, if non-nil, is the result of a pointer analysis of prog,
+// whose call graph, if any, is used by the queries of the call graph
+// in place of a second analysis.  Other pointer-analysis queries run
+// the analysis afresh.
+func NewProgram(lprog *loader.Program, prog *ssa.Program, ptares *pointer.Result) *Program {
+	if ptares != nil && prog == nil {
+		panic("NewProgram: pointer analysis result without SSA program")
+	}
+	return &Program{lprog: lprog, prog: prog, ptares: ptares}
+}
+
+// Run runs the query mode against the program, in the manner of the
+// package-level Run function.  Pos and Output are required, and Build
+// must locate the package of the query position; the loading options
+// of q, and its Scope, Cache and ExportData, are ignored.
+func (p *Program) Run(mode string, q *Query) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	q2 := *q
+	q2.Cache = ""
+	q2.ExportData = false
+	q2.program = p
+	return Run(mode, &q2)
+}
+
+// analysis returns the whole-program analysis of p, on behalf of the
+// query q.
+func (p *Program) analysis(q *Query) *analysis {
+	if p.a == nil {
+		p.a = &analysis{lprog: p.lprog, mode: wholeProgramMode, prog: p.prog}
+		if p.ptares != nil && p.ptares.CallGraph != nil {
+			p.a.cg = copyGraph(p.ptares.CallGraph)
+			p.a.cg.DeleteSyntheticNodes()
+			sortCallGraph(p.a.cg)
+		}
+	}
+	p.a.q = q
+	return p.a
+}
+
+// copyGraph returns a copy of the call graph g, which the caller may
+// modify without affecting g.
+func copyGraph(g *callgraph.Graph) *callgraph.Graph {
+	cg := callgraph.New(g.Root.Func)
+	for _, n := range g.Nodes {
+		caller := cg.CreateNode(n.Func)
+		for _, e := range n.Out {
+			callgraph.AddEdge(caller, e.Site, cg.CreateNode(e.Callee.Func))
+		}
+	}
+	return cg
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"bytes"
@@ -31,8 +31,9 @@ import (
 // as the queried identifier, within any package in the workspace.
 func referrers(q *Query) error {
 	fset := token.NewFileSet()
-	lconf := loader.Config{Fset: fset, Build: q.Build}
+	lconf := loader.Config{Fset: fset, Build: q.Build, Parallelism: q.Parallelism}
 	allowErrors(&lconf)
+	cancelHook(q, &lconf)
 
 	if _, err := importQueryPackage(q.Pos, &lconf); err != nil {
 		return err
@@ -45,10 +46,16 @@ func referrers(q *Query) error {
 	}
 
 	// Load/parse/type-check the query package.
-	lprog, err := lconf.Load()
-	if err != nil {
+	cache := newPkgCache(q, &lconf)
+	lprog, err := load(q, &lconf)
+	if err := canceled(q); err != nil {
 		return err
 	}
+	if err != nil {
+		return withCode(codeLoad, err)
+	}
+	cache.save(lprog)
+	fset = lprog.Fset
 
 	qpos, err := parseQueryPos(lprog, q.Pos, false)
 	if err != nil {
@@ -80,10 +87,13 @@ func referrers(q *Query) error {
 		return fmt.Errorf("references to predeclared %q are everywhere!", obj.Name())
 	}
 
+	decl := declIdent(q.Build, fset, obj)
 	q.Output(fset, &referrersInitialResult{
 		qinfo: qpos.info,
 		obj:   obj,
+		decl:  decl,
 	})
+	objposn := objectPosOf(fset, obj, decl)
 
 	// For a globally accessible object defined in package P, we
 	// must load packages that depend on P.  Specifically, for a
@@ -93,10 +103,9 @@ func referrers(q *Query) error {
 
 	if global, pkglevel := classify(obj); global {
 		if pkglevel {
-			return globalReferrersPkgLevel(q, obj, fset)
+			return globalReferrersPkgLevel(q, obj, objposn, fset)
 		}
 		// We'll use the the object's position to identify it in the larger program.
-		objposn := fset.Position(obj.Pos())
 		defpkg := obj.Pkg().Path() // defining package
 		return globalReferrers(q, qpos.info.Pkg.Path(), defpkg, objposn)
 	}
@@ -137,19 +146,24 @@ func packageReferrers(q *Query, path string) error {
 	// Load the larger program.
 	fset := token.NewFileSet()
 	lconf := loader.Config{
-		Fset:  fset,
-		Build: q.Build,
+		Fset:        fset,
+		Build:       q.Build,
+		Parallelism: q.Parallelism,
 		TypeCheckFuncBodies: func(p string) bool {
 			return users[strings.TrimSuffix(p, "_test")]
 		},
 	}
 	allowErrors(&lconf)
+	cancelHook(q, &lconf)
 
 	// The importgraph doesn't treat external test packages
-	// as separate nodes, so we must use ImportWithTests.
+	// as separate nodes, so we must use ImportWithTests,
+	// unless tests are excluded.
 	for path := range users {
-		lconf.ImportWithTests(path)
+		importPackage(&lconf, path, !q.NoTests)
 	}
+	q, order := orderPackages(q, typeCheckParts(q.Build, users, func(string) bool { return !q.NoTests }))
+	defer order.close()
 
 	// Subtle!  AfterTypeCheck needs no mutex for qpkg because the
 	// topological import order gives us the necessary happens-before edges.
@@ -186,12 +200,16 @@ func packageReferrers(q *Query, path string) error {
 				}
 			}
 			outputUses(q, fset, refs, info.Pkg)
+			order.done(info.Pkg.Path())
 		}
 
 		clearInfoFields(info) // save memory
 	}
 
 	lconf.Load() // ignore error
+	if err := canceled(q); err != nil {
+		return err
+	}
 
 	if qpkg == nil {
 		log.Fatalf("query package %q not found during reloading", path)
@@ -226,31 +244,12 @@ func outputUses(q *Query, fset *token.FileSet, refs []*ast.Ident, pkg *types.Pac
 // globalReferrers reports references throughout the entire workspace to the
 // object (a field or method) at the specified source position.
 // Its defining package is defpkg, and the query package is qpkg.
-func globalReferrers(q *Query, qpkg, defpkg string, objposn token.Position) error {
-	// Scan the workspace and build the import graph.
-	// Ignore broken packages.
-	_, rev, _ := importgraph.Build(q.Build)
-
-	// Find the set of packages that depend on defpkg.
-	// Only function bodies in those packages need type-checking.
-	users := rev.Search(defpkg) // transitive importers
-
+func globalReferrers(q *Query, qpkg, defpkg string, objposn objectPos) error {
 	// Prepare to load the larger program.
-	fset := token.NewFileSet()
-	lconf := loader.Config{
-		Fset:  fset,
-		Build: q.Build,
-		TypeCheckFuncBodies: func(p string) bool {
-			return users[strings.TrimSuffix(p, "_test")]
-		},
-	}
-	allowErrors(&lconf)
-
-	// The importgraph doesn't treat external test packages
-	// as separate nodes, so we must use ImportWithTests.
-	for path := range users {
-		lconf.ImportWithTests(path)
-	}
+	lconf, users, tests := importersConfig(q, qpkg, defpkg)
+	fset := lconf.Fset
+	q, order := orderPackages(q, typeCheckParts(q.Build, users, tests))
+	defer order.close()
 
 	// The remainder of this function is somewhat tricky because it
 	// operates on the concurrent stream of packages observed by the
@@ -288,7 +287,7 @@ func globalReferrers(q *Query, qpkg, defpkg string, objposn token.Position) erro
 					// It really ought to be there;
 					// we found it once already.
 					log.Fatalf("object at %s not found in package %s",
-						objposn, defpkg)
+						objposn.posn, defpkg)
 				}
 			}
 			obj := qobj
@@ -298,12 +297,16 @@ func globalReferrers(q *Query, qpkg, defpkg string, objposn token.Position) erro
 			if obj != nil {
 				outputUses(q, fset, usesOf(obj, info), info.Pkg)
 			}
+			order.done(info.Pkg.Path())
 		}
 
 		clearInfoFields(info) // save memory
 	}
 
 	lconf.Load() // ignore error
+	if err := canceled(q); err != nil {
+		return err
+	}
 
 	if qobj == nil {
 		log.Fatal("query object not found during reloading")
@@ -312,9 +315,47 @@ func globalReferrers(q *Query, qpkg, defpkg string, objposn token.Position) erro
 	return nil // success
 }
 
+// importersConfig returns the configuration of a loader of the
+// packages of the workspace that transitively import defpkg, the
+// defining package of the query object, and those packages, its users.
+// Only their function bodies are type-checked.
+//
+// The importgraph doesn't treat external test packages as separate
+// nodes, so each user is imported with its tests, as reported by the
+// returned function, unless tests are excluded.  Even then, the tests
+// of the query package qpkg and of the defining package defpkg are
+// needed to find the object.
+func importersConfig(q *Query, qpkg, defpkg string) (*loader.Config, map[string]bool, func(path string) bool) {
+	// Scan the workspace and build the import graph.
+	// Ignore broken packages.
+	_, rev, _ := importgraph.Build(q.Build)
+	users := rev.Search(strings.TrimSuffix(defpkg, "_test")) // transitive importers
+
+	lconf := &loader.Config{
+		Fset:        token.NewFileSet(),
+		Build:       q.Build,
+		Parallelism: q.Parallelism,
+		TypeCheckFuncBodies: func(p string) bool {
+			return users[strings.TrimSuffix(p, "_test")]
+		},
+	}
+	allowErrors(lconf)
+	cancelHook(q, lconf)
+
+	tests := func(path string) bool {
+		return !q.NoTests ||
+			path == strings.TrimSuffix(qpkg, "_test") ||
+			path == strings.TrimSuffix(defpkg, "_test")
+	}
+	for path := range users {
+		importPackage(lconf, path, tests(path))
+	}
+	return lconf, users, tests
+}
+
 // globalReferrersPkgLevel reports references throughout the entire workspace to the package-level object obj.
 // It assumes that the query object itself has already been reported.
-func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) error {
+func globalReferrersPkgLevel(q *Query, obj types.Object, objpos objectPos, fset *token.FileSet) error {
 	// globalReferrersPkgLevel uses go/ast and friends instead of go/types.
 	// This affords a considerable performance benefit.
 	// It comes at the cost of some code complexity.
@@ -382,8 +423,17 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 	isxtest := strings.HasSuffix(defname, "_test") // indicates whether the query object is defined in an xtest package
 
 	name := obj.Name()
-	namebytes := []byte(name)          // byte slice version of query object name, for early filtering
-	objpos := fset.Position(obj.Pos()) // position of query object, used to prevent re-emitting original decl
+	namebytes := []byte(name) // byte slice version of query object name, for early filtering
+
+	// The files of each package, and of the special defpkg xtest
+	// package, are searched concurrently, but their results are
+	// output in order.
+	parts := make(map[string]int)
+	for u := range users {
+		parts[strings.TrimSuffix(u, "!test")]++
+	}
+	q, order := orderPackages(q, parts)
+	defer order.close()
 
 	sema := make(chan struct{}, 20) // counting semaphore to limit I/O concurrency
 	var wg sync.WaitGroup
@@ -396,6 +446,7 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 
 			uIsXTest := strings.HasSuffix(u, "!test") // indicates whether this package is the special defpkg xtest package
 			u = strings.TrimSuffix(u, "!test")
+			defer order.done(u)
 
 			// Resolve package.
 			sema <- struct{}{} // acquire token
@@ -412,14 +463,19 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 			// we want to only process the files that are
 			// part of that query package;
 			// that set depends on whether the query package itself is an xtest.
+			// The tests of packages other than defpkg are
+			// skipped if tests are excluded.
 			inQueryPkg := u == defpkg && isxtest == uIsXTest
+			tests := !q.NoTests || u == defpkg
 			var files []string
 			if !inQueryPkg || !isxtest {
 				files = append(files, pkg.GoFiles...)
-				files = append(files, pkg.TestGoFiles...)
+				if tests {
+					files = append(files, pkg.TestGoFiles...)
+				}
 				files = append(files, pkg.CgoFiles...) // use raw cgo files, as we're only parsing
 			}
-			if !inQueryPkg || isxtest {
+			if tests && (!inQueryPkg || isxtest) {
 				files = append(files, pkg.XTestGoFiles...)
 			}
 
@@ -435,6 +491,9 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 			buf := new(bytes.Buffer) // reusable buffer for reading files
 
 			for _, file := range files {
+				if canceled(q) != nil {
+					return
+				}
 				if !buildutil.IsAbsPath(q.Build, file) {
 					file = buildutil.JoinPath(q.Build, pkg.Dir, file)
 				}
@@ -540,6 +599,7 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 
 				// Emit any references we found.
 				if len(refs) > 0 {
+					sort.Sort(byNamePos{fset, refs})
 					q.Output(fset, &referrersPackageResult{
 						pkg:   types.NewPackage(pkg.ImportPath, pkg.Name),
 						build: q.Build,
@@ -568,7 +628,7 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 						// Check both that this is a reference to the query object
 						// and that it is not the query object itself;
 						// the query object itself was already emitted.
-						if id.Obj == pkgobj && objpos != fset.Position(id.Pos()) {
+						if id.Obj == pkgobj && !objpos.matches(fset.Position(id.Pos()), id.Name) {
 							refs = append(refs, id)
 							return false
 						}
@@ -576,6 +636,7 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 					return true
 				})
 				if len(refs) > 0 {
+					sort.Sort(byNamePos{fset, refs}) // (the files are a map)
 					q.Output(fset, &referrersPackageResult{
 						pkg:   types.NewPackage(pkg.ImportPath, pkg.Name),
 						build: q.Build,
@@ -590,17 +651,13 @@ func globalReferrersPkgLevel(q *Query, obj types.Object, fset *token.FileSet) er
 
 	wg.Wait()
 
-	return nil
+	return canceled(q)
 }
 
 // findObject returns the object defined at the specified position.
-func findObject(fset *token.FileSet, info *types.Info, objposn token.Position) types.Object {
+func findObject(fset *token.FileSet, info *types.Info, objposn objectPos) types.Object {
 	good := func(obj types.Object) bool {
-		if obj == nil {
-			return false
-		}
-		posn := fset.Position(obj.Pos())
-		return posn.Filename == objposn.Filename && posn.Offset == objposn.Offset
+		return obj != nil && objposn.matches(fset.Position(obj.Pos()), obj.Name())
 	}
 	for _, obj := range info.Defs {
 		if good(obj) {
@@ -615,6 +672,63 @@ func findObject(fset *token.FileSet, info *types.Info, objposn token.Position) t
 	return nil
 }
 
+// An objectPos locates the declaration of an object in the source, by
+// its position, which, for an object of export data (see isLineOnly)
+// not found in its source by declIdent, is known only to the line, on
+// which its name must distinguish it.
+type objectPos struct {
+	posn     token.Position
+	name     string
+	lineOnly bool
+}
+
+// objectPosOf returns the objectPos of obj, whose declaring identifier,
+// if found by declIdent, is decl.
+func objectPosOf(fset *token.FileSet, obj types.Object, decl *ast.Ident) objectPos {
+	if decl != nil {
+		return objectPos{fset.Position(decl.Pos()), obj.Name(), false}
+	}
+	file := fset.File(obj.Pos())
+	return objectPos{fset.Position(obj.Pos()), obj.Name(), file != nil && isLineOnly(file)}
+}
+
+// declIdent returns the identifier that declares obj, if obj is of
+// export data, which locates it only to the line, by parsing its file;
+// or nil, if obj is of source or the identifier cannot be found.
+func declIdent(ctxt *build.Context, fset *token.FileSet, obj types.Object) *ast.Ident {
+	if file := fset.File(obj.Pos()); file == nil || !isLineOnly(file) {
+		return nil
+	}
+	posn := fset.Position(obj.Pos())
+	f, _ := buildutil.ParseFile(fset, ctxt, nil, "", posn.Filename, 0)
+	if f == nil {
+		return nil
+	}
+	var decl *ast.Ident
+	ast.Inspect(f, func(n ast.Node) bool {
+		// A declaring identifier is its ast.Object's, if any.
+		if id, ok := n.(*ast.Ident); ok && id.Name == obj.Name() &&
+			fset.Position(id.Pos()).Line == posn.Line &&
+			(id.Obj == nil || id.Obj.Pos() == id.Pos()) {
+			decl = id
+		}
+		return decl == nil
+	})
+	return decl
+}
+
+// matches reports whether the declaration of the object is that of the
+// identifier name at posn.
+func (p objectPos) matches(posn token.Position, name string) bool {
+	if posn.Filename != p.posn.Filename {
+		return false
+	}
+	if p.lineOnly {
+		return posn.Line == p.posn.Line && name == p.name
+	}
+	return posn.Offset == p.posn.Offset
+}
+
 // same reports whether x and y are identical, or both are PkgNames
 // that import the same Package.
 //
@@ -650,17 +764,120 @@ func clearInfoFields(info *loader.PackageInfo) {
 	info.Selections = nil
 }
 
-// -------- utils --------
+// A packageOrder outputs the results for each package, which are
+// found concurrently, in order of import path, then of position, so
+// that the output of repeated queries is the same.  The results of a
+// package are output as soon as it, and every package before it, is
+// complete, not held back until the whole query is.
+type packageOrder struct {
+	output  func(*token.FileSet, QueryResult)
+	mu      sync.Mutex
+	paths   []string                             // packages not yet output, in order
+	parts   map[string]int                       // number of incomplete parts of each package
+	results map[string][]*referrersPackageResult // results of packages not yet output
+}
 
-// An deterministic ordering for token.Pos that doesn't
-// depend on the order in which packages were loaded.
-func lessPos(fset *token.FileSet, x, y token.Pos) bool {
-	fx := fset.File(x)
-	fy := fset.File(y)
-	if fx != fy {
-		return fx.Name() < fy.Name()
+// orderPackages returns a copy of q whose Output passes the results
+// for each package to a packageOrder, which it also returns.  parts
+// maps the import path of each package to be searched to the number
+// of parts in which it is searched; the caller calls done as each
+// part is complete, and close once the query is.
+func orderPackages(q *Query, parts map[string]int) (*Query, *packageOrder) {
+	o := &packageOrder{
+		output:  q.Output,
+		parts:   parts,
+		results: make(map[string][]*referrersPackageResult),
+	}
+	for path := range parts {
+		o.paths = append(o.paths, path)
+	}
+	sort.Strings(o.paths)
+	q2 := *q
+	q2.Output = func(fset *token.FileSet, qr QueryResult) {
+		r, ok := qr.(*referrersPackageResult)
+		if !ok {
+			o.output(fset, qr)
+			return
+		}
+		o.mu.Lock()
+		o.results[r.pkg.Path()] = append(o.results[r.pkg.Path()], r)
+		o.mu.Unlock()
+	}
+	return &q2, o
+}
+
+// done records that a part of the package path is complete, and
+// outputs the results of the packages that are then complete and
+// preceded only by complete ones.
+func (o *packageOrder) done(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.parts[path]--
+	for len(o.paths) > 0 && o.parts[o.paths[0]] <= 0 {
+		o.outputPackage(o.paths[0])
+		o.paths = o.paths[1:]
+	}
+}
+
+// close outputs the results not yet output, such as those of the
+// packages left incomplete by a canceled query, in order.
+func (o *packageOrder) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, path := range o.paths {
+		o.outputPackage(path)
+	}
+	o.paths = nil
+	// Results of packages not expected, or reported after they
+	// were complete; see typeCheckParts.
+	var rest []string
+	for path := range o.results {
+		rest = append(rest, path)
+	}
+	sort.Strings(rest)
+	for _, path := range rest {
+		o.outputPackage(path)
 	}
-	return x < y
+}
+
+// outputPackage outputs the results of the package path, in order of
+// position.
+func (o *packageOrder) outputPackage(path string) {
+	results := o.results[path]
+	delete(o.results, path)
+	sort.Slice(results, func(i, j int) bool {
+		x, y := results[i], results[j]
+		return lessPos(x.fset, x.refs[0].NamePos, y.refs[0].NamePos)
+	})
+	for _, r := range results {
+		o.output(r.fset, r)
+	}
+}
+
+// typeCheckParts returns the number of times that the loader calls
+// AfterTypeCheck for each package, including each external test
+// package, of the program of the packages users, each imported by
+// importPackage with its tests if tests reports so: once for the
+// package, once more as it is augmented by its in-package tests, even
+// if there are none, and once for its external test package, if any.
+// A package that cannot be found is omitted.
+func typeCheckParts(ctxt *build.Context, users map[string]bool, tests func(path string) bool) map[string]int {
+	cwd, _ := os.Getwd()
+	parts := make(map[string]int)
+	for path := range users {
+		bp, err := ctxt.Import(path, cwd, build.IgnoreVendor)
+		if err != nil {
+			continue
+		}
+		parts[path]++
+		if tests(path) {
+			parts[path]++
+			if len(bp.XTestGoFiles) > 0 {
+				parts[path+"_test"]++
+			}
+		}
+	}
+	return parts
 }
 
 type byNamePos struct {
@@ -676,28 +893,41 @@ func (p byNamePos) Less(i, j int) bool {
 
 // referrersInitialResult is the initial result of a "referrers" query.
 type referrersInitialResult struct {
+	resultSource
 	qinfo *loader.PackageInfo
 	obj   types.Object // object it denotes
+	decl  *ast.Ident   // its declaration in the source, if obj is of export data
 }
 
 func (r *referrersInitialResult) PrintPlain(printf printfFunc) {
-	printf(r.obj, "references to %s",
+	var pos interface{} = r.obj
+	if r.decl != nil {
+		pos = r.decl
+	}
+	printf(pos, "references to %s",
 		types.ObjectString(r.obj, types.RelativeTo(r.qinfo.Pkg)))
 }
 
-func (r *referrersInitialResult) JSON(fset *token.FileSet) []byte {
+func (r *referrersInitialResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *referrersInitialResult) serialize(s *serializer) interface{} {
 	var objpos string
-	if pos := r.obj.Pos(); pos.IsValid() {
-		objpos = fset.Position(pos).String()
+	if r.decl != nil {
+		objpos = s.pos(r.decl.Pos())
+	} else if pos := r.obj.Pos(); pos.IsValid() {
+		objpos = s.pos(pos)
+	}
+	return &serial.ReferrersInitial{
+		Version: serial.Version,
+		Desc:    r.obj.String(),
+		ObjPos:  objpos,
+		Offsets: s.offsets(),
 	}
-	return toJSON(&serial.ReferrersInitial{
-		Desc:   r.obj.String(),
-		ObjPos: objpos,
-	})
 }
 
 // referrersPackageResult is the streaming result for one package of a "referrers" query.
 type referrersPackageResult struct {
+	resultSource
 	pkg   *types.Package
 	build *build.Context
 	fset  *token.FileSet
@@ -719,7 +949,7 @@ func (r *referrersPackageResult) foreachRef(f func(id *ast.Ident, text string))
 	// First pass: start the file reads concurrently.
 	sema := make(chan struct{}, 20) // counting semaphore to limit I/O concurrency
 	for _, ref := range r.refs {
-		posn := r.fset.Position(ref.Pos())
+		posn := r.src.position(r.fset, ref.Pos())
 		fi := fileinfosByName[posn.Filename]
 		if fi == nil {
 			fi = &fileinfo{data: make(chan interface{})}
@@ -784,19 +1014,46 @@ func readFile(ctxt *build.Context, filename string, buf *bytes.Buffer) ([]byte,
 	return buf.Bytes(), nil
 }
 
+func (r *referrersPackageResult) len() int { return len(r.refs) }
+
+func (r *referrersPackageResult) slice(i, j int) QueryResult {
+	r2 := *r
+	r2.refs = r.refs[i:j]
+	return &r2
+}
+
 func (r *referrersPackageResult) PrintPlain(printf printfFunc) {
 	r.foreachRef(func(id *ast.Ident, text string) {
 		printf(id, "%s", text)
 	})
 }
 
-func (r *referrersPackageResult) JSON(fset *token.FileSet) []byte {
-	refs := serial.ReferrersPackage{Package: r.pkg.Path()}
+func (r *referrersPackageResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *referrersPackageResult) serialize(s *serializer) interface{} {
+	refs := serial.ReferrersPackage{Version: serial.Version, Package: r.pkg.Path()}
 	r.foreachRef(func(id *ast.Ident, text string) {
 		refs.Refs = append(refs.Refs, serial.Ref{
-			Pos:  fset.Position(id.NamePos).String(),
+			Pos:  s.pos(id.NamePos),
 			Text: text,
 		})
 	})
-	return toJSON(refs)
+	refs.Offsets = s.offsets()
+	return &refs
+}
+
+// elements emits each reference as a ReferrersPackage of its own.
+func (r *referrersPackageResult) elements(s *serializer, emit func(elem interface{}) error) error {
+	var err error
+	r.foreachRef(func(id *ast.Ident, text string) {
+		if err == nil {
+			err = emit(&serial.ReferrersPackage{
+				Version: serial.Version,
+				Package: r.pkg.Path(),
+				Refs:    []serial.Ref{{Pos: s.pos(id.NamePos), Text: text}},
+				Offsets: s.offsets(),
+			})
+		}
+	})
+	return err
 }
@@ -0,0 +1,493 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// renameCheck reports, for the object denoted by the selected
+// identifier, what a tool that renames it must take into account:
+// whether it is exported, all its references, the names to which it
+// cannot be renamed, and the interface satisfactions that depend on
+// its name.
+//
+// The names to which it cannot be renamed are those already declared
+// in its block, or, for a field or method, by its type; those that
+// would shadow it at one of its references, being declared in a
+// block between the reference and its own; and those declared outside
+// its block but referred to within it, whose references the renamed
+// object would capture.  Only references within its package may be
+// shadowed or captured, as any others are qualified.
+//
+// For an exported package-level object, field or method, the program
+// includes every package that transitively imports its package, found
+// and loaded as for a global referrers query (see importersConfig).
+//
+// The checks are those of gorename (golang.org/x/tools/refactor/rename),
+// but made for every name at once: its renamer checks a single new
+// name, reporting each conflict through a package-level hook and then
+// failing, and is not exported, so it cannot be used here.
+func renameCheck(q *Query) error {
+	lconf := loader.Config{Build: q.Build, Parallelism: q.Parallelism}
+	allowErrors(&lconf)
+	cancelHook(q, &lconf)
+
+	qpkg, err := importQueryPackage(q.Pos, &lconf)
+	if err != nil {
+		return err
+	}
+
+	// Load tests of the query package
+	// even if the query location is not in the tests.
+	for path := range lconf.ImportPkgs {
+		lconf.ImportPkgs[path] = true
+	}
+
+	lprog, err := load(q, &lconf)
+	if err := canceled(q); err != nil {
+		return err
+	}
+	if err != nil {
+		return withCode(codeLoad, err)
+	}
+
+	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	if err != nil {
+		return err
+	}
+	obj, err := renameObject(qpos)
+	if err != nil {
+		return err
+	}
+
+	// An exported object may be referred to by any package that
+	// depends on its own, so load them all, and find it again.
+	if global, _ := classify(obj); global && q.program == nil {
+		lprog, err = loadImporters(q, qpkg, obj.Pkg().Path())
+		if err != nil {
+			return err
+		}
+		if qpos, err = parseQueryPos(lprog, q.Pos, false); err != nil {
+			return err
+		}
+		if obj, err = renameObject(qpos); err != nil {
+			return err
+		}
+	}
+
+	res := &renameCheckResult{
+		qpos:      qpos,
+		obj:       obj,
+		refs:      renameRefs(lprog, obj),
+		satisfies: renameSatisfactions(lprog, obj),
+	}
+	res.conflicts = renameConflicts(lprog, obj, res.refs)
+
+	q.Output(lprog.Fset, res)
+	return nil
+}
+
+// renameObject returns the object denoted by the identifier at qpos.
+func renameObject(qpos *queryPos) (types.Object, error) {
+	id, _ := qpos.path[0].(*ast.Ident)
+	if id == nil {
+		return nil, fmt.Errorf("no identifier here")
+	}
+	obj := qpos.info.ObjectOf(id)
+	if obj == nil {
+		// Happens for y in "switch y := x.(type)",
+		// the package declaration,
+		// and unresolved identifiers.
+		return nil, fmt.Errorf("no object for identifier")
+	}
+	if obj.Pkg() == nil {
+		return nil, fmt.Errorf("cannot rename predeclared %q", obj.Name())
+	}
+	return obj, nil
+}
+
+// loadImporters loads the package defpkg and all the packages that
+// transitively import it, as a global referrers query does, except
+// that each package is retained in full rather than scanned as it is
+// type-checked: the conflicts and satisfactions of the object depend
+// on the whole program.
+func loadImporters(q *Query, qpkg, defpkg string) (*loader.Program, error) {
+	lconf, _, _ := importersConfig(q, qpkg, defpkg)
+	lprog, err := lconf.Load()
+	if err := canceled(q); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, withCode(codeLoad, err)
+	}
+	return lprog, nil
+}
+
+// renameRefs returns the references to obj throughout lprog, in order.
+// Unlike in a referrers query, only those of the same PkgName refer to
+// an imported package name, as each file declares its own.
+func renameRefs(lprog *loader.Program, obj types.Object) []*ast.Ident {
+	var refs []*ast.Ident
+	for _, info := range lprog.AllPackages {
+		for id, o := range info.Uses {
+			if o == obj {
+				refs = append(refs, id)
+			}
+		}
+	}
+	sort.Sort(byNamePos{lprog.Fset, refs})
+	return refs
+}
+
+// renameConflicts returns the names to which obj cannot be renamed:
+// those declared in its block or by its type, those that would shadow
+// refs, its references, and those whose references it would capture.
+func renameConflicts(lprog *loader.Program, obj types.Object, refs []*ast.Ident) []renameConflict {
+	var conflicts []renameConflict
+	add := func(ref *ast.Ident, reason string, names map[string]bool) {
+		delete(names, obj.Name())
+		delete(names, "_")
+		if len(names) == 0 {
+			return
+		}
+		c := renameConflict{ref: ref, reason: reason}
+		for name := range names {
+			c.names = append(c.names, name)
+		}
+		sort.Strings(c.names)
+		conflicts = append(conflicts, c)
+	}
+
+	block := obj.Parent()
+	if block == nil {
+		// A field or method.
+		add(nil, "declared", memberNames(lprog, obj))
+		return conflicts
+	}
+
+	// Names already declared in its block.  A package-level object
+	// conflicts with the imports of each file, and an import with
+	// the package-level objects.
+	declared := make(map[string]bool)
+	addNames := func(s *types.Scope) {
+		for _, name := range s.Names() {
+			declared[name] = true
+		}
+	}
+	addNames(block)
+	pkgScope := obj.Pkg().Scope()
+	switch {
+	case block == pkgScope:
+		for i := 0; i < block.NumChildren(); i++ {
+			addNames(block.Child(i))
+		}
+	case block.Parent() == pkgScope: // a file block
+		addNames(pkgScope)
+	}
+	add(nil, "declared", declared)
+
+	info := lprog.AllPackages[obj.Pkg()]
+	if info == nil {
+		return conflicts
+	}
+
+	// Names that would shadow its references: those declared in
+	// the blocks between a reference and its own block, and visible
+	// at the reference.  They are reported once per innermost block,
+	// at its first reference.
+	var inners []*types.Scope
+	shadows := make(map[*types.Scope]map[string]bool)
+	firstRef := make(map[*types.Scope]*ast.Ident)
+	for _, ref := range refs {
+		inner := pkgScope.Innermost(ref.Pos())
+		if inner == nil {
+			continue // in another package
+		}
+		names := shadows[inner]
+		if names == nil {
+			names = make(map[string]bool)
+			inners = append(inners, inner)
+			shadows[inner] = names
+			firstRef[inner] = ref
+		}
+		for s := inner; s != nil && s != block; s = s.Parent() {
+			for _, name := range s.Names() {
+				if scope, _ := inner.LookupParent(name, ref.Pos()); scope == s {
+					names[name] = true
+				}
+			}
+		}
+	}
+	for _, inner := range inners {
+		add(firstRef[inner], "shadows", shadows[inner])
+	}
+
+	// Names whose references it would capture: those of its block's
+	// references to objects declared in enclosing blocks, at which
+	// it is visible, and so would be found first.
+	encloses := func(s *types.Scope) bool {
+		for b := block.Parent(); b != nil; b = b.Parent() {
+			if b == s {
+				return true
+			}
+		}
+		return false
+	}
+	captured := make(map[string]bool)
+	for id, o := range info.Uses {
+		if o.Parent() == nil || !encloses(o.Parent()) {
+			continue
+		}
+		if inner := pkgScope.Innermost(id.Pos()); inner != nil {
+			if _, found := inner.LookupParent(obj.Name(), id.Pos()); found == obj {
+				captured[o.Name()] = true
+			}
+		}
+	}
+	add(nil, "captures", captured)
+
+	return conflicts
+}
+
+// memberNames returns the names of the other fields and methods of
+// the type that declares the field or method obj, as far as it is
+// known.
+func memberNames(lprog *loader.Program, obj types.Object) map[string]bool {
+	names := make(map[string]bool)
+	addMethods := func(T types.Type) {
+		mset := types.NewMethodSet(T)
+		for i := 0; i < mset.Len(); i++ {
+			names[mset.At(i).Obj().Name()] = true
+		}
+	}
+	addFields := func(T types.Type) {
+		if s, ok := T.Underlying().(*types.Struct); ok {
+			for i := 0; i < s.NumFields(); i++ {
+				names[s.Field(i).Name()] = true
+			}
+		}
+	}
+
+	if fn, ok := obj.(*types.Func); ok {
+		recv := fn.Type().(*types.Signature).Recv().Type()
+		if isInterface(recv) {
+			addMethods(recv)
+		} else {
+			T := deref(recv)
+			addMethods(types.NewPointer(T))
+			addFields(T)
+		}
+		return names
+	}
+
+	// Find the struct type that declares the field,
+	// and its named type, if any.
+	_, path, _ := lprog.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	for i, n := range path {
+		if n, ok := n.(*ast.StructType); ok {
+			info, _, _ := lprog.PathEnclosingInterval(n.Pos(), n.Pos())
+			if T := info.TypeOf(n); T != nil {
+				addFields(T)
+			}
+			if i+1 < len(path) {
+				if spec, ok := path[i+1].(*ast.TypeSpec); ok {
+					if def := info.Defs[spec.Name]; def != nil {
+						addMethods(types.NewPointer(def.Type()))
+					}
+				}
+			}
+			break
+		}
+	}
+	return names
+}
+
+// renameSatisfactions returns, for a method obj, the pairs of the
+// program's named types, and the pointers to them, and the interfaces
+// they implement, where each has a method of its name: renaming the
+// one requires renaming the other.
+func renameSatisfactions(lprog *loader.Program, obj types.Object) []renameSatisfaction {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil // a function
+	}
+
+	var msets typeutil.MethodSetCache
+	hasMethod := func(T types.Type) bool {
+		return msets.MethodSet(T).Lookup(obj.Pkg(), obj.Name()) != nil
+	}
+
+	// implementer returns T or *T, for a
+	// named concrete type T, if it implements I.
+	implementer := func(T, I types.Type) types.Type {
+		for _, T := range []types.Type{T, types.NewPointer(T)} {
+			if types.AssignableTo(T, I) {
+				return T
+			}
+		}
+		return nil
+	}
+
+	var res []renameSatisfaction
+	R := recv.Type()
+	for _, U := range allNamedTypes(lprog) {
+		if types.Identical(U, deref(R)) {
+			continue
+		}
+		switch {
+		case isInterface(R) && !isInterface(U):
+			if T := implementer(U, R); T != nil {
+				res = append(res, renameSatisfaction{T, R})
+			}
+		case isInterface(R):
+			if !hasMethod(U) {
+				continue
+			}
+			if types.AssignableTo(U, R) {
+				res = append(res, renameSatisfaction{U, R})
+			} else if types.AssignableTo(R, U) {
+				res = append(res, renameSatisfaction{R, U})
+			}
+		case isInterface(U) && hasMethod(U):
+			if T := implementer(deref(R), U); T != nil {
+				res = append(res, renameSatisfaction{T, U})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		x, y := res[i], res[j]
+		if !types.Identical(x.T, y.T) {
+			return lessType(lprog.Fset, x.T, y.T)
+		}
+		return lessType(lprog.Fset, x.I, y.I)
+	})
+	return res
+}
+
+type renameCheckResult struct {
+	resultSource
+	qpos      *queryPos
+	obj       types.Object         // the object to rename
+	refs      []*ast.Ident         // its references, in order
+	conflicts []renameConflict     // names to which it cannot be renamed
+	satisfies []renameSatisfaction // satisfactions that depend on its name
+}
+
+// A renameConflict is a set of names to which an object cannot be
+// renamed, for the same reason.
+type renameConflict struct {
+	ref    *ast.Ident // the reference the names would shadow, if any
+	reason string     // "declared", "shadows" or "captures"
+	names  []string   // sorted
+}
+
+// A renameSatisfaction records that T implements the interface I.
+type renameSatisfaction struct {
+	T, I types.Type
+}
+
+func (r *renameCheckResult) PrintPlain(printf printfFunc) {
+	desc := r.qpos.objectString(r.obj)
+	if r.obj.Exported() {
+		printf(r.obj, "%s is exported", desc)
+	} else {
+		printf(r.obj, "%s is not exported", desc)
+	}
+
+	switch len(r.refs) {
+	case 0:
+		printf(r.obj, "It has no references.")
+	case 1:
+		printf(r.obj, "It has 1 reference:")
+	default:
+		printf(r.obj, "It has %d references:", len(r.refs))
+	}
+	for _, ref := range r.refs {
+		printf(ref, "\treferenced here")
+	}
+
+	if len(r.conflicts) == 0 {
+		printf(r.obj, "No names conflict with it.")
+	} else {
+		printf(r.obj, "It cannot be renamed to:")
+	}
+	for _, c := range r.conflicts {
+		var pos interface{} = r.obj
+		if c.ref != nil {
+			pos = c.ref
+		}
+		names := strings.Join(c.names, ", ")
+		switch c.reason {
+		case "declared":
+			if r.obj.Parent() == nil {
+				printf(pos, "\t%s, already declared by its type", names)
+			} else {
+				printf(pos, "\t%s, already declared in its block", names)
+			}
+		case "shadows":
+			printf(pos, "\t%s, which would shadow it here", names)
+		case "captures":
+			printf(pos, "\t%s, whose references in its block it would capture", names)
+		}
+	}
+
+	if len(r.satisfies) > 0 {
+		printf(r.obj, "These interface satisfactions depend on its name:")
+	}
+	for _, s := range r.satisfies {
+		var pos interface{} = r.obj
+		if nt, ok := deref(s.T).(*types.Named); ok {
+			pos = nt.Obj()
+		}
+		printf(pos, "\t%s implements %s", r.qpos.typeString(s.T), r.qpos.typeString(s.I))
+	}
+}
+
+func (r *renameCheckResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *renameCheckResult) serialize(s *serializer) interface{} {
+	res := &serial.RenameCheck{
+		Version:  serial.Version,
+		ObjPos:   s.pos(r.obj.Pos()),
+		Desc:     r.obj.String(),
+		Exported: r.obj.Exported(),
+	}
+	for _, ref := range r.refs {
+		res.Refs = append(res.Refs, s.pos(ref.Pos()))
+	}
+	for _, c := range r.conflicts {
+		pos := r.obj.Pos()
+		if c.ref != nil {
+			pos = c.ref.Pos()
+		}
+		res.Conflicts = append(res.Conflicts, serial.RenameConflict{
+			Pos:    s.pos(pos),
+			Reason: c.reason,
+			Names:  c.names,
+		})
+	}
+	for _, sat := range r.satisfies {
+		res.Satisfies = append(res.Satisfies, serial.RenameSatisfaction{
+			Type:      makeImplementsType(sat.T, s),
+			Interface: makeImplementsType(sat.I, s),
+		})
+	}
+	res.Offsets = s.offsets()
+	return res
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the conversion of query results to their serial
+// form, the values of package serial that their JSON encodes.
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"unicode/utf8"
+
+	"golang.org/x/tools/cmd/guru/serial"
+)
+
+// A serialResult is a QueryResult that has a serial form, from which
+// its JSON method, and the output formats, obtain its JSON.
+type serialResult interface {
+	QueryResult
+
+	// serialize returns the serial form of the result, a value of
+	// package serial or a slice of them, converting its positions
+	// using s.
+	serialize(s *serializer) interface{}
+}
+
+// A serialStream is the serial form of a result that is a stream of
+// objects, such as the FreeVars and ExtractSignature of freevars.
+// Its JSON is that of each object in turn, one after another.
+type serialStream []interface{}
+
+// serialJSON returns the JSON of the serial form of r, whose positions
+// are in fset.
+func serialJSON(fset *token.FileSet, r serialResult) []byte {
+	return streamJSON(r.serialize(newSerializer(fset, sourceOf(r), nil)))
+}
+
+// streamJSON returns the JSON of the serial form v, which may be a
+// serialStream.
+func streamJSON(v interface{}) []byte {
+	stream, ok := v.(serialStream)
+	if !ok {
+		return toJSON(v)
+	}
+	var buf bytes.Buffer
+	for i, x := range stream {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.Write(toJSON(x))
+	}
+	return buf.Bytes()
+}
+
+// serialObjects returns the objects of the serial form v of a result:
+// those of a serialStream, the elements of a slice, or v itself.
+func serialObjects(v interface{}) []interface{} {
+	if stream, ok := v.(serialStream); ok {
+		return stream
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		objects := make([]interface{}, rv.Len())
+		for i := range objects {
+			objects[i] = rv.Index(i).Addr().Interface()
+		}
+		return objects
+	}
+	return []interface{}{v}
+}
+
+// A serializer converts the positions of a result to the position
+// strings "file:line:col" of its serial form, in the form specified
+// by its options, and records the Offset of each, in its file as read
+// from src, for the object that contains it (see serial.Offset).
+type serializer struct {
+	fset  *token.FileSet
+	src   *fileSource
+	runes bool   // columns are in runes, not bytes
+	root  string // if nonempty, file names within this directory are relative to it
+
+	pending []serial.Offset // of the positions converted since the last call of offsets
+	seen    map[string]bool // position strings of pending
+}
+
+// newSerializer returns a serializer of positions in fset, in the
+// form specified by opts, if non-nil, or by default, the absolute
+// file names and byte columns of QueryResult.JSON.
+func newSerializer(fset *token.FileSet, src *fileSource, opts *FormatOptions) *serializer {
+	s := &serializer{fset: fset, src: src, seen: make(map[string]bool)}
+	if opts != nil {
+		s.runes = opts.Runes
+		s.root = opts.Root
+	}
+	return s
+}
+
+// pos returns the position string of pos.
+func (s *serializer) pos(pos token.Pos) string {
+	str, offset, ok := s.convert(pos)
+	if ok && !s.seen[str] {
+		s.seen[str] = true
+		s.pending = append(s.pending, offset)
+	}
+	return str
+}
+
+// posString is like pos, but records no Offset, for the output formats
+// that have none, such as -format=dot.
+func (s *serializer) posString(pos token.Pos) string {
+	str, _, _ := s.convert(pos)
+	return str
+}
+
+// offsets returns the Offsets of the positions converted since its
+// previous call, those of the object that contains them, and begins
+// those of the next.
+func (s *serializer) offsets() []serial.Offset {
+	offsets := s.pending
+	s.pending = nil
+	s.seen = make(map[string]bool)
+	return offsets
+}
+
+// convert returns the position string of pos, and its Offset, or false
+// if its file cannot be read or has no such position.  If s.runes, the
+// column is the Offset's RuneCol, unless the file cannot be read.
+func (s *serializer) convert(pos token.Pos) (string, serial.Offset, bool) {
+	posn := s.src.position(s.fset, pos)
+	if !posn.IsValid() {
+		return posn.String(), serial.Offset{}, false
+	}
+	offset, ok := s.offset(pos, posn)
+	if ok && s.runes {
+		posn.Column = offset.RuneCol
+	}
+	if s.root != "" {
+		posn.Filename = relativePath(s.root, posn.Filename)
+	}
+	offset.Pos = posn.String()
+	return offset.Pos, offset, ok
+}
+
+// offset returns the Offset of posn, the position of pos, in its file
+// as read from src, but for its Pos, or false if the file cannot be
+// read or has no such position.
+func (s *serializer) offset(pos token.Pos, posn token.Position) (serial.Offset, bool) {
+	data := s.src.content(posn.Filename)
+	if data == nil {
+		return serial.Offset{}, false
+	}
+	start, ok := sourceOffset(s.fset, pos, posn, data)
+	if !ok {
+		return serial.Offset{}, false
+	}
+	end := start + tokenLen(data[start:])
+	if isLineOnly(s.fset.File(pos)) {
+		end = start // the position is only that of the line
+	}
+	lineStart := bytes.LastIndexByte(data[:start], '\n') + 1
+	return serial.Offset{
+		Start:   start,
+		End:     end,
+		RuneCol: utf8.RuneCount(data[lineStart:start]) + 1,
+	}, true
+}
+
+// lineOffset returns the byte offset of the 1-based line and byte
+// column within data, or false if there is no such line.
+func lineOffset(data []byte, line, col int) (int, bool) {
+	if line < 1 || col < 1 {
+		return 0, false
+	}
+	var offset int
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(data[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	return offset + col - 1, true
+}
+
+// tokenLen returns the length of the Go token at the start of src.
+func tokenLen(src []byte) int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	pos, tok, lit := s.Scan()
+	switch {
+	case tok == token.EOF || file.Offset(pos) > 0:
+		return 0 // not at a token
+	case tok == token.SEMICOLON && lit == "\n":
+		return 0 // implicit semicolon
+	case lit != "":
+		return len(lit)
+	}
+	return len(tok.String())
+}
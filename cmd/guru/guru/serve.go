@@ -0,0 +1,370 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the guru server, which loads and analyzes a
+// program once and then answers queries about it using JSON-RPC.
+// See golang.org/x/tools/cmd/guru/client for the protocol.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/client"
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/loader"
+)
+
+// A Server holds the state of a whole-program analysis---the loaded
+// program, its SSA form, and the pointer-analysis call graph---for
+// reuse across many queries.  A client creates one with NewServer,
+// paying the cost of loading once, then calls Query or Run for each
+// query, or serves queries over the network using Serve.
+//
+// Queries that do not require whole-program analysis (such as
+// describe or referrers) use the loaded program too, in the manner of
+// a Program, if their positions are within it, and otherwise load the
+// packages they need afresh each time.
+//
+// Before each query, the server checks whether any file or directory
+// of the loaded program has changed, and if so, re-loads it,
+// type-checking only the changed packages and those that depend on
+// them.  The SSA form and pointer analysis are recomputed for the
+// whole program, since an ssa.Program cannot be updated in place.
+type Server struct {
+	q Query // template for queries; Pos and Output are ignored
+
+	mu     sync.Mutex           // serializes queries
+	a      *analysis            // shared whole-program analysis
+	stamps map[string]fileStamp // state of a's files and directories
+	tests  map[string]bool      // packages imported with their tests for queries in their _test.go files
+}
+
+// A fileStamp records the modification state of a file or directory.
+// Adding or removing a file changes the stamp of its directory.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stat(name string) fileStamp {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{} // e.g. deleted, or a file of a virtual build.Context
+	}
+	return fileStamp{fi.ModTime(), fi.Size()}
+}
+
+// NewServer loads and type-checks the program specified by q's
+// analysis scope, and constructs its SSA form.
+// Build, Roots, Overlay, Cache, ExportData, NoTests, Parallelism,
+// Scope, PTA, Progress, MaxMemory, MaxResults, PhysicalPositions and
+// Context are the only fields of q used; Context cancels only the construction of the
+// server (see RunContext).
+func NewServer(q *Query) (*Server, error) {
+	s := &Server{q: *addRoots(q), tests: make(map[string]bool)}
+	s.q.Pos = ""
+	s.q.Output = nil
+	defer func() { s.q.Context = nil }()
+
+	// Build SSA in the mode required by all whole-program queries.
+	a, err := loadAnalysis(&s.q, wholeProgramMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+	a.buildSSA()
+	if err := canceled(&s.q); err != nil {
+		return nil, err
+	}
+	s.a = a
+	s.stamps = stampFiles(a.lprog)
+	return s, nil
+}
+
+// stampFiles returns the current stamps of the files of each package
+// of lprog, and of their directories.
+func stampFiles(lprog *loader.Program) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, info := range lprog.AllPackages {
+		for _, f := range info.Files {
+			name := lprog.Fset.File(f.Pos()).Name()
+			stamps[name] = stat(name)
+			if dir := filepath.Dir(name); stamps[dir] == (fileStamp{}) {
+				stamps[dir] = stat(dir)
+			}
+		}
+	}
+	return stamps
+}
+
+// modified reports whether the named file, or the directory
+// containing it, has changed since its stamp was recorded.
+func (s *Server) modified(name string) bool {
+	dir := filepath.Dir(name)
+	return stat(name) != s.stamps[name] || stat(dir) != s.stamps[dir]
+}
+
+// reload re-loads the program if any of its files or directories have
+// changed since it was loaded.  Each importable package unaffected by
+// the changes is reused as is; the others, and all packages created
+// from a list of files, are loaded afresh.
+//
+// It also re-loads the program if tests, the import paths of the
+// packages whose _test.go files contain query positions, names one
+// not yet imported with its tests; thereafter, it always is, so that
+// queries in those files find them.
+//
+// The reload is on behalf of the query q, which may cancel it.
+// On failure, the previous analysis is retained.
+func (s *Server) reload(q *Query, tests []string) error {
+	lprog := s.a.lprog
+
+	var added []string
+	for _, path := range tests {
+		if !s.tests[path] {
+			added = append(added, path)
+		}
+	}
+
+	// Find the packages with changed files, or new tests.
+	changed := make(map[*types.Package]bool)
+	for _, info := range lprog.AllPackages {
+		for _, path := range added {
+			if info.Pkg.Path() == path {
+				changed[info.Pkg] = true
+			}
+		}
+		for _, f := range info.Files {
+			name := lprog.Fset.File(f.Pos()).Name()
+			if s.modified(name) {
+				changed[info.Pkg] = true
+				break
+			}
+		}
+	}
+	if len(changed) == 0 && len(added) == 0 {
+		return nil
+	}
+
+	// Invalidate the changed packages and all that import them.
+	importedBy := make(map[*types.Package][]*types.Package)
+	for P := range lprog.AllPackages {
+		for _, Q := range P.Imports() {
+			importedBy[Q] = append(importedBy[Q], P)
+		}
+	}
+	var invalidate func(*types.Package)
+	invalid := make(map[*types.Package]bool)
+	invalidate = func(p *types.Package) {
+		if !invalid[p] {
+			invalid[p] = true
+			for _, q := range importedBy[p] {
+				invalidate(q)
+			}
+		}
+	}
+	for p := range changed {
+		invalidate(p)
+	}
+	reusable := make(map[string]*loader.PackageInfo)
+	for pkg, info := range lprog.AllPackages {
+		if info.Importable && !invalid[pkg] {
+			reusable[pkg.Path()] = info
+		}
+	}
+
+	lconf := loader.Config{
+		Build:       s.q.Build,
+		Fset:        lprog.Fset,
+		ParserMode:  parser.ParseComments, // as loadAnalysis
+		Reuse:       func(path string) *loader.PackageInfo { return reusable[path] },
+		Parallelism: s.q.Parallelism,
+	}
+	if err := setPTAScope(&lconf, s.q.Scope, !s.q.NoTests); err != nil {
+		return err
+	}
+	for path := range s.tests {
+		lconf.ImportWithTests(path)
+	}
+	for _, path := range added {
+		lconf.ImportWithTests(path)
+	}
+	progressHook(q, &lconf, "reload")
+	cancelHook(q, &lconf)
+	end := beginPhase(q, "reload")
+	newprog, err := loadWithSoftErrors(q, &lconf, false)
+	end()
+	if err := canceled(q); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	a := &analysis{q: q, lprog: newprog, mode: s.a.mode}
+	if err := a.createSSA(); err != nil {
+		return err
+	}
+	a.buildSSA()
+	if err := canceled(q); err != nil {
+		return err
+	}
+	a.q = &s.q // a outlives q
+	s.a = a
+	s.stamps = stampFiles(newprog)
+	for _, path := range added {
+		s.tests[path] = true
+	}
+	return nil
+}
+
+// Run runs the query mode at each of the positions posns, in order,
+// calling output, which must be safe for concurrent use, for each
+// query result.  It returns a slice of
+// errors, one per position; see RunBatch.
+func (s *Server) Run(mode string, posns []string, output func(*token.FileSet, QueryResult)) []error {
+	return s.RunContext(context.Background(), mode, posns, output)
+}
+
+// RunContext is like Run, but the queries fail with the error of ctx
+// once it is done, leaving the server ready for subsequent queries.
+func (s *Server) RunContext(ctx context.Context, mode string, posns []string, output func(*token.FileSet, QueryResult)) []error {
+	return s.run(ctx, mode, posns, 0, s.q.MaxResults, output)
+}
+
+// run is like RunContext, but limits the items of the results of
+// each query to those from index first, up to max of them.
+func (s *Server) run(ctx context.Context, mode string, posns []string, first, max int, output func(*token.FileSet, QueryResult)) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.q
+	q.Output = output
+	q.Context = ctx
+	q.MaxResults = max
+	q.firstResult = first
+	m, ok := modes[mode]
+	if !ok {
+		return runEach(mode, &q, posns)
+	}
+	if err := s.reload(&q, queryTestPackages(posns, q.Build)); err != nil {
+		errors := make([]error, len(posns))
+		for i := range errors {
+			errors[i] = err
+		}
+		return errors
+	}
+	if m.needs&needScope != 0 {
+		return runPTABatch(s.a, m, &q, posns)
+	}
+
+	// Run the other queries against the loaded program, if their
+	// positions are within it.
+	defer func() { s.a.q = &s.q }() // (see Program.analysis)
+	prog := &Program{lprog: s.a.lprog, prog: s.a.prog, a: s.a}
+	errs := make([]error, len(posns))
+	for i, pos := range posns {
+		q2 := q
+		q2.Pos = pos
+		if s.contains(pos) {
+			errs[i] = prog.Run(mode, &q2)
+		} else {
+			errs[i] = Run(mode, &q2)
+		}
+	}
+	return errs
+}
+
+// contains reports whether the file of the query position pos is one
+// of the loaded program.
+func (s *Server) contains(pos string) bool {
+	filename, _, _, err := parsePos(pos)
+	if err != nil {
+		return false
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	_, ok := s.stamps[filename]
+	return ok
+}
+
+// Query performs the query mode at position pos and returns its
+// results, in order, and the file set of their positions.  It is a
+// convenience for clients that make one query at a time; see Run.
+func (s *Server) Query(mode, pos string) (*token.FileSet, []QueryResult, error) {
+	var (
+		mu      sync.Mutex
+		fset    *token.FileSet
+		results []QueryResult
+	)
+	err := s.Run(mode, []string{pos}, func(fs *token.FileSet, qr QueryResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		fset = fs
+		results = append(results, qr)
+	})[0]
+	return fset, results, err
+}
+
+// Serve accepts connections on the listener l and serves JSON-RPC
+// requests on each one.  It returns only if l.Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(client.ServiceName, &service{s}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// service is the RPC service of a Server.
+type service struct{ s *Server }
+
+// Query performs a query and returns its results as JSON, or the
+// page of them that args requests.
+func (svc *service) Query(args *client.QueryArgs, reply *client.QueryReply) error {
+	if args.First < 0 || args.Max < 0 {
+		return fmt.Errorf("invalid page: first %d, max %d", args.First, args.Max)
+	}
+	max := args.Max
+	if max == 0 {
+		max = svc.s.q.MaxResults
+	}
+	reply.Version = serial.Version
+	var outputMu sync.Mutex
+	output := func(fset *token.FileSet, qr QueryResult) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		reply.Results = append(reply.Results, json.RawMessage(qr.JSON(fset)))
+	}
+	for _, err := range svc.s.run(context.Background(), args.Mode, args.Posns, args.First, max, output) {
+		var msg string
+		if err != nil {
+			msg = err.Error()
+		}
+		reply.Errors = append(reply.Errors, msg)
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// shared reports, for a selected variable or field selection x.f,
+// the goroutines that may access it, and those of its accesses that
+// may conflict: pairs of accesses, at least one of them a write, by
+// different goroutines.
+//
+// The goroutines are those started by the go statements of the call
+// graph, and the main goroutine, which runs the callgraph root.  A go
+// statement is assumed to start more than one goroutine if it is in
+// a loop, or in a function that has more than one caller or that a
+// goroutine other than the main one may run.
+//
+// Only loads and stores of the variable itself are accesses; copies
+// of an enclosing struct or array, and calls such as those of package
+// sync/atomic, are not.  Synchronization is not considered, so that
+// accesses ordered by a mutex or a channel are reported too.
+func shared(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.createSSA(); err != nil {
+		return nil, err
+	}
+
+	id, _ := qpos.path[0].(*ast.Ident)
+	var obj *types.Var
+	if id != nil {
+		obj, _ = qpos.info.ObjectOf(id).(*types.Var)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("there is no variable here")
+	}
+
+	if obj.IsField() {
+		if sel, ok := qpos.path[1].(*ast.SelectorExpr); !ok || sel.Sel != id {
+			return nil, fmt.Errorf("this is a declaration of field %s; select a selection x.%s", obj.Name(), obj.Name())
+		}
+	}
+	pkgLevel := !obj.IsField() && obj.Parent() == obj.Pkg().Scope()
+	var fn *ssa.Function // the function of the selection
+	if !pkgLevel {
+		pkg := a.prog.Package(qpos.info.Pkg)
+		pkg.SetDebugMode(true)
+		pkg.Build()
+		if fn = ssa.EnclosingFunction(pkg, qpos.path); fn == nil {
+			return nil, errorf(codeAnalysis, "no SSA function built for this location (dead code?)")
+		}
+	}
+
+	// Defer SSA construction till after errors are reported.
+	a.buildSSA()
+
+	res := &sharedResult{qpos: qpos, desc: "var " + obj.Name()}
+	if obj.IsField() {
+		res.desc = "field " + obj.Name()
+	}
+
+	// addr is the address of the variable, or nil if it
+	// is held in registers, by each call of fn.
+	var addr ssa.Value
+	switch {
+	case obj.IsField():
+		addr = fieldAccessAddr(fn, id.Pos())
+	case pkgLevel:
+		addr = a.prog.Package(obj.Pkg()).Var(obj.Name())
+	default:
+		addr = localAddr(a.prog, obj)
+	}
+	if addr == nil {
+		res.local = fn
+		return func() error {
+			q.Output(a.lprog.Fset, res)
+			return nil
+		}, nil
+	}
+
+	// Look at all loads and stores of variables of the
+	// same type in the whole ssa.Program.
+	// The address of a global needs no query: the pointer analysis
+	// has no node for it, only for the object it denotes, whose
+	// label is the Global.
+	queryType := deref(addr.Type())
+	global, _ := addr.(*ssa.Global)
+	if global == nil {
+		a.ptaConfig.AddQuery(addr)
+	}
+	var accesses []memAccess
+	for fn := range ssautil.AllFunctions(a.prog) {
+		for _, acc := range memAccesses(fn) {
+			if _, ok := acc.addr.(*ssa.Global); ok {
+				if acc.addr == addr {
+					accesses = append(accesses, acc)
+				}
+			} else if types.Identical(deref(acc.addr.Type()), queryType) {
+				a.ptaConfig.AddQuery(acc.addr)
+				accesses = append(accesses, acc)
+			}
+		}
+	}
+
+	// The pointer analysis runs once all queries are prepared.
+	a.needPTA = true
+	a.needCallGraph()
+
+	return func() error {
+		queryPtr, ok := a.ptares.Queries[addr]
+		if !ok && global == nil {
+			return errorf(codeAnalysis, "pointer analysis did not find expression (dead code?)")
+		}
+		var aliases []memAccess
+		for _, acc := range accesses {
+			if acc.addr == addr {
+				aliases = append(aliases, acc)
+			} else if ptr, ok := a.ptares.Queries[acc.addr]; ok && mayAlias(ptr, queryPtr, global) {
+				aliases = append(aliases, acc)
+			}
+		}
+		res.mainPos = mainPos(a.ptaConfig.Mains)
+		res.goroutines, res.conflicts = conflicts(a.prog.Fset, goroutinesOf(a.prog.Fset, a.cg), aliases)
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
+}
+
+// mayAlias reports whether ptr may point to the variable of queryPtr,
+// or, if global is non-nil, to the global.
+func mayAlias(ptr, queryPtr pointer.Pointer, global *ssa.Global) bool {
+	if global == nil {
+		return ptr.MayAlias(queryPtr)
+	}
+	for _, label := range ptr.PointsTo().Labels() {
+		if label.Value() == global {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldAccessAddr returns the address of the field selected by the
+// selection x.f in fn of which pos is that of f, or nil if x is a
+// struct held in registers.
+func fieldAccessAddr(fn *ssa.Function, pos token.Pos) ssa.Value {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *ssa.Store:
+				if instr.Pos() == pos {
+					return instr.Addr
+				}
+			case *ssa.UnOp:
+				if instr.Op == token.MUL && instr.Pos() == pos {
+					return instr.X
+				}
+			case *ssa.DebugRef:
+				if instr.Pos() != pos {
+					continue
+				}
+				if instr.IsAddr {
+					return instr.X
+				}
+				if load, ok := instr.X.(*ssa.UnOp); ok && load.Op == token.MUL {
+					if _, ok := load.X.(*ssa.FieldAddr); ok {
+						return load.X
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// localAddr returns the Alloc of the local variable v, or nil if it
+// was lifted to registers.
+func localAddr(prog *ssa.Program, v *types.Var) ssa.Value {
+	for fn := range ssautil.AllFunctions(prog) {
+		if fn.Pkg == nil || fn.Pkg.Pkg != v.Pkg() {
+			continue
+		}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if alloc, ok := instr.(*ssa.Alloc); ok && alloc.Pos() == v.Pos() && alloc.Comment == v.Name() {
+					return alloc
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// A memAccess is a load or store of a variable.
+type memAccess struct {
+	addr  ssa.Value     // the address of the variable
+	write bool          // the access is a store
+	pos   token.Pos     // the position of the access
+	fn    *ssa.Function // the function containing the access
+}
+
+// memAccesses returns the loads and stores of fn that have a position.
+// A load without one is at the position of the expression of its
+// value, if it has one (see ssa.DebugRef), or that of the next
+// instruction that has one.
+func memAccesses(fn *ssa.Function) []memAccess {
+	exprs := make(map[ssa.Value]token.Pos)
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if ref, ok := instr.(*ssa.DebugRef); ok && !ref.IsAddr {
+				pos := ref.Expr.Pos()
+				if sel, ok := unparen(ref.Expr).(*ast.SelectorExpr); ok {
+					pos = sel.Sel.Pos()
+				}
+				exprs[ref.X] = pos
+			}
+		}
+	}
+	var accesses []memAccess
+	for _, b := range fn.Blocks {
+		for i, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *ssa.Store:
+				if instr.Pos().IsValid() {
+					accesses = append(accesses, memAccess{instr.Addr, true, instr.Pos(), fn})
+				}
+			case *ssa.UnOp:
+				if instr.Op != token.MUL {
+					continue
+				}
+				pos, ok := instr.Pos(), instr.Pos().IsValid()
+				if !ok {
+					pos, ok = exprs[instr]
+				}
+				for _, next := range b.Instrs[i+1:] {
+					if ok {
+						break
+					}
+					pos, ok = next.Pos(), next.Pos().IsValid()
+				}
+				if ok {
+					accesses = append(accesses, memAccess{instr.X, false, pos, fn})
+				}
+			}
+		}
+	}
+	return accesses
+}
+
+// A goroutine is the main goroutine, or those started by a go statement.
+type goroutine struct {
+	site  *ssa.Go                // the go statement, or nil for the main goroutine
+	many  bool                   // the go statement may start more than one goroutine
+	funcs map[*ssa.Function]bool // the functions that it may run
+}
+
+// goroutinesOf returns the goroutines of the call graph: the main
+// goroutine, followed by those of the go statements in order of
+// position.
+func goroutinesOf(fset *token.FileSet, cg *callgraph.Graph) []*goroutine {
+	// reach returns the functions reachable from the nodes
+	// by calls other than go statements.
+	reach := func(nodes []*callgraph.Node) map[*ssa.Function]bool {
+		funcs := make(map[*ssa.Function]bool)
+		for len(nodes) > 0 {
+			n := nodes[len(nodes)-1]
+			nodes = nodes[:len(nodes)-1]
+			if funcs[n.Func] {
+				continue
+			}
+			funcs[n.Func] = true
+			for _, e := range n.Out {
+				if _, ok := e.Site.(*ssa.Go); !ok {
+					nodes = append(nodes, e.Callee)
+				}
+			}
+		}
+		return funcs
+	}
+
+	entries := make(map[*ssa.Go][]*callgraph.Node)
+	var sites []*ssa.Go
+	for _, n := range cg.Nodes {
+		for _, e := range n.Out {
+			if site, ok := e.Site.(*ssa.Go); ok {
+				if entries[site] == nil {
+					sites = append(sites, site)
+				}
+				entries[site] = append(entries[site], e.Callee)
+			}
+		}
+	}
+	sort.Slice(sites, func(i, j int) bool { return lessPos(fset, sites[i].Pos(), sites[j].Pos()) })
+
+	goroutines := []*goroutine{{funcs: reach([]*callgraph.Node{cg.Root})}}
+	for _, site := range sites {
+		goroutines = append(goroutines, &goroutine{site: site, funcs: reach(entries[site])})
+	}
+	for _, g := range goroutines[1:] {
+		fn := g.site.Parent()
+		g.many = inLoop(g.site.Block()) || len(cg.Nodes[fn].In) > 1
+		for _, other := range goroutines[1:] {
+			if other.funcs[fn] {
+				g.many = true
+			}
+		}
+	}
+	return goroutines
+}
+
+// inLoop reports whether the block is within a loop of its function.
+func inLoop(b *ssa.BasicBlock) bool {
+	seen := make(map[*ssa.BasicBlock]bool)
+	stack := append([]*ssa.BasicBlock(nil), b.Succs...)
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s == b {
+			return true
+		}
+		if !seen[s] {
+			seen[s] = true
+			stack = append(stack, s.Succs...)
+		}
+	}
+	return false
+}
+
+// A sharedAccess is an access of the queried variable that may conflict.
+type sharedAccess struct {
+	memAccess
+	goroutines []int // indices of the goroutines that may make it
+}
+
+// conflicts returns the goroutines that may make the accesses, and
+// the accesses that may conflict, each with the indices of those
+// goroutines, in order of position.
+func conflicts(fset *token.FileSet, all []*goroutine, accesses []memAccess) ([]*goroutine, []sharedAccess) {
+	// Find the goroutines of each access, discarding those
+	// of unreachable functions and the goroutines of none.
+	var goroutines []*goroutine
+	for _, g := range all {
+		for _, acc := range accesses {
+			if g.funcs[acc.fn] {
+				goroutines = append(goroutines, g)
+				break
+			}
+		}
+	}
+	var made []sharedAccess
+	for _, acc := range accesses {
+		sa := sharedAccess{memAccess: acc}
+		for i, g := range goroutines {
+			if g.funcs[acc.fn] {
+				sa.goroutines = append(sa.goroutines, i)
+			}
+		}
+		if sa.goroutines != nil {
+			made = append(made, sa)
+		}
+	}
+
+	// Two accesses conflict if either is a write and
+	// they may be made by different goroutines.
+	concurrent := func(x, y sharedAccess) bool {
+		for _, i := range x.goroutines {
+			for _, j := range y.goroutines {
+				if i != j || goroutines[i].many {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var res []sharedAccess
+	for _, x := range made {
+		for _, y := range made {
+			if (x.write || y.write) && concurrent(x, y) {
+				res = append(res, x)
+				break
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].pos != res[j].pos {
+			return lessPos(fset, res[i].pos, res[j].pos)
+		}
+		return !res[i].write && res[j].write
+	})
+	return goroutines, res
+}
+
+// mainPos returns the position of the first main function of the
+// main packages that has one, or NoPos.
+func mainPos(mains []*ssa.Package) token.Pos {
+	for _, pkg := range mains {
+		if fn := pkg.Func("main"); fn != nil && fn.Pos().IsValid() {
+			return fn.Pos()
+		}
+	}
+	return token.NoPos
+}
+
+type sharedResult struct {
+	resultSource
+	qpos       *queryPos
+	desc       string         // e.g. "var x" or "field f"
+	local      *ssa.Function  // the function of a variable held in registers, or nil
+	mainPos    token.Pos      // position of the main function
+	goroutines []*goroutine   // the goroutines that may access the variable
+	conflicts  []sharedAccess // the accesses that may conflict
+}
+
+func (r *sharedResult) PrintPlain(printf printfFunc) {
+	if r.local != nil {
+		printf(r.qpos, "This %s is not shared: each call of %s has its own.", r.desc, r.local.RelString(r.qpos.info.Pkg))
+		return
+	}
+	if len(r.goroutines) == 0 {
+		printf(r.qpos, "This %s is not accessed by any goroutine.", r.desc)
+		return
+	}
+	printf(r.qpos, "This %s may be accessed by these goroutines:", r.desc)
+	for _, g := range r.goroutines {
+		switch {
+		case g.site == nil:
+			printf(r.mainPos, "\tthe main goroutine")
+		case g.many:
+			printf(edgePos(g.site.Pos()), "\tthe goroutines started here")
+		default:
+			printf(edgePos(g.site.Pos()), "\tthe goroutine started here")
+		}
+	}
+	if len(r.conflicts) == 0 {
+		printf(r.qpos, "None of its accesses conflict.")
+		return
+	}
+	printf(r.qpos, "These accesses of it may conflict:")
+	for _, acc := range r.conflicts {
+		verb := "read"
+		if acc.write {
+			verb = "written"
+		}
+		printf(acc.pos, "\t%s here, in %s", verb, acc.fn.RelString(r.qpos.info.Pkg))
+	}
+}
+
+func (r *sharedResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *sharedResult) serialize(s *serializer) interface{} {
+	shared := &serial.Shared{
+		Version: serial.Version,
+		Pos:     s.pos(r.qpos.start),
+		Desc:    r.desc,
+	}
+	if r.local != nil {
+		shared.Local = r.local.String()
+	}
+	for _, g := range r.goroutines {
+		sg := serial.SharedGoroutine{Many: g.many}
+		if g.site == nil {
+			sg.Pos = s.pos(r.mainPos)
+			sg.Main = true
+		} else {
+			sg.Pos = s.pos(g.site.Pos())
+		}
+		shared.Goroutines = append(shared.Goroutines, sg)
+	}
+	for _, acc := range r.conflicts {
+		shared.Conflicts = append(shared.Conflicts, serial.SharedAccess{
+			Pos:        s.pos(acc.pos),
+			Write:      acc.write,
+			Func:       acc.fn.String(),
+			Goroutines: acc.goroutines,
+		})
+	}
+	shared.Offsets = s.offsets()
+	return shared
+}
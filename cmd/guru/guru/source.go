@@ -0,0 +1,135 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the source of the files of a query's results,
+// which the output formats read to convert positions to character
+// offsets and columns.
+
+import (
+	"bytes"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"sync"
+	"unicode/utf8"
+)
+
+// A fileSource reads the files of a query through its build context,
+// so that their contents are those the query loaded, such as the
+// unsaved files of -modified or of Query.Overlay, not those on disk.
+// It caches the contents of each file until the file's modification
+// time or size on disk changes.
+//
+// A nil *fileSource reads files from disk, without caching.
+type fileSource struct {
+	ctxt     *build.Context
+	physical bool // positions are those of the files as parsed; see Query.PhysicalPositions
+
+	mu    sync.Mutex
+	files map[string]sourceFile
+}
+
+// A sourceFile is the contents of a file, and its stamp when read.
+type sourceFile struct {
+	stamp fileStamp
+	data  []byte
+}
+
+func newFileSource(ctxt *build.Context, physical bool) *fileSource {
+	return &fileSource{ctxt: ctxt, physical: physical, files: make(map[string]sourceFile)}
+}
+
+// content returns the contents of the named file, or nil if it
+// cannot be read.
+func (src *fileSource) content(filename string) []byte {
+	if src == nil {
+		data, _ := ioutil.ReadFile(filename)
+		return data
+	}
+	stamp := stat(filename)
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if f, ok := src.files[filename]; ok && f.stamp == stamp {
+		return f.data
+	}
+	data, _ := readFile(src.ctxt, filename, nil)
+	src.files[filename] = sourceFile{stamp, data}
+	return data
+}
+
+// runeOffset returns the character offset of posn, the position of
+// pos in fset, within its file.  If the file cannot be read, it
+// returns the byte offset of posn.
+func (src *fileSource) runeOffset(fset *token.FileSet, pos token.Pos, posn token.Position) int {
+	data := src.content(posn.Filename)
+	offset, ok := sourceOffset(fset, pos, posn, data)
+	if !ok {
+		return posn.Offset
+	}
+	return utf8.RuneCount(data[:offset])
+}
+
+// runeColumn converts a 1-based byte column on the specified line of
+// the named file to a 1-based character column.  If the file cannot
+// be read, it returns col.
+func (src *fileSource) runeColumn(filename string, line, col int) int {
+	data := src.content(filename)
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return col
+		}
+		data = data[i+1:]
+	}
+	if col < 1 || col-1 > len(data) {
+		return col
+	}
+	return utf8.RuneCount(data[:col-1]) + 1
+}
+
+// A sourcedResult is a QueryResult that holds the fileSource of its
+// query, through which its positions are converted.
+type sourcedResult interface {
+	QueryResult
+	source() *fileSource
+	setSource(src *fileSource)
+}
+
+// resultSource is embedded in each QueryResult type with positions to
+// implement sourcedResult.
+type resultSource struct{ src *fileSource }
+
+func (r *resultSource) source() *fileSource       { return r.src }
+func (r *resultSource) setSource(src *fileSource) { r.src = src }
+
+// sourceOf returns the fileSource of the result qr, or nil if it has
+// none, as for the results of other packages.
+func sourceOf(qr QueryResult) *fileSource {
+	if r, ok := qr.(sourcedResult); ok {
+		return r.source()
+	}
+	return nil
+}
+
+// withSource returns a copy of q whose Output gives each result the
+// fileSource of q, or if q has none, a new one of its build context
+// and positions, which is then that of the query.
+func withSource(q *Query) *Query {
+	src := q.src
+	if src == nil {
+		src = newFileSource(q.Build, q.PhysicalPositions)
+	}
+	output := q.Output
+	q2 := *q
+	q2.src = src
+	q2.Output = func(fset *token.FileSet, qr QueryResult) {
+		if r, ok := qr.(sourcedResult); ok {
+			r.setSource(src)
+		}
+		output(fset, qr)
+	}
+	return &q2
+}
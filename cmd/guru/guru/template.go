@@ -0,0 +1,89 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+// This file defines the output format of a text/template, such as
+// -format='{{.Pos}}'.
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// isTemplateFormat reports whether the -format flag value is a
+// template, not the name of an output format.
+func isTemplateFormat(format string) bool {
+	return strings.Contains(format, "{{")
+}
+
+// A templateFormatter writes results by executing a template for each
+// object of their result stream, the value of package serial that
+// describes it (see the table of package serial), followed by a
+// newline.  The template is executed for each element of a result
+// that is an array, so that, for example, the '{{.Pos}}' template
+// prints the position of each caller of callers, or each function of
+// pointsto.
+type templateFormatter struct {
+	opts *FormatOptions
+	tmpl *template.Template
+}
+
+// parseTemplateFormat parses the template text, and returns the
+// constructor of the Formatter that executes it.
+func parseTemplateFormat(text string) (func(opts *FormatOptions) Formatter, error) {
+	tmpl, err := template.New("format").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(opts *FormatOptions) Formatter { return templateFormatter{opts, tmpl} }, nil
+}
+
+func (f templateFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	objects, err := f.objects(fset, qr)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		buf.Reset()
+		if err := f.tmpl.Execute(&buf, obj); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objects returns the values for which to execute the template: the
+// objects of the serial form of qr, or, for a result of another
+// package, which has none, the generic values of those of its JSON.
+func (f templateFormatter) objects(fset *token.FileSet, qr QueryResult) ([]interface{}, error) {
+	if r, ok := qr.(serialResult); ok {
+		return serialObjects(r.serialize(f.opts.serializer(fset, qr))), nil
+	}
+	var objects []interface{}
+	dec := json.NewDecoder(bytes.NewReader(qr.JSON(fset)))
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if elems, ok := v.([]interface{}); ok {
+			objects = append(objects, elems...)
+		} else {
+			objects = append(objects, v)
+		}
+	}
+	return objects, nil
+}
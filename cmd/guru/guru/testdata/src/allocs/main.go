@@ -0,0 +1,34 @@
+package main
+
+// Tests of 'allocs' query.
+// See go.tools/guru/guru_test.go for explanation.
+// See allocs.golden for expected query results.
+
+type T struct{ x int }
+
+var global T
+
+func use(p *T) int { return p.x }
+
+func main() {
+	s := T{1}         // @allocs allocs-complit-stack "{"
+	h := &T{2}        // @allocs allocs-complit-heap "{"
+	n := new(T)       // @allocs allocs-new "new"
+	m := make([]T, 1) // @allocs allocs-make "make"
+
+	var p *T // @allocs allocs-var-pointer "p"
+	if len(m) > 0 {
+		p = h
+	} else {
+		p = n
+	}
+	_ = use(p) // @allocs allocs-var-lifted "p"
+	print(s.x)
+
+	var c T // @allocs allocs-var-captured "c"
+	func() { c.x++ }()
+
+	print(use(&global)) // @allocs allocs-global "global"
+
+	print(0) // @allocs allocs-none "print"
+}
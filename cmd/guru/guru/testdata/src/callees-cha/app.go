@@ -0,0 +1,19 @@
+package app
+
+import "callees-cha/lib"
+
+type A struct{}
+
+func (A) M() {}
+
+type B struct{}
+
+func (*B) M() {}
+
+type C struct{}
+
+func (C) N() {}
+
+func Use() {
+	lib.Call(A{}, func(x int) int { return x })
+}
@@ -0,0 +1,16 @@
+package lib
+
+// Tests of 'callees' query without a scope, in which the callees of
+// dynamic calls are found by class hierarchy analysis of the packages
+// that depend on the selected one.
+// See go.tools/guru/guru_test.go for explanation.
+// See lib.golden for expected query results.
+
+type I interface{ M() }
+
+func Call(i I, f func(int) int) {
+	i.M() // @callees callees-method "M" -scope=
+	f(1)  // @callees callees-func "f" -scope=
+}
+
+func Double(x int) int { return 2 * x }
@@ -0,0 +1,33 @@
+package main
+
+// Tests of 'callees' query of calls of method values and method
+// expressions, whose callees are the methods of their receivers.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+type I interface{ M() }
+
+type A struct{}
+
+func (A) M() {}
+
+type B struct{}
+
+func (*B) M() {}
+
+type C struct{}
+
+func (C) M() {}
+
+func main() {
+	var i I = A{}
+	if len("x") > 0 {
+		i = new(B)
+	}
+	f := i.M
+	f() // @callees callees-method-value "f"
+	g := C{}.M
+	g() // @callees callees-concrete-method-value "g"
+	h := I.M
+	h(C{}) // @callees callees-method-expr "h"
+}
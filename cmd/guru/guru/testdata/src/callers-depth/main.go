@@ -0,0 +1,22 @@
+package main
+
+// Tests of 'callers' query with -depth, and its DOT output.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func main() { a(); c() }
+
+func a() { b() }
+
+func b() { // @callers callers-b-depth1 "b" -depth=1
+	// @callers callers-b-depth1-dot "^" -depth=1 -format=dot
+
+	// @callers callers-b-depth3 "^" -depth=3
+
+	// @callers callers-b-depth3-dot "^" -depth=3 -format=dot
+	if false {
+		a()
+	}
+}
+
+func c() { b() }
@@ -0,0 +1,29 @@
+package main
+
+// Tests of 'callees' query of a dynamic call in the call graph of each
+// -callgraph algorithm.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+type I interface{ f() }
+
+type A struct{}
+
+func (A) f() {}
+
+type B struct{}
+
+func (B) f() {}
+
+type C struct{}
+
+func (C) f() {}
+
+func main() {
+	var i I = A{}
+	i.f() // @callees callees-cha "f" -callgraph=cha
+	i.f() // @callees callees-rta "f" -callgraph=rta
+	i.f() // @callees callees-pta "f" -callgraph=pta
+	var j I = B{}
+	_ = j
+}
@@ -0,0 +1,5 @@
+package lib
+
+func F() {}
+
+func G(f func()) { f() }
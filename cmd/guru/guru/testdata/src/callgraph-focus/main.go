@@ -0,0 +1,19 @@
+package main
+
+// Tests of 'callgraph' query with -focus, which summarizes the calls
+// that cross the boundary of the focus packages.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+import "callgraph-focus/lib"
+
+// @callgraph callgraph "nopos"
+
+// @callgraph callgraph-focus "nopos" -scope=callgraph-focus -focus=callgraph-focus
+
+func main() {
+	lib.F()
+	lib.G(f)
+}
+
+func f() { lib.F() }
@@ -0,0 +1,26 @@
+package main
+
+// Tests of 'callstack' query with -paths, and its DOT output.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func main() { a(); b() }
+
+func a() { c() }
+
+func b() { a(); c() }
+
+func c() { // @callstack callstack-c-paths1 "c" -paths=1
+	// @callstack callstack-c-paths1-dot "^" -paths=1 -format=dot
+
+	// @callstack callstack-c-paths3 "^" -paths=3
+
+	// @callstack callstack-c-paths3-dot "^" -paths=3 -format=dot
+
+	// @callstack callstack-c-all "^" -paths=-1
+
+	// @callstack callstack-c-all-dot "^" -paths=-1 -format=dot
+	if false {
+		b()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+// Tests of 'deadcode' query.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+type I interface{ f() }
+
+type A int
+
+func (A) f() {}
+func (A) g() {} // dead: never called
+
+type B int
+
+func (B) f() {} // dead: no B is converted to I
+
+func live() {
+	var i I = A(0)
+	i.f()
+	_ = func() {} // dead: never called
+}
+
+func dead() {
+	func() {}() // within dead; not reported
+}
+
+func init() {
+	live()
+}
+
+func main() { // @deadcode deadcode "main"
+	indirect := func() {}
+	fns := []func(){indirect}
+	fns[0]()
+}
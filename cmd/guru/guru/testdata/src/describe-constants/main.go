@@ -0,0 +1,23 @@
+package main
+
+// Tests of 'describe' query of constants: their values, exact where
+// inexact in their type, and whether they overflow it.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+const big = 1 << 100 // @describe describe-big "big"
+
+const (
+	A = iota * 10
+	B // @describe describe-iota "B"
+)
+
+const pi = 3.14159265358979323846 // @describe describe-pi "pi"
+
+const f float32 = 0.1 // @describe describe-float32 "f"
+
+const huge = 1e400 // @describe describe-huge "huge"
+
+var _ int8 = B // @describe describe-ref "B"
+
+func main() {}
@@ -0,0 +1,15 @@
+package main
+
+// Tests of 'describe' query of the doc comments of objects and
+// packages, even those of dependencies, of which it reports the first
+// sentence.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+import "describe-doc/p" // @describe describe-doc-pkg "p"
+
+var _ p.T // @describe describe-doc-type "T"
+
+var _ = p.F // @describe describe-doc-func "F"
+
+func main() {}
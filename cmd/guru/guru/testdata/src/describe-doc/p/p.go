@@ -0,0 +1,10 @@
+// Package p is documented. Really.
+package p
+
+// T is a type. It has a doc comment.
+type T int
+
+// F is a function.
+//
+// Its doc comment has two paragraphs.
+func F() {}
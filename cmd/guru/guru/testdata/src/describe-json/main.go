@@ -27,3 +27,18 @@ type D struct{}
 
 func (c C) f()  {} // @describe desc-param-c "\\bc\\b"
 func (d *D) f() {} // @describe desc-param-d "\\bd\\b"
+
+type E struct { // @describe desc-type-E "E"
+	D
+	*F
+	x int
+}
+
+type F struct{ y bool }
+
+func (F) g() {}
+
+// G has a doc comment.
+//
+// Only its first sentence is shown in plain output.
+func G() {} // @describe desc-doc-G "G"
@@ -0,0 +1,39 @@
+package main
+
+// Tests of 'effects' query of the package-level variables accessed by
+// the callees of a function, through their fields and elements, and
+// through pointers.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+var (
+	count int
+	cfg   struct{ name string }
+	table [4]int
+	hits  []int
+	ptr   = &count
+)
+
+func bump() {
+	count++
+}
+
+func setName(s string) {
+	cfg.name = s
+	table[1] = len(hits)
+	hits[0] = 1
+}
+
+func viaPtr() {
+	*ptr = 3 // @effects effects-ptr "ptr"
+}
+
+func run() {
+	bump() // @effects effects-calls "bump"
+	setName("x")
+	go viaPtr()
+}
+
+func main() {
+	run() // @effects effects-main "run"
+}
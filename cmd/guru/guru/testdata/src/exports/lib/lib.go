@@ -0,0 +1,18 @@
+package lib
+
+// Tests of 'callers' query of a library that has no main and no tests,
+// whose analysis starts from calls to its exported functions and
+// methods.  The synthetic main function is not shown.  (-depth=2
+// selects the call graph, not the direct calls.)
+// See go.tools/guru/guru_test.go for explanation.
+// See lib.golden for expected query results.
+
+type T struct{}
+
+func (*T) Method(x int) { helper() }
+
+func Func(t *T, s ...string) { helper() }
+
+func unexported() { helper() }
+
+func helper() {} // @callers callers-helper "helper" -scope=exports/... -depth=2
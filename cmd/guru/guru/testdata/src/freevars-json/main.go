@@ -0,0 +1,11 @@
+package main
+
+// Tests of 'freevars' query, -format=json.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func main() {
+	x, s := 1, ""
+	s, y := s+"!", x // @freevars fv-json "s, y.*x"
+	println(s, y)
+}
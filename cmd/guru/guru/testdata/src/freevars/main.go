@@ -38,3 +38,13 @@ loop: // @freevars fv-def-label "loop:"
 		break loop // @freevars fv-ref-label "break loop"
 	}
 }
+
+func (t *T) inc() { t.a++ }
+
+func extract() {
+	n, a, t := 0, [2]int{}, T{}
+	n, a[0], t.b = n+1, 2, 3 // @freevars fv-assign "n, a.*3"
+	y := n + t.b             // @freevars fv-result "y := n . t.b"
+	t.inc()                  // @freevars fv-method "t.inc.."
+	println(y * a[1])        // @freevars fv-expr "y . a.1."
+}
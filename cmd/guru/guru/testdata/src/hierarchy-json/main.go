@@ -0,0 +1,51 @@
+package main
+
+// Tests of 'hierarchy' query, -format=json.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func main() {
+}
+
+type E interface{}
+
+type Reader interface { // @hierarchy Reader "Reader"
+	Read() int
+}
+
+type Writer interface {
+	Write(int)
+}
+
+type ReadWriter interface { // @hierarchy ReadWriter "ReadWriter"
+	Reader
+	Writer
+}
+
+// Synonym has the same methods as Reader.
+type Synonym interface {
+	Read() int
+}
+
+type File struct{} // @hierarchy File "File"
+
+func (*File) Read() int { return 0 }
+func (*File) Write(int) {}
+
+type Buffer []int
+
+func (Buffer) Read() int { return 0 }
+
+// LogFile embeds File, and so satisfies its interfaces too.
+type LogFile struct {
+	*File
+	name string
+}
+
+type Alone int
+
+func _() {
+	var rw ReadWriter
+	_ = rw
+
+}
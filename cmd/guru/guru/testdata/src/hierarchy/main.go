@@ -0,0 +1,52 @@
+package main
+
+// Tests of 'hierarchy' query.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func main() {
+}
+
+type E interface{} // @hierarchy E "E"
+
+type Reader interface { // @hierarchy Reader "Reader"
+	Read() int
+}
+
+type Writer interface {
+	Write(int)
+}
+
+type ReadWriter interface { // @hierarchy ReadWriter "ReadWriter"
+	Reader
+	Writer
+}
+
+// Synonym has the same methods as Reader.
+type Synonym interface {
+	Read() int
+}
+
+type File struct{} // @hierarchy File "File"
+
+func (*File) Read() int { return 0 }
+func (*File) Write(int) {}
+
+type Buffer []int
+
+func (Buffer) Read() int { return 0 }
+
+// LogFile embeds File, and so satisfies its interfaces too.
+type LogFile struct { // @hierarchy LogFile "LogFile"
+	*File
+	name string
+}
+
+type Alone int // @hierarchy Alone "Alone"
+
+func _() {
+	var rw ReadWriter
+	_ = rw // @hierarchy var-rw "rw"
+
+	_ = 0 // @hierarchy not-a-type "0"
+}
@@ -0,0 +1,8 @@
+// Package gen is generated code whose //line comments refer to its
+// source, which does not exist, as is that of a parser whose grammar
+// is absent.  See TestIdentPosLine.
+//line orig.y:1
+package gen
+
+//line orig.y:10
+func Parse() {}
@@ -20,8 +20,8 @@ type FG interface { // @implements FG "FG"
 	g() []int // @implements slice "..int"
 }
 
-type C int // @implements C "C"
-type D struct{}
+type C int      // @implements C "C"
+type D struct{} // @loc D-decl "D"
 
 func (c *C) f() {} // @implements starC ".C"
 func (d D) f()  {} // @implements D "D"
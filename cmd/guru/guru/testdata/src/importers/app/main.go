@@ -0,0 +1,5 @@
+package main
+
+import "importers/mid"
+
+func main() { println(mid.X) }
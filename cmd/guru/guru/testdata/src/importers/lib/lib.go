@@ -0,0 +1,8 @@
+package lib // @importers importers-clause "lib" -scope=importers/...
+
+// Tests of 'importers' query of the direct and indirect importers of
+// a package, selected by its package clause.
+// See go.tools/guru/guru_test.go for explanation.
+// See lib.golden for expected query results.
+
+func F() int { return 1 }
@@ -0,0 +1,13 @@
+package mid
+
+// Tests of 'importers' query of the direct and indirect importers of
+// a package, selected by an import of it, or a reference to it.
+// See go.tools/guru/guru_test.go for explanation.
+// See mid.golden for expected query results.
+
+import (
+	"importers/lib" // @importers importers-import "lib" -scope=importers/...
+	l2 "importers/lib"
+)
+
+var X = lib.F() + l2.F() // @importers importers-ref "l2" -scope=importers/...
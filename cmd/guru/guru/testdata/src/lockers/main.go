@@ -0,0 +1,39 @@
+package main
+
+// Tests of 'lockers' query, which distinguishes mutexes that cannot
+// alias.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+import "sync"
+
+type counter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *counter) inc() {
+	c.mu.Lock() // @lockers lockers-a "Lock"
+	c.n++
+	c.mu.Unlock()
+}
+
+var cache struct {
+	sync.RWMutex
+	m map[string]int
+}
+
+func get(k string) int {
+	cache.RLock()
+	defer cache.RUnlock() // @lockers lockers-promoted "RUnlock"
+	return cache.m[k]
+}
+
+func main() {
+	a, b := new(counter), new(counter)
+	a.inc()
+	b.mu.Lock()
+	mu := &b.mu // @lockers lockers-b "mu"
+	mu.Unlock()
+	print(get("x"))
+}
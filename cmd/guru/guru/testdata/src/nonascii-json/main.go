@@ -0,0 +1,19 @@
+package main
+
+// Tests of queries on source containing non-ASCII text, -json output.
+// See golang.org/x/tools/cmd/guru/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+// The columns of positions count bytes, not characters.
+
+type Größe struct{ wert int }
+
+var π = 3.14159
+
+func main() {
+	var größe Größe           // @definition def-größe "Größe"
+	_ = "日本" + größe.Fläche() // @definition def-fläche "Fläche"
+	println("日本", π)          // @definition def-π "π"
+}
+
+func (g Größe) Fläche() string { return "" }
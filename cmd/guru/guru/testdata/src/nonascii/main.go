@@ -0,0 +1,23 @@
+package main
+
+// Tests of queries on source containing non-ASCII text.
+// See golang.org/x/tools/cmd/guru/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+// Each selection follows multibyte characters on its line,
+// so that its offset differs from its column in characters.
+
+type Größe struct{ wert int } // @loc Größe-decl "Größe"
+
+func (g Größe) Fläche() int { return g.wert * g.wert } // @loc Fläche-decl "Fläche"
+
+var π = 3.14159 // @loc π-decl "π"
+
+func main() {
+	größe := Größe{2}   // @describe describe-größe "Größe"
+	_ = größe.Fläche()  // @describe describe-fläche "Fläche"
+	s := "héllo, 世界"    // @describe describe-string "\"héllo, 世界\""
+	println("→", π, s)  // @referrers ref-π "π"
+	_ = "日本" + s        // @describe describe-s "s"
+	println(größe.wert) // @freevars fv-größe "größe.wert"
+}
@@ -0,0 +1,33 @@
+package main
+
+// Tests of 'panics' query, which follows the call graph from the
+// functions that defer a recover to the panics they may recover, and
+// back, but not across go statements.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+func check(x int) {
+	if x < 0 {
+		panic("negative") // @panics panics-recovered "panic"
+	}
+}
+
+func work(x int) {
+	check(x)
+	go func() { panic("lost") }() // @panics panics-lost "panic"
+}
+
+func safe(x int) (err error) {
+	defer func() { // @panics panics-defer "defer"
+		if r := recover(); r != nil { // @panics panics-recover "recover"
+			err = r.(error)
+		}
+	}()
+	work(x)
+	return nil
+}
+
+func main() {
+	defer println() // @panics panics-no-recover "defer"
+	safe(1)
+}
@@ -7,7 +7,8 @@ package main
 func main() {
 	chA := make(chan *int)
 	<-chA
-	select {
+	select { // @describe describe-select "select"
 	case <-chA: // @peers peer-recv-chA "<-"
+	default:
 	}
 }
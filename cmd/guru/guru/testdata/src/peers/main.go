@@ -24,7 +24,7 @@ func main() {
 	<-chA2 // @pointsto pointsto-chA2 "chA2"
 	<-chB  // @pointsto pointsto-chB "chB"
 
-	select {
+	select { // @describe describe-select "select"
 	case rA := <-chA: // @peers peer-recv-chA "<-"
 		_ = rA // @pointsto pointsto-rA "rA"
 	case rB := <-chB: // @peers peer-recv-chB "<-"
@@ -49,4 +49,39 @@ func main() {
 
 	close(chC) <- &b // @peers peer-send-chC "chC"
 	<-close(chC)     // @peers peer-recv-chC "chC"
+
+	chD := make(chan *int, a2)
+	<-chD // @peers peer-recv-chD "<-"
+
+	// A channel that is a field is reported with the objects that hold it.
+	s := &S{ch: make(chan *int, 1)}
+	s.ch <- &b
+	<-s.ch // @peers peer-recv-field "<-"
+
+	chE := make(chan int)
+	select { // @describe describe-select-default "select"
+	case chE <- 1:
+	default:
+	}
+
+	registry()
+}
+
+// A channel sent over another channel is followed to its peers.
+func registry() {
+	replies := make(chan chan string, 1)
+	go func() {
+		r := <-replies
+		r <- "a" // @peers peer-send-reply "<-"
+		rr := make(chan chan chan string, 1)
+		rr <- replies
+		(<-rr) <- make(chan string)
+	}()
+	reply := make(chan string)
+	replies <- reply
+	<-reply // @peers peer-recv-reply "<-"
+}
+
+type S struct {
+	ch chan *int
 }
@@ -14,6 +14,16 @@ func main() { //
 		i = new(D)
 	}
 	print(i) // @pointsto val-i "\\bi\\b"
+
+	t := &T{p: p}
+	print(t.p) // @pointsto val-t-p "t.p"
+
+	mp := map[*int]*T{p: t}
+	print(mp) // @pointsto val-mp "mp"
+}
+
+type T struct {
+	p *int
 }
 
 type I interface {
@@ -46,6 +46,9 @@ func main() {
 	_ = mapval          // @pointsto mapval "mapval"
 	_ = m               // @pointsto m "m"
 
+	keys := map[*int]interface{}{&a: new(int)}
+	_ = keys // @pointsto map-keys "keys"
+
 	if false {
 		panic(3) // @pointsto builtin-panic "panic"
 	}
@@ -54,6 +57,19 @@ func main() {
 	// but our query concerns the object, not its address.
 	s := struct{ f interface{} }{f: make(chan bool)}
 	print(s.f) // @pointsto var-ref-s-f "s.f"
+
+	// A field of pointer type is reported with the objects that hold it.
+	var c int
+	t := &T{p: &c}
+	u := &T{p: new(int)}
+	if a == 0 {
+		t = u
+	}
+	print(t.p) // @pointsto field-ref-t-p "t.p"
+}
+
+type T struct {
+	p *int
 }
 
 func livecode() {} // @pointsto func-live "livecode"
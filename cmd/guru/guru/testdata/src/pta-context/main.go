@@ -0,0 +1,21 @@
+package main
+
+// Tests of 'pointsto' query with -pta=1cfa, which distinguishes the
+// results of calls to a helper function from distinct call sites.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+var a, b int
+
+func id(p *int) *int {
+	if p == nil {
+		panic("nil")
+	}
+	return p
+}
+
+func main() {
+	x := id(&a) // @pointsto pointsto-x "x"
+	y := id(&b)
+	print(x, y) // @pointsto pointsto-x-1cfa "x" -pta=1cfa
+}
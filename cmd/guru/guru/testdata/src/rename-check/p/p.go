@@ -0,0 +1,24 @@
+package p
+
+// Tests of 'rename-check' query of the references of an object, the
+// names to which it cannot be renamed, and the interface satisfactions
+// that depend on its name.
+// See go.tools/guru/guru_test.go for explanation.
+// See p.golden for expected query results.
+
+import "fmt"
+
+type T struct{ A, B int } // @rename-check rename-check-field "A"
+
+func (T) M() {} // @rename-check rename-check-method "M"
+
+type I interface{ M() }
+
+var x = 1 // @rename-check rename-check-var "x"
+
+func f(y int) {
+	{
+		w := 2 // @rename-check rename-check-local "w"
+		fmt.Println(x, w)
+	}
+}
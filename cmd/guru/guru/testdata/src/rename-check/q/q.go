@@ -0,0 +1,7 @@
+package q
+
+import "rename-check/p"
+
+var _ p.I = p.T{}
+
+func g() { p.T{}.M() }
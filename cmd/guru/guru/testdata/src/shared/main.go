@@ -0,0 +1,30 @@
+package main
+
+// Tests of 'shared' query of the goroutines and conflicting accesses
+// of variables and fields.
+// See go.tools/guru/guru_test.go for explanation.
+// See main.golden for expected query results.
+
+var counter int
+
+var done = make(chan bool)
+
+type stats struct{ hits, misses int }
+
+func worker(s *stats) {
+	counter++ // @shared shared-global "counter"
+	s.hits++  // @shared shared-field "hits"
+	done <- true
+}
+
+func main() {
+	s := new(stats)
+	local := 0
+	for i := 0; i < 2; i++ {
+		go worker(s)
+	}
+	local++      // @shared shared-local "local"
+	s.misses = 1 // @shared shared-unshared-field "misses"
+	<-done
+	print(counter, s.misses, local)
+}
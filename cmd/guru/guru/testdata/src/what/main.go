@@ -1,5 +1,7 @@
 package main // @what pkgdecl "main"
 
+import "fmt" // @what import "fmt"
+
 // Tests of 'what' queries.
 // See go.tools/guru/guru_test.go for explanation.
 // See what.golden for expected query results.
@@ -8,4 +10,7 @@ func main() {
 	f()             // @what call "f"
 	var ch chan int // @what var "var"
 	<-ch            // @what recv "ch"
+	return          // @what stmt "return"
 }
+
+var _ = fmt.Sprint
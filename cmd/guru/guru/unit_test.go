@@ -0,0 +1,1257 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/serial"
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// Unit tests for internal guru functions
+
+func TestIssue17515(t *testing.T) {
+	// Tests handling of symlinks in function guessImportPath
+	// If we have Go code inside $HOME/go/src and create a symlink $HOME/src to it
+	// there are 4 possible cases that need to be tested:
+	// (1) absolute & absolute: GOPATH=$HOME/go/src file=$HOME/go/src/test/test.go
+	// (2) absolute & symlink:  GOPATH=$HOME/go/src file=$HOME/src/test/test.go
+	// (3) symlink & symlink:   GOPATH=$HOME/src file=$HOME/src/test/test.go
+	// (4) symlink & absolute:  GOPATH=$HOME/src file= $HOME/go/src/test/test.go
+
+	// Create a temporary home directory under /tmp
+	home, err := ioutil.TempDir(os.TempDir(), "home")
+	if err != nil {
+		t.Errorf("Unable to create a temporary directory in %s", os.TempDir())
+	}
+
+	defer os.RemoveAll(home)
+
+	// create filepath /tmp/home/go/src/test/test.go
+	if err = os.MkdirAll(home+"/go/src/test", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buildContext = build.Default
+
+	// Success test cases
+	type SuccessTest struct {
+		gopath, filename, wantSrcdir string
+	}
+
+	successTests := []SuccessTest{
+		{home + "/go", home + "/go/src/test/test.go", filepath.FromSlash(home + "/go/src")},
+	}
+
+	// Add symlink cases if not on Windows, Plan 9
+	if runtime.GOOS != "windows" && runtime.GOOS != "plan9" {
+		// symlink between /tmp/home/go/src and /tmp/home/src
+		if err := os.Symlink(home+"/go/src", home+"/src"); err != nil {
+			t.Fatal(err)
+		}
+
+		successTests = append(successTests, []SuccessTest{
+			{home + "/go", home + "/src/test/test.go", filepath.FromSlash(home + "/go/src")},
+			{home, home + "/go/src/test/test.go", filepath.FromSlash(home + "/src")},
+			{home, home + "/src/test/test.go", filepath.FromSlash(home + "/src")},
+		}...)
+	}
+
+	for _, test := range successTests {
+		buildContext.GOPATH = test.gopath
+		srcdir, importPath, err := guessImportPath(test.filename, &buildContext)
+		if srcdir != test.wantSrcdir || importPath != "test" || err != nil {
+			t.Errorf("guessImportPath(%q, %q) = %q, %q, %q; want %q, %q, %q",
+				test.filename, test.gopath, srcdir, importPath, err, test.wantSrcdir, "test", "nil")
+		}
+	}
+	// Function to format expected error message
+	errFormat := func(fpath string) string {
+		return fmt.Sprintf("can't evaluate symlinks of %s", fpath)
+	}
+
+	// Failure test cases
+	type FailTest struct {
+		gopath, filename, wantErr string
+	}
+
+	failTests := []FailTest{
+		{home + "/go", home + "/go/src/fake/test.go", errFormat(filepath.FromSlash(home + "/go/src/fake"))},
+	}
+
+	if runtime.GOOS != "windows" && runtime.GOOS != "plan9" {
+		failTests = append(failTests, []FailTest{
+			{home + "/go", home + "/src/fake/test.go", errFormat(filepath.FromSlash(home + "/src/fake"))},
+			{home, home + "/src/fake/test.go", errFormat(filepath.FromSlash(home + "/src/fake"))},
+			{home, home + "/go/src/fake/test.go", errFormat(filepath.FromSlash(home + "/go/src/fake"))},
+		}...)
+	}
+
+	for _, test := range failTests {
+		buildContext.GOPATH = test.gopath
+		srcdir, importPath, err := guessImportPath(test.filename, &buildContext)
+		if !strings.HasPrefix(fmt.Sprint(err), test.wantErr) {
+			t.Errorf("guessImportPath(%q, %q) = %q, %q, %q; want %q, %q, %q",
+				test.filename, test.gopath, srcdir, importPath, err, "", "", test.wantErr)
+		}
+	}
+}
+
+func TestToXML(t *testing.T) {
+	for _, test := range []struct {
+		json, want string
+	}{
+		{`{"pos":"a.go:1:2","desc":"x<y"}`,
+			"<result>\n\t<pos>a.go:1:2</pos>\n\t<desc>x&lt;y</desc>\n</result>"},
+		{`[{"name":"f","n":1},{"name":null}]`,
+			"<result>\n\t<item>\n\t\t<name>f</name>\n\t\t<n>1</n>\n\t</item>\n\t<item>\n\t\t<name></name>\n\t</item>\n</result>"},
+		{`{"a":true}` + "\n" + `{"a":false}`,
+			"<result>\n\t<a>true</a>\n</result>\n<result>\n\t<a>false</a>\n</result>"},
+	} {
+		got, err := toXML([]byte(test.json))
+		if err != nil {
+			t.Errorf("toXML(%s) failed: %v", test.json, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("toXML(%s) = %q, want %q", test.json, got, test.want)
+		}
+	}
+}
+
+func TestFormatPos(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	start, end := f.Pos(12), f.Pos(25) // 2:3 to 3:6
+	for _, test := range []struct {
+		editor     string
+		start, end token.Pos
+		want       string
+	}{
+		{"emacs", start, end, "a.go:2.3-3.5"},
+		{"emacs", start, start, "a.go:2:3"},
+		{"emacs", token.NoPos, token.NoPos, "-"},
+		{"vim", start, end, "a.go:2:3"},
+		{"vim", token.NoPos, token.NoPos, "-"},
+		{"acme", start, end, "a.go:#12,#25"}, // (no such file: byte offsets)
+		{"acme", token.NoPos, token.NoPos, "-"},
+	} {
+		if got := formatPos(test.editor, false, "", fset, nil, test.start, test.end); got != test.want {
+			t.Errorf("formatPos(%s, %d, %d) = %s, want %s",
+				test.editor, test.start, test.end, got, test.want)
+		}
+	}
+}
+
+func TestRuneOffset(t *testing.T) {
+	f, err := ioutil.TempFile("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("// héllo, 世界\npackage p\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	src := newFileSource(&build.Default, false)
+	fset := token.NewFileSet()
+	file := fset.AddFile(f.Name(), -1, 28)
+	file.SetLines([]int{0, 18})
+	for _, test := range []struct{ offset, want int }{
+		{0, 0},
+		{4, 4},   // h
+		{7, 6},   // l, after é
+		{17, 12}, // \n, after 世界
+		{18, 13}, // package
+	} {
+		pos := file.Pos(test.offset)
+		if got := src.runeOffset(fset, pos, fset.Position(pos)); got != test.want {
+			t.Errorf("runeOffset(%d) = %d, want %d", test.offset, got, test.want)
+		}
+	}
+}
+
+func TestRuneColumns(t *testing.T) {
+	f, err := ioutil.TempFile("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("package p\n\nvar s = \"世界\" + x\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	src := newFileSource(&build.Default, false)
+	for _, test := range []struct{ line, col, want int }{
+		{1, 1, 1},
+		{1, 9, 9},
+		{3, 9, 9},   // "
+		{3, 17, 13}, // +, after 世界
+		{4, 1, 1},   // EOF
+		{9, 5, 5},   // no such line
+	} {
+		if got := src.runeColumn(f.Name(), test.line, test.col); got != test.want {
+			t.Errorf("runeColumn(%d, %d) = %d, want %d", test.line, test.col, got, test.want)
+		}
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile(f.Name(), -1, 100)
+	file.SetLines([]int{0, 10, 11})
+	s := newSerializer(fset, src, &FormatOptions{Runes: true})
+	if got, want := s.pos(file.Pos(28)), f.Name()+":3:14"; got != want {
+		t.Errorf("pos with rune columns = %s, want %s", got, want)
+	}
+	want := []serial.Offset{{Pos: f.Name() + ":3:14", Start: 28, End: 29, RuneCol: 14}}
+	if got := s.offsets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("offsets = %+v, want %+v", got, want)
+	}
+}
+
+func TestRuneColumnsModified(t *testing.T) {
+	const filename = "/nonesuch/p.go"
+	src := newFileSource(buildutil.OverlayContext(&build.Default, map[string][]byte{
+		filename: []byte("package p\n\nvar s = \"世界\" + x\n"),
+	}), false)
+	if got := src.runeColumn(filename, 3, 17); got != 13 {
+		t.Errorf("runeColumn of modified file = %d, want 13", got)
+	}
+}
+
+func TestFileSourceChanged(t *testing.T) {
+	f, err := ioutil.TempFile("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("var s = \"世界\" + x\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	src := newFileSource(&build.Default, false)
+	if got := src.runeColumn(f.Name(), 1, 17); got != 13 {
+		t.Errorf("runeColumn = %d, want 13", got)
+	}
+	// Rewrite the file, with the same size, and a later time.
+	if err := ioutil.WriteFile(f.Name(), []byte("var s = \"abcdef\" + x\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(f.Name(), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := src.runeColumn(f.Name(), 1, 17); got != 17 {
+		t.Errorf("runeColumn of changed file = %d, want 17", got)
+	}
+}
+
+// TestOverlaySource checks that the output formats read the files of
+// results through the build context, for queries by Run and by a
+// Server: the acme offsets are those of the overlay, in which a
+// comment of multibyte characters precedes the declaration, not those
+// of the file on disk.
+func TestOverlaySource(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "p", "p.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const src = "// 世界\npackage main\n\nvar s = 1\n\nfunc main() { println(s) }\n"
+	if err := ioutil.WriteFile(filename, []byte(strings.TrimPrefix(src, "// 世界\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	var buf bytes.Buffer
+	f := formatters["plain"](&FormatOptions{Editor: "acme"})
+	output := func(fset *token.FileSet, qr QueryResult) {
+		if err := f.Format(&buf, fset, qr); err != nil {
+			t.Error(err)
+		}
+	}
+	pos := fmt.Sprintf("%s:#%d", filename, strings.LastIndex(src, "s"))
+	want := fmt.Sprintf("%s:#24,#24: defined here as var s\n", filename)
+
+	q := &Query{
+		Pos:     pos,
+		Build:   &ctxt,
+		Overlay: map[string][]byte{filename: []byte(src)},
+		Output:  output,
+	}
+	if err := Run("definition", q); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Run: got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	q.Scope = []string{"p"}
+	s, err := NewServer(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run("definition", []string{pos}, output)[0]; err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Server.Run: got %q, want %q", got, want)
+	}
+}
+
+func TestRelative(t *testing.T) {
+	root := filepath.FromSlash("/ws/src")
+	for _, test := range []struct{ filename, want string }{
+		{"/ws/src/a/b.go", "a/b.go"},
+		{"/ws/src2/a/b.go", "/ws/src2/a/b.go"}, // not beneath root
+		{"/goroot/src/fmt/print.go", "/goroot/src/fmt/print.go"},
+		{"b.go", "b.go"}, // already relative
+		{"", ""},
+	} {
+		filename := filepath.FromSlash(test.filename)
+		if got := filepath.ToSlash(relativePath(root, filename)); got != test.want {
+			t.Errorf("relativePath(%s, %s) = %s, want %s", root, filename, got, test.want)
+		}
+		if got := relativePath("", filename); got != filename {
+			t.Errorf("relativePath(\"\", %s) = %s, want it unchanged", filename, got)
+		}
+	}
+
+	fset := token.NewFileSet()
+	f := fset.AddFile(filepath.FromSlash("/ws/src/a.go"), -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	if got := formatPos("emacs", false, root, fset, nil, f.Pos(12), f.Pos(25)); got != "a.go:2.3-3.5" {
+		t.Errorf("formatPos relative to %s = %s, want a.go:2.3-3.5", root, got)
+	}
+
+	g := fset.AddFile(filepath.FromSlash("/elsewhere/b.go"), -1, 100)
+	g.SetLines([]int{0, 10, 20})
+	s := newSerializer(fset, nil, &FormatOptions{Root: root})
+	if got, want := s.pos(f.Pos(12)), filepath.FromSlash("a.go")+":2:3"; got != want {
+		t.Errorf("pos relative to %s = %s, want %s", root, got, want)
+	}
+	if got, want := s.pos(g.Pos(12)), g.Name()+":2:3"; got != want {
+		t.Errorf("pos outside %s = %s, want %s", root, got, want)
+	}
+}
+
+// TestVersion checks that each object of the result stream of a query
+// with several, such as freevars, has the version of the schema.
+func TestVersion(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "app", "main.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const src = "package main\n\nfunc main() {\n\tx, s := 1, \"\"\n\tprintln(x, s)\n}\n"
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	var buf bytes.Buffer
+	f := jsonFormatter{&FormatOptions{}}
+	q := &Query{
+		Pos:   fmt.Sprintf("%s:#%d,#%d", filename, strings.Index(src, "println"), strings.Index(src, "\n}")),
+		Build: &ctxt,
+		Output: func(fset *token.FileSet, qr QueryResult) {
+			if err := f.Format(&buf, fset, qr); err != nil {
+				t.Error(err)
+			}
+		},
+	}
+	if err := Run("freevars", q); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for dec := json.NewDecoder(&buf); dec.More(); n++ {
+		var obj struct{ Version int }
+		if err := dec.Decode(&obj); err != nil {
+			t.Fatal(err)
+		}
+		if obj.Version != serial.Version {
+			t.Errorf("object %d has version %d, want %d", n, obj.Version, serial.Version)
+		}
+	}
+	if n != 3 { // two FreeVars and an ExtractSignature
+		t.Errorf("got %d objects, want 3", n)
+	}
+}
+
+func TestParsePos(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 30)
+	f.SetLines([]int{0, 10, 20})
+	for _, test := range []struct {
+		pos        string
+		start, end int // offsets, or -1 for error
+	}{
+		{"a.go:#3", 3, 3},
+		{"a.go:#3,#12", 3, 12},
+		{"a.go:1:4", 3, 3},
+		{"a.go:2:3,3:6", 12, 25},
+		{"a.go:#3,3:1", 3, 20},
+		{"a.go:4:1", -1, -1}, // no such line
+		{"a.go:2:0", -1, -1}, // no such column
+		{"a.go:2", -1, -1},
+		{"a.go", -1, -1},
+		{"", -1, -1},
+	} {
+		filename, startPos, endPos, err := parsePos(test.pos)
+		if err == nil {
+			if filename != "a.go" {
+				t.Errorf("parsePos(%q): filename = %q", test.pos, filename)
+			}
+			var start, end token.Pos
+			start, end, err = fileOffsetToPos(f, startPos, endPos)
+			if err == nil {
+				if got, want := [2]int{f.Offset(start), f.Offset(end)}, [2]int{test.start, test.end}; got != want {
+					t.Errorf("position %q has offsets %v, want %v", test.pos, got, want)
+				}
+				continue
+			}
+		}
+		if test.start >= 0 {
+			t.Errorf("position %q: %v", test.pos, err)
+		}
+	}
+}
+
+func TestIdentPos(t *testing.T) {
+	const src = `package b
+
+var V, W int
+
+type T struct{ F int }
+
+type I interface{ M() }
+
+func (*T) M() {}
+
+func F() {}
+`
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"a/b.v2": {"b.go": src},
+	})
+	for _, test := range []struct {
+		ident, want string // want is the selected text, or an error
+	}{
+		{"a/b.v2.W", "W"},
+		{"a/b.v2.T", "T"},
+		{"a/b.v2.F", "F"},
+		{"a/b.v2.T.F", "F"},
+		{"a/b.v2.T.M", "M"},
+		{"a/b.v2.I.M", "M"},
+		{"a/b.v2", "b"},
+		{"a/b.v2.X", "package a/b.v2 has no declaration of X"},
+		{"a/c.X", "a/c.X is neither a package nor a qualified identifier of one"},
+	} {
+		pos, err := IdentPos(&Query{Build: ctxt}, test.ident)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			_, start, end, err := parsePos(pos)
+			if err != nil {
+				t.Errorf("IdentPos(%s) = %s: %v", test.ident, pos, err)
+				continue
+			}
+			got = src[start.offset:end.offset]
+		}
+		if got != test.want {
+			t.Errorf("IdentPos(%s) selects %q, want %q", test.ident, got, test.want)
+		}
+	}
+}
+
+// TestIdentPosLine tests that IdentPos selects the declaration within
+// the file as parsed, not within the file to which a //line comment
+// maps it.
+func TestIdentPosLine(t *testing.T) {
+	const filename = "testdata/src/ident-line/gen.go"
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = "testdata"
+	q := &Query{Build: &ctxt, Output: func(*token.FileSet, QueryResult) {}}
+	for _, test := range []struct {
+		ident, want string // want is the selected text
+	}{
+		{"ident-line.Parse", "Parse"},
+		{"ident-line", "gen"},
+	} {
+		pos, err := IdentPos(q, test.ident)
+		if err != nil {
+			t.Errorf("IdentPos(%s): %v", test.ident, err)
+			continue
+		}
+		file, start, end, err := parsePos(pos)
+		if err != nil || filepath.Base(file) != filepath.Base(filename) {
+			t.Errorf("IdentPos(%s) = %s, want a position in %s", test.ident, pos, filename)
+			continue
+		}
+		if got := string(data[start.offset:end.offset]); got != test.want {
+			t.Errorf("IdentPos(%s) selects %q, want %q", test.ident, got, test.want)
+		}
+		q.Pos = pos
+		if err := Run("describe", q); err != nil {
+			t.Errorf("describe at IdentPos(%s) = %s: %v", test.ident, pos, err)
+		}
+	}
+}
+
+// edgesResult is a trivial graphResult.
+type edgesResult []callEdge
+
+func (r edgesResult) PrintPlain(printf printfFunc)    {}
+func (r edgesResult) JSON(fset *token.FileSet) []byte { return nil }
+func (r edgesResult) DOT(fset *token.FileSet) []byte  { return toDOT(newSerializer(fset, nil, nil), r) }
+func (r edgesResult) callEdges(visit func(callEdge)) {
+	for _, e := range r {
+		visit(e)
+	}
+}
+
+func TestWriteEdges(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	r := edgesResult{
+		{1, graphNode{name: "p.f"}, graphNode{name: "p.g"}, f.Pos(12), "static function call", 0, false},
+		{2, graphNode{name: "<root>"}, graphNode{name: "p.f"}, token.NoPos, "synthetic call", 0, false},
+	}
+	var buf bytes.Buffer
+	if err := writeEdges(&buf, newSerializer(fset, nil, nil), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"depth":1,"caller":"p.f","callee":"p.g","pos":"a.go:2:3","desc":"static function call"}
+{"depth":2,"caller":"\u003croot\u003e","callee":"p.f","pos":"-","desc":"synthetic call"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeEdges:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile(filepath.FromSlash("/dir/a.go"), -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	r := edgesResult{
+		{0, graphNode{name: "<root>"}, graphNode{"p.f", "p", f.Pos(2)}, token.NoPos, "synthetic call", 0, false},
+		{0, graphNode{"p.f", "p", f.Pos(2)}, graphNode{"(*p.T).m", "p", f.Pos(22)}, f.Pos(12), "dynamic method call", 0, true},
+		{0, graphNode{"p.f", "p", f.Pos(2)}, graphNode{"q", "q", token.NoPos}, token.NoPos, "2 calls", 2, false},
+	}
+	opts := &FormatOptions{Root: filepath.FromSlash("/dir")}
+	var buf bytes.Buffer
+	if err := writeGraphML(&buf, newSerializer(fset, nil, opts), r); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+		t.Errorf("writeGraphML: invalid XML: %v\n%s", err, got)
+	}
+	for _, want := range []string{
+		`<node id="n0">
+      <data key="name">&lt;root&gt;</data>
+    </node>`,
+		`<node id="n1">
+      <data key="name">p.f</data>
+      <data key="package">p</data>
+      <data key="function">f</data>
+      <data key="npos">a.go:1:3</data>
+    </node>`,
+		`<data key="function">(*T).m</data>`,
+		`<node id="n3">
+      <data key="name">q</data>
+      <data key="package">q</data>
+    </node>`,
+		`<edge source="n1" target="n2">
+      <data key="epos">a.go:2:3</data>
+      <data key="desc">dynamic method call</data>
+      <data key="dynamic">true</data>
+    </edge>`,
+		`<edge source="n1" target="n3">
+      <data key="desc">2 calls</data>
+      <data key="calls">2</data>
+    </edge>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeGraphML: got:\n%s\nwant it to contain:\n%s", got, want)
+		}
+	}
+}
+
+// TestCallgraphSynthetic checks that the callgraph query names the
+// boundary of the focus with the functions of no package.
+func TestCallgraphSynthetic(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "app.go", "package app; func F() {}", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _, err := ssautil.BuildPackage(new(types.Config), fset, types.NewPackage("app", ""), []*ast.File{f}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := new(types.Signature)
+	cg := callgraph.New(pkg.Prog.NewFunction("<root>", sig, "root of callgraph"))
+	nf := cg.CreateNode(pkg.Func("F"))
+	nw := cg.CreateNode(pkg.Prog.NewFunction("w", sig, ""))
+	callgraph.AddEdge(cg.Root, nil, nf)
+	callgraph.AddEdge(nf, nil, nw)
+	callgraph.AddEdge(nw, nil, nf)
+
+	r := &callgraphResult{root: cg.Root, focus: map[string]bool{"app": true}, nodes: []*callgraph.Node{cg.Root, nf}}
+	var buf bytes.Buffer
+	r.PrintPlain(func(_ interface{}, format string, args ...interface{}) {
+		fmt.Fprintf(&buf, format+"\n", args...)
+	})
+	want := `<root>
+	synthetic call to app.F
+app.F
+	1 call to synthetic functions
+	1 call from synthetic functions
+`
+	if got := buf.String(); got != want {
+		t.Errorf("PrintPlain:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	if err := r.elements(newSerializer(fset, nil, nil), func(elem interface{}) error {
+		json.Compact(&buf, toJSON(elem))
+		buf.WriteByte('\n')
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"version":1,"caller":"\u003croot\u003e","callee":"app.F","pos":"-","desc":"synthetic call"}
+{"version":1,"caller":"app.F","callee":"\u003csynthetic\u003e","pos":"-","desc":"1 call","calls":1}
+{"version":1,"caller":"\u003csynthetic\u003e","callee":"app.F","pos":"-","desc":"1 call","calls":1}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("elements:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestPackageOrder checks that the referrers results of each package
+// are output in order as soon as it and all before it are complete.
+func TestPackageOrder(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 100)
+	result := func(path string, offset int) *referrersPackageResult {
+		id := &ast.Ident{NamePos: f.Pos(offset), Name: "x"}
+		return &referrersPackageResult{pkg: types.NewPackage(path, path), fset: fset, refs: []*ast.Ident{id}}
+	}
+	var got []string
+	base := &Query{Output: func(fset *token.FileSet, qr QueryResult) {
+		r := qr.(*referrersPackageResult)
+		got = append(got, fmt.Sprintf("%s:%d", r.pkg.Path(), f.Offset(r.refs[0].NamePos)))
+	}}
+	q, order := orderPackages(base, map[string]int{"a": 1, "b": 2, "c": 1})
+	for _, step := range []struct {
+		output *referrersPackageResult
+		done   string
+		want   string
+	}{
+		{output: result("b", 20), done: "b"},
+		{output: result("a", 2)},
+		{output: result("a", 1), done: "a", want: "a:1 a:2"},
+		{output: result("c", 3), done: "c", want: "a:1 a:2"},
+		{output: result("b", 10), done: "b", want: "a:1 a:2 b:10 b:20 c:3"},
+	} {
+		q.Output(fset, step.output)
+		if step.done != "" {
+			order.done(step.done)
+		}
+		if got := strings.Join(got, " "); got != step.want {
+			t.Errorf("after output of %s:%d: got %q, want %q", step.output.pkg.Path(), f.Offset(step.output.refs[0].NamePos), got, step.want)
+		}
+	}
+
+	// The results of incomplete packages are output on close.
+	got = nil
+	q, order = orderPackages(base, map[string]int{"a": 1, "b": 1})
+	q.Output(fset, result("b", 1))
+	q.Output(fset, result("d", 1)) // not expected
+	q.Output(fset, result("a", 1))
+	order.close()
+	if got, want := strings.Join(got, " "), "a:1 b:1 d:1"; got != want {
+		t.Errorf("after close: got %q, want %q", got, want)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "p", "p.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("package p\n\nvar x = 1 + 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	for _, test := range []struct {
+		mode, pos, want string
+	}{
+		{"nosuch", filename + ":#16", codeMode},
+		{"definition", "p.go", codePosition},
+		{"definition", filename + ":#99", codePosition},
+		{"definition", filename + ":#23", codeQuery}, // 2
+		{"callers", filename + ":#16", codeScope},
+	} {
+		q := &Query{Pos: test.pos, Build: &ctxt, Output: func(*token.FileSet, QueryResult) {}}
+		err := Run(test.mode, q)
+		if err == nil {
+			t.Errorf("%s %s: unexpected success", test.mode, test.pos)
+			continue
+		}
+		if got := ErrorCode(err); got != test.want {
+			t.Errorf("%s %s: got code %q (%v), want %q", test.mode, test.pos, got, err, test.want)
+		}
+	}
+
+	data := toJSON(SerialError("callers", &Query{Scope: []string{"p"}}, "p.go:#1", errorf(codeScope, "no main")))
+	want := `{
+	"version": 1,
+	"code": "scope",
+	"message": "no main",
+	"mode": "callers",
+	"position": "p.go:#1",
+	"scope": [
+		"p"
+	]
+}`
+	if string(data) != want {
+		t.Errorf("SerialError = %s, want %s", data, want)
+	}
+}
+
+// TestModeNeeds checks that each query mode that needs the analysis
+// scope prepares a whole-program query, and that the others run
+// without loading it.
+func TestModeNeeds(t *testing.T) {
+	for name, m := range modes {
+		if got, want := m.prepare != nil, m.needs&needScope != 0; got != want {
+			t.Errorf("%s: has prepare = %t, needs scope = %t", name, got, want)
+		}
+		if m.run == nil && m.prepare == nil {
+			t.Errorf("%s: neither run nor prepare", name)
+		}
+		if m.needs&(needSSA|needPTA) != 0 && m.needs&needScope == 0 {
+			t.Errorf("%s: needs analysis without the scope", name)
+		}
+	}
+
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "p", "p.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("package p\n\nvar x = 1 + 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	// A scope that cannot be loaded is no obstacle to the modes
+	// that do not need it.
+	scope := []string{"nonesuch"}
+	for _, mode := range []string{"describe", "freevars", "implements", "what"} {
+		q := &Query{Pos: filename + ":#21", Build: &ctxt, Scope: scope, Output: func(*token.FileSet, QueryResult) {}}
+		if err := Run(mode, q); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+	q := &Query{Pos: filename + ":#21", Build: &ctxt, Scope: scope, Output: func(*token.FileSet, QueryResult) {}}
+	if err := Run("pointsto", q); err == nil {
+		t.Errorf("pointsto: unexpected success with scope %s", scope)
+	}
+}
+
+func TestModes(t *testing.T) {
+	infos := Modes()
+	if len(infos) != len(modes) {
+		t.Fatalf("Modes() returned %d modes, want %d", len(infos), len(modes))
+	}
+	byName := make(map[string]ModeInfo)
+	for i, m := range infos {
+		if i > 0 && infos[i-1].Name >= m.Name {
+			t.Errorf("Modes() not in order of name: %s before %s", infos[i-1].Name, m.Name)
+		}
+		if m.Description == "" {
+			t.Errorf("%s: no description", m.Name)
+		}
+		byName[m.Name] = m
+	}
+	for _, test := range []struct {
+		name              string
+		pos, exact, scope bool
+		formats           string
+	}{
+		{"callees", true, true, true, "plain json xml ndjson dot graphml jsonl"},
+		{"callgraph", false, false, true, "plain json xml ndjson dot graphml jsonl"},
+		{"describe", true, true, false, "plain json xml ndjson"},
+		{"referrers", true, false, false, "plain json xml ndjson"},
+		{"pointsto", true, true, true, "plain json xml ndjson"},
+	} {
+		m := byName[test.name]
+		if m.NeedsPos != test.pos || m.NeedsExact != test.exact || m.NeedsScope != test.scope {
+			t.Errorf("%s: NeedsPos, NeedsExact, NeedsScope = %t, %t, %t, want %t, %t, %t",
+				test.name, m.NeedsPos, m.NeedsExact, m.NeedsScope, test.pos, test.exact, test.scope)
+		}
+		if got := strings.Join(m.Formats, " "); got != test.formats {
+			t.Errorf("%s: Formats = %s, want %s", test.name, got, test.formats)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	for _, test := range []struct{ expr, want string }{
+		{"new(T)", "new(T)"},
+		{"&T{\n\tA: 1,\n\tB: 2,\n}", "&T{A: 1, B: 2}"},
+		{"make(chan int,\n\t2)", "make(chan int, 2)"},
+		{"func() {\n\tf()\n\tg()\n}", "func() {...}"},
+		{`[]string{"alpha", "beta", "gamma", "delta", "epsilon"}`, `[]string{"alpha", "beta", "gamma", "delt...`},
+		{`[]string{"aααααααααααααααααααα"}`, `[]string{"aαααααααααααααα...`},
+	} {
+		fset := token.NewFileSet()
+		e, err := parser.ParseExprFrom(fset, "x.go", test.expr, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := snippet(fset, e); got != test.want {
+			t.Errorf("snippet(%s) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "app", "main.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("package main\n\nfunc f() {}\n\nfunc main() { f() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	for _, test := range []struct {
+		mode, pos string
+		limit     uint64
+		want      string // error code, or "" for success
+	}{
+		{"callgraph", "", 1, codeMemory},
+		{"callgraph", "", 1 << 40, ""},
+		{"referrers", filename + ":#19", 1, codeMemory},
+		{"referrers", filename + ":#19", 1 << 40, ""},
+	} {
+		var results int
+		q := &Query{
+			Pos:       test.pos,
+			Build:     &ctxt,
+			Scope:     []string{"app"},
+			MaxMemory: test.limit,
+			Output:    func(*token.FileSet, QueryResult) { results++ },
+		}
+		err := Run(test.mode, q)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s, limit %d: %v", test.mode, test.limit, err)
+			} else if results == 0 {
+				t.Errorf("%s, limit %d: no results", test.mode, test.limit)
+			}
+			continue
+		}
+		if got := ErrorCode(err); err == nil || got != test.want {
+			t.Errorf("%s, limit %d: got error %v (code %q), want code %q", test.mode, test.limit, err, got, test.want)
+		}
+	}
+}
+
+func TestFprintfColor(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	pos := f.Pos(12) // 2:3
+	for _, test := range []struct {
+		color bool
+		pos   interface{}
+		want  string
+	}{
+		{false, pos, "a.go:2:3: msg\n"},
+		{false, edgePos(pos), "a.go:2:3: msg\n"},
+		{true, pos, "\x1b[36ma.go:2:3\x1b[0m: msg\n"},
+		{true, nil, "-: msg\n"},
+		{true, edgePos(pos), "\x1b[36ma.go:2:3\x1b[0m: \x1b[33mmsg\x1b[0m\n"},
+		{true, &queryPos{start: pos, end: pos}, "\x1b[36ma.go:2:3\x1b[0m: \x1b[1mmsg\x1b[0m\n"},
+	} {
+		var buf bytes.Buffer
+		fprintf(&buf, "emacs", false, test.color, "", fset, nil, test.pos, "%s", "msg")
+		if got := buf.String(); got != test.want {
+			t.Errorf("fprintf(color=%t, %T) = %q, want %q", test.color, test.pos, got, test.want)
+		}
+	}
+}
+
+func TestExpandLocalPatterns(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dir := filepath.Join(gopath, "src", "a", "b")
+	if err := os.MkdirAll(filepath.Join(dir, "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	got, err := ExpandPatterns(&Query{Build: &ctxt}, []string{".", "./...", "./c", "./c...", "-./c", "..", "../...", "fmt", "net/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/b", "a/b/...", "a/b/c", "a/b/c...", "-a/b/c", "a", "a/...", "fmt", "net/..."}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ExpandPatterns = %v, want %v", got, want)
+	}
+
+	if _, err := ExpandPatterns(&Query{Build: &ctxt}, []string{"../../.."}); err == nil {
+		t.Errorf("ExpandPatterns(../../..) succeeded outside the workspace")
+	}
+}
+
+func TestLessPos(t *testing.T) {
+	// The files are added out of the order of their names,
+	// as by the concurrent loader.
+	fset := token.NewFileSet()
+	b := fset.AddFile("b.go", -1, 10)
+	a := fset.AddFile("a.go", -1, 10)
+	a2 := fset.AddFile("a.go", -1, 10) // e.g. reparsed by referrers
+	for _, test := range []struct {
+		x, y token.Pos
+		want bool
+	}{
+		{a.Pos(1), a.Pos(2), true},
+		{a.Pos(2), a.Pos(1), false},
+		{a.Pos(1), a.Pos(1), false},
+		{a.Pos(9), b.Pos(0), true},
+		{b.Pos(0), a.Pos(9), false},
+		{a2.Pos(1), a.Pos(2), true},
+		{a.Pos(2), a2.Pos(1), false},
+		{token.NoPos, a.Pos(0), true},
+		{a.Pos(0), token.NoPos, false},
+		{token.NoPos, token.NoPos, false},
+	} {
+		if got := lessPos(fset, test.x, test.y); got != test.want {
+			t.Errorf("lessPos(%s, %s) = %t, want %t",
+				fset.Position(test.x), fset.Position(test.y), got, test.want)
+		}
+	}
+}
+
+// TestSerializerOffsets checks the Offsets of positions in a file read
+// through the build context, and in one to which a //line comment maps
+// another.
+func TestSerializerOffsets(t *testing.T) {
+	const filename = "/nonesuch/p.go"
+	const src = "package p\n\nvar s = \"世界\" + xyz\n"
+	fset := token.NewFileSet()
+	f := fset.AddFile(filename, -1, len(src))
+	f.SetLinesForContent([]byte(src))
+	s := newSerializer(fset, newFileSource(buildutil.OverlayContext(&build.Default, map[string][]byte{
+		filename: []byte(src),
+	}), false), nil)
+
+	for _, test := range []struct{ start, end, runecol int }{
+		{0, 7, 1},    // package
+		{15, 16, 5},  // s
+		{19, 27, 9},  // "世界"
+		{30, 33, 16}, // xyz, after 世界
+		{33, 33, 19}, // implicit semicolon
+	} {
+		str := s.pos(f.Pos(test.start))
+		s.pos(f.Pos(test.start)) // (recorded once)
+		want := []serial.Offset{{Pos: str, Start: test.start, End: test.end, RuneCol: test.runecol}}
+		if got := s.offsets(); !reflect.DeepEqual(got, want) {
+			t.Errorf("offsets of %s = %v, want %v", str, got, want)
+		}
+	}
+
+	// A position of gen.go that a //line comment maps to p.go:3.
+	gen := fset.AddFile("/nonesuch/gen.go", -1, 20)
+	gen.AddLineInfo(0, filename, 3)
+	if str := s.pos(gen.Pos(4)); str != filename+":3:5" {
+		t.Errorf("mapped position = %s, want %s:3:5", str, filename)
+	}
+	if got, want := s.offsets(), []serial.Offset{{Pos: filename + ":3:5", Start: 15, End: 16, RuneCol: 5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("offsets of mapped position = %v, want %v", got, want)
+	}
+
+	// Positions in files that cannot be read have none.
+	other := fset.AddFile("/nonesuch/q.go", -1, 10)
+	s.pos(other.Pos(1))
+	if got := s.offsets(); got != nil {
+		t.Errorf("offsets of unreadable file = %v, want none", got)
+	}
+}
+
+// TestLineOnly checks the positions of an object of export data, which
+// records only its line, as the importer of package gcimporter does.
+func TestLineOnly(t *testing.T) {
+	const filename = "/nonesuch/p.go"
+	const src = "package p\n\nvar s = \"世界\" + xyz\n"
+	fset := token.NewFileSet()
+	f := fset.AddFile(filename, -1, 100)
+	lines := make([]int, 100)
+	for i := range lines {
+		lines[i] = i
+	}
+	f.SetLines(lines)
+	obj := types.NewVar(f.Pos(2), nil, "s", types.Typ[types.Int]) // line 3
+
+	if start, end := posRange(fset, obj); start != obj.Pos() || end != start {
+		t.Errorf("posRange of line-only object = %d-%d, want %d-%d", start, end, obj.Pos(), obj.Pos())
+	}
+	s := newSerializer(fset, newFileSource(buildutil.OverlayContext(&build.Default, map[string][]byte{
+		filename: []byte(src),
+	}), false), nil)
+	if str := s.pos(obj.Pos()); str != filename+":3:1" {
+		t.Errorf("position = %s, want %s:3:1", str, filename)
+	}
+	if got, want := s.offsets(), []serial.Offset{{Pos: filename + ":3:1", Start: 11, End: 11, RuneCol: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("offsets of line-only position = %v, want %v", got, want)
+	}
+}
+
+// fakeResult is a QueryResult of a single position.
+type fakeResult struct{ pos token.Pos }
+
+func (r fakeResult) PrintPlain(printf printfFunc) { printf(r.pos, "result") }
+
+func (r fakeResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r fakeResult) serialize(s *serializer) interface{} {
+	return &serial.Definition{Version: serial.Version, ObjPos: s.pos(r.pos), Desc: "result"}
+}
+
+// upperFormatter is a Formatter that writes plain text in upper case.
+type upperFormatter struct{ opts *FormatOptions }
+
+func (f upperFormatter) Format(w io.Writer, fset *token.FileSet, qr QueryResult) error {
+	var buf bytes.Buffer
+	if err := (plainFormatter{f.opts}).Format(&buf, fset, qr); err != nil {
+		return err
+	}
+	_, err := w.Write(bytes.ToUpper(buf.Bytes()))
+	return err
+}
+
+func TestFormatters(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile(filepath.FromSlash("/dir/a.go"), -1, 10)
+	f.SetLines([]int{0, 5})
+	r := fakeResult{f.Pos(6)}
+	opts := &FormatOptions{Editor: "vim", Root: filepath.FromSlash("/dir")}
+
+	RegisterFormatter("upper", func(opts *FormatOptions) Formatter { return upperFormatter{opts} })
+	defer delete(formatters, "upper")
+	if got, want := formatNames(), "plain, json, jsonl, ndjson, xml, upper, dot, or graphml"; got != want {
+		t.Errorf("formatNames() = %q, want %q", got, want)
+	}
+
+	for _, test := range []struct {
+		format, want string
+	}{
+		{"plain", "a.go:2:2: result\n"},
+		{"upper", "A.GO:2:2: RESULT\n"},
+		{"json", `"objpos": "a.go:2:2"`},
+	} {
+		var buf bytes.Buffer
+		if err := formatters[test.format](opts).Format(&buf, fset, r); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if got := buf.String(); !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+
+	if _, ok := formatters["plain"](opts).(ErrorFormatter); ok {
+		t.Errorf("plain is an ErrorFormatter")
+	}
+	var buf bytes.Buffer
+	e := &serial.Error{Version: serial.Version, Code: codeQuery, Message: "no"}
+	if err := formatters["jsonl"](opts).(ErrorFormatter).FormatError(&buf, e); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), `{"version":`; !strings.HasPrefix(got, want) || strings.Count(got, "\n") != 1 {
+		t.Errorf("jsonl error = %q, want one line beginning %q", got, want)
+	}
+}
+
+func TestTemplateFormat(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile(filepath.FromSlash("/dir/a.go"), -1, 10)
+	f.SetLines([]int{0, 5})
+	opts := &FormatOptions{Root: filepath.FromSlash("/dir")}
+
+	if !isTemplateFormat("{{.Pos}}") || isTemplateFormat("json") {
+		t.Errorf("isTemplateFormat is wrong")
+	}
+	if _, err := parseTemplateFormat("{{.Pos"); err == nil {
+		t.Errorf("parseTemplateFormat of invalid template succeeded")
+	}
+	if _, err := NewFormatter("{{.Pos}}", opts); err != nil {
+		t.Errorf("NewFormatter of template failed: %v", err)
+	}
+	if _, err := NewFormatter("bogus", opts); err == nil {
+		t.Errorf("NewFormatter of unknown format succeeded")
+	}
+
+	for _, test := range []struct {
+		text string
+		qr   QueryResult
+		want string
+	}{
+		{"{{printf `%T` .}} {{.ObjPos}}", &definitionResult{pos: f.Pos(6), descr: "var x"}, "*serial.Definition a.go:2:2\n"},
+		{"{{printf `%T` .}} {{.More}}", &truncatedResult{more: 3, item: "caller"}, "*serial.Truncated 3\n"},
+		{`{{.desc}}`, fakeArrayResult{}, "first\nsecond\n"}, // an unknown result, as generic values
+	} {
+		newFormatter, err := parseTemplateFormat(test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := newFormatter(opts).Format(&buf, fset, test.qr); err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+// fakeArrayResult is a QueryResult whose JSON form is an array.
+type fakeArrayResult struct{}
+
+func (r fakeArrayResult) PrintPlain(printf printfFunc) {}
+
+func (r fakeArrayResult) JSON(fset *token.FileSet) []byte {
+	return toJSON([]serial.Definition{
+		{Version: serial.Version, Desc: "first"},
+		{Version: serial.Version, Desc: "second"},
+	})
+}
+
+func TestNDJSONFormat(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile(filepath.FromSlash("/dir/a.go"), -1, 10)
+	f.SetLines([]int{0, 5})
+	opts := &FormatOptions{Root: filepath.FromSlash("/dir")}
+
+	for _, test := range []struct {
+		qr   QueryResult
+		want string
+	}{
+		{fakeResult{f.Pos(6)}, `{"version":1,"objpos":"a.go:2:2","desc":"result"}` + "\n"},
+		{fakeArrayResult{}, `{"version":1,"desc":"first"}` + "\n" +
+			`{"version":1,"desc":"second"}` + "\n"},
+	} {
+		var buf bytes.Buffer
+		if err := formatters["ndjson"](opts).Format(&buf, fset, test.qr); err != nil {
+			t.Errorf("%T: %v", test.qr, err)
+			continue
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("%T: got %q, want %q", test.qr, got, test.want)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	const src = "package p\n\n//line gen.y:10:1\nvar x = 1\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := f.Scope.Lookup("x").Pos()
+	if got, want := (*fileSource)(nil).position(fset, x).String(), "gen.y:10:5"; got != want {
+		t.Errorf("position(nil source) = %s, want %s", got, want)
+	}
+	for _, test := range []struct {
+		physical bool
+		want     string
+	}{
+		{false, "gen.y:10:5"},
+		{true, "p.go:4:5"},
+	} {
+		s := newFileSource(&build.Default, test.physical)
+		if got := s.position(fset, x).String(); got != test.want {
+			t.Errorf("position(physical=%t) = %s, want %s", test.physical, got, test.want)
+		}
+	}
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"fmt"
@@ -16,6 +16,7 @@ import (
 
 	"golang.org/x/tools/cmd/guru/serial"
 	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/buildutil"
 )
 
 // what reports all the information about the query selection that can be
@@ -47,12 +48,39 @@ func what(q *Query) error {
 		case *ast.Ident:
 			enable["definition"] = true
 			enable["referrers"] = true
+			enable["rename-check"] = true
 			enable["implements"] = true
+			enable["shared"] = true // possibly a variable
+			enable["hierarchy"] = true
 		case *ast.CallExpr:
 			enable["callees"] = true
+			if id, ok := n.Fun.(*ast.Ident); ok {
+				switch id.Name {
+				case "new", "make":
+					enable["allocs"] = true
+				case "panic", "recover":
+					enable["panics"] = true
+				}
+			}
+		case *ast.CompositeLit:
+			enable["allocs"] = true
+		case *ast.ImportSpec:
+			enable["importers"] = true
+		case *ast.File:
+			if qpos.path[0] == n.Name {
+				enable["importers"] = true // the package clause
+			}
 		case *ast.FuncDecl:
 			enable["callers"] = true
 			enable["callstack"] = true
+			enable["effects"] = true
+		case *ast.SelectorExpr:
+			switch n.Sel.Name {
+			case "Lock", "Unlock", "RLock", "RUnlock":
+				enable["lockers"] = true // possibly a mutex op
+			}
+		case *ast.DeferStmt:
+			enable["panics"] = true
 		case *ast.SendStmt:
 			enable["peers"] = true
 		case *ast.UnaryExpr:
@@ -61,7 +89,7 @@ func what(q *Query) error {
 			}
 		}
 
-		// For implements, we approximate findInterestingNode.
+		// For implements and hierarchy, we approximate findInterestingNode.
 		if _, ok := enable["implements"]; !ok {
 			switch n.(type) {
 			case *ast.ArrayType,
@@ -71,6 +99,7 @@ func what(q *Query) error {
 				*ast.MapType,
 				*ast.ChanType:
 				enable["implements"] = true
+				enable["hierarchy"] = true
 			}
 		}
 
@@ -108,8 +137,10 @@ func what(q *Query) error {
 	}
 
 	var modes []string
-	for mode := range enable {
-		modes = append(modes, mode)
+	for mode, ok := range enable {
+		if ok {
+			modes = append(modes, mode)
+		}
 	}
 	sort.Strings(modes)
 
@@ -177,7 +208,10 @@ func guessImportPath(filename string, buildContext *build.Context) (srcdir, impo
 	}
 
 	absFileDir := filepath.Dir(absFile)
-	resolvedAbsFileDir, err := filepath.EvalSymlinks(absFileDir)
+	if !buildutil.IsDir(buildContext, absFileDir) {
+		return "", "", fmt.Errorf("can't evaluate symlinks of %s: no such directory", absFileDir)
+	}
+	resolvedAbsFileDir, err := evalSymlinks(absFileDir)
 	if err != nil {
 		return "", "", fmt.Errorf("can't evaluate symlinks of %s: %v", absFileDir, err)
 	}
@@ -217,6 +251,21 @@ func guessImportPath(filename string, buildContext *build.Context) (srcdir, impo
 	return srcdir, importPath, nil
 }
 
+// evalSymlinks is like filepath.EvalSymlinks for the absolute name of
+// a directory, but resolves one that is not on disk, such as that of
+// an overlay file, by its nearest ancestor that is.
+func evalSymlinks(dir string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil && os.IsNotExist(err) {
+		if parent := filepath.Dir(dir); parent != dir {
+			if resolved, err := evalSymlinks(parent); err == nil {
+				return filepath.Join(resolved, filepath.Base(dir)), nil
+			}
+		}
+	}
+	return resolved, err
+}
+
 func segments(path string) []string {
 	return strings.Split(path, string(os.PathSeparator))
 }
@@ -236,6 +285,7 @@ func prefixLen(x, y []string) int {
 }
 
 type whatResult struct {
+	resultSource
 	path       []ast.Node
 	modes      []string
 	srcdir     string
@@ -256,27 +306,31 @@ func (r *whatResult) PrintPlain(printf printfFunc) {
 	}
 }
 
-func (r *whatResult) JSON(fset *token.FileSet) []byte {
+func (r *whatResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *whatResult) serialize(s *serializer) interface{} {
 	var enclosing []serial.SyntaxNode
 	for _, n := range r.path {
 		enclosing = append(enclosing, serial.SyntaxNode{
 			Description: astutil.NodeDescription(n),
-			Start:       fset.Position(n.Pos()).Offset,
-			End:         fset.Position(n.End()).Offset,
+			Start:       s.src.position(s.fset, n.Pos()).Offset,
+			End:         s.src.position(s.fset, n.End()).Offset,
 		})
 	}
 
 	var sameids []string
 	for _, pos := range r.sameids {
-		sameids = append(sameids, fset.Position(pos).String())
+		sameids = append(sameids, s.pos(pos))
 	}
 
-	return toJSON(&serial.What{
+	return &serial.What{
+		Version:    serial.Version,
 		Modes:      r.modes,
 		SrcDir:     r.srcdir,
 		ImportPath: r.importPath,
 		Enclosing:  enclosing,
 		Object:     r.object,
 		SameIDs:    sameids,
-	})
+		Offsets:    s.offsets(),
+	}
 }
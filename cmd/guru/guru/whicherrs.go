@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package main
+package guru
 
 import (
 	"fmt"
@@ -13,7 +13,6 @@ import (
 
 	"golang.org/x/tools/cmd/guru/serial"
 	"golang.org/x/tools/go/ast/astutil"
-	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
@@ -29,34 +28,20 @@ var builtinErrorType = types.Universe.Lookup("error").Type()
 //
 // TODO(dmorsing): figure out if fields in errors like *os.PathError.Err
 // can be queried recursively somehow.
-func whicherrs(q *Query) error {
-	lconf := loader.Config{Build: q.Build}
-
-	if err := setPTAScope(&lconf, q.Scope); err != nil {
-		return err
-	}
-
-	// Load/parse/type-check the program.
-	lprog, err := loadWithSoftErrors(&lconf)
-	if err != nil {
-		return err
-	}
-
-	qpos, err := parseQueryPos(lprog, q.Pos, true) // needs exact pos
+func whicherrs(q *Query, a *analysis) (func() error, error) {
+	qpos, err := parseQueryPos(a.lprog, q.Pos, true) // needs exact pos
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	prog := ssautil.CreateProgram(lprog, ssa.GlobalDebug)
-
-	ptaConfig, err := setupPTA(prog, lprog, q.PTALog, q.Reflection)
-	if err != nil {
-		return err
+	if err := a.createSSA(); err != nil {
+		return nil, err
 	}
+	prog := a.prog
 
 	path, action := findInterestingNode(qpos.info, qpos.path)
 	if action != actionExpr {
-		return fmt.Errorf("whicherrs wants an expression; got %s",
+		return nil, fmt.Errorf("whicherrs wants an expression; got %s",
 			astutil.NodeDescription(qpos.path[0]))
 	}
 	var expr ast.Expr
@@ -64,19 +49,19 @@ func whicherrs(q *Query) error {
 	switch n := path[0].(type) {
 	case *ast.ValueSpec:
 		// ambiguous ValueSpec containing multiple names
-		return fmt.Errorf("multiple value specification")
+		return nil, fmt.Errorf("multiple value specification")
 	case *ast.Ident:
 		obj = qpos.info.ObjectOf(n)
 		expr = n
 	case ast.Expr:
 		expr = n
 	default:
-		return fmt.Errorf("unexpected AST for expr: %T", n)
+		return nil, fmt.Errorf("unexpected AST for expr: %T", n)
 	}
 
 	typ := qpos.info.TypeOf(expr)
 	if !types.Identical(typ, builtinErrorType) {
-		return fmt.Errorf("selection is not an expression of type 'error'")
+		return nil, fmt.Errorf("selection is not an expression of type 'error'")
 	}
 	// Determine the ssa.Value for the expression.
 	var value ssa.Value
@@ -87,11 +72,11 @@ func whicherrs(q *Query) error {
 		value, _, err = ssaValueForExpr(prog, qpos.info, path)
 	}
 	if err != nil {
-		return err // e.g. trivially dead code
+		return nil, err // e.g. trivially dead code
 	}
 
 	// Defer SSA construction till after errors are reported.
-	prog.Build()
+	a.buildSSA()
 
 	globals := findVisibleErrs(prog, qpos)
 	constants := findVisibleConsts(prog, qpos)
@@ -134,80 +119,84 @@ func whicherrs(q *Query) error {
 		}
 	}
 
-	ptaConfig.AddQuery(value)
+	a.ptaConfig.AddQuery(value)
 	for _, v := range globals {
-		ptaConfig.AddQuery(v)
+		a.ptaConfig.AddQuery(v)
 	}
+	a.needPTA = true
 
-	ptares := ptrAnalysis(ptaConfig)
-	valueptr := ptares.Queries[value]
-	if valueptr == (pointer.Pointer{}) {
-		return fmt.Errorf("pointer analysis did not find expression (dead code?)")
-	}
-	for g, v := range globals {
-		ptr, ok := ptares.Queries[v]
-		if !ok {
-			continue
-		}
-		if !ptr.MayAlias(valueptr) {
-			continue
+	return func() error {
+		ptares := a.ptares
+		valueptr := ptares.Queries[value]
+		if valueptr == (pointer.Pointer{}) {
+			return errorf(codeAnalysis, "pointer analysis did not find expression (dead code?)")
 		}
-		res.globals = append(res.globals, g)
-	}
-	pts := valueptr.PointsTo()
-	dedup := make(map[*ssa.NamedConst]bool)
-	for _, label := range pts.Labels() {
-		// These values are either MakeInterfaces or reflect
-		// generated interfaces. For the purposes of this
-		// analysis, we don't care about reflect generated ones
-		makeiface, ok := label.Value().(*ssa.MakeInterface)
-		if !ok {
-			continue
-		}
-		constval, ok := makeiface.X.(*ssa.Const)
-		if !ok {
-			continue
-		}
-		c := constants[*constval]
-		if c != nil && !dedup[c] {
-			dedup[c] = true
-			res.consts = append(res.consts, c)
-		}
-	}
-	concs := pts.DynamicTypes()
-	concs.Iterate(func(conc types.Type, _ interface{}) {
-		// go/types is a bit annoying here.
-		// We want to find all the types that we can
-		// typeswitch or assert to. This means finding out
-		// if the type pointed to can be seen by us.
-		//
-		// For the purposes of this analysis, we care only about
-		// TypeNames of Named or pointer-to-Named types.
-		// We ignore other types (e.g. structs) that implement error.
-		var name *types.TypeName
-		switch t := conc.(type) {
-		case *types.Pointer:
-			named, ok := t.Elem().(*types.Named)
+		for g, v := range globals {
+			ptr, ok := ptares.Queries[v]
 			if !ok {
-				return
+				continue
+			}
+			if !ptr.MayAlias(valueptr) {
+				continue
 			}
-			name = named.Obj()
-		case *types.Named:
-			name = t.Obj()
-		default:
-			return
+			res.globals = append(res.globals, g)
 		}
-		if !isAccessibleFrom(name, qpos.info.Pkg) {
-			return
+		pts := valueptr.PointsTo()
+		dedup := make(map[*ssa.NamedConst]bool)
+		for _, label := range pts.Labels() {
+			// These values are either MakeInterfaces or reflect
+			// generated interfaces. For the purposes of this
+			// analysis, we don't care about reflect generated ones
+			makeiface, ok := label.Value().(*ssa.MakeInterface)
+			if !ok {
+				continue
+			}
+			constval, ok := makeiface.X.(*ssa.Const)
+			if !ok {
+				continue
+			}
+			c := constants[*constval]
+			if c != nil && !dedup[c] {
+				dedup[c] = true
+				res.consts = append(res.consts, c)
+			}
 		}
-		res.types = append(res.types, &errorType{conc, name})
-	})
-	sort.Sort(membersByPosAndString(res.globals))
-	sort.Sort(membersByPosAndString(res.consts))
-	sort.Sort(sorterrorType(res.types))
+		concs := pts.DynamicTypes()
+		concs.Iterate(func(conc types.Type, _ interface{}) {
+			// go/types is a bit annoying here.
+			// We want to find all the types that we can
+			// typeswitch or assert to. This means finding out
+			// if the type pointed to can be seen by us.
+			//
+			// For the purposes of this analysis, we care only about
+			// TypeNames of Named or pointer-to-Named types.
+			// We ignore other types (e.g. structs) that implement error.
+			var name *types.TypeName
+			switch t := conc.(type) {
+			case *types.Pointer:
+				named, ok := t.Elem().(*types.Named)
+				if !ok {
+					return
+				}
+				name = named.Obj()
+			case *types.Named:
+				name = t.Obj()
+			default:
+				return
+			}
+			if !isAccessibleFrom(name, qpos.info.Pkg) {
+				return
+			}
+			res.types = append(res.types, &errorType{conc, name})
+		})
+		fset := a.prog.Fset
+		sort.Sort(membersByPosAndString{fset, res.globals})
+		sort.Sort(membersByPosAndString{fset, res.consts})
+		sort.Sort(sorterrorType{fset, res.types})
 
-	q.Output(lprog.Fset, res)
-	return nil
+		q.Output(a.lprog.Fset, res)
+		return nil
+	}, nil
 }
 
 // findVisibleErrs returns a mapping from each package-level variable of type "error" to nil.
@@ -256,23 +245,37 @@ func findVisibleConsts(prog *ssa.Program, qpos *queryPos) map[ssa.Const]*ssa.Nam
 	return constants
 }
 
-type membersByPosAndString []ssa.Member
+type membersByPosAndString struct {
+	fset    *token.FileSet
+	members []ssa.Member
+}
 
-func (a membersByPosAndString) Len() int { return len(a) }
+func (a membersByPosAndString) Len() int { return len(a.members) }
 func (a membersByPosAndString) Less(i, j int) bool {
-	cmp := a[i].Pos() - a[j].Pos()
-	return cmp < 0 || cmp == 0 && a[i].String() < a[j].String()
+	x, y := a.members[i], a.members[j]
+	if x.Pos() == y.Pos() {
+		return x.String() < y.String()
+	}
+	return lessPos(a.fset, x.Pos(), y.Pos())
+}
+func (a membersByPosAndString) Swap(i, j int) {
+	a.members[i], a.members[j] = a.members[j], a.members[i]
 }
-func (a membersByPosAndString) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
-type sorterrorType []*errorType
+type sorterrorType struct {
+	fset  *token.FileSet
+	types []*errorType
+}
 
-func (a sorterrorType) Len() int { return len(a) }
+func (a sorterrorType) Len() int { return len(a.types) }
 func (a sorterrorType) Less(i, j int) bool {
-	cmp := a[i].obj.Pos() - a[j].obj.Pos()
-	return cmp < 0 || cmp == 0 && a[i].typ.String() < a[j].typ.String()
+	x, y := a.types[i], a.types[j]
+	if x.obj.Pos() == y.obj.Pos() {
+		return x.typ.String() < y.typ.String()
+	}
+	return lessPos(a.fset, x.obj.Pos(), y.obj.Pos())
 }
-func (a sorterrorType) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a sorterrorType) Swap(i, j int) { a.types[i], a.types[j] = a.types[j], a.types[i] }
 
 type errorType struct {
 	typ types.Type      // concrete type N or *N that implements error
@@ -280,6 +283,7 @@ type errorType struct {
 }
 
 type whicherrsResult struct {
+	resultSource
 	qpos    *queryPos
 	errpos  token.Pos
 	globals []ssa.Member
@@ -308,20 +312,23 @@ func (r *whicherrsResult) PrintPlain(printf printfFunc) {
 	}
 }
 
-func (r *whicherrsResult) JSON(fset *token.FileSet) []byte {
-	we := &serial.WhichErrs{}
-	we.ErrPos = fset.Position(r.errpos).String()
+func (r *whicherrsResult) JSON(fset *token.FileSet) []byte { return serialJSON(fset, r) }
+
+func (r *whicherrsResult) serialize(s *serializer) interface{} {
+	we := &serial.WhichErrs{Version: serial.Version}
+	we.ErrPos = s.pos(r.errpos)
 	for _, g := range r.globals {
-		we.Globals = append(we.Globals, fset.Position(g.Pos()).String())
+		we.Globals = append(we.Globals, s.pos(g.Pos()))
 	}
 	for _, c := range r.consts {
-		we.Constants = append(we.Constants, fset.Position(c.Pos()).String())
+		we.Constants = append(we.Constants, s.pos(c.Pos()))
 	}
 	for _, t := range r.types {
 		var et serial.WhichErrsType
 		et.Type = r.qpos.typeString(t.typ)
-		et.Position = fset.Position(t.obj.Pos()).String()
+		et.Position = s.pos(t.obj.Pos())
 		we.Types = append(we.Types, et)
 	}
-	return toJSON(we)
+	we.Offsets = s.offsets()
+	return we
 }
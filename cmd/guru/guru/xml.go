@@ -0,0 +1,84 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package guru
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// toXML converts a stream of JSON values, as produced by
+// QueryResult.JSON, to XML of the same structure, so that the schema
+// of package serial applies to both.  Each value in the stream
+// becomes a <result> element; each member of an object becomes an
+// element named by its key; and each element of an array becomes an
+// <item> element.
+func toXML(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := encodeXML(enc, dec, "result", tok); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeXML encodes the JSON value whose first token is tok, reading
+// any remaining tokens from dec, as an XML element of the given name.
+func encodeXML(enc *xml.Encoder, dec *json.Decoder, name string, tok json.Token) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		for dec.More() {
+			name := "item"
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name = key.(string)
+			}
+			val, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if err := encodeXML(enc, dec, name, val); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+
+	case nil:
+		// null: empty element
+
+	default: // string, json.Number, or bool
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(tok))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
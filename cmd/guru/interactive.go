@@ -0,0 +1,88 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the interactive mode of the guru command (-i),
+// which loads and analyzes a program once and then answers queries
+// read from its standard input, for clients that would rather talk
+// to a subprocess over pipes than to a server over a socket.
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/cmd/guru/guru"
+)
+
+// interactive loads the program specified by q, then answers each of
+// the queries read from in, one per line, of the form "mode pos...",
+// by writing its results to out in the output format of formatter,
+// followed by a line "-- ok", or, if it failed, "-- error: message".
+// In the structured formats, a failure is reported by a serial.Error
+// too, before the line.  Blank lines are ignored.
+//
+// As with a Server, the program is reloaded before each query if any
+// of its files have changed.
+func interactive(in io.Reader, out io.Writer, q *guru.Query, formatter guru.Formatter) error {
+	s, err := guru.NewServer(q)
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		mode, posns := fields[0], fields[1:]
+		if len(posns) == 0 {
+			posns = []string{""} // a whole-program query
+		}
+
+		// A failure to format a result, such as one of a query
+		// that the format does not support, fails the query.
+		var mu sync.Mutex
+		var formatErr error
+		output := func(fset *token.FileSet, qr guru.QueryResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			if formatErr == nil {
+				formatErr = formatter.Format(out, fset, qr)
+			}
+		}
+		var failure error
+		for i, err := range s.Run(mode, posns, output) {
+			if err == nil {
+				continue
+			}
+			if ef, ok := formatter.(guru.ErrorFormatter); ok {
+				if err := ef.FormatError(out, guru.SerialError(mode, q, posns[i], err)); err != nil {
+					return err
+				}
+			}
+			if failure == nil {
+				failure = err
+			}
+		}
+		if failure == nil {
+			failure = formatErr
+		}
+		if failure != nil {
+			// The delimiter is a single line.
+			msg := strings.Replace(failure.Error(), "\n", "; ", -1)
+			_, err = fmt.Fprintf(out, "-- error: %s\n", msg)
+		} else {
+			_, err = fmt.Fprintln(out, "-- ok")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
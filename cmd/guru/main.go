@@ -7,24 +7,31 @@
 //    http://golang.org/s/using-guru
 //
 // Run with -help flag or help subcommand for usage information.
+// The queries are those of package golang.org/x/tools/cmd/guru/guru,
+// which other programs may import.
 //
 package main // import "golang.org/x/tools/cmd/guru"
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"go/build"
 	"go/token"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
 
+	"golang.org/x/tools/cmd/guru/client"
+	"golang.org/x/tools/cmd/guru/guru"
 	"golang.org/x/tools/go/buildutil"
 )
 
@@ -32,14 +39,40 @@ import (
 var (
 	modifiedFlag   = flag.Bool("modified", false, "read archive of modified files from standard input")
 	scopeFlag      = flag.String("scope", "", "comma-separated list of `packages` the analysis should be limited to")
+	rootsFlag      = flag.String("roots", "", "comma-separated list of additional workspace `directories`, searched before $GOPATH")
+	cacheFlag      = flag.String("cache", "", "`directory` of the on-disk cache of type information")
+	exportFlag     = flag.Bool("export", false, "read the types of dependencies from their compiled package objects, where up to date")
+	testsFlag      = flag.Bool("include-tests", true, "include the tests of the packages in scope; -include-tests=false excludes them")
+	jobsFlag       = flag.Int("j", 0, "maximum number of packages to type-check in parallel (0 means no limit)")
+	maxMemoryFlag  = flag.Uint64("max-memory", 0, "soft limit on the heap, in `megabytes`, beyond which queries stop early with truncated results (0 means no limit)")
+	maxResultsFlag = flag.Int("max-results", 0, "for referrers and callgraph, the maximum `number` of references or functions to report (0 means no limit)")
 	ptalogFlag     = flag.String("ptalog", "", "write points-to analysis log to `file`")
-	jsonFlag       = flag.Bool("json", false, "emit output in JSON format")
+	jsonFlag       = flag.Bool("json", false, "emit output in JSON format (same as -format=json)")
+	formatFlag     = flag.String("format", "plain", "output `format`: plain, json, jsonl, ndjson, xml, dot, graphml, or a template")
+	editorFlag     = flag.String("editor", "emacs", "syntax of positions in plain output for `editor`: emacs, vim, or acme")
+	columnsFlag    = flag.String("columns", "bytes", "`unit` of the columns of positions in the output: bytes or runes")
+	relativeFlag   = flag.String("relative", "", "print the file names of positions beneath `directory` relative to it")
+	positionsFlag  = flag.String("positions", "logical", "`kind` of positions in the output: logical, as mapped by //line comments, or physical")
+	colorFlag      = flag.String("color", "auto", "`when` to color plain output: auto (if standard output is a terminal), always, or never")
 	reflectFlag    = flag.Bool("reflect", false, "analyze reflection soundly (slow)")
+	ptaFlag        = flag.String("pta", "", "context sensitivity of the pointer analysis: 1cfa (slower), or empty for the default")
+	callgraphFlag  = flag.String("callgraph", "pta", "`algorithm` of the call graph: cha (fastest), rta, or pta (most precise)")
+	timingFlag     = flag.Bool("timing", false, "print the duration of each phase of the analysis to standard error")
+	verboseFlag    = flag.Bool("verbose", false, "print the wall time and memory of each phase of the query to standard error")
 	cpuprofileFlag = flag.String("cpuprofile", "", "write CPU profile to `file`")
+	serveFlag      = flag.String("serve", "", "serve JSON-RPC queries at `address`")
+	interactFlag   = flag.Bool("i", false, "answer queries, one 'mode pos' per line, read from standard input")
+	focusFlag      = flag.String("focus", "", "for callgraph, comma-separated list of `packages` whose functions are shown; calls to others are summarized")
+	depthFlag      = flag.Int("depth", 1, "for callers, report transitive callers to `depth` levels")
+	pathsFlag      = flag.String("paths", "", "for callstack, which `paths` to report: shortest, a number N of shortest paths, or all (default: any one path)")
+	identFlag      = flag.String("ident", "", "query the declaration of the qualified `identifier`, e.g. net/http.Client.Do, or a package")
 )
 
 func init() {
 	flag.Var((*buildutil.TagsFlag)(&build.Default.BuildTags), "tags", buildutil.TagsFlagDoc)
+	flag.StringVar(&build.Default.GOOS, "goos", build.Default.GOOS, "target `os` of the analyzed build, as for $GOOS")
+	flag.StringVar(&build.Default.GOARCH, "goarch", build.Default.GOARCH, "target `architecture` of the analyzed build, as for $GOARCH")
+	flag.BoolVar(&build.Default.CgoEnabled, "cgo", build.Default.CgoEnabled, "analyze files that use cgo, as for $CGO_ENABLED")
 
 	// gccgo does not provide a GOROOT with standard library sources.
 	// If we have one in the environment, force gc mode.
@@ -53,33 +86,116 @@ func init() {
 const useHelp = "Run 'guru -help' for more information.\n"
 
 const helpMessage = `Go source code guru.
-Usage: guru [flags] <mode> <position>
+Usage: guru [flags] <mode> <position>...
+       guru [flags] callgraph|deadcode
+       guru [flags] -ident <identifier> <mode>
+       guru [flags] -serve <address>
+       guru [flags] -i [<scope>]
 
 The mode argument determines the query to perform:
 
-	callees	  	show possible targets of selected function call
-	callers	  	show possible callers of selected function
-	callstack 	show path from callgraph root to selected function
-	definition	show declaration of selected identifier
-	describe  	describe selected syntax: definition, methods, etc
-	freevars  	show free variables of selection
-	implements	show 'implements' relation for selected type or method
-	peers     	show send/receive corresponding to selected channel op
-	pointsto	show variables the selected pointer may point to
-	referrers 	show all refs to entity denoted by selected identifier
-	what		show basic information about the selected syntax node
-	whicherrs	show possible values of the selected error variable
-
+%s
 The position argument specifies the filename and byte offset (or range)
 of the syntax element to query.  For example:
 
 	foo.go:#123,#128
 	bar.go:#123
 
-The -json flag causes guru to emit output in JSON format;
-	golang.org/x/tools/cmd/guru/serial defines its schema.
-	Otherwise, the output is in an editor-friendly format in which
-	every line has the form "pos: text", where pos is "-" if unknown.
+A position may instead be given as a 1-based line and column,
+where the column is measured in bytes:
+
+	foo.go:10:5,12:1
+	bar.go:10:5
+
+The -ident flag specifies the query position instead as the
+	declaration of a package-qualified identifier of one of the forms
+		net/http.Get            # a package-level entity
+		net/http.Client.Do      # a method or field of a type
+	or as the package clause of a package, such as net/http, which
+	is useful in scripts, where positions may be unknown or change
+	across edits.
+
+If several positions are given, the query is performed at each
+	of them in turn.  Queries that require whole-program analysis
+	share a single loaded program and pointer analysis.
+
+The -format flag selects the output format.  The default, plain, is
+	an editor-friendly format in which every line has the form
+	"pos: text", where pos is "-" if unknown.  With -format=json,
+	guru emits JSON; golang.org/x/tools/cmd/guru/serial defines its
+	schema.  With -format=xml, guru emits XML of the same structure:
+	each JSON value is a <result> element, each object member an
+	element named by its key, and each array element an <item>.
+	With -format=dot, the callees, callers, callstack and callgraph queries
+	emit a Graphviz digraph whose nodes are functions and whose edges
+	are calls, labelled by the positions of the call sites.
+	With -format=graphml, they emit the same graph as a GraphML
+	document, for tools such as Gephi and yEd, in which each node has
+	the package, name and position of its function, and each edge
+	the position and description of its call site, and whether the
+	call is dynamic.
+	With -format=jsonl, they instead emit one JSON serial.CallEdge
+	record per line, as each edge is found, which is suitable for
+	very large results, such as those of callers with a large -depth.
+	With -format=ndjson, any query emits each object of its results,
+	and each element of a result that is an array, such as each
+	caller, or each edge of callgraph, as compact JSON on a line of
+	its own; each reference of referrers is a serial.ReferrersPackage
+	of its own.  The elements of callgraph are written as they are
+	found, and those of referrers as the search of each package, and
+	of every package before it in order of import path, is complete,
+	so that a consumer may process them before a long query is.
+	In the json and xml formats, each result object has a version
+	member, serial.Version, which changes only when the schema does
+	so incompatibly, and an offsets member, which gives the byte
+	offsets of the start and end of the token at each position.
+	In the json, jsonl and xml formats, a query that fails emits a
+	serial.Error, which classifies the failure by a code, instead of
+	printing a message to standard error.
+	A -format value that contains "{{" is a Go text/template, which is
+	executed, and followed by a newline, for each object of the
+	result stream, or each element of a result that is an array,
+	as the value of package serial that describes it.  So
+		guru -format='{{range .Callees}}{{.Name}} {{end}}' callees ...
+	prints just the names of the callees.
+	The -json flag is a synonym for -format=json.
+
+The -editor flag selects the syntax of the positions in plain output.
+	With the default, emacs, an interval is written as
+	file:line.col-line.col, which Emacs' compilation mode recognizes,
+	so that each line of output is a clickable location; see guru.el.
+	With vim, each position is written as file:line:col, which
+	Vim's default 'errorformat' recognizes, so that the output
+	may be loaded into the quickfix list; see guru.vim.
+	With acme, each position is written as file:#q0,#q1, where q0
+	and q1 are character offsets, an address that Acme and the
+	plumber understand; see acme/Guru.
+
+The -columns flag selects the unit of the column numbers of positions
+	in all output formats.  By default, columns are 1-based byte
+	indices, as in the go/token package.  With -columns=runes, they
+	are 1-based character indices, as used by many editors for lines
+	containing non-ASCII text.  Acme offsets are always in characters.
+
+The -relative flag causes the file names of positions beneath the
+	specified directory, in all output formats, to be printed
+	relative to it, so that the output does not depend on where the
+	workspace is; -relative=. makes them relative to the current
+	directory.  The names of other files, such as those of the
+	standard library, are printed in full.
+
+The -positions flag selects the kind of the positions of results in
+	generated code, such as that of yacc, stringer or protoc, which
+	carries //line comments.  With the default, logical, they are
+	the positions in the original source to which the comments map
+	them; with physical, they are those of the generated files.
+	Positions in files preprocessed by cgo are always logical.
+
+The -color flag controls the coloring of plain output by ANSI escape
+	sequences, which show positions in cyan, the query in bold, and
+	calls and channel operations in yellow.  With the default, auto,
+	output is colored only if it is a terminal; -color=always and
+	-color=never force it on and off.
 
 The -modified flag causes guru to read an archive from standard input.
 	Files in this archive will be used in preference to those in
@@ -88,14 +204,151 @@ The -modified flag causes guru to read an archive from standard input.
 	consists of the file name, a newline, the decimal file size,
 	another newline, and the contents of the file.
 
+The -depth flag causes the callers query to report also the callers
+	of each caller, transitively, as an indented tree up to the
+	specified depth.  Recursive callers, and callers whose own
+	callers have already been shown, are not expanded again.
+
+The -callgraph flag selects the algorithm that computes the call
+	graph of the callees, callers, callstack, callgraph and deadcode
+	queries.  The default, pta, derives it from the pointer analysis,
+	which is the most precise, but may take minutes on large programs.
+	With -callgraph=cha, Class Hierarchy Analysis assumes that a
+	dynamic call may reach any function or method of a matching
+	signature; it is the fastest, and often good enough for navigation.
+	With -callgraph=rta, Rapid Type Analysis limits those to the
+	types converted to interfaces in the code reachable from main.
+
+The -paths flag selects the call paths reported by the callstack
+	query.  By default, it reports any one path, preferring one of
+	only static calls, which is quick to find.  With -paths=shortest,
+	it reports a shortest path, and with -paths=N, up to N distinct
+	paths, shortest first.  With -paths=all, it reports every path,
+	as a tree of the callers of the function that are on a path
+	from the root, in which callers whose own callers have already
+	been shown are not expanded again.  Other than the default,
+	these need the complete call graph, and so the pointer analysis.
+
 The -scope flag restricts analysis to the specified packages.
 	Its value is a comma-separated list of patterns of these forms:
 		golang.org/x/tools/cmd/guru     # a single package
 		golang.org/x/tools/...          # all packages beneath dir
+		./...                           # all packages beneath the current directory
 		...                             # the entire workspace.
+		std                             # the standard library
+		cmd                             # the commands of the Go distribution
 	A pattern preceded by '-' is negative, so the scope
 		encoding/...,-encoding/xml
 	matches all encoding packages except encoding/xml.
+	The analysis starts from the main packages of the scope, and
+	from the tests of the others; those that have neither are
+	analyzed as if called with zero arguments from a synthetic
+	main function, which calls each of their exported functions
+	and methods.  So the libraries of the standard library may be
+	analyzed themselves, as in
+		guru -scope std -ident io.Copy callers
+	A query within a _test.go file adds the package it tests, with
+	its internal and external tests, to the scope.
+	Without a scope, a callees query of a dynamic call answers by
+	class hierarchy analysis of the packages that depend on the
+	selected one: the result is labelled as an over-approximation.
+
+The -include-tests flag, true by default, causes the _test.go files
+	and external test packages of the packages in the -scope, and of
+	those that the implements, referrers and rename-check queries
+	search, to be analyzed too, so that the results include the uses
+	within tests.
+	With -include-tests=false, they are excluded, which is faster
+	when only the production code is of interest.
+
+The -roots flag names additional workspaces, each laid out like a
+	directory of $GOPATH, in which packages are found before those
+	of $GOPATH, such as a project-local tree of vendored packages.
+
+The -tags flag specifies a space-separated list of build tags to
+	consider satisfied, as does that of 'go build', so that files
+	guarded by build constraints are included or excluded alike.
+
+The -goos, -goarch and -cgo flags select the build configuration to
+	analyze, overriding $GOOS, $GOARCH and $CGO_ENABLED, so that, for
+	example, guru on darwin/amd64 reports on a linux/arm program.
+	As with the go command, cgo is disabled by default when the
+	target differs from the host.
+
+The -cache flag names a directory in which guru saves the type
+	information of each dependency of the query package, so that the
+	definition, describe, freevars, implements and referrers queries
+	need not parse and type-check unchanged dependencies again.
+	Objects of cached packages are located only to the line, not the
+	column.  The SSA form of the whole-program queries is not cached:
+	go/ssa has no serial form, and builds functions from their syntax,
+	so those queries load the analysis scope from source; a guru
+	server (-serve, or -i) keeps it in memory instead.
+
+The -export flag causes the same queries to read the type
+	information of each dependency from its compiled package object,
+	as installed by 'go install', if that is newer than its files,
+	instead of parsing and type-checking it.  As with -cache, its
+	objects are located only to the line.
+
+The -j flag limits the number of packages that guru type-checks at
+	once.  Each package is type-checked as soon as its dependencies
+	are complete, so by default, all independent packages are
+	checked in parallel.
+
+The -max-memory flag sets a soft limit, in megabytes, on the heap of
+	guru.  A query that exceeds it, such as callgraph or referrers
+	over a large scope, stops loading and analyzing the program,
+	prints the results found so far, if any, and then a warning
+	that they are truncated, instead of exhausting the memory of
+	the machine.  Its exit status is 1.  Referrers prints the
+	references in each package as soon as that package, and every
+	package before it in order of import path, has been searched,
+	so a consumer need not wait for the end of a long query.
+
+The -verbose flag causes guru to print, to standard error, a line for
+	each phase of the query as it ends: loading, which parses and
+	type-checks the packages concurrently, and so includes both;
+	SSA construction; the generation and solving of the constraints
+	of the pointer analysis; and the query itself.  Each line gives
+	the wall time of the phase, the memory allocated during it, and
+	the size of the heap at its end.  The figures of a phase exclude
+	those of the phases within it, so that those of the query are of
+	its evaluation alone.  With -serve, each query is reported.
+
+The -max-results flag limits the number of items reported by the
+	queries whose results may be enormous: the references of
+	referrers, and the functions, with their calls, of callgraph.
+	The results of a query that has more end with a line such as
+	"and 1234 more references", or, in the json and xml formats,
+	with a serial.Truncated object.  A client of -serve may request
+	the subsequent pages of a result; see the First and Max fields
+	of client.QueryArgs.
+
+The -serve flag causes guru to load and analyze the program specified
+	by -scope once, and then to answer queries using JSON-RPC
+	at the specified address, which is either a TCP address such as
+	localhost:1234, or a Unix-domain socket such as unix:/tmp/guru.
+	golang.org/x/tools/cmd/guru/client provides a client.
+
+The -i flag causes guru to load and analyze the program specified by
+	-scope, or by its argument, once, and then to answer the queries
+	that it reads from standard input, one per line, each a mode and
+	its positions separated by spaces, such as
+		callers foo.go:#123
+	until the end of the input.  The results of each query, in the
+	-format, end with a line "-- ok", or, if the query failed, with
+	"-- error: " and its message, so that an editor may run guru as
+	a subprocess and read the results of each query from its pipe.
+	Like -serve, it reloads the packages whose files have changed
+	before each query.
+
+The exit status distinguishes the categories of failure: 1 for a
+	failure or limitation of the analysis, 2 for invalid flags, mode
+	or scope, 3 if the program could not be loaded or type-checked,
+	and 4 if the position is invalid or the query does not apply
+	there.  In a batch of several positions, the status is that of
+	the first failure.
 
 User manual: http://golang.org/s/using-guru
 
@@ -105,7 +358,11 @@ Example: describe syntax at offset 530 in this file (an import spec):
 `
 
 func printHelp() {
-	fmt.Fprintln(os.Stderr, helpMessage)
+	var modeList bytes.Buffer
+	for _, m := range guru.Modes() {
+		fmt.Fprintf(&modeList, "\t%-13s%s\n", m.Name, m.Description)
+	}
+	fmt.Fprintf(os.Stderr, helpMessage+"\n", modeList.Bytes())
 	fmt.Fprintln(os.Stderr, "Flags:")
 	flag.PrintDefaults()
 }
@@ -123,19 +380,123 @@ func main() {
 		if err == flag.ErrHelp {
 			printHelp()
 		}
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 
-	args := flag.Args()
-	if len(args) != 2 {
-		flag.Usage()
-		os.Exit(2)
+	// As in the go command, cgo is disabled by default
+	// when the target differs from the host.
+	cgoSet := os.Getenv("CGO_ENABLED") != ""
+	flag.Visit(func(f *flag.Flag) { cgoSet = cgoSet || f.Name == "cgo" })
+	if !cgoSet && (build.Default.GOOS != runtime.GOOS || build.Default.GOARCH != runtime.GOARCH) {
+		build.Default.CgoEnabled = false
 	}
-	mode, posn := args[0], args[1]
 
-	if mode == "help" {
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "help" {
 		printHelp()
-		os.Exit(2)
+		os.Exit(exitUsage)
+	}
+	if *serveFlag != "" {
+		if len(args) != 0 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+	} else if *interactFlag {
+		if len(args) > 1 || len(args) == 1 && *scopeFlag != "" {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if len(args) == 1 {
+			*scopeFlag = args[0]
+		}
+	} else if *identFlag != "" {
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+	} else if len(args) < 2 && !(len(args) == 1 && isWholeProgramMode(args[0])) {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *jsonFlag {
+		*formatFlag = "json"
+	}
+	switch *formatFlag {
+	case "dot", "graphml", "jsonl":
+		if *serveFlag == "" && !*interactFlag {
+			if m, ok := lookupMode(args[0]); ok && !supportsFormat(m, *formatFlag) {
+				usagef("-format=%s is not supported by %s queries", *formatFlag, args[0])
+			}
+		}
+	}
+	switch *editorFlag {
+	case "emacs", "vim", "acme":
+	default:
+		usagef("invalid -editor %q: want emacs, vim, or acme", *editorFlag)
+	}
+	switch *columnsFlag {
+	case "bytes", "runes":
+	default:
+		usagef("invalid -columns %q: want bytes or runes", *columnsFlag)
+	}
+	runes := *columnsFlag == "runes"
+	switch *positionsFlag {
+	case "logical", "physical":
+	default:
+		usagef("invalid -positions %q: want logical or physical", *positionsFlag)
+	}
+	var root string
+	if *relativeFlag != "" {
+		var err error
+		if root, err = filepath.Abs(*relativeFlag); err != nil {
+			usagef("invalid -relative %q: %v", *relativeFlag, err)
+		}
+	}
+	var color bool
+	switch *colorFlag {
+	case "auto":
+		color = isTerminal(os.Stdout) && os.Getenv("TERM") != "dumb"
+	case "always":
+		color = true
+	case "never":
+	default:
+		usagef("invalid -color %q: want auto, always, or never", *colorFlag)
+	}
+	formatter, err := guru.NewFormatter(*formatFlag, &guru.FormatOptions{
+		Editor: *editorFlag,
+		Runes:  runes,
+		Color:  color,
+		Root:   root,
+	})
+	if err != nil {
+		usagef("invalid -format: %v", err)
+	}
+	switch *ptaFlag {
+	case "", "1cfa":
+	default:
+		usagef("invalid -pta %q: want 1cfa", *ptaFlag)
+	}
+	switch *callgraphFlag {
+	case "cha", "rta", "pta":
+	default:
+		usagef("invalid -callgraph %q: want cha, rta, or pta", *callgraphFlag)
+	}
+	if *maxResultsFlag < 0 {
+		usagef("invalid -max-results %d: want a positive number, or 0 for no limit", *maxResultsFlag)
+	}
+	var paths int
+	switch *pathsFlag {
+	case "":
+	case "shortest":
+		paths = 1
+	case "all":
+		paths = -1
+	default:
+		n, err := strconv.Atoi(*pathsFlag)
+		if err != nil || n < 1 {
+			usagef("invalid -paths %q: want shortest, a positive number, or all", *pathsFlag)
+		}
+		paths = n
 	}
 
 	// Set up points-to analysis log file.
@@ -179,7 +540,8 @@ func main() {
 		}
 
 		// All I/O done by guru needs to consult the modified map.
-		// The ReadFile done by referrers does,
+		// The ReadFile done by referrers does, as does the
+		// conversion of positions to character offsets,
 		// but the loader's cgo preprocessing currently does not.
 
 		if len(modified) > 0 {
@@ -188,38 +550,213 @@ func main() {
 	}
 
 	var outputMu sync.Mutex
-	output := func(fset *token.FileSet, qr QueryResult) {
+	output := func(fset *token.FileSet, qr guru.QueryResult) {
 		outputMu.Lock()
 		defer outputMu.Unlock()
-		if *jsonFlag {
-			// JSON output
-			fmt.Printf("%s\n", qr.JSON(fset))
-		} else {
-			// plain output
-			printf := func(pos interface{}, format string, args ...interface{}) {
-				fprintf(os.Stdout, fset, pos, format, args...)
-			}
-			qr.PrintPlain(printf)
+		if err := formatter.Format(os.Stdout, fset, qr); err != nil {
+			log.Fatal(err)
 		}
 	}
 
+	var timing io.Writer
+	if *timingFlag {
+		timing = os.Stderr
+	}
+
 	// Avoid corner case of split("").
 	var scope []string
 	if *scopeFlag != "" {
 		scope = strings.Split(*scopeFlag, ",")
 	}
 
+	var roots []string
+	if *rootsFlag != "" {
+		roots = strings.Split(*rootsFlag, ",")
+	}
+
+	var focus []string
+	if *focusFlag != "" {
+		focus = strings.Split(*focusFlag, ",")
+	}
+
 	// Ask the guru.
-	query := Query{
-		Pos:        posn,
-		Build:      ctxt,
-		Scope:      scope,
-		PTALog:     ptalog,
-		Reflection: *reflectFlag,
-		Output:     output,
+	query := guru.Query{
+		Build:       ctxt,
+		Roots:       roots,
+		Cache:       *cacheFlag,
+		ExportData:  *exportFlag,
+		NoTests:     !*testsFlag,
+		Parallelism: *jobsFlag,
+		MaxMemory:   *maxMemoryFlag << 20,
+		MaxResults:  *maxResultsFlag,
+		Scope:       scope,
+		PTA: guru.PTAOptions{
+			Log:        ptalog,
+			Timing:     timing,
+			Reflection: *reflectFlag,
+			Context:    *ptaFlag,
+			CallGraph:  *callgraphFlag,
+		},
+		Depth:  *depthFlag,
+		Paths:  paths,
+		Focus:  focus,
+		Output: output,
+
+		PhysicalPositions: *positionsFlag == "physical",
+
+		// Report type errors as the loader would, even those that
+		// the query tolerates.
+		TypeErrors: func(err error) { fmt.Fprintln(os.Stderr, err) },
+	}
+	if *verboseFlag {
+		query.Progress = newPhaseReporter(os.Stderr).progress
 	}
 
-	if err := Run(mode, &query); err != nil {
-		log.Fatal(err)
+	// Resolve the patterns relative to the current directory.
+	if query.Scope, err = guru.ExpandPatterns(&query, scope); err != nil {
+		usagef("invalid -scope: %v", err)
+	}
+	if query.Focus, err = guru.ExpandPatterns(&query, focus); err != nil {
+		usagef("invalid -focus: %v", err)
+	}
+
+	if *serveFlag != "" {
+		if err := serve(*serveFlag, &query); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *interactFlag {
+		if err := interactive(os.Stdin, os.Stdout, &query, formatter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	mode, posns := args[0], args[1:]
+	if *identFlag != "" {
+		pos, err := guru.IdentPos(&query, *identFlag)
+		if err != nil {
+			usagef("-ident: %s", err)
+		}
+		posns = []string{pos}
+	}
+	if len(posns) == 0 {
+		posns = []string{""} // a whole-program query
+	}
+	query.Pos = posns[0]
+
+	// In the structured formats, a failure is reported on
+	// standard output, as a serial.Error.
+	report := func(pos string, err error) {
+		if ef, ok := formatter.(guru.ErrorFormatter); ok {
+			if err := ef.FormatError(os.Stdout, guru.SerialError(mode, &query, pos, err)); err != nil {
+				log.Fatal(err)
+			}
+		} else if len(posns) > 1 {
+			log.Printf("%s: %s", pos, err)
+		} else {
+			log.Print(err)
+		}
+	}
+
+	if len(posns) == 1 {
+		if err := guru.Run(mode, &query); err != nil {
+			report(query.Pos, err)
+			os.Exit(exitCode(guru.ErrorCode(err)))
+		}
+		return
+	}
+
+	var failure error // the first
+	for i, err := range guru.RunBatch(mode, &query, posns) {
+		if err != nil {
+			report(posns[i], err)
+			if failure == nil {
+				failure = err
+			}
+		}
+	}
+	if failure != nil {
+		os.Exit(exitCode(guru.ErrorCode(failure)))
+	}
+}
+
+// Exit codes of the guru command, by category of failure.
+const (
+	exitAnalysis = 1 // a failure or limitation of the analysis
+	exitUsage    = 2 // invalid flags, mode or scope
+	exitLoad     = 3 // the program could not be loaded or type-checked
+	exitQuery    = 4 // the query does not apply at this position
+)
+
+// exitCode returns the exit code for a failure of a query, of the
+// specified code (see serial.Error).
+func exitCode(code string) int {
+	switch code {
+	case "mode", "scope":
+		return exitUsage
+	case "load":
+		return exitLoad
+	case "position", "query":
+		return exitQuery
+	}
+	return exitAnalysis
+}
+
+// lookupMode returns the description of the named query mode, or
+// false if there is no such mode.
+func lookupMode(name string) (guru.ModeInfo, bool) {
+	for _, m := range guru.Modes() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return guru.ModeInfo{}, false
+}
+
+// isWholeProgramMode reports whether the query mode concerns the
+// entire analysis scope, and so needs no query position.
+func isWholeProgramMode(mode string) bool {
+	m, ok := lookupMode(mode)
+	return ok && !m.NeedsPos
+}
+
+// supportsFormat reports whether the query mode m supports the
+// standard output format.
+func supportsFormat(m guru.ModeInfo, format string) bool {
+	for _, f := range m.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// usagef reports an invalid command line and exits.
+func usagef(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(exitUsage)
+}
+
+// isTerminal reports whether f is a terminal (or other character
+// device), to which it is safe to write ANSI escapes.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// serve loads the program specified by q and serves queries about it
+// at the specified address.
+func serve(addr string, q *guru.Query) error {
+	s, err := guru.NewServer(q)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen(client.Network(addr))
+	if err != nil {
+		return err
 	}
+	log.Printf("serving queries at %s", l.Addr())
+	return s.Serve(l)
 }
@@ -0,0 +1,121 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Tests of the guru command itself; those of its queries are in
+// package guru.
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/guru"
+)
+
+func TestPhaseReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := newPhaseReporter(&buf)
+	var clock time.Duration
+	var allocated uint64
+	r.now = func() time.Time { return time.Unix(0, 0).Add(clock) }
+	r.mem = func() (uint64, uint64) { return allocated, 100 << 20 }
+	for _, e := range []struct {
+		event     guru.ProgressEvent
+		clock     time.Duration // in seconds
+		allocated uint64        // in megabytes
+	}{
+		{guru.ProgressEvent{Phase: "query"}, 0, 0},
+		{guru.ProgressEvent{Phase: "load"}, 0, 0},
+		{guru.ProgressEvent{Phase: "load", Package: "p", Packages: 1}, 1, 1},
+		{guru.ProgressEvent{Phase: "load", Package: "q", Packages: 2}, 2, 2},
+		{guru.ProgressEvent{Phase: "load", Done: true}, 3, 10},
+		{guru.ProgressEvent{Phase: "pointer analysis"}, 4, 10},
+		{guru.ProgressEvent{Phase: "pointer analysis", Step: "generate"}, 5, 10},
+		{guru.ProgressEvent{Phase: "pointer analysis", Step: "solve"}, 7, 20},
+		{guru.ProgressEvent{Phase: "pointer analysis", Done: true}, 10, 30},
+		{guru.ProgressEvent{Phase: "query", Done: true}, 12, 32},
+	} {
+		clock, allocated = e.clock*time.Second, e.allocated<<20
+		r.progress(e.event)
+	}
+	const want = `load: 3s wall, 10.0 MB allocated, 100.0 MB heap, 2 packages
+pointer analysis: generate: 2s wall, 10.0 MB allocated, 100.0 MB heap
+pointer analysis: solve: 3s wall, 10.0 MB allocated, 100.0 MB heap
+pointer analysis: 1s wall, 0.0 MB allocated, 100.0 MB heap
+query: 3s wall, 2.0 MB allocated, 100.0 MB heap
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+
+func TestExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		code string
+		want int
+	}{
+		{"mode", exitUsage},
+		{"scope", exitUsage},
+		{"load", exitLoad},
+		{"position", exitQuery},
+		{"query", exitQuery},
+		{"analysis", exitAnalysis},
+		{"memory", exitAnalysis},
+	} {
+		if got := exitCode(test.code); got != test.want {
+			t.Errorf("exitCode(%q) = %d, want %d", test.code, got, test.want)
+		}
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "guru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	filename := filepath.Join(gopath, "src", "app", "main.go")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("package main\n\nfunc f() {}\n\nfunc main() { f() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := build.Default // copy
+	ctxt.GOPATH = gopath
+
+	in := strings.Join([]string{
+		"callers " + filename + ":#19",
+		"",
+		"bogus " + filename + ":#19",
+		"callers " + filename + ":#19",
+		"what " + filename + ":#1000",
+	}, "\n")
+	var out bytes.Buffer
+	q := &guru.Query{Build: &ctxt, Scope: []string{"app"}}
+	plain, err := guru.NewFormatter("plain", &guru.FormatOptions{Editor: "vim", Root: gopath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := interactive(strings.NewReader(in), &out, q, plain); err != nil {
+		t.Fatal(err)
+	}
+	callers := "src/app/main.go:3:6: app.f is called from these 1 sites:\n" +
+		"src/app/main.go:5:16: \tstatic function call from app.main\n"
+	want := callers + "-- ok\n" +
+		`-- error: invalid mode: "bogus"` + "\n" +
+		callers + "-- ok\n" +
+		"-- error: start position is beyond end of file\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
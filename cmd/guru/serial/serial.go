@@ -2,52 +2,310 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package serial defines the guru's schema for -json output.
+// Package serial defines the guru's schema for -json (and -format=xml) output.
 //
 // The output of a guru query is a stream of one or more JSON objects.
 // This table shows the types of objects in the result stream for each
 // query type.
 //
-//      Query      Result stream
-//      -----      -------------
-//      callees    Callees
-//      callers    Caller ...
-//      callstack  CallStack
-//      definition Definition
-//      describe   Describe
-//      freevars   FreeVar ...
-//      implements Implements
-//      peers      Peers
-//      pointsto   PointsTo ...
-//      referrers  ReferrersInitial ReferrersPackage ...
-//      what       What
-//      whicherrs  WhichErrs
+//      Query        Result stream
+//      -----        -------------
+//      allocs       Allocs
+//      callees      Callees
+//      callgraph    []CallEdge
+//      callers      Caller ...
+//      callstack    CallStack
+//      deadcode     DeadCode
+//      definition   Definition
+//      describe     Describe
+//      effects      Effects
+//      freevars     FreeVar ... ExtractSignature
+//      hierarchy    TypeHierarchy
+//      implements   Implements
+//      importers    Importer ...
+//      lockers      Lockers
+//      panics       Panics
+//      peers        Peers
+//      pointsto     PointsTo ...
+//      referrers    ReferrersInitial ReferrersPackage ...
+//      rename-check RenameCheck
+//      shared       Shared
+//      what         What
+//      whicherrs    WhichErrs
+//
+// In XML output, each object in the result stream is a <result>
+// element containing one element per field, named by its JSON key;
+// the elements of a slice are <item> elements.
+//
+// With -format=jsonl, the callees, callers, callstack and callgraph queries
+// instead emit a stream of CallEdge objects, one per line.
+//
+// With -format=ndjson, each object of the result stream, and each
+// element of a result that is an array, is emitted as compact JSON on
+// a line of its own, except that each Ref of referrers is emitted as a
+// ReferrersPackage of its own.
+//
+// If a query fails, its output ends with an Error object.  If the items
+// of its results were limited by -max-results, and there were more,
+// its output ends with a Truncated object.
+//
+// Versioning: each object of the result stream, and each object
+// element of a result that is an array, has a first member "version",
+// its Version field, whose value is Version.  (Objects nested within
+// others, and the CallEdge records of -format=jsonl, do not.)  Within
+// a version, the schema changes only compatibly: fields and query
+// modes may be added, but no existing field is removed, renamed, or
+// changed in type or meaning.  Any other change increments Version,
+// so that clients can detect it rather than misinterpret the output.
 //
 // All 'pos' strings in the output are of the form "file:line:col",
-// where line is the 1-based line number and col is the 1-based byte index.
+// where line is the 1-based line number and col is the 1-based byte index,
+// or, with -columns=runes, the 1-based character index.
+//
+// Offsets: each object of the result stream that contains positions,
+// and each such object element of a result that is an array, has a
+// last member "offsets", its Offsets field, an array of Offset, one for
+// each distinct position within it, so that clients need not convert
+// lines and columns to byte offsets themselves.  (Objects nested
+// within others do not.)  Each Offset also gives the character column
+// of its position, so that clients of the guru server, whose positions
+// have byte columns, need not convert those either.
 package serial
 
+import (
+	"encoding/json"
+	"io"
+)
+
+// Version is the version of the schema defined by this package.
+// See the package documentation for its guarantees.
+const Version = 1
+
 // A Peers is the result of a 'peers' query.
 // If Allocs is empty, the selected channel can't point to anything.
+// Closes are the close(ch) calls that may close it.
 type Peers struct {
+	Version  int      `json:"version,omitempty"`  // Version, unless within a DescribeSelectCase
 	Pos      string   `json:"pos"`                // location of the selected channel op (<-)
 	Type     string   `json:"type"`               // type of the selected channel
 	Allocs   []string `json:"allocs,omitempty"`   // locations of aliased make(chan) ops
+	Caps     []int64  `json:"caps,omitempty"`     // buffer capacity of Allocs[i], or -1 if not constant
 	Sends    []string `json:"sends,omitempty"`    // locations of aliased ch<-x ops
 	Receives []string `json:"receives,omitempty"` // locations of aliased <-ch ops
 	Closes   []string `json:"closes,omitempty"`   // locations of aliased close(ch) ops
+
+	Field   string          `json:"field,omitempty"`   // name of the field, if the channel is a field x.f
+	Holders []PointsToLabel `json:"holders,omitempty"` // fields f of the objects x that may hold the channel
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions, unless within a DescribeSelectCase
+}
+
+// An Allocs is the result of an 'allocs' query.
+// Labels are reported only for a variable of reference type; if
+// empty, the variable can't point to anything.
+type Allocs struct {
+	Version int             `json:"version"`          // always Version
+	Pos     string          `json:"pos"`              // location of the selected expression
+	Type    string          `json:"type"`             // type of the selected expression
+	Storage string          `json:"storage"`          // "heap", "stack", or "global"
+	Site    string          `json:"site"`             // location of the allocation
+	Labels  []PointsToLabel `json:"labels,omitempty"` // objects to which the variable may point
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A DeadCode is the result of a 'deadcode' query: the functions of
+// the analysis scope that are unreachable from main and init,
+// grouped by package in order of import path.
+type DeadCode struct {
+	Version  int           `json:"version"` // always Version
+	Packages []DeadPackage `json:"packages,omitempty"`
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A DeadPackage lists the unreachable functions of one package,
+// in order of position.
+type DeadPackage struct {
+	Path  string     `json:"path"`  // import path of the package
+	Funcs []DeadFunc `json:"funcs"` // unreachable functions
+}
+
+// A DeadFunc is an unreachable function.
+type DeadFunc struct {
+	Name string `json:"name"` // full name of the function
+	Pos  string `json:"pos"`  // location of the function
+}
+
+// An Importer is one element of the result of an 'importers' query:
+// an import declaration, in a package of the analysis scope, of the
+// selected package, or of a package that depends on it.  The elements
+// are in order of the path of the importing package, then of position.
+type Importer struct {
+	Version int    `json:"version"`          // always Version
+	Pos     string `json:"pos"`              // location of the import declaration
+	Package string `json:"package"`          // import path of the importing package
+	Import  string `json:"import"`           // import path of the package it declares
+	Direct  bool   `json:"direct,omitempty"` // Import is the selected package itself
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A Lockers is the result of a 'lockers' query.
+// If Allocs is empty, the selected mutex can't point to anything.
+type Lockers struct {
+	Version  int          `json:"version"`            // always Version
+	Pos      string       `json:"pos"`                // location of the selected mutex or mutex op
+	Type     string       `json:"type"`               // sync.Mutex or sync.RWMutex
+	Allocs   []string     `json:"allocs,omitempty"`   // locations of aliased mutexes
+	Locks    []string     `json:"locks,omitempty"`    // locations of aliased Lock calls
+	Unlocks  []string     `json:"unlocks,omitempty"`  // locations of aliased Unlock calls
+	RLocks   []string     `json:"rlocks,omitempty"`   // locations of aliased RLock calls
+	RUnlocks []string     `json:"runlocks,omitempty"` // locations of aliased RUnlock calls
+	Holders  []LockHolder `json:"holders,omitempty"`  // functions that may acquire the mutex
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// An Effects is the result of an 'effects' query: the package-level
+// variables that the selected function may access, in order of name.
+type Effects struct {
+	Version int            `json:"version"`           // always Version
+	Pos     string         `json:"pos"`               // location of the selection
+	Func    string         `json:"func"`              // the selected function
+	Globals []EffectGlobal `json:"globals,omitempty"` // the variables it may access
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// An EffectGlobal is a package-level variable that a function may
+// read or write, with a representative access of each kind.
+type EffectGlobal struct {
+	Name  string        `json:"name"`            // full name of the variable
+	Pos   string        `json:"pos"`             // location of its declaration
+	Read  *EffectAccess `json:"read,omitempty"`  // a load of it, if any
+	Write *EffectAccess `json:"write,omitempty"` // a store of it, if any
+}
+
+// An EffectAccess is a load or store of a package-level variable.
+type EffectAccess struct {
+	Pos  string `json:"pos"`  // location of the access
+	Func string `json:"func"` // the function containing the access
+}
+
+// A Panics is the result of a 'panics' query.  For a call of recover,
+// or a defer statement, Panics lists the calls of panic whose values
+// it may recover; for a call of panic, Recovers lists the calls of
+// recover that may recover its value, and for a defer statement,
+// those of the functions it defers.
+type Panics struct {
+	Version  int           `json:"version"`            // always Version
+	Pos      string        `json:"pos"`                // location of the selected call or defer statement
+	Desc     string        `json:"desc"`               // "call of panic", "call of recover" or "defer statement"
+	Panics   []PanicSite   `json:"panics,omitempty"`   // calls of panic
+	Recovers []RecoverSite `json:"recovers,omitempty"` // calls of recover
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A PanicSite is a call of panic.
+type PanicSite struct {
+	Pos  string `json:"pos"`  // location of the call
+	Func string `json:"func"` // the function containing the call
+}
+
+// A RecoverSite is a call of recover by a deferred function.
+type RecoverSite struct {
+	Pos      string `json:"pos"`      // location of the call
+	Func     string `json:"func"`     // the deferred function containing the call
+	Deferrer string `json:"deferrer"` // the function that defers it
+}
+
+// A RenameCheck is the result of a 'rename-check' query: what renaming
+// the selected object must take into account.
+type RenameCheck struct {
+	Version   int                  `json:"version"`             // always Version
+	ObjPos    string               `json:"objpos"`              // location of the definition
+	Desc      string               `json:"desc"`                // description of the object
+	Exported  bool                 `json:"exported,omitempty"`  // the object is exported
+	Refs      []string             `json:"refs,omitempty"`      // locations of its references
+	Conflicts []RenameConflict     `json:"conflicts,omitempty"` // names to which it cannot be renamed
+	Satisfies []RenameSatisfaction `json:"satisfies,omitempty"` // satisfactions that depend on its name
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A RenameConflict is a set of names to which an object cannot be
+// renamed because they are already declared in its block, or by its
+// type ("declared"), because they would shadow it at a reference
+// ("shadows"), or because it would capture the references to them in
+// its block ("captures").
+type RenameConflict struct {
+	Pos    string   `json:"pos"`    // location of the object, or of the reference
+	Reason string   `json:"reason"` // "declared", "shadows" or "captures"
+	Names  []string `json:"names"`
+}
+
+// A RenameSatisfaction is a type that implements an interface by a
+// method of the name of the selected method.
+type RenameSatisfaction struct {
+	Type      ImplementsType `json:"type"`      // the implementing type, perhaps an interface
+	Interface ImplementsType `json:"interface"` // the interface it implements
+}
+
+// A Shared is the result of a 'shared' query.
+// If Local is set, the selected variable is held in registers by each
+// call of that function, and is not shared; otherwise, if Conflicts is
+// empty, none of its accesses conflict.
+type Shared struct {
+	Version    int               `json:"version"`              // always Version
+	Pos        string            `json:"pos"`                  // location of the selected variable
+	Desc       string            `json:"desc"`                 // e.g. "var x" or "field f"
+	Local      string            `json:"local,omitempty"`      // the function each call of which has its own variable
+	Goroutines []SharedGoroutine `json:"goroutines,omitempty"` // the goroutines that may access the variable
+	Conflicts  []SharedAccess    `json:"conflicts,omitempty"`  // the accesses that may conflict
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A SharedGoroutine is the main goroutine, or the goroutines started
+// by a go statement.
+type SharedGoroutine struct {
+	Pos  string `json:"pos"`            // location of the main function, or of the go statement
+	Main bool   `json:"main,omitempty"` // the main goroutine
+	Many bool   `json:"many,omitempty"` // the go statement may start more than one goroutine
+}
+
+// A SharedAccess is a load or store of a shared variable.
+type SharedAccess struct {
+	Pos        string `json:"pos"`             // location of the access
+	Write      bool   `json:"write,omitempty"` // the access is a store
+	Func       string `json:"func"`            // the function containing the access
+	Goroutines []int  `json:"goroutines"`      // indices in Shared.Goroutines of those that may make it
+}
+
+// A LockHolder is a function that calls Lock or RLock on a mutex.
+type LockHolder struct {
+	Name string `json:"name"` // full name of the function
+	Pos  string `json:"pos"`  // location of the function
 }
 
 // A "referrers" query emits a ReferrersInitial object followed by zero or
 // more ReferrersPackage objects, one per package that contains a reference.
 type (
 	ReferrersInitial struct {
-		ObjPos string `json:"objpos,omitempty"` // location of the definition
-		Desc   string `json:"desc"`             // description of the denoted object
+		Version int    `json:"version"`          // always Version
+		ObjPos  string `json:"objpos,omitempty"` // location of the definition
+		Desc    string `json:"desc"`             // description of the denoted object
+
+		Offsets []Offset `json:"offsets,omitempty"` // of its positions
 	}
 	ReferrersPackage struct {
+		Version int    `json:"version"` // always Version
 		Package string `json:"package"`
 		Refs    []Ref  `json:"refs"` // non-empty list of references within this package
+
+		Offsets []Offset `json:"offsets,omitempty"` // of its positions
 	}
 	Ref struct {
 		Pos  string `json:"pos"`  // location of all references
@@ -57,19 +315,30 @@ type (
 
 // A Definition is the result of a 'definition' query.
 type Definition struct {
-	ObjPos string `json:"objpos,omitempty"` // location of the definition
-	Desc   string `json:"desc"`             // description of the denoted object
+	Version int    `json:"version,omitempty"` // Version, unless within a DescribeValue
+	ObjPos  string `json:"objpos,omitempty"`  // location of the definition
+	Desc    string `json:"desc"`              // description of the denoted object
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions, unless within a DescribeValue
 }
 
 // A Callees is the result of a 'callees' query.
 //
 // Callees is nonempty unless the call was a dynamic call on a
 // provably nil func or interface value.
+//
+// Without an analysis scope, the callees of a dynamic call are those
+// found by class hierarchy analysis of the loaded packages, which
+// include some that are not possible; Approximate is then set.
 type (
 	Callees struct {
-		Pos     string    `json:"pos"`  // location of selected call site
-		Desc    string    `json:"desc"` // description of call site
-		Callees []*Callee `json:"callees"`
+		Version     int       `json:"version"` // always Version
+		Pos         string    `json:"pos"`     // location of selected call site
+		Desc        string    `json:"desc"`    // description of call site
+		Callees     []*Callee `json:"callees"`
+		Approximate bool      `json:"approximate,omitempty"` // callees are an over-approximation
+
+		Offsets []Offset `json:"offsets,omitempty"` // of its positions
 	}
 	Callee struct {
 		Name string `json:"name"` // full name of called function
@@ -81,32 +350,120 @@ type (
 // (Callstack also contains a similar slice.)
 //
 // The root of the callgraph has an unspecified "Caller" string.
+//
+// If transitive callers were requested (-depth), Callers holds the
+// callers of Caller.  It is empty at the depth limit, and for a
+// recursive caller or one whose callers appear earlier in the result.
 type Caller struct {
-	Pos    string `json:"pos,omitempty"` // location of the calling function
-	Desc   string `json:"desc"`          // description of call site
-	Caller string `json:"caller"`        // full name of calling function
+	Version int      `json:"version,omitempty"` // Version, unless within another Caller or a CallStack
+	Pos     string   `json:"pos,omitempty"`     // location of the calling function
+	Desc    string   `json:"desc"`              // description of call site
+	Caller  string   `json:"caller"`            // full name of calling function
+	Callers []Caller `json:"callers,omitempty"` // callers of Caller, if requested
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions, unless within another Caller or a CallStack
+}
+
+// A CallEdge is a record of the streaming output (-format=jsonl) of
+// the callees, callers, callstack and callgraph queries, each line of
+// which is a CallEdge describing a single call edge of the result.
+// The result of a 'callgraph' query is also a list of CallEdges; in an
+// edge that summarizes the calls to or from another package, the
+// other endpoint is its import path, or "<synthetic>" for synthetic
+// functions, such as wrappers, which belong to no package.
+type CallEdge struct {
+	Version int    `json:"version,omitempty"` // Version, except in -format=jsonl
+	Depth   int    `json:"depth,omitempty"`   // callers: depth in tree of transitive callers
+	Caller  string `json:"caller"`            // full name of calling function
+	Callee  string `json:"callee"`            // full name of called function
+	Pos     string `json:"pos"`               // location of call site, or "-"
+	Desc    string `json:"desc"`              // description of call site
+	Calls   int    `json:"calls,omitempty"`   // callgraph: number of calls summarized by an edge to or from another package
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions, except in -format=jsonl
+}
+
+// A CallGraph is a call graph whose edges are produced incrementally:
+// Edges calls emit for each one, stopping at the first error.
+type CallGraph struct {
+	Edges func(emit func(*CallEdge) error) error
+}
+
+// WriteTo writes the edges of g to w as a stream of JSON CallEdge
+// objects, one per line, as they are produced, so that a large graph
+// need not be materialized in its entirety.
+func (g CallGraph) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	enc := json.NewEncoder(cw)
+	err := g.Edges(func(e *CallEdge) error { return enc.Encode(e) })
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
 // A CallStack is the result of a 'callstack' query.
 // It indicates an arbitrary path from the root of the callgraph to
-// the query function.
+// the query function, or with the -paths flag, the shortest ones, or
+// all of them.
 //
-// If the Callers slice is empty, the function was unreachable in this
-// analysis scope.
+// If the Callers slice is empty, and so are More and Tree, the
+// function was unreachable in this analysis scope.
 type CallStack struct {
-	Pos     string   `json:"pos"`     // location of the selected function
-	Target  string   `json:"target"`  // the selected function
-	Callers []Caller `json:"callers"` // enclosing calls, innermost first.
+	Version int        `json:"version"`        // always Version
+	Pos     string     `json:"pos"`            // location of the selected function
+	Target  string     `json:"target"`         // the selected function
+	Callers []Caller   `json:"callers"`        // enclosing calls, innermost first.
+	More    [][]Caller `json:"more,omitempty"` // -paths=N: the other paths, as Callers
+
+	// With -paths=all, Tree holds the calls of the selected function
+	// on a path from the root, each with its own such calls (in
+	// Caller.Callers), transitively, and Callers is empty.
+	Tree []Caller `json:"tree,omitempty"`
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
 }
 
 // A FreeVar is one element of the slice returned by a 'freevars'
 // query.  Each one identifies an expression referencing a local
 // identifier defined outside the selected region.
 type FreeVar struct {
-	Pos  string `json:"pos"`  // location of the identifier's definition
-	Kind string `json:"kind"` // one of {var,func,type,const,label}
-	Ref  string `json:"ref"`  // referring expression (e.g. "x" or "x.y.z")
-	Type string `json:"type"` // type of the expression
+	Version int    `json:"version"` // always Version
+	Pos     string `json:"pos"`     // location of the identifier's definition
+	Kind    string `json:"kind"`    // one of {var,func,type,const,label}
+	Ref     string `json:"ref"`     // referring expression (e.g. "x" or "x.y.z")
+	Type    string `json:"type"`    // type of the expression
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// An ExtractSignature is the final result of a 'freevars' query.
+// It describes the function that would result from extracting the
+// selection: its parameters are the free variables and constants,
+// and its results are the variables defined in the selection and used
+// after it, or, if the selection is an expression, its value.
+// Control flow that leaves the selection, such as a return statement,
+// is not accounted for.
+type ExtractSignature struct {
+	Version   int          `json:"version"`           // always Version
+	Signature string       `json:"signature"`         // the signature in Go syntax, e.g. "func(x *int) (y string)"
+	Params    []ExtractVar `json:"params,omitempty"`  // parameters, in order
+	Results   []ExtractVar `json:"results,omitempty"` // results, in order
+}
+
+// An ExtractVar is a parameter or result of an ExtractSignature.
+type ExtractVar struct {
+	Name    string `json:"name,omitempty"`    // name of the variable; empty for the value of an expression
+	Type    string `json:"type"`              // type of the parameter, e.g. "*int" if Pointer
+	Pointer bool   `json:"pointer,omitempty"` // variable is assigned by the selection, so must be passed by address
 }
 
 // An Implements contains the result of an 'implements' query.
@@ -115,6 +472,7 @@ type FreeVar struct {
 // (concrete or non-empty interface) which may be assigned to it.
 //
 type Implements struct {
+	Version           int              `json:"version"`           // always Version
 	T                 ImplementsType   `json:"type,omitempty"`    // the queried type
 	AssignableTo      []ImplementsType `json:"to,omitempty"`      // types assignable to T
 	AssignableFrom    []ImplementsType `json:"from,omitempty"`    // interface types assignable from T
@@ -128,6 +486,8 @@ type Implements struct {
 	AssignableToMethod      []DescribeMethod `json:"to_method,omitempty"`
 	AssignableFromMethod    []DescribeMethod `json:"from_method,omitempty"`
 	AssignableFromPtrMethod []DescribeMethod `json:"fromptr_method,omitempty"`
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
 }
 
 // An ImplementsType describes a single type as part of an 'implements' query.
@@ -137,6 +497,35 @@ type ImplementsType struct {
 	Kind string `json:"kind"` // "basic", "array", etc
 }
 
+// A TypeHierarchy is the result of a 'hierarchy' query.
+// It describes the named types above and below the queried type T,
+// each as a tree of immediate relations.
+type TypeHierarchy struct {
+	Version    int              `json:"version"`              // always Version
+	T          ImplementsType   `json:"type"`                 // the queried type
+	Equivalent []ImplementsType `json:"equivalent,omitempty"` // interfaces with the same methods as T
+	Supertypes []HierarchyType  `json:"supertypes,omitempty"` // types above T
+	Subtypes   []HierarchyType  `json:"subtypes,omitempty"`   // types below T
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A HierarchyType is a node of a tree of the TypeHierarchy, related to
+// its parent (T, at the top level) by Relation, which is "implements"
+// or "embeds" in the tree of supertypes, and "implemented-by" or
+// "embedded-in" in that of subtypes.  If Pointer is set, only a pointer
+// to the lower type of the pair satisfies the interface.  If Shown is
+// set, the type's own relatives are listed elsewhere in the tree.
+type HierarchyType struct {
+	Name     string          `json:"name"`              // full name of the type
+	Pos      string          `json:"pos"`               // location of its definition
+	Kind     string          `json:"kind"`              // "basic", "array", etc
+	Relation string          `json:"relation"`          // relation to the parent
+	Pointer  bool            `json:"pointer,omitempty"` // the relation holds only of a pointer
+	Shown    bool            `json:"shown,omitempty"`   // relatives are listed elsewhere
+	Types    []HierarchyType `json:"types,omitempty"`   // the type's own supertypes or subtypes
+}
+
 // A SyntaxNode is one element of a stack of enclosing syntax nodes in
 // a "what" query.
 type SyntaxNode struct {
@@ -149,12 +538,15 @@ type SyntaxNode struct {
 // the selection, parsing only a single file.  It is intended for use
 // in low-latency GUIs.
 type What struct {
+	Version    int          `json:"version"`              // always Version
 	Enclosing  []SyntaxNode `json:"enclosing"`            // enclosing nodes of syntax tree
 	Modes      []string     `json:"modes"`                // query modes enabled for this selection.
 	SrcDir     string       `json:"srcdir,omitempty"`     // $GOROOT src directory containing queried package
 	ImportPath string       `json:"importpath,omitempty"` // import path of queried package
 	Object     string       `json:"object,omitempty"`     // name of identified object, if any
 	SameIDs    []string     `json:"sameids,omitempty"`    // locations of references to same object
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
 }
 
 // A PointsToLabel describes a pointer analysis label.
@@ -170,8 +562,9 @@ type What struct {
 //    - and their subelements, e.g. "alloc.y[*].z"
 //
 type PointsToLabel struct {
-	Pos  string `json:"pos"`  // location of syntax that allocated the object
-	Desc string `json:"desc"` // description of the label
+	Pos     string `json:"pos"`               // location of syntax that allocated the object
+	Desc    string `json:"desc"`              // description of the label
+	Snippet string `json:"snippet,omitempty"` // source text of the allocating expression, truncated
 }
 
 // A PointsTo is one element of the result of a 'pointsto' query on an
@@ -184,10 +577,23 @@ type PointsToLabel struct {
 // it may point to.  The same is true for reflect.Values, except the
 // dynamic types needn't be concrete.
 //
+// If it is a map, Keys and Values describe, in the same way, the
+// pointers that are its keys and values, of pointer-like type; if it
+// is a slice or channel, Elements describes its elements.
+//
 type PointsTo struct {
+	Version int             `json:"version,omitempty"` // Version, unless within another PointsTo
 	Type    string          `json:"type"`              // (concrete) type of the pointer
 	NamePos string          `json:"namepos,omitempty"` // location of type defn, if Named
 	Labels  []PointsToLabel `json:"labels,omitempty"`  // pointed-to objects
+	Field   string          `json:"field,omitempty"`   // name of the field, if the expression is a field x.f
+	Holders []PointsToLabel `json:"holders,omitempty"` // fields f of the objects x that may hold the pointer
+
+	Keys     []PointsTo `json:"keys,omitempty"`     // of a map, what its keys may point to
+	Values   []PointsTo `json:"values,omitempty"`   // of a map, what its values may point to
+	Elements []PointsTo `json:"elements,omitempty"` // of a slice or channel, what its elements may point to
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions, unless within another PointsTo
 }
 
 // A DescribeValue is the additional result of a 'describe' query
@@ -195,13 +601,27 @@ type PointsTo struct {
 type DescribeValue struct {
 	Type     string       `json:"type"`               // type of the expression
 	Value    string       `json:"value,omitempty"`    // value of the expression, if constant
+	Kind     string       `json:"kind,omitempty"`     // kind of the constant: "boolean", "string", "integer", "floating-point" or "complex"
+	Untyped  bool         `json:"untyped,omitempty"`  // the constant is untyped
+	Exact    string       `json:"exact,omitempty"`    // exact value of the constant, if Value abbreviates or rounds it
+	Note     string       `json:"note,omitempty"`     // representability of an untyped constant by its default type, e.g. "overflows int"
 	ObjPos   string       `json:"objpos,omitempty"`   // location of the definition, if an Ident
 	TypesPos []Definition `json:"typespos,omitempty"` // location of the named types, that type consist of
 }
 
 type DescribeMethod struct {
-	Name string `json:"name"` // method name, as defined by types.Selection.String()
-	Pos  string `json:"pos"`  // location of the method's definition
+	Name string `json:"name"`          // method name, as defined by types.Selection.String()
+	Pos  string `json:"pos"`           // location of the method's definition
+	Via  string `json:"via,omitempty"` // embedded fields through which a promoted method is selected, e.g. "A.B"
+}
+
+// A DescribeField describes a field of a struct type, including one
+// promoted from an embedded field.
+type DescribeField struct {
+	Name string `json:"name"`          // field name
+	Type string `json:"type"`          // field type
+	Pos  string `json:"pos"`           // location of the field's definition
+	Via  string `json:"via,omitempty"` // embedded fields through which a promoted field is selected, e.g. "A.B"
 }
 
 // A DescribeType is the additional result of a 'describe' query
@@ -210,7 +630,8 @@ type DescribeType struct {
 	Type    string           `json:"type"`              // the string form of the type
 	NamePos string           `json:"namepos,omitempty"` // location of definition of type, if named
 	NameDef string           `json:"namedef,omitempty"` // underlying definition of type, if named
-	Methods []DescribeMethod `json:"methods,omitempty"` // methods of the type
+	Methods []DescribeMethod `json:"methods,omitempty"` // methods of the type, including those of *T
+	Fields  []DescribeField  `json:"fields,omitempty"`  // fields of the type, including promoted ones
 }
 
 type DescribeMember struct {
@@ -219,6 +640,7 @@ type DescribeMember struct {
 	Value   string           `json:"value,omitempty"`   // value of member (if 'const')
 	Pos     string           `json:"pos"`               // location of definition of member
 	Kind    string           `json:"kind"`              // one of {var,const,func,type}
+	Doc     string           `json:"doc,omitempty"`     // doc comment of member, if any
 	Methods []DescribeMethod `json:"methods,omitempty"` // methods (if member is a type)
 }
 
@@ -233,28 +655,96 @@ type DescribePackage struct {
 // It may contain an element describing the selected semantic entity
 // in detail.
 type Describe struct {
-	Desc   string `json:"desc"`             // description of the selected syntax node
-	Pos    string `json:"pos"`              // location of the selected syntax node
-	Detail string `json:"detail,omitempty"` // one of {package, type, value}, or "".
+	Version int    `json:"version"`          // always Version
+	Desc    string `json:"desc"`             // description of the selected syntax node
+	Pos     string `json:"pos"`              // location of the selected syntax node
+	Doc     string `json:"doc,omitempty"`    // doc comment of the selected object or package, if any
+	Detail  string `json:"detail,omitempty"` // one of {package, type, value, select}, or "".
 
 	// At most one of the following fields is populated:
 	// the one specified by 'detail'.
 	Package *DescribePackage `json:"package,omitempty"`
 	Type    *DescribeType    `json:"type,omitempty"`
 	Value   *DescribeValue   `json:"value,omitempty"`
+	Select  *DescribeSelect  `json:"select,omitempty"`
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
+}
+
+// A DescribeSelect is the additional result of a 'describe' query
+// whose selection is a select statement.
+type DescribeSelect struct {
+	Cases []DescribeSelectCase `json:"cases"` // the communication clauses, in order
+}
+
+// A DescribeSelectCase is a communication clause of a select statement.
+// With an analysis scope, Peers holds the result of a 'peers' query of
+// its channel operation, unless it is the default case.
+type DescribeSelectCase struct {
+	Pos   string `json:"pos"`             // location of the case or default keyword
+	Dir   string `json:"dir"`             // "send", "receive", or "default"
+	Type  string `json:"type,omitempty"`  // type of the channel
+	Peers *Peers `json:"peers,omitempty"` // possible peers of the operation, if found
 }
 
 // A WhichErrs is the result of a 'whicherrs' query.
 // It contains the position of the queried error and the possible globals,
 // constants, and types it may point to.
 type WhichErrs struct {
+	Version   int             `json:"version"`             // always Version
 	ErrPos    string          `json:"errpos,omitempty"`    // location of queried error
 	Globals   []string        `json:"globals,omitempty"`   // locations of globals
 	Constants []string        `json:"constants,omitempty"` // locations of constants
 	Types     []WhichErrsType `json:"types,omitempty"`     // Types
+
+	Offsets []Offset `json:"offsets,omitempty"` // of its positions
 }
 
 type WhichErrsType struct {
 	Type     string `json:"type,omitempty"`
 	Position string `json:"position,omitempty"`
 }
+
+// An Error is emitted, in JSON, XML and JSONL output, when a query
+// fails.  Code classifies the failure, as one of:
+//
+//	mode      the query mode is unknown
+//	position  the query position is malformed, or not within the program
+//	load      the program could not be loaded or type-checked
+//	scope     the pointer analysis scope is unsuitable, e.g. it has no main
+//	analysis  the analysis cannot answer the query, e.g. in dead code
+//	query     the query does not apply to the selected syntax
+//
+// The first four indicate a mistake in the user's request; analysis
+// indicates a limitation of the analysis.
+type Error struct {
+	Version  int      `json:"version"`         // always Version
+	Code     string   `json:"code"`            // classification of the failure; see above
+	Message  string   `json:"message"`         // description of the failure
+	Mode     string   `json:"mode"`            // the query mode
+	Position string   `json:"position"`        // the query position, as given
+	Scope    []string `json:"scope,omitempty"` // the pointer analysis scope, if any
+}
+
+// A Truncated is the final object of the output of a query whose
+// results were limited, by -max-results or by the pages of a guru
+// server, and which had More items than those output: references for
+// referrers, functions for callgraph.
+type Truncated struct {
+	Version   int  `json:"version"`   // always Version
+	Truncated bool `json:"truncated"` // always true
+	More      int  `json:"more"`      // number of items omitted
+}
+
+// An Offset is the extent in bytes of the token at a position of a
+// result, within its file: Start is the offset of the position, and
+// End that of the end of the token, such as an identifier, there.
+// RuneCol is the column of the position in characters, whatever the
+// unit of the columns of the result's position strings.
+// The offsets of positions in files that cannot be read are omitted.
+type Offset struct {
+	Pos     string `json:"pos"`     // the position, as elsewhere in the result
+	Start   int    `json:"start"`   // byte offset of the position
+	End     int    `json:"end"`     // byte offset of the end of its token
+	RuneCol int    `json:"runecol"` // 1-based character column of the position
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This file defines the report of the -verbose flag: the wall time
+// and memory of each phase of a query, from its progress events.
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cmd/guru/guru"
+)
+
+// A phaseReporter writes a line for each phase of a query, and each
+// step of the pointer analysis, as it ends: its wall time, the memory
+// allocated during it, and the size of the heap at its end.  The
+// figures of a phase exclude those of the phases within it, so that
+// those of the "query" phase are of the evaluation of the query alone.
+type phaseReporter struct {
+	w     io.Writer
+	now   func() time.Time
+	mem   func() (allocated, heap uint64) // see runtime.MemStats.TotalAlloc and HeapAlloc
+	stack []*phaseFrame                   // the phases in progress, innermost last
+}
+
+// A phaseFrame is a phase in progress.
+type phaseFrame struct {
+	name     string
+	start    time.Time
+	alloc    uint64        // allocated at the start
+	inner    time.Duration // wall time of the phases within it
+	innerMem uint64        // bytes allocated by the phases within it
+	packages int           // load and reload: the number of packages type-checked
+}
+
+func newPhaseReporter(w io.Writer) *phaseReporter {
+	return &phaseReporter{w: w, now: time.Now, mem: readMem}
+}
+
+func readMem() (allocated, heap uint64) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.TotalAlloc, stats.HeapAlloc
+}
+
+// progress is the Query.Progress function of the report.
+func (r *phaseReporter) progress(e guru.ProgressEvent) {
+	switch {
+	case e.Package != "":
+		if f := r.find(e.Phase); f != nil {
+			f.packages = e.Packages
+		}
+	case e.Step != "":
+		// A step ends at the start of the next, or with its phase.
+		if top := r.top(); top != nil && strings.HasPrefix(top.name, e.Phase+": ") {
+			r.pop()
+		}
+		r.push(e.Phase + ": " + e.Step)
+	case !e.Done:
+		r.push(e.Phase)
+	default:
+		if r.find(e.Phase) == nil {
+			return // not begun within the report
+		}
+		for r.pop() != e.Phase {
+		}
+	}
+}
+
+func (r *phaseReporter) top() *phaseFrame {
+	if len(r.stack) == 0 {
+		return nil
+	}
+	return r.stack[len(r.stack)-1]
+}
+
+// find returns the innermost phase in progress of the name, or nil.
+func (r *phaseReporter) find(name string) *phaseFrame {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		if r.stack[i].name == name {
+			return r.stack[i]
+		}
+	}
+	return nil
+}
+
+func (r *phaseReporter) push(name string) {
+	alloc, _ := r.mem()
+	r.stack = append(r.stack, &phaseFrame{name: name, start: r.now(), alloc: alloc})
+}
+
+// pop reports the end of the innermost phase, and returns its name.
+func (r *phaseReporter) pop() string {
+	f := r.top()
+	r.stack = r.stack[:len(r.stack)-1]
+	alloc, heap := r.mem()
+	wall, allocated := r.now().Sub(f.start), alloc-f.alloc
+	if outer := r.top(); outer != nil {
+		outer.inner += wall
+		outer.innerMem += allocated
+	}
+	var packages string
+	switch {
+	case f.packages == 1:
+		packages = ", 1 package"
+	case f.packages > 1:
+		packages = fmt.Sprintf(", %d packages", f.packages)
+	}
+	fmt.Fprintf(r.w, "%s: %s wall, %s allocated, %s heap%s\n",
+		f.name, wall-f.inner, megabytes(allocated-f.innerMem), megabytes(heap), packages)
+	return f.name
+}
+
+// megabytes formats a number of bytes, e.g. "12.3 MB".
+func megabytes(n uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
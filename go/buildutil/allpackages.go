@@ -13,6 +13,7 @@ import (
 	"go/build"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -129,7 +130,17 @@ func allPackages(ctxt *build.Context, root string, ch chan<- item) {
 //
 //		golang.org/x/tools/cmd/guru     # a single package
 //		golang.org/x/tools/...          # all packages beneath dir
+//		golang.org/x/.../cmd/...        # ... may appear anywhere
 //		...                             # the entire workspace.
+//		std                             # the standard library
+//		cmd                             # the commands of the Go distribution
+//
+// As with 'go list', the wildcard "..." matches any string, including
+// the empty string and strings containing slashes, except that a
+// trailing "/..." also matches the empty string, so that dir/...
+// matches dir itself.  As with 'go list', std and cmd match the
+// packages beneath $GOROOT/src that contain Go files: those of cmd
+// are cmd itself and those beneath it, and those of std are the rest.
 //
 // Order is significant: a pattern preceded by '-' removes matching
 // packages from the set.  For example, these patterns match all encoding
@@ -142,8 +153,7 @@ func allPackages(ctxt *build.Context, root string, ch chan<- item) {
 //
 func ExpandPatterns(ctxt *build.Context, patterns []string) map[string]bool {
 	// TODO(adonovan): support other features of 'go list':
-	// - "std"/"cmd"/"all" meta-packages
-	// - "..." not at the end of a pattern
+	// - "all" meta-package
 	// - relative patterns using "./" or "../" prefix
 
 	pkgs := make(map[string]bool)
@@ -157,11 +167,14 @@ func ExpandPatterns(ctxt *build.Context, patterns []string) map[string]bool {
 
 	// Scan entire workspace if wildcards are present.
 	// TODO(adonovan): opt: scan only the necessary subtrees of the workspace.
-	var all []string
+	// Scan $GOROOT if meta-packages are present.
+	var all, goroot []string
 	for _, arg := range patterns {
-		if strings.HasSuffix(arg, "...") {
+		if strings.Contains(arg, "...") && all == nil {
 			all = AllPackages(ctxt)
-			break
+		}
+		if arg := strings.TrimPrefix(arg, "-"); (arg == "std" || arg == "cmd") && goroot == nil {
+			goroot = gorootPackages(ctxt)
 		}
 	}
 
@@ -175,16 +188,17 @@ func ExpandPatterns(ctxt *build.Context, patterns []string) map[string]bool {
 			arg = arg[1:]
 		}
 
-		if arg == "..." {
-			// ... matches all packages
-			for _, pkg := range all {
-				doPkg(pkg, neg)
+		if arg == "std" || arg == "cmd" {
+			for _, pkg := range goroot {
+				if isCmd := pkg == "cmd" || strings.HasPrefix(pkg, "cmd/"); isCmd == (arg == "cmd") {
+					doPkg(pkg, neg)
+				}
 			}
-		} else if dir := strings.TrimSuffix(arg, "/..."); dir != arg {
-			// dir/... matches all packages beneath dir
+		} else if strings.Contains(arg, "...") {
+			// e.g. dir/..., or a/.../b
+			match := matchPattern(arg)
 			for _, pkg := range all {
-				if strings.HasPrefix(pkg, dir) &&
-					(len(pkg) == len(dir) || pkg[len(dir)] == '/') {
+				if match(pkg) {
 					doPkg(pkg, neg)
 				}
 			}
@@ -196,3 +210,58 @@ func ExpandPatterns(ctxt *build.Context, patterns []string) map[string]bool {
 
 	return pkgs
 }
+
+// gorootPackages returns the sorted paths of the packages beneath
+// $GOROOT/src that contain Go files, other than tests, that match the
+// build context.
+func gorootPackages(ctxt *build.Context) []string {
+	if ctxt.GOROOT == "" {
+		return nil
+	}
+	ch := make(chan item)
+	go func() {
+		allPackages(ctxt, filepath.Join(ctxt.GOROOT, "src"), ch)
+		close(ch)
+	}()
+	var list []string
+	for i := range ch {
+		if i.err == nil && hasGoFiles(ctxt, i.importPath) {
+			list = append(list, i.importPath)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// hasGoFiles reports whether the directory $GOROOT/src/pkg contains
+// Go files, other than tests, that match the build context.
+func hasGoFiles(ctxt *build.Context, pkg string) bool {
+	dir := filepath.Join(ctxt.GOROOT, "src", filepath.FromSlash(pkg))
+	files, err := ReadDir(ctxt, dir)
+	if err != nil {
+		return false
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := ctxt.MatchFile(dir, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern returns a function that reports whether a package path
+// matches the specified pattern, in which "..." is a wildcard.
+func matchPattern(pattern string) func(pkg string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	// Special case: foo/... matches foo too.
+	if strings.HasSuffix(re, `/.*`) {
+		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+	}
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return reg.MatchString
+}
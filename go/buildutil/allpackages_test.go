@@ -50,6 +50,9 @@ func TestExpandPatterns(t *testing.T) {
 		"encoding/hex",
 		"encoding/json",
 		"fmt",
+		"golang.org/x/tools/cmd/guru",
+		"golang.org/x/tools/cmd/guru/client",
+		"golang.org/x/tools/go/ssa",
 	} {
 		tree[pkg] = make(map[string]string)
 	}
@@ -63,9 +66,14 @@ func TestExpandPatterns(t *testing.T) {
 		{"fmt", "fmt"},
 		{"nosuchpkg", "nosuchpkg"},
 		{"nosuchdir/...", ""},
-		{"...", "encoding encoding/hex encoding/json encoding/xml fmt"},
+		{"...", "encoding encoding/hex encoding/json encoding/xml fmt golang.org/x/tools/cmd/guru golang.org/x/tools/cmd/guru/client golang.org/x/tools/go/ssa"},
+		{"encoding/...", "encoding encoding/hex encoding/json encoding/xml"},
 		{"encoding/... -encoding/xml", "encoding encoding/hex encoding/json"},
-		{"... -encoding/...", "fmt"},
+		{"... -encoding/... -golang.org/...", "fmt"},
+		{"golang.org/x/.../cmd/...", "golang.org/x/tools/cmd/guru golang.org/x/tools/cmd/guru/client"},
+		{"golang.org/.../ssa", "golang.org/x/tools/go/ssa"},
+		{"encoding/x...", "encoding/xml"},
+		{"fm...", "fmt"},
 		{"encoding", "encoding"},
 		{"encoding/", "encoding"},
 	} {
@@ -81,3 +89,41 @@ func TestExpandPatterns(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandMetaPackages(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"archive":      {},
+		"fmt":          {"print.go": "package fmt"},
+		"io":           {"io.go": "package io"},
+		"io/ioutil":    {"ioutil_test.go": "package ioutil"},
+		"syscall":      {"syscall_plan9.go": "package syscall"},
+		"cmd":          {"doc.go": "package cmd"},
+		"cmd/go":       {"main.go": "package main"},
+		"cmdline/args": {"args.go": "package args"},
+	})
+	if runtime.GOOS == "plan9" {
+		t.Skip("syscall matches on plan9")
+	}
+
+	for _, test := range []struct {
+		patterns string
+		want     string
+	}{
+		{"std", "cmdline/args fmt io"},
+		{"cmd", "cmd cmd/go"},
+		{"std -io", "cmdline/args fmt"},
+		{"std cmd -cmd/...", "cmdline/args fmt io"},
+		{"... -std", "archive cmd cmd/go io/ioutil syscall"},
+	} {
+		var pkgs []string
+		for pkg := range buildutil.ExpandPatterns(ctxt, strings.Fields(test.patterns)) {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		got := strings.Join(pkgs, " ")
+		if got != test.want {
+			t.Errorf("ExpandPatterns(%s) = %s, want %s",
+				test.patterns, got, test.want)
+		}
+	}
+}
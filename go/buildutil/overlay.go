@@ -11,7 +11,9 @@ import (
 	"go/build"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,12 +27,13 @@ import (
 // symbolic links are followed for directories, but not files.
 //
 // A common use case for OverlayContext is to allow editors to pass in
-// a set of unsaved, modified files.
-//
-// Currently, only the Context.OpenFile function will respect the
-// overlay. This may change in the future.
+// a set of unsaved, modified files.  The overlay may also add files
+// that do not exist, such as generated ones, in directories that may
+// not exist either: the Context.OpenFile, ReadDir and IsDir functions
+// all respect the overlay, though the latter two compare the names of
+// directories without following symbolic links.
 func OverlayContext(orig *build.Context, overlay map[string][]byte) *build.Context {
-	// TODO(dominikh): Implement IsDir, HasSubdir and ReadDir
+	// TODO(dominikh): Implement HasSubdir
 
 	rc := func(data []byte) (io.ReadCloser, error) {
 		return ioutil.NopCloser(bytes.NewBuffer(data)), nil
@@ -54,9 +57,64 @@ func OverlayContext(orig *build.Context, overlay map[string][]byte) *build.Conte
 
 		return OpenFile(orig, path)
 	}
+	ctxt.IsDir = func(path string) bool {
+		if IsDir(orig, path) {
+			return true
+		}
+		for filename := range overlay {
+			if _, _, ok := overlayEntry(path, filename); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		fis, err := ReadDir(orig, dir)
+		entries := make(map[string]os.FileInfo)
+		for _, fi := range fis {
+			entries[fi.Name()] = fi
+		}
+		added := false
+		for filename := range overlay {
+			name, isDir, ok := overlayEntry(dir, filename)
+			if !ok {
+				continue
+			}
+			added = true
+			if !isDir {
+				entries[name] = fakeFileInfo(name)
+			} else if fi := entries[name]; fi == nil || !fi.IsDir() {
+				entries[name] = fakeDirInfo(name)
+			}
+		}
+		if err != nil && !added {
+			return nil, err
+		}
+		fis = fis[:0]
+		for _, fi := range entries {
+			fis = append(fis, fi)
+		}
+		sort.Sort(byName(fis))
+		return fis, nil
+	}
 	return ctxt
 }
 
+// overlayEntry returns the name of the entry of the directory dir,
+// compared by name, that holds the overlay file filename, either the
+// file itself or a directory that contains it; ok is false if it is
+// not beneath dir.
+func overlayEntry(dir, filename string) (name string, isDir, ok bool) {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(filename))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, false
+	}
+	if i := strings.IndexByte(rel, filepath.Separator); i >= 0 {
+		return rel[:i], true, true
+	}
+	return rel, false, true
+}
+
 // ParseOverlayArchive parses an archive containing Go files and their
 // contents. The result is intended to be used with OverlayContext.
 //
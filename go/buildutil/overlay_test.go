@@ -3,6 +3,8 @@ package buildutil_test
 import (
 	"go/build"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -68,3 +70,65 @@ func TestOverlay(t *testing.T) {
 		}
 	}
 }
+
+func TestOverlayAddedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package p"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := buildutil.OverlayContext(&build.Default, map[string][]byte{
+		filepath.Join(dir, "a.go"):             []byte("package p // modified"),
+		filepath.Join(dir, "b.go"):             []byte("package p"),
+		filepath.Join(dir, "gen", "q", "q.go"): []byte("package q"),
+	})
+
+	for _, test := range []struct {
+		dir  string
+		want []string // names of entries; dirs end in "/"
+	}{
+		{dir, []string{"a.go", "b.go", "gen/"}},
+		{filepath.Join(dir, "gen"), []string{"q/"}},
+		{filepath.Join(dir, "gen", "q"), []string{"q.go"}},
+	} {
+		if !buildutil.IsDir(ctx, test.dir) {
+			t.Errorf("IsDir(%s) = false", test.dir)
+		}
+		fis, err := buildutil.ReadDir(ctx, test.dir)
+		if err != nil {
+			t.Errorf("ReadDir(%s): %v", test.dir, err)
+			continue
+		}
+		var got []string
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() {
+				name += "/"
+			}
+			got = append(got, name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ReadDir(%s) = %q, want %q", test.dir, got, test.want)
+		}
+	}
+	for _, name := range []string{filepath.Join(dir, "b.go"), filepath.Join(dir, "nonesuch")} {
+		if buildutil.IsDir(ctx, name) {
+			t.Errorf("IsDir(%s) = true", name)
+		}
+	}
+	if _, err := buildutil.ReadDir(ctx, filepath.Join(dir, "nonesuch")); err == nil {
+		t.Errorf("ReadDir of a missing directory succeeded")
+	}
+
+	// The added package can be imported.
+	bp, err := ctx.ImportDir(filepath.Join(dir, "gen", "q"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bp.GoFiles, []string{"q.go"}) {
+		t.Errorf("GoFiles of added package = %q", bp.GoFiles)
+	}
+}
@@ -126,6 +126,30 @@ type Config struct {
 	//
 	// It must be safe to call concurrently from multiple goroutines.
 	AfterTypeCheck func(info *PackageInfo, files []*ast.File)
+
+	// Parallelism, if positive, limits the number of packages that
+	// Load type-checks at once.  Load type-checks each package as
+	// soon as its dependencies are complete, so by default the
+	// concurrency is bounded only by the shape of the import graph.
+	Parallelism int
+
+	// Reuse, if non-nil, is called during Load before an importable
+	// package is loaded from source.  If it returns a non-nil
+	// PackageInfo, typically one from a previous Program, that
+	// package is used as is, without parsing or type-checking, and
+	// its in-package tests (if any) are assumed to be present already.
+	//
+	// This allows a client to re-load a program after some files
+	// have changed, type-checking only the changed packages and
+	// the packages that depend on them.  The client must ensure that
+	// Fset is the FileSet of the earlier Program, that Reuse returns
+	// a non-nil result for every dependency of a reused package, and
+	// that it returns nil for every package that is changed or that
+	// transitively imports one that is.
+	//
+	// It is called with an internal lock held, so it must be fast
+	// and must not call Load.
+	Reuse func(importPath string) *PackageInfo
 }
 
 // A PkgSpec specifies a non-importable package to be created by Load.
@@ -415,6 +439,10 @@ type importer struct {
 	// packages.  Nodes are identified by their import paths.
 	graphMu sync.Mutex
 	graph   map[string]map[string]bool
+
+	// checkLimit is a counting semaphore that limits the number
+	// of packages type-checked at once, or nil if unlimited.
+	checkLimit chan bool
 }
 
 type findpkgKey struct {
@@ -438,6 +466,7 @@ type findpkgValue struct {
 type importInfo struct {
 	path     string        // import path
 	info     *PackageInfo  // results of typechecking (including errors)
+	reused   bool          // info was provided by Config.Reuse
 	complete chan struct{} // closed to broadcast that info is set.
 }
 
@@ -510,6 +539,9 @@ func (conf *Config) Load() (*Program, error) {
 		start:    time.Now(),
 		graph:    make(map[string]map[string]bool),
 	}
+	if conf.Parallelism > 0 {
+		imp.checkLimit = make(chan bool, conf.Parallelism)
+	}
 
 	// -- loading proper (concurrent phase) --------------------------------
 
@@ -576,6 +608,10 @@ func (conf *Config) Load() (*Program, error) {
 		info := ii.info
 		imp.importedMu.Unlock()
 
+		if ii.reused {
+			continue // already augmented
+		}
+
 		// Parse the in-package test files.
 		files, errs := imp.conf.parsePackageFiles(bp, 't')
 		for _, err := range errs {
@@ -956,6 +992,9 @@ func (imp *importer) startLoad(bp *build.Package) *importInfo {
 	imp.importedMu.Lock()
 	ii, ok := imp.imported[path]
 	if !ok {
+		ii = imp.reuseLocked(path)
+	}
+	if ii == nil {
 		ii = &importInfo{path: path, complete: make(chan struct{})}
 		imp.imported[path] = ii
 		go func() {
@@ -968,6 +1007,39 @@ func (imp *importer) startLoad(bp *build.Package) *importInfo {
 	return ii
 }
 
+// reuseLocked returns a completed importInfo for the specified
+// package if Config.Reuse provides one, or nil otherwise.
+// It records the package and all its dependencies as imported.
+//
+// Precondition: imp.importedMu is held.
+//
+func (imp *importer) reuseLocked(path string) *importInfo {
+	if imp.conf.Reuse == nil {
+		return nil
+	}
+	info := imp.conf.Reuse(path)
+	if info == nil {
+		return nil
+	}
+	ii := &importInfo{path: path, reused: true, complete: make(chan struct{})}
+	ii.Complete(info)
+	imp.imported[path] = ii
+
+	imp.progMu.Lock()
+	imp.prog.importMap[path] = info.Pkg
+	imp.prog.AllPackages[info.Pkg] = info
+	imp.progMu.Unlock()
+
+	for _, dep := range info.Pkg.Imports() {
+		if _, ok := imp.imported[dep.Path()]; !ok {
+			if imp.reuseLocked(dep.Path()) == nil {
+				panic(fmt.Sprintf("Reuse(%q) = nil, but %s imports it", dep.Path(), path))
+			}
+		}
+	}
+	return ii
+}
+
 // load implements package loading by parsing Go source files
 // located by go/build.
 func (imp *importer) load(bp *build.Package) *PackageInfo {
@@ -1010,6 +1082,11 @@ func (imp *importer) addFiles(info *PackageInfo, files []*ast.File, cycleCheck b
 			time.Since(imp.start), info.Pkg.Path(), len(files))
 	}
 
+	if imp.checkLimit != nil {
+		imp.checkLimit <- true // wait
+		defer func() { <-imp.checkLimit }()
+	}
+
 	// Don't call checker.Files on Unsafe, even with zero files,
 	// because it would mutate the package, which is a global.
 	if info.Pkg == types.Unsafe {
@@ -10,6 +10,7 @@ package loader_test
 
 import (
 	"fmt"
+	"go/ast"
 	"go/build"
 	"go/constant"
 	"go/types"
@@ -20,6 +21,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/loader"
@@ -604,6 +606,99 @@ func TestTransitivelyErrorFreeFlag(t *testing.T) {
 	}
 }
 
+// TestReuse checks that Config.Reuse lets a reload share the
+// packages that are unaffected by a change.
+func TestReuse(t *testing.T) {
+	// a --> b --> c
+	//   \
+	//    d
+	pkgs := map[string]string{
+		"a": `package a; import (_ "b"; _ "d")`,
+		"b": `package b; import _ "c"`,
+		"c": `package c; const K = 1`,
+		"d": `package d;`,
+	}
+	conf := loader.Config{Build: fakeContext(pkgs)}
+	conf.Import("a")
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	// Change c; reuse only d, the one package unaffected.
+	pkgs["c"] = `package c; const K = "one"`
+	conf2 := loader.Config{
+		Fset:  prog.Fset,
+		Build: fakeContext(pkgs),
+		Reuse: func(path string) *loader.PackageInfo {
+			if path == "d" {
+				return prog.Package(path)
+			}
+			return nil
+		},
+	}
+	conf2.Import("a")
+	prog2, err := conf2.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	for _, path := range []string{"a", "b", "c", "d"} {
+		reused := prog2.Package(path) == prog.Package(path)
+		if want := path == "d"; reused != want {
+			t.Errorf("package %s: reused = %t, want %t", path, reused, want)
+		}
+	}
+	if got := prog2.Package("c").Pkg.Scope().Lookup("K").Type().String(); got != "untyped string" {
+		t.Errorf("c.K has type %s, want untyped string", got)
+	}
+	if len(prog2.AllPackages) != 4 {
+		t.Errorf("AllPackages has %d packages, want 4", len(prog2.AllPackages))
+	}
+}
+
+// TestParallelism checks that Config.Parallelism bounds the number of
+// packages type-checked at once.
+func TestParallelism(t *testing.T) {
+	// a imports b1 ... b8, which are independent.
+	pkgs := map[string]string{"a": `package a`}
+	for i := 1; i <= 8; i++ {
+		b := fmt.Sprintf("b%d", i)
+		pkgs[b] = "package " + b
+		pkgs["a"] += fmt.Sprintf(`; import _ %q`, b)
+	}
+
+	var mu sync.Mutex
+	var active, max int
+	conf := loader.Config{
+		Build:       fakeContext(pkgs),
+		Parallelism: 2,
+		AfterTypeCheck: func(info *loader.PackageInfo, files []*ast.File) {
+			mu.Lock()
+			active++
+			if active > max {
+				max = active
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+		},
+	}
+	conf.Import("a")
+	prog, err := conf.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if len(prog.AllPackages) != 9 {
+		t.Errorf("AllPackages has %d packages, want 9", len(prog.AllPackages))
+	}
+	if max > 2 {
+		t.Errorf("%d packages were type-checked at once, want at most 2", max)
+	}
+}
+
 // Test that syntax (scan/parse), type, and loader errors are recorded
 // (in PackageInfo.Errors) and reported (via Config.TypeChecker.Error).
 func TestErrorReporting(t *testing.T) {
@@ -7,6 +7,7 @@ package pointer
 // This file defines the main datatypes and Analyze function of the pointer analysis.
 
 import (
+	"errors"
 	"fmt"
 	"go/token"
 	"go/types"
@@ -129,6 +130,9 @@ type analysis struct {
 	track       track                       // pointerlike types whose aliasing we track
 	deltaSpace  []int                       // working space for iterating over PTS deltas
 
+	// Contours of Config.ContextSensitive functions, by call site:
+	siteObjs map[ssa.CallInstruction]nodeid
+
 	// Reflection & intrinsics:
 	hasher              typeutil.Hasher // cache of type hashes
 	reflectValueObj     types.Object    // type symbol for reflect.Value (if present)
@@ -167,6 +171,27 @@ func (a *analysis) labelFor(id nodeid) *Label {
 	}
 }
 
+// progress reports the start of the named phase to the client, if
+// it requested it.
+func (a *analysis) progress(phase string) {
+	if a.config.Progress != nil {
+		a.config.Progress(phase)
+	}
+}
+
+// errCanceled is the error of an analysis canceled by the client.
+var errCanceled = errors.New("pointer analysis canceled")
+
+// canceled reports whether the client has canceled the analysis.
+func (a *analysis) canceled() bool {
+	select {
+	case <-a.config.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
 func (a *analysis) warnf(pos token.Pos, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	if a.log != nil {
@@ -237,6 +262,7 @@ func Analyze(config *Config) (result *Result, err error) {
 		flattenMemo: make(map[types.Type][]*fieldInfo),
 		trackTypes:  make(map[types.Type]bool),
 		atFuncs:     make(map[*ssa.Function]bool),
+		siteObjs:    make(map[ssa.CallInstruction]nodeid),
 		hasher:      typeutil.MakeHasher(),
 		intrinsics:  make(map[*ssa.Function]intrinsic),
 		result: &Result{
@@ -289,9 +315,15 @@ func Analyze(config *Config) (result *Result, err error) {
 	}
 	a.computeTrackBits()
 
+	a.progress("generate")
 	a.generate()
+	if a.canceled() {
+		return nil, errCanceled
+	}
 	a.showCounts()
 
+	a.progress("solve") // including the presolver
+
 	if optRenumber {
 		a.renumber()
 	}
@@ -329,6 +361,9 @@ func Analyze(config *Config) (result *Result, err error) {
 	}
 
 	a.solve()
+	if a.canceled() {
+		return nil, errCanceled
+	}
 
 	// Compare solutions.
 	if optHVN && debugHVNCrossCheck {
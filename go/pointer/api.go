@@ -38,6 +38,24 @@ type Config struct {
 	// has not yet been reduced by presolver optimisation.
 	Reflection bool
 
+	// ContextSensitive, if non-nil, selects functions to be
+	// analyzed with one level of call-site sensitivity (1-CFA)
+	// in addition to those chosen by the analysis's own policy:
+	// each static call site of such a function is analyzed in
+	// its own context, so that the results of distinct calls
+	// are not merged.  Dynamic calls share a single context.
+	// Selecting many functions increases the cost of the analysis.
+	ContextSensitive func(fn *ssa.Function) bool
+
+	// Progress, if non-nil, is called as each phase of the
+	// analysis begins: "generate", which generates the
+	// constraints, and "solve", which solves them.
+	Progress func(phase string)
+
+	// Cancel, if non-nil, is a channel whose closing causes the
+	// analysis to stop early: Analyze then returns an error.
+	Cancel <-chan struct{}
+
 	// BuildCallGraph determines whether to construct a callgraph.
 	// If enabled, the graph will be available in Result.CallGraph.
 	BuildCallGraph bool
@@ -113,9 +131,10 @@ func (c *Config) AddIndirectQuery(v ssa.Value) {
 // the value, and result in a pointer-like object. Only a subset of
 // Go expressions are permitted in queries, namely channel receives,
 // pointer dereferences, field selectors, array/slice/map/tuple
-// indexing and grouping with parentheses. The specific indices when
-// indexing arrays, slices and maps have no significance. Indices used
-// on tuples must be numeric and within bounds.
+// indexing and grouping with parentheses, and the pseudo-function
+// key, for which key(m) denotes the keys of the map m. The specific
+// indices when indexing arrays, slices and maps have no significance.
+// Indices used on tuples must be numeric and within bounds.
 //
 // All field selectors must be explicit, even ones usually elided
 // due to promotion of embedded fields.
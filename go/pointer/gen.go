@@ -582,6 +582,21 @@ func (a *analysis) shouldUseContext(fn *ssa.Function) bool {
 	return true
 }
 
+// siteObject returns the function object for the contour of fn
+// specific to the static call site, creating it if necessary.
+//
+// Contours are memoized by call instruction, not by the contour of
+// the caller, so recursion through fn creates finitely many of them.
+//
+func (a *analysis) siteObject(fn *ssa.Function, site *callsite) nodeid {
+	obj, ok := a.siteObjs[site.instr]
+	if !ok {
+		obj = a.makeFunctionObject(fn, site)
+		a.siteObjs[site.instr] = obj
+	}
+	return obj
+}
+
 // genStaticCall generates constraints for a statically dispatched function call.
 func (a *analysis) genStaticCall(caller *cgnode, site *callsite, call *ssa.CallCommon, result nodeid) {
 	fn := call.StaticCallee()
@@ -612,6 +627,8 @@ func (a *analysis) genStaticCall(caller *cgnode, site *callsite, call *ssa.CallC
 	var obj nodeid
 	if a.shouldUseContext(fn) {
 		obj = a.makeFunctionObject(fn, site) // new contour
+	} else if a.config.ContextSensitive != nil && a.config.ContextSensitive(fn) {
+		obj = a.siteObject(fn, site) // contour for this call site
 	} else {
 		obj = a.objectNode(nil, fn) // shared contour
 	}
@@ -1300,7 +1317,7 @@ func (a *analysis) generate() {
 	// Generate constraints for functions as they become reachable
 	// from the roots.  (No constraints are generated for functions
 	// that are dead in this analysis scope.)
-	for len(a.genq) > 0 {
+	for len(a.genq) > 0 && !a.canceled() {
 		cgn := a.genq[0]
 		a.genq = a.genq[1:]
 		a.genFunc(cgn)
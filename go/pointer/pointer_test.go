@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -575,6 +576,106 @@ func TestInput(t *testing.T) {
 	}
 }
 
+func TestContextSensitive(t *testing.T) {
+	const input = `package main
+
+var a, b int
+
+// id is recursive and has several blocks, so the analysis's own
+// policy would analyze it context-insensitively.
+func id(p *int) *int {
+	if p == nil {
+		return id(&a)
+	}
+	return p
+}
+
+func main() {
+	print(id(&a), id(&b))
+}
+`
+	var conf loader.Config
+	f, err := conf.ParseFile("input.go", input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.CreateFromFiles("main", f)
+	iprog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := ssautil.CreateProgram(iprog, 0)
+	prog.Build()
+	mainPkg := prog.Package(iprog.Created[0].Pkg)
+
+	// Find the two calls to id in main.
+	var calls []*ssa.Call
+	for _, instr := range mainPkg.Func("main").Blocks[0].Instrs {
+		if call, ok := instr.(*ssa.Call); ok && call.Call.StaticCallee() == mainPkg.Func("id") {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) != 2 {
+		t.Fatalf("found %d calls to id, want 2", len(calls))
+	}
+
+	for _, test := range []struct {
+		sensitive func(*ssa.Function) bool
+		want      [2]string
+	}{
+		{nil, [2]string{"[main.a main.b]", "[main.a main.b]"}},
+		{func(fn *ssa.Function) bool { return fn.Name() == "id" }, [2]string{"[main.a]", "[main.a main.b]"}},
+	} {
+		config := &pointer.Config{
+			Mains:            []*ssa.Package{mainPkg},
+			ContextSensitive: test.sensitive,
+		}
+		for _, call := range calls {
+			config.AddQuery(call)
+		}
+		result, err := pointer.Analyze(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, call := range calls {
+			var labels []string
+			for _, l := range result.Queries[call].PointsTo().Labels() {
+				labels = append(labels, l.String())
+			}
+			sort.Strings(labels)
+			if got := fmt.Sprint(labels); got != test.want[i] {
+				t.Errorf("sensitive=%t: pts(call %d) = %s, want %s",
+					test.sensitive != nil, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	var conf loader.Config
+	f, err := conf.ParseFile("input.go", "package main\n\nfunc main() { print(new(int)) }\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.CreateFromFiles("main", f)
+	iprog, err := conf.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog := ssautil.CreateProgram(iprog, 0)
+	prog.Build()
+
+	cancel := make(chan struct{})
+	close(cancel)
+	config := &pointer.Config{
+		Mains:  []*ssa.Package{prog.Package(iprog.Created[0].Pkg)},
+		Cancel: cancel,
+	}
+	if _, err := pointer.Analyze(config); err == nil {
+		t.Errorf("Analyze succeeded despite cancellation")
+	}
+}
+
 // join joins the elements of multiset with " | "s.
 func join(set map[string]int) string {
 	var buf bytes.Buffer
@@ -56,8 +56,9 @@ func parseExtendedQuery(typ types.Type, query string) ([]interface{}, types.Type
 
 // destructuringOps parses a Go expression consisting only of an
 // identifier "x", field selections, indexing, channel receives, load
-// operations and parens---for example: "<-(*x[i])[key]"--- and
-// returns the sequence of destructuring operations on x.
+// operations, parens and calls of key---for example:
+// "<-(*x[i])[key]" or "key(*x)"--- and returns the sequence of
+// destructuring operations on x.
 func destructuringOps(typ types.Type, expr ast.Expr) ([]interface{}, types.Type, error) {
 	switch expr := expr.(type) {
 	case *ast.SelectorExpr:
@@ -146,6 +147,20 @@ func destructuringOps(typ types.Type, expr ast.Expr) ([]interface{}, types.Type,
 		}
 		out = append(out, "recv")
 		return out, ch.Elem().Underlying(), err
+	case *ast.CallExpr:
+		if fn, ok := expr.Fun.(*ast.Ident); !ok || fn.Name != "key" || len(expr.Args) != 1 {
+			return nil, nil, fmt.Errorf("unsupported call; only key(m) may be called")
+		}
+		out, typ, err := destructuringOps(typ, expr.Args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		m, ok := typ.(*types.Map)
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot take key of value of type %s", typ)
+		}
+		out = append(out, "mapkey")
+		return out, m.Key().Underlying(), nil
 	case *ast.ParenExpr:
 		return destructuringOps(typ, expr.X)
 	case *ast.StarExpr:
@@ -199,6 +214,10 @@ func (a *analysis) evalExtendedQuery(t types.Type, id nodeid, ops []interface{})
 			vsize := a.sizeof(tt.Elem())
 			nid = a.addNodes(t, "query.extended")
 			a.load(nid, pid, ksize, vsize)
+		case "mapkey":
+			t = t.(*types.Map).Key()
+			nid = a.addNodes(t, "query.extended")
+			a.load(nid, pid, 0, a.sizeof(t))
 		case "index":
 			i++ // fetch index
 			tt := t.(*types.Tuple)
@@ -24,6 +24,7 @@ var V6 [1]chan *int
 var V7 int
 var V8 T
 var V9 reflect.Value
+var V10 map[*int]chan *int
 `
 	tests := []struct {
 		in    string
@@ -40,6 +41,9 @@ var V9 reflect.Value
 		{`(<-x)[0]`, []interface{}{"x", "recv", "sliceelem"}, "V4", true},
 		{`<-x.F2`, []interface{}{"x", "field", 1, "recv"}, "V5", true},
 		{`<-x[0]`, []interface{}{"x", "arrayelem", "recv"}, "V6", true},
+		{`key(x)`, []interface{}{"x", "mapkey"}, "V10", true},
+		{`<-x[0]`, []interface{}{"x", "mapelem", "recv"}, "V10", true},
+		{`key(x)`, nil, "V3", false},
 		{`x`, nil, "V7", false},
 		{`y`, nil, "V1", false},
 		{`x; x`, nil, "V1", false},
@@ -27,7 +27,13 @@ func (a *analysis) solve() {
 
 	// Solver main loop.
 	var delta nodeset
-	for {
+	for i := 0; ; i++ {
+		// Check for cancellation periodically.
+		if i%1024 == 0 && a.canceled() {
+			stop("Solving")
+			return
+		}
+
 		// Add new constraints to the graph:
 		// static constraints from SSA on round 1,
 		// dynamic constraints from reflection thereafter.
@@ -2,7 +2,7 @@
 
 package main
 
-var a int
+var a, b int
 
 type t struct {
 	a *map[string]chan *int
@@ -18,4 +18,7 @@ func fn() []t {
 func main() {
 	x := fn()
 	print(x) // @pointstoquery <-(*x[i].a)[key] main.a
+
+	y := map[*int]bool{&b: true}
+	print(y) // @pointstoquery key(x) main.b
 }
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"text/template"
 
+	"golang.org/x/tools/godoc/analysis"
 	"golang.org/x/tools/godoc/vfs/httpfs"
 )
 
@@ -85,6 +86,14 @@ type Presentation struct {
 	// the query string highlighted.
 	URLForSrcQuery func(src, query string, line int) string
 
+	// SourceLinks optionally specifies a function that returns links
+	// to decorate the Go source file src, in addition to those of the
+	// analysis, such as links to the queries of a guru server.  The
+	// links must be in order of position; those that overlap a link
+	// of the analysis are dropped.
+	// The source file argument has the form /src/<path>/<filename>.
+	SourceLinks func(path string, src []byte) []analysis.Link
+
 	// SearchResults optionally specifies a list of functions returning an HTML
 	// body for displaying search results.
 	SearchResults []SearchResultFunc
@@ -594,8 +594,13 @@ func (p *Presentation) serveTextFile(w http.ResponseWriter, r *http.Request, abs
 			fmt.Fprintf(&buf, "<span style='color: grey'>[%s]</span><br/>", htmlpkg.EscapeString(status))
 		}
 
+		links := fi.Links
+		if p.SourceLinks != nil {
+			links = mergeLinks(links, p.SourceLinks(abspath, src))
+		}
+
 		buf.WriteString("<pre>")
-		formatGoSource(&buf, src, fi.Links, h, s)
+		formatGoSource(&buf, src, links, h, s)
 		buf.WriteString("</pre>")
 	} else {
 		buf.WriteString("<pre>")
@@ -613,6 +618,31 @@ func (p *Presentation) serveTextFile(w http.ResponseWriter, r *http.Request, abs
 	})
 }
 
+// mergeLinks returns the links of x and y, which are in order of
+// position, in a single order, less those of y that overlap a link
+// of x.
+func mergeLinks(x, y []analysis.Link) []analysis.Link {
+	if len(y) == 0 {
+		return x
+	}
+	links := make([]analysis.Link, 0, len(x)+len(y))
+	end := 0 // the end of the last link of links
+	for len(x) > 0 || len(y) > 0 {
+		if len(y) == 0 || len(x) > 0 && x[0].Start() <= y[0].Start() {
+			links = append(links, x[0])
+			end = x[0].End()
+			x = x[1:]
+			continue
+		}
+		if y[0].Start() >= end && (len(x) == 0 || y[0].End() <= x[0].Start()) {
+			links = append(links, y[0])
+			end = y[0].End()
+		}
+		y = y[1:]
+	}
+	return links
+}
+
 // formatGoSource HTML-escapes Go source text and writes it to w,
 // decorating it with the specified analysis links.
 //
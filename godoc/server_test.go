@@ -5,8 +5,12 @@
 package godoc
 
 import (
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
+	"golang.org/x/tools/godoc/analysis"
 	"golang.org/x/tools/godoc/vfs/mapfs"
 )
 
@@ -46,3 +50,32 @@ package main`}))
 		t.Error("pInfo.FSet = nil; want non-nil.")
 	}
 }
+
+// testLink is an analysis.Link named by its source.
+type testLink struct {
+	name       string
+	start, end int
+}
+
+func (l testLink) Start() int                           { return l.start }
+func (l testLink) End() int                             { return l.end }
+func (l testLink) Write(w io.Writer, _ int, start bool) {}
+
+func TestMergeLinks(t *testing.T) {
+	x := []analysis.Link{testLink{"x", 0, 5}, testLink{"x", 10, 15}, testLink{"x", 20, 25}}
+	y := []analysis.Link{
+		testLink{"y", 3, 4},   // within x's first
+		testLink{"y", 6, 9},   // between x's
+		testLink{"y", 14, 18}, // overlaps x's second
+		testLink{"y", 18, 20}, // adjoins x's third
+		testLink{"y", 30, 35}, // after x's
+	}
+	var got []string
+	for _, l := range mergeLinks(x, y) {
+		l := l.(testLink)
+		got = append(got, fmt.Sprintf("%s%d-%d", l.name, l.start, l.end))
+	}
+	if got, want := strings.Join(got, " "), "x0-5 y6-9 x10-15 y18-20 x20-25 y30-35"; got != want {
+		t.Errorf("mergeLinks = %s, want %s", got, want)
+	}
+}